@@ -0,0 +1,388 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/nais/device/apiserver/database"
+	"github.com/nais/device/pkg/admin"
+	"github.com/nais/device/pkg/audit"
+	"github.com/nais/device/pkg/pb"
+	log "github.com/sirupsen/logrus"
+)
+
+// wireGuardKeyLength is the length in bytes of a decoded WireGuard key.
+const wireGuardKeyLength = 32
+
+type adminRequest struct {
+	PublicKey string `json:"publicKey"`
+	Name      string `json:"name"`
+	Username  string `json:"username"`
+}
+
+func (a *api) device(w http.ResponseWriter, r *http.Request) {
+	deviceID, ok := deviceIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	device, err := a.db.ReadDeviceById(r.Context(), deviceID)
+	respondAdminJSON(w, "reading device", device, err)
+}
+
+func (a *api) deleteDevice(w http.ResponseWriter, r *http.Request) {
+	deviceID, ok := deviceIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.sessions.RevokeDeviceSessions(r.Context(), deviceID); err != nil {
+		a.respondAdmin(w, "revoking device sessions", err)
+		return
+	}
+
+	a.respondAdmin(w, "deleting device", a.db.RemoveDevice(r.Context(), deviceID))
+}
+
+func (a *api) revokeDeviceSessions(w http.ResponseWriter, r *http.Request) {
+	deviceID, ok := deviceIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	a.respondAdmin(w, "revoking device sessions", a.sessions.RevokeDeviceSessions(r.Context(), deviceID))
+}
+
+func (a *api) revokeSession(w http.ResponseWriter, r *http.Request) {
+	a.respondAdmin(w, "revoking session", a.sessions.RevokeSession(r.Context(), chi.URLParam(r, "sessionKey")))
+}
+
+func (a *api) revokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	a.respondAdmin(w, "revoking user sessions", a.sessions.RevokeUserSessions(r.Context(), chi.URLParam(r, "objectID")))
+}
+
+func (a *api) sessionInfos(w http.ResponseWriter, r *http.Request) {
+	sessionInfos, err := a.db.ReadSessionInfos(r.Context())
+	if err != nil {
+		respondAdminJSON(w, "reading sessions", nil, err)
+		return
+	}
+
+	sessions := make([]admin.Session, len(sessionInfos))
+	for i, si := range sessionInfos {
+		sessions[i] = admin.Session{
+			DeviceID: si.Device.ID,
+			Username: si.Device.Username,
+			Serial:   si.Device.Serial,
+			Platform: si.Device.Platform,
+			ObjectID: si.ObjectId,
+			Groups:   si.Groups,
+			Expiry:   si.Expiry,
+		}
+	}
+
+	respondAdminJSON(w, "reading sessions", sessions, nil)
+}
+
+func (a *api) setDeviceDisabled(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deviceID, ok := deviceIDParam(w, r)
+		if !ok {
+			return
+		}
+
+		a.respondAdmin(w, "updating device", a.db.SetDeviceDisabled(r.Context(), deviceID, disabled))
+	}
+}
+
+func (a *api) setDevicePublicKey(w http.ResponseWriter, r *http.Request) {
+	deviceID, ok := deviceIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	req, ok := decodeAdminRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !validPublicKey(req.PublicKey) {
+		respondf(w, http.StatusBadRequest, "invalid public key\n")
+		return
+	}
+
+	a.respondAdmin(w, "re-keying device", a.db.SetDevicePublicKey(r.Context(), deviceID, req.PublicKey))
+}
+
+func (a *api) setDeviceName(w http.ResponseWriter, r *http.Request) {
+	deviceID, ok := deviceIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	req, ok := decodeAdminRequest(w, r)
+	if !ok {
+		return
+	}
+
+	a.respondAdmin(w, "renaming device", a.db.SetDeviceName(r.Context(), deviceID, req.Name))
+}
+
+// enrollments lists the device enrollments requiring approval, optionally only those in the given state.
+func (a *api) enrollments(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", database.EnrollmentPending, database.EnrollmentApproved, database.EnrollmentRejected:
+	default:
+		respondf(w, http.StatusBadRequest, "invalid enrollment state\n")
+		return
+	}
+
+	enrollments, err := a.db.ReadEnrollments(r.Context(), state)
+	respondAdminJSON(w, "reading enrollments", enrollments, err)
+}
+
+// decideEnrollment approves or rejects the enrollment. The decision is passed on to the device by the enroller.
+func (a *api) decideEnrollment(state string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enrollmentID, ok := enrollmentIDParam(w, r)
+		if !ok {
+			return
+		}
+
+		decidedBy, _, _ := r.BasicAuth()
+		err := a.db.SetEnrollmentState(r.Context(), enrollmentID, state, decidedBy)
+		if err == nil {
+			a.audit.Record(audit.Event{
+				Action:  audit.ActionEnrollmentDecided,
+				Outcome: audit.OutcomeSuccess,
+				Actor:   decidedBy,
+				Target:  strconv.Itoa(enrollmentID),
+				Details: map[string]string{"state": state},
+			})
+		}
+
+		a.respondAdmin(w, "deciding enrollment", err)
+	}
+}
+
+func (a *api) deleteEnrollment(w http.ResponseWriter, r *http.Request) {
+	enrollmentID, ok := enrollmentIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	a.respondAdmin(w, "deleting enrollment", a.db.RemoveEnrollment(r.Context(), enrollmentID))
+}
+
+// transferDevice gives the device to another user. The sessions of the previous owner are revoked, and the new owner
+// re-enrolls the device with their own key.
+func (a *api) transferDevice(w http.ResponseWriter, r *http.Request) {
+	deviceID, ok := deviceIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	req, ok := decodeAdminRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if len(req.Username) == 0 {
+		respondf(w, http.StatusBadRequest, "missing required field: username\n")
+		return
+	}
+
+	transferredBy, _, _ := r.BasicAuth()
+	if err := a.db.TransferDevice(r.Context(), deviceID, req.Username, transferredBy); err != nil {
+		a.respondAdmin(w, "transferring device", err)
+		return
+	}
+
+	a.respondAdmin(w, "revoking device sessions", a.sessions.RevokeDeviceSessions(r.Context(), deviceID))
+}
+
+func (a *api) ownershipTransfers(w http.ResponseWriter, r *http.Request) {
+	deviceID, ok := deviceIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	transfers, err := a.db.ReadOwnershipTransfers(r.Context(), deviceID)
+	respondAdminJSON(w, "reading ownership transfers", transfers, err)
+}
+
+func (a *api) gateway(w http.ResponseWriter, r *http.Request) {
+	gateway, err := a.db.ReadGateway(chi.URLParam(r, "gateway"))
+	respondAdminJSON(w, "reading gateway", gateway, err)
+}
+
+// gatewayAdmissions lists the devices the gateway currently admits, as calculated for its gateway configuration.
+func (a *api) gatewayAdmissions(w http.ResponseWriter, r *http.Request) {
+	gateway, err := a.db.ReadGateway(chi.URLParam(r, "gateway"))
+	if err != nil {
+		respondAdminJSON(w, "reading gateway", nil, err)
+		return
+	}
+
+	admissions := []admin.Admission{} // don't want nil declaration here as this JSON encodes to 'null' instead of '[]'
+	if gateway.Disabled {
+		respondAdminJSON(w, "reading gateway admissions", admissions, nil)
+		return
+	}
+
+	sessionInfos, err := a.admittedSessions(r.Context(), gateway)
+	if err != nil {
+		respondAdminJSON(w, "reading gateway admissions", nil, err)
+		return
+	}
+
+	routes := deviceRoutes(gateway.RoutePolicies, sessionInfos)
+	for _, si := range sessionInfos {
+		admissions = append(admissions, admin.Admission{
+			DeviceID: si.Device.ID,
+			Username: si.Device.Username,
+			ObjectID: si.ObjectId,
+			Serial:   si.Device.Serial,
+			Platform: si.Device.Platform,
+			IP:       si.Device.IP,
+			Routes:   pb.RouteCIDRs(routes[si.Device.PublicKey]),
+		})
+	}
+
+	respondAdminJSON(w, "reading gateway admissions", admissions, nil)
+}
+
+func (a *api) deleteGateway(w http.ResponseWriter, r *http.Request) {
+	a.respondAdmin(w, "deleting gateway", a.db.RemoveGateway(r.Context(), chi.URLParam(r, "gateway")))
+}
+
+func (a *api) setGatewayDisabled(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.respondAdmin(w, "updating gateway", a.db.SetGatewayDisabled(r.Context(), chi.URLParam(r, "gateway"), disabled))
+	}
+}
+
+func (a *api) setGatewayPublicKey(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeAdminRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !validPublicKey(req.PublicKey) {
+		respondf(w, http.StatusBadRequest, "invalid public key\n")
+		return
+	}
+
+	a.respondAdmin(w, "re-keying gateway", a.db.SetGatewayPublicKey(r.Context(), chi.URLParam(r, "gateway"), req.PublicKey))
+}
+
+func (a *api) renameGateway(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeAdminRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if len(req.Name) == 0 {
+		respondf(w, http.StatusBadRequest, "missing required field: name\n")
+		return
+	}
+
+	name := chi.URLParam(r, "gateway")
+
+	// The gateway authenticates and is configured by name, which can not be changed along with the database.
+	if _, ok := a.apiKeys[name]; ok {
+		respondf(w, http.StatusConflict, "gateway %s still has credentials, configure them for %s instead before renaming\n", name, req.Name)
+		return
+	}
+
+	if a.gatewayConfigurer != nil {
+		configured, err := a.gatewayConfigurer.HasConfig(r.Context(), name)
+		if err != nil {
+			log.Errorf("Admin API: reading gateway config: %v", err)
+			respondf(w, http.StatusInternalServerError, "renaming gateway failed\n")
+			return
+		}
+
+		if configured {
+			respondf(w, http.StatusConflict, "gateway %s still has an entry in the gateway config bucket, move it to %s before renaming\n", name, req.Name)
+			return
+		}
+	}
+
+	a.respondAdmin(w, "renaming gateway", a.db.RenameGateway(r.Context(), name, req.Name))
+}
+
+// respondAdmin writes the outcome of an admin operation. Successful changes are pushed to the gateways right away,
+// and picked up by the apiserver in its next WireGuard sync.
+func (a *api) respondAdmin(w http.ResponseWriter, operation string, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		respondf(w, http.StatusNotFound, "not found\n")
+	case err != nil:
+		log.Errorf("Admin API: %s: %v", operation, err)
+		respondf(w, http.StatusInternalServerError, "%s failed\n", operation)
+	default:
+		a.triggerGatewayConfigs()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// respondAdminJSON writes v as JSON, or the error if reading it failed.
+func respondAdminJSON(w http.ResponseWriter, operation string, v interface{}, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		respondf(w, http.StatusNotFound, "not found\n")
+	case err != nil:
+		log.Errorf("Admin API: %s: %v", operation, err)
+		respondf(w, http.StatusInternalServerError, "%s failed\n", operation)
+	default:
+		w.Header().Add("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			log.Errorf("Admin API: encoding response: %v", err)
+		}
+	}
+}
+
+func deviceIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	deviceID, err := strconv.Atoi(chi.URLParam(r, "deviceID"))
+	if err != nil {
+		respondf(w, http.StatusBadRequest, "invalid device id\n")
+		return 0, false
+	}
+
+	return deviceID, true
+}
+
+func enrollmentIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	enrollmentID, err := strconv.Atoi(chi.URLParam(r, "enrollmentID"))
+	if err != nil {
+		respondf(w, http.StatusBadRequest, "invalid enrollment id\n")
+		return 0, false
+	}
+
+	return enrollmentID, true
+}
+
+func decodeAdminRequest(w http.ResponseWriter, r *http.Request) (*adminRequest, bool) {
+	defer r.Body.Close()
+
+	var req adminRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondf(w, http.StatusBadRequest, "error during JSON unmarshal: %s\n", err)
+		return nil, false
+	}
+
+	return &req, true
+}
+
+func validPublicKey(publicKey string) bool {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	return err == nil && len(key) == wireGuardKeyLength
+}
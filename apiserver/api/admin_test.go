@@ -0,0 +1,286 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/nais/device/apiserver/api"
+	"github.com/nais/device/apiserver/auth"
+	"github.com/nais/device/apiserver/database"
+	"github.com/nais/device/apiserver/gatewayconfigurer"
+	"github.com/nais/device/pkg/admin"
+	"github.com/nais/device/pkg/audit"
+	"github.com/nais/device/pkg/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+const validPublicKey = "8TwUQqGBeeKgpy2dT1wLCXR6A2ZUVvB8VeffsRdoz3c="
+
+func TestAdminAPIRequiresCredentials(t *testing.T) {
+	router := api.New(api.Config{
+		AdminAPIKeys: map[string]string{"admin": "password"},
+		Sessions:     &auth.Sessions{},
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, "/admin/devices/1/disable", nil)
+	assert.Equal(t, http.StatusUnauthorized, executeRequest(req, router).Code)
+
+	req.SetBasicAuth("gateway", "password")
+	assert.Equal(t, http.StatusUnauthorized, executeRequest(req, router).Code)
+}
+
+func TestAdminAPIDisabledWithoutCredentials(t *testing.T) {
+	router := api.New(api.Config{Sessions: &auth.Sessions{}})
+
+	req, _ := http.NewRequest(http.MethodPost, "/admin/devices/1/disable", nil)
+	assert.Equal(t, http.StatusNotFound, executeRequest(req, router).Code)
+}
+
+func TestAdminAPIValidation(t *testing.T) {
+	router := api.New(api.Config{
+		AdminAPIKeys: map[string]string{"admin": "password"},
+		Sessions:     &auth.Sessions{},
+	})
+
+	for _, tc := range []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/admin/devices/notanumber/disable", ""},
+		{http.MethodPut, "/admin/devices/1/publickey", `{"publicKey": "tooshort"}`},
+		{http.MethodPut, "/admin/devices/1/publickey", `not json`},
+		{http.MethodPut, "/admin/gateways/gateway/publickey", `{"publicKey": ""}`},
+		{http.MethodPut, "/admin/gateways/gateway/name", `{"name": ""}`},
+		{http.MethodPost, "/admin/enrollments/notanumber/approve", ""},
+		{http.MethodPut, "/admin/devices/1/owner", `{"username": ""}`},
+		{http.MethodGet, "/admin/enrollments?state=unknown", ""},
+		{http.MethodGet, "/admin/audit?since=yesterday", ""},
+		{http.MethodGet, "/admin/audit?limit=0", ""},
+		{http.MethodPost, "/admin/audit", `{"component": "bootstrap-api"}`},
+	} {
+		req, _ := http.NewRequest(tc.method, tc.path, bytes.NewBufferString(tc.body))
+		req.SetBasicAuth("admin", "password")
+		assert.Equal(t, http.StatusBadRequest, executeRequest(req, router).Code, "%s %s %s", tc.method, tc.path, tc.body)
+	}
+}
+
+func TestAdminAPIAudit(t *testing.T) {
+	events := make(chan audit.Event, 2)
+	auditLog := audit.New("apiserver", audit.SinkFunc(func(ctx context.Context, e audit.Event) error {
+		events <- e
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go auditLog.Run(ctx)
+
+	router := api.New(api.Config{
+		AdminAPIKeys: map[string]string{"admin": "password"},
+		Sessions:     &auth.Sessions{},
+		Audit:        auditLog,
+	})
+
+	assert.Equal(t, http.StatusBadRequest, adminRequest(router, http.MethodPost, "/admin/devices/notanumber/disable", "").Code)
+	e := <-events
+	assert.Equal(t, audit.ActionAdminRequest, e.Action)
+	assert.Equal(t, audit.OutcomeFailure, e.Outcome)
+	assert.Equal(t, "admin", e.Actor)
+	assert.Equal(t, "/admin/devices/notanumber/disable", e.Target)
+	assert.Equal(t, "apiserver", e.Component)
+
+	body := `{"component": "bootstrap-api", "action": "enrollment_queued", "outcome": "success", "actor": "username"}`
+	assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodPost, "/admin/audit", body).Code)
+	e = <-events
+	assert.Equal(t, audit.ActionEnrollmentQueued, e.Action)
+	assert.Equal(t, "bootstrap-api", e.Component, "ingested events keep their component")
+}
+
+func TestAdminDeviceLifecycle(t *testing.T) {
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx := context.Background()
+
+		device := addDevice(t, db, ctx, "serial", "username", "publicKey1", true, 0)
+		path := fmt.Sprintf("/admin/devices/%d", device.ID)
+
+		assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodPost, path+"/disable", "").Code)
+		assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodPut, path+"/name", `{"name": "laptop"}`).Code)
+		assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodPut, path+"/publickey", `{"publicKey": "`+validPublicKey+`"}`).Code)
+
+		updated, err := db.ReadDeviceById(ctx, device.ID)
+		assert.NoError(t, err)
+		assert.True(t, updated.Disabled)
+		assert.Equal(t, "laptop", updated.Name)
+		assert.Equal(t, validPublicKey, updated.PublicKey)
+
+		assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodDelete, path, "").Code)
+		assert.Equal(t, http.StatusNotFound, adminRequest(router, http.MethodDelete, path, "").Code)
+		assert.Empty(t, getDevices(t, router))
+	})
+}
+
+func TestAdminTransferDevice(t *testing.T) {
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx := context.Background()
+
+		device := addDevice(t, db, ctx, "serial", "username", "publicKey1", true, 0)
+		path := fmt.Sprintf("/admin/devices/%d", device.ID)
+
+		assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodPut, path+"/owner", `{"username": "newUsername"}`).Code)
+		assert.Equal(t, http.StatusNotFound, adminRequest(router, http.MethodPut, "/admin/devices/1234/owner", `{"username": "newUsername"}`).Code)
+
+		updated, err := db.ReadDeviceById(ctx, device.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "newUsername", updated.Username)
+
+		resp := adminRequest(router, http.MethodGet, path+"/transfers", "")
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var transfers []admin.OwnershipTransfer
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&transfers))
+		assert.Len(t, transfers, 1)
+		assert.Equal(t, "username", transfers[0].FromUsername)
+		assert.Equal(t, "admin", transfers[0].TransferredBy)
+	})
+}
+
+func TestAdminGatewayLifecycle(t *testing.T) {
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx := context.Background()
+
+		assert.NoError(t, db.AddGateway(ctx, "gateway", "1.2.3.4:51820", "publicKey"))
+
+		assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodPost, "/admin/gateways/gateway/disable", "").Code)
+		assert.Empty(t, getGatewayConfig(t, router, "gateway", "").Devices)
+
+		assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodPut, "/admin/gateways/gateway/name", `{"name": "renamed"}`).Code)
+		assert.Equal(t, http.StatusNotFound, adminRequest(router, http.MethodPost, "/admin/gateways/gateway/enable", "").Code)
+		assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodPost, "/admin/gateways/renamed/enable", "").Code)
+
+		gateway, err := db.ReadGateway("renamed")
+		assert.NoError(t, err)
+		assert.False(t, gateway.Disabled)
+
+		assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodDelete, "/admin/gateways/renamed", "").Code)
+		_, err = db.ReadGateway("renamed")
+		assert.Error(t, err)
+	})
+}
+
+// bucketReader serves the gateway config bucket object from a string.
+type bucketReader string
+
+func (b bucketReader) ReadBucketObject(context.Context) (io.Reader, error) {
+	return strings.NewReader(string(b)), nil
+}
+
+func TestAdminRenameGatewayWithConfiguration(t *testing.T) {
+	db := database.NewMemoryDB(database.DefaultConfig())
+	router := api.New(api.Config{
+		DB:                db,
+		APIKeys:           map[string]string{"credentials": "password"},
+		AdminAPIKeys:      map[string]string{"admin": "password"},
+		Sessions:          &auth.Sessions{},
+		GatewayConfigurer: &gatewayconfigurer.GatewayConfigurer{BucketReader: bucketReader(`{"configured": {}}`)},
+	})
+	ctx := context.Background()
+
+	for _, name := range []string{"credentials", "configured", "gateway"} {
+		assert.NoError(t, db.AddGateway(ctx, name, "1.2.3.4:51820", "publicKey-"+name))
+	}
+
+	assert.Equal(t, http.StatusConflict, adminRequest(router, http.MethodPut, "/admin/gateways/credentials/name", `{"name": "renamed-1"}`).Code)
+	assert.Equal(t, http.StatusConflict, adminRequest(router, http.MethodPut, "/admin/gateways/configured/name", `{"name": "renamed-2"}`).Code)
+	assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodPut, "/admin/gateways/gateway/name", `{"name": "renamed-3"}`).Code)
+
+	for _, name := range []string{"credentials", "configured", "renamed-3"} {
+		_, err := db.ReadGateway(name)
+		assert.NoError(t, err)
+	}
+}
+
+func TestAdminEnrollmentDecisions(t *testing.T) {
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx := context.Background()
+
+		enrollment := &database.Enrollment{
+			EnrollmentID: "enrollment-1",
+			Serial:       "serial",
+			Platform:     "darwin",
+			Username:     "username",
+			PublicKey:    "publicKey",
+			State:        database.EnrollmentPending,
+		}
+		assert.NoError(t, db.SaveEnrollment(ctx, enrollment))
+		assert.NoError(t, db.AcknowledgeEnrollment(ctx, enrollment.ID, database.EnrollmentPending))
+
+		path := fmt.Sprintf("/admin/enrollments/%d", enrollment.ID)
+		assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodPost, path+"/approve", "").Code)
+
+		resp := adminRequest(router, http.MethodGet, "/admin/enrollments?state=approved", "")
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var enrollments []admin.Enrollment
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&enrollments))
+		assert.Len(t, enrollments, 1)
+		assert.Equal(t, "admin", enrollments[0].DecidedBy)
+		assert.NotNil(t, enrollments[0].Decided)
+		assert.False(t, enrollments[0].Acknowledged, "decision must be passed on to the bootstrap-api")
+
+		assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodDelete, path, "").Code)
+		assert.Equal(t, http.StatusNotFound, adminRequest(router, http.MethodPost, path+"/reject", "").Code)
+	})
+}
+
+func adminRequest(router chi.Router, method, path, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.SetBasicAuth("admin", "password")
+	return executeRequest(req, router)
+}
+
+func TestAdminGatewayAdmissions(t *testing.T) {
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx := context.Background()
+
+		admitted := addDevice(t, db, ctx, "serial1", "admittedUser", "pubKey1", true, time.Now().Unix())
+		disabled := addDevice(t, db, ctx, "serial2", "disabledUser", "pubKey2", true, time.Now().Unix())
+		_ = addSessionInfo(t, db, ctx, admitted, "admittedUserId", []string{"authorized"})
+		_ = addSessionInfo(t, db, ctx, disabled, "disabledUserId", []string{"authorized"})
+		assert.NoError(t, db.SetDeviceDisabled(ctx, disabled.ID, true))
+
+		assert.NoError(t, db.AddGateway(ctx, "gateway", "1.2.3.4:51820", "publicKey"))
+		assert.NoError(t, db.UpdateGateway(ctx, "gateway", []*pb.Route{{Cidr: "10.0.0.0/24"}}, []string{"authorized"}, false))
+
+		resp := adminRequest(router, http.MethodGet, "/admin/gateways/gateway/admissions", "")
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var admissions []admin.Admission
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&admissions))
+		assert.Equal(t, []admin.Admission{{
+			DeviceID: admitted.ID,
+			Username: "admittedUser",
+			ObjectID: "admittedUserId",
+			Serial:   "serial1",
+			Platform: "darwin",
+			IP:       admitted.IP,
+			Routes:   []string{"10.0.0.0/24"},
+		}}, admissions)
+
+		assert.Equal(t, http.StatusNoContent, adminRequest(router, http.MethodDelete, fmt.Sprintf("/admin/devices/%d/sessions", admitted.ID), "").Code)
+		resp = adminRequest(router, http.MethodGet, "/admin/sessions", "")
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var sessions []admin.Session
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&sessions))
+		assert.Len(t, sessions, 1)
+		assert.Equal(t, disabled.ID, sessions[0].DeviceID)
+	})
+}
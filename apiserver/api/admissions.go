@@ -0,0 +1,58 @@
+package api
+
+import (
+	"sync"
+
+	"github.com/nais/device/pkg/pb"
+)
+
+// Admissions holds the devices last sent to each gateway by any stream. Devices are told which gateways have been
+// configured to let them through, as a device that is not admitted has no peer on the gateway and cannot ask it.
+type Admissions struct {
+	lock      sync.RWMutex
+	published map[string]map[int64]*pb.Device
+}
+
+func NewAdmissions() *Admissions {
+	return &Admissions{
+		published: make(map[string]map[int64]*pb.Device),
+	}
+}
+
+// Admitted tells whether the device was in the configuration last sent to the gateway.
+func (a *Admissions) Admitted(gatewayName string, deviceID int64) bool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	_, ok := a.published[gatewayName][deviceID]
+	return ok
+}
+
+// publish records the devices in the configuration sent to the gateway, and returns the devices admitted to and
+// dropped from the gateway since the configuration previously sent to it.
+func (a *Admissions) publish(gatewayName string, devices []*pb.Device) (admitted, dropped []*pb.Device) {
+	current := make(map[int64]*pb.Device)
+	for _, device := range devices {
+		current[device.GetId()] = device
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	previous := a.published[gatewayName]
+	a.published[gatewayName] = current
+
+	for id, device := range current {
+		if _, ok := previous[id]; !ok {
+			admitted = append(admitted, device)
+		}
+	}
+
+	for id, device := range previous {
+		if _, ok := current[id]; !ok {
+			dropped = append(dropped, device)
+		}
+	}
+
+	return admitted, dropped
+}
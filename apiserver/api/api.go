@@ -4,19 +4,29 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/nais/device/apiserver/auth"
 	"github.com/nais/device/apiserver/jita"
+	"github.com/nais/device/pkg/audit"
 	"github.com/nais/device/pkg/pb"
+	"github.com/nais/device/pkg/session"
 	"time"
 
 	"net/http"
 
 	"github.com/nais/device/apiserver/database"
+	"github.com/nais/device/apiserver/gatewayconfigurer"
 	log "github.com/sirupsen/logrus"
 )
 
 type api struct {
-	db   *database.APIServerDB
-	jita *jita.Jita
+	db                 database.Repository
+	jita               *jita.Jita
+	sessions           *auth.Sessions
+	triggerGatewaySync chan<- struct{}
+	audit              *audit.Log
+	apiKeys            map[string]string
+	gatewayConfigurer  *gatewayconfigurer.GatewayConfigurer
+	admissions         *Admissions
 }
 
 const (
@@ -24,37 +34,82 @@ const (
 )
 
 type GatewayConfig struct {
-	Devices []database.Device
-	Routes  []string
+	Devices       []database.Device
+	Routes        []string
+	RoutePolicies []*pb.Route
+	// DeviceRoutes holds the routes each device is allowed to reach, keyed by device public key.
+	DeviceRoutes map[string][]*pb.Route
 }
 
 // gatewayConfig returns the devices for the gateway that has the group membership required
 func (a *api) gatewayConfig(w http.ResponseWriter, r *http.Request) {
 	gatewayName, _, _ := r.BasicAuth()
 
-	ctx := context.Background()
-	sessionInfos, err := a.db.ReadSessionInfos(ctx)
-
+	gatewayConfig, err := a.gatewayConfiguration(r.Context(), gatewayName)
 	if err != nil {
-		log.Errorf("reading session infos from database: %v", err)
+		log.Errorf("making gateway config: %v", err)
 		respondf(w, http.StatusInternalServerError, "failed getting gateway config")
 		return
 	}
 
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(gatewayConfig)
+}
+
+// gatewayConfiguration calculates which devices are allowed to connect to the given gateway
+func (a *api) gatewayConfiguration(ctx context.Context, gatewayName string) (*GatewayConfig, error) {
 	gateway, err := a.db.ReadGateway(gatewayName)
 	if err != nil {
-		log.Errorf("reading gateway from database: %v", err)
-		respondf(w, http.StatusInternalServerError, "failed getting gateway config")
-		return
+		return nil, fmt.Errorf("reading gateway from database: %w", err)
 	}
 
-	gatewayConfig := GatewayConfig{
-		Devices: healthy(authorized(gateway.AccessGroupIDs, a.privileged(*gateway, sessionInfos))),
-		Routes:  gateway.Routes,
+	if gateway.Disabled {
+		return &GatewayConfig{}, nil
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(gatewayConfig)
+	admittedSessions, err := a.admittedSessions(ctx, gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GatewayConfig{
+		Devices:       sessionDevices(admittedSessions),
+		Routes:        gateway.Routes,
+		RoutePolicies: gateway.RoutePolicies,
+		DeviceRoutes:  deviceRoutes(gateway.RoutePolicies, admittedSessions),
+	}, nil
+}
+
+// admittedSessions returns the unexpired sessions of the users the gateway admits,
+// limited to sessions on devices that are enabled and healthy.
+func (a *api) admittedSessions(ctx context.Context, gateway *pb.Gateway) ([]database.SessionInfo, error) {
+	sessionInfos, err := a.db.ReadSessionInfos(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading session infos from database: %w", err)
+	}
+
+	authorizedSessions := authorized(gateway.AccessGroupIDs, a.privileged(*gateway, unexpired(sessionInfos, time.Now())))
+
+	admittedDevices := make(map[int]bool)
+	for _, device := range healthy(enabled(sessionDevices(authorizedSessions))) {
+		admittedDevices[device.ID] = true
+	}
+
+	var admittedSessions []database.SessionInfo
+	for _, session := range authorizedSessions {
+		if admittedDevices[session.Device.ID] {
+			admittedSessions = append(admittedSessions, session)
+		}
+	}
+
+	return admittedSessions, nil
+}
+
+func (a *api) triggerGatewayConfigs() {
+	select {
+	case a.triggerGatewaySync <- struct{}{}:
+	default:
+	}
 }
 
 func (api *api) privileged(gateway pb.Gateway, sessions []database.SessionInfo) []database.SessionInfo {
@@ -97,18 +152,81 @@ func healthy(devices []database.Device) []database.Device {
 	return healthyDevices
 }
 
-func authorized(gatewayGroups []string, sessions []database.SessionInfo) []database.Device {
-	var authorizedDevices []database.Device
+func enabled(devices []database.Device) []database.Device {
+	var enabledDevices []database.Device
+	for _, device := range devices {
+		if device.Disabled {
+			log.Tracef("Skipping disabled device: %s", device.Serial)
+			continue
+		}
+		enabledDevices = append(enabledDevices, device)
+	}
+
+	return enabledDevices
+}
+
+// unexpired leaves out the expired sessions that have not yet been reaped.
+func unexpired(sessions []database.SessionInfo, now time.Time) []database.SessionInfo {
+	var unexpiredSessions []database.SessionInfo
+
+	for _, si := range sessions {
+		if session.Expired(si.Expiry, now) {
+			log.Tracef("Skipping expired session: %s", si.Device.Serial)
+			continue
+		}
+		unexpiredSessions = append(unexpiredSessions, si)
+	}
+
+	return unexpiredSessions
+}
+
+func authorized(gatewayGroups []string, sessions []database.SessionInfo) []database.SessionInfo {
+	var authorizedSessions []database.SessionInfo
 
 	for _, session := range sessions {
 		if userIsAuthorized(gatewayGroups, session.Groups) {
-			authorizedDevices = append(authorizedDevices, *session.Device)
+			authorizedSessions = append(authorizedSessions, session)
 		} else {
 			log.Tracef("Skipping unauthorized session: %s", session.Device.Serial)
 		}
 	}
 
-	return authorizedDevices
+	return authorizedSessions
+}
+
+func sessionDevices(sessions []database.SessionInfo) []database.Device {
+	var devices []database.Device
+	for _, session := range sessions {
+		devices = append(devices, *session.Device)
+	}
+
+	return devices
+}
+
+// deviceRoutes returns the routes each device may reach based on the groups of its sessions.
+// A device with several sessions may reach the routes allowed by any of them.
+func deviceRoutes(routes []*pb.Route, sessions []database.SessionInfo) map[string][]*pb.Route {
+	allowed := make(map[string]map[*pb.Route]bool)
+	for _, session := range sessions {
+		publicKey := session.Device.PublicKey
+		if allowed[publicKey] == nil {
+			allowed[publicKey] = make(map[*pb.Route]bool)
+		}
+		for _, route := range pb.AllowedRoutes(routes, session.Groups) {
+			allowed[publicKey][route] = true
+		}
+	}
+
+	routesPerDevice := make(map[string][]*pb.Route, len(allowed))
+	for publicKey, allowedRoutes := range allowed {
+		for _, route := range routes {
+			if allowedRoutes[route] {
+				routesPerDevice[publicKey] = append(routesPerDevice[publicKey], route)
+			}
+		}
+	}
+
+	return routesPerDevice
 }
 
 func (a *api) devices(w http.ResponseWriter, r *http.Request) {
@@ -147,6 +265,8 @@ func (a *api) updateHealth(w http.ResponseWriter, r *http.Request) {
 		respondf(w, http.StatusInternalServerError, "unable to persist device statuses\n")
 		return
 	}
+
+	a.triggerGatewayConfigs()
 }
 
 func (a *api) gateways(w http.ResponseWriter, r *http.Request) {
@@ -186,6 +306,12 @@ func (a *api) deviceConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if device.Disabled {
+		log.Infof("Device is disabled, returning HTTP %v", http.StatusForbidden)
+		respondf(w, http.StatusForbidden, "device has been disabled by an administrator")
+		return
+	}
+
 	if !*device.Healthy {
 		log.Infof("Device is unhealthy, returning HTTP %v", http.StatusForbidden)
 		respondf(w, http.StatusForbidden, "device not healthy, on slack: /msg @Kolide status")
@@ -193,6 +319,16 @@ func (a *api) deviceConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	gateways, err := a.UserGateways(sessionInfo.Groups)
+	if err != nil {
+		log.Errorf("Reading gateways: %v", err)
+		respondf(w, http.StatusInternalServerError, "unable to get device config\n")
+		return
+	}
+
+	for i := range *gateways {
+		gw := &(*gateways)[i]
+		gw.Admitted = a.admissions.Admitted(gw.Name, int64(device.ID))
+	}
 
 	w.WriteHeader(http.StatusOK)
 	err = json.NewEncoder(w).Encode(gateways)
@@ -214,7 +350,9 @@ func (a *api) UserGateways(userGroups []string) (*[]pb.Gateway, error) {
 
 	var filtered []pb.Gateway
 	for _, gw := range gateways {
-		if userIsAuthorized(gw.AccessGroupIDs, userGroups) {
+		if !gw.Disabled && userIsAuthorized(gw.AccessGroupIDs, userGroups) {
+			gw.RoutePolicies = pb.AllowedRoutes(gw.RoutePolicies, userGroups)
+			gw.Routes = pb.RouteCIDRs(gw.RoutePolicies)
 			filtered = append(filtered, gw)
 		}
 	}
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
 	"time"
 
@@ -22,96 +21,153 @@ import (
 )
 
 func TestGetDevices(t *testing.T) {
-	db, router := setup(t, nil)
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx := context.Background()
 
-	ctx := context.Background()
-
-	d := database.Device{Username: "user", Serial: "serial", PublicKey: "pubkey", Platform: "darwin"}
-	if err := db.AddDevice(ctx, d); err != nil {
-		t.Fatalf("Adding device: %v", err)
-	}
+		d := database.Device{Username: "user", Serial: "serial", PublicKey: "pubkey", Platform: "darwin"}
+		if err := db.AddDevice(ctx, d); err != nil {
+			t.Fatalf("Adding device: %v", err)
+		}
 
-	devices := getDevices(t, router)
-	assert.Len(t, devices, 1)
-	device := devices[0]
-	assert.Equal(t, d.Username, device.Username)
-	assert.Equal(t, d.PublicKey, device.PublicKey)
-	assert.Equal(t, d.Serial, device.Serial)
-	assert.Equal(t, d.Platform, device.Platform)
-	assert.NotNil(t, device.IP)
-	assert.False(t, *device.Healthy, "unhealthy by default")
-	assert.Nil(t, device.LastUpdated, "not updated by default")
-	assert.Nil(t, device.KolideLastSeen, "unseen by default")
+		devices := getDevices(t, router)
+		assert.Len(t, devices, 1)
+		device := devices[0]
+		assert.Equal(t, d.Username, device.Username)
+		assert.Equal(t, d.PublicKey, device.PublicKey)
+		assert.Equal(t, d.Serial, device.Serial)
+		assert.Equal(t, d.Platform, device.Platform)
+		assert.NotNil(t, device.IP)
+		assert.False(t, *device.Healthy, "unhealthy by default")
+		assert.Nil(t, device.LastUpdated, "not updated by default")
+		assert.Nil(t, device.KolideLastSeen, "unseen by default")
+	})
 }
 
 func TestGetDeviceConfig(t *testing.T) {
-	db, router := setup(t, nil)
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx := context.Background()
+
+		device := database.Device{
+			Serial:    "serial",
+			PublicKey: "pubkey",
+			Username:  "user",
+			Platform:  "darwin",
+		}
 
-	ctx := context.Background()
+		if err := db.AddDevice(ctx, device); err != nil {
+			t.Fatalf("Adding device: %v", err)
+		}
 
-	device := database.Device{
-		Serial:    "serial",
-		PublicKey: "pubkey",
-		Username:  "user",
-		Platform:  "darwin",
-	}
+		device.Healthy = boolp(true)
 
-	if err := db.AddDevice(ctx, device); err != nil {
-		t.Fatalf("Adding device: %v", err)
-	}
+		err := db.UpdateDeviceStatus([]database.Device{device})
+		assert.NoError(t, err)
 
-	device.Healthy = boolp(true)
+		authorizedGateway := pb.Gateway{Name: "gw1", Endpoint: "ep1", PublicKey: "pubkey1"}
+		unauthorizedGateway := pb.Gateway{Name: "gw2", Endpoint: "ep2", PublicKey: "pubkey2"}
+		if err := db.AddGateway(ctx, authorizedGateway.Name, authorizedGateway.Endpoint, authorizedGateway.PublicKey); err != nil {
+			t.Fatalf("Adding gateway: %v", err)
+		}
 
-	err := db.UpdateDeviceStatus([]database.Device{device})
-	assert.NoError(t, err)
+		assert.NoError(t, db.UpdateGateway(ctx, authorizedGateway.Name, nil, []string{"group1"}, false))
 
-	authorizedGateway := pb.Gateway{Name: "gw1", Endpoint: "ep1", PublicKey: "pubkey1"}
-	unauthorizedGateway := pb.Gateway{Name: "gw2", Endpoint: "ep2", PublicKey: "pubkey2"}
-	if err := db.AddGateway(ctx, authorizedGateway.Name, authorizedGateway.Endpoint, authorizedGateway.PublicKey); err != nil {
-		t.Fatalf("Adding gateway: %v", err)
-	}
+		if err := db.AddGateway(ctx, unauthorizedGateway.Name, unauthorizedGateway.Endpoint, unauthorizedGateway.PublicKey); err != nil {
+			t.Fatalf("Adding gateway: %v", err)
+		}
 
-	assert.NoError(t, db.UpdateGateway(ctx, authorizedGateway.Name, nil, []string{"group1"}, false))
+		gateways := getDeviceConfig(t, router, "keyyolo123")
 
-	if err := db.AddGateway(ctx, unauthorizedGateway.Name, unauthorizedGateway.Endpoint, unauthorizedGateway.PublicKey); err != nil {
-		t.Fatalf("Adding gateway: %v", err)
-	}
+		assert.Len(t, gateways, 1)
+		assert.Equal(t, gateways[0].PublicKey, authorizedGateway.PublicKey)
+	})
+}
+
+func TestGatewayConfig(t *testing.T) {
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx := context.Background()
+
+		healthyDevice := addDevice(t, db, ctx, "serial1", "healthyUser", "pubKey1", true, time.Now().Unix())
+		healthyDevice2 := addDevice(t, db, ctx, "serial2", "healthyUser2", "pubKey2", true, time.Now().Unix())
+		healthyDeviceOutOfDate := addDevice(t, db, ctx, "serial2", "healthyUser2", "pubKey2", true, time.Now().Add(-api.MaxTimeSinceKolideLastSeen).Unix())
+		unhealthyDevice := addDevice(t, db, ctx, "serial3", "unhealthyUser", "pubKey3", false, time.Now().Unix())
+
+		_ = addSessionInfo(t, db, ctx, healthyDevice, "userId", []string{"authorized"})
+		_ = addSessionInfo(t, db, ctx, healthyDevice2, "userId", []string{"unauthorized"})
+		_ = addSessionInfo(t, db, ctx, unhealthyDevice, "userId", []string{"authorized"})
+		_ = addSessionInfo(t, db, ctx, unhealthyDevice, "userId", []string{"unauthorized"})
+		_ = addSessionInfo(t, db, ctx, healthyDevice2, "userId", []string{""})
+		_ = addSessionInfo(t, db, ctx, healthyDeviceOutOfDate, "userId", []string{"authorized"})
+
+		// todo don't use username as gateway
+		authorizedGateway := pb.Gateway{Name: "username", Endpoint: "ep1", PublicKey: "pubkey1"}
+		if err := db.AddGateway(ctx, authorizedGateway.Name, authorizedGateway.Endpoint, authorizedGateway.PublicKey); err != nil {
+			t.Fatalf("Adding gateway: %v", err)
+		}
+		assert.NoError(t, db.UpdateGateway(ctx, authorizedGateway.Name, nil, []string{"authorized"}, false))
 
-	gateways := getDeviceConfig(t, router, "keyyolo123")
+		gatewayConfig := getGatewayConfig(t, router, "username", "password")
+		devices := gatewayConfig.Devices
 
-	assert.Len(t, gateways, 1)
-	assert.Equal(t, gateways[0].PublicKey, authorizedGateway.PublicKey)
+		assert.Len(t, devices, 1)
+		assert.Equal(t, devices[0].PublicKey, healthyDevice.PublicKey)
+	})
 }
 
-func TestGatewayConfig(t *testing.T) {
-	db, router := setup(t, nil)
+func TestGatewayConfigExpiredSessions(t *testing.T) {
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx := context.Background()
+
+		validDevice := addDevice(t, db, ctx, "serial1", "validUser", "pubKey1", true, time.Now().Unix())
+		expiredDevice := addDevice(t, db, ctx, "serial2", "expiredUser", "pubKey2", true, time.Now().Unix())
+
+		for _, sessionInfo := range []database.SessionInfo{
+			{Key: "valid", Expiry: time.Now().Add(time.Minute).Unix(), Device: validDevice, Groups: []string{"authorized"}, ObjectId: "validUserId"},
+			{Key: "expired", Expiry: time.Now().Add(-time.Minute).Unix(), Device: expiredDevice, Groups: []string{"authorized"}, ObjectId: "expiredUserId"},
+		} {
+			sessionInfo := sessionInfo
+			if err := db.AddSessionInfo(ctx, &sessionInfo); err != nil {
+				t.Fatalf("Adding SessionInfo: %v", err)
+			}
+		}
 
-	ctx := context.Background()
+		assert.NoError(t, db.AddGateway(ctx, "username", "ep1", "pubkey1"))
+		assert.NoError(t, db.UpdateGateway(ctx, "username", nil, []string{"authorized"}, false))
 
-	healthyDevice := addDevice(t, db, ctx, "serial1", "healthyUser", "pubKey1", true, time.Now().Unix())
-	healthyDevice2 := addDevice(t, db, ctx, "serial2", "healthyUser2", "pubKey2", true, time.Now().Unix())
-	healthyDeviceOutOfDate := addDevice(t, db, ctx, "serial2", "healthyUser2", "pubKey2", true, time.Now().Add(-api.MaxTimeSinceKolideLastSeen).Unix())
-	unhealthyDevice := addDevice(t, db, ctx, "serial3", "unhealthyUser", "pubKey3", false, time.Now().Unix())
-
-	_ = addSessionInfo(t, db, ctx, healthyDevice, "userId", []string{"authorized"})
-	_ = addSessionInfo(t, db, ctx, healthyDevice2, "userId", []string{"unauthorized"})
-	_ = addSessionInfo(t, db, ctx, unhealthyDevice, "userId", []string{"authorized"})
-	_ = addSessionInfo(t, db, ctx, unhealthyDevice, "userId", []string{"unauthorized"})
-	_ = addSessionInfo(t, db, ctx, healthyDevice2, "userId", []string{""})
-	_ = addSessionInfo(t, db, ctx, healthyDeviceOutOfDate, "userId", []string{"authorized"})
-
-	// todo don't use username as gateway
-	authorizedGateway := pb.Gateway{Name: "username", Endpoint: "ep1", PublicKey: "pubkey1"}
-	if err := db.AddGateway(ctx, authorizedGateway.Name, authorizedGateway.Endpoint, authorizedGateway.PublicKey); err != nil {
-		t.Fatalf("Adding gateway: %v", err)
-	}
-	assert.NoError(t, db.UpdateGateway(ctx, authorizedGateway.Name, nil, []string{"authorized"}, false))
+		devices := getGatewayConfig(t, router, "username", "password").Devices
+
+		if assert.Len(t, devices, 1, "expired sessions are not admitted before they are reaped") {
+			assert.Equal(t, validDevice.PublicKey, devices[0].PublicKey)
+		}
+	})
+}
+
+func TestGatewayConfigDeviceRoutes(t *testing.T) {
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx := context.Background()
+
+		teamDevice := addDevice(t, db, ctx, "serial1", "teamUser", "pubKey1", true, time.Now().Unix())
+		otherDevice := addDevice(t, db, ctx, "serial2", "otherUser", "pubKey2", true, time.Now().Unix())
+
+		_ = addSessionInfo(t, db, ctx, teamDevice, "userId1", []string{"authorized", "team"})
+		_ = addSessionInfo(t, db, ctx, otherDevice, "userId2", []string{"authorized"})
+
+		gateway := pb.Gateway{Name: "username", Endpoint: "ep1", PublicKey: "pubkey1"}
+		if err := db.AddGateway(ctx, gateway.Name, gateway.Endpoint, gateway.PublicKey); err != nil {
+			t.Fatalf("Adding gateway: %v", err)
+		}
+		routes := []*pb.Route{
+			{Cidr: "10.0.0.0/24", Protocol: "tcp"},
+			{Cidr: "10.0.1.0/24", Protocol: "tcp", AccessGroupIDs: []string{"team"}},
+		}
+		assert.NoError(t, db.UpdateGateway(ctx, gateway.Name, routes, []string{"authorized"}, false))
 
-	gatewayConfig := getGatewayConfig(t, router, "username", "password")
-	devices := gatewayConfig.Devices
+		gatewayConfig := getGatewayConfig(t, router, "username", "password")
 
-	assert.Len(t, devices, 1)
-	assert.Equal(t, devices[0].PublicKey, healthyDevice.PublicKey)
+		assert.Len(t, gatewayConfig.Devices, 2)
+		assert.Len(t, gatewayConfig.DeviceRoutes[teamDevice.PublicKey], 2)
+		assert.Len(t, gatewayConfig.DeviceRoutes[otherDevice.PublicKey], 1)
+		assert.Equal(t, "10.0.0.0/24", gatewayConfig.DeviceRoutes[otherDevice.PublicKey][0].GetCidr())
+	})
 }
 
 func TestPrivilegedGatewayConfig(t *testing.T) {
@@ -122,36 +178,36 @@ func TestPrivilegedGatewayConfig(t *testing.T) {
 		UserId: "userId",
 	}}
 	server := httptest.NewServer(mockJita(t, "privileged1", privilegedUsers))
-	db, router := setup(t, jita.New("username", "password", server.URL))
-
-	healthyDevice := addDevice(t, db, ctx, "serial1", "healthyUser", "pubKey1", true, time.Now().Unix())
+	forEachSetup(t, jita.New("username", "password", server.URL), func(t *testing.T, db database.Repository, router chi.Router) {
+		healthyDevice := addDevice(t, db, ctx, "serial1", "healthyUser", "pubKey1", true, time.Now().Unix())
 
-	_ = addSessionInfo(t, db, ctx, healthyDevice, privilegedUsers[0].UserId, []string{"authorized"})
+		_ = addSessionInfo(t, db, ctx, healthyDevice, privilegedUsers[0].UserId, []string{"authorized"})
 
-	// todo don't use username as gateway
-	privilegedGateway1 := pb.Gateway{Name: "privileged1", Endpoint: "ep1", PublicKey: "pubkey1"}
-	if err := db.AddGateway(ctx, privilegedGateway1.Name, privilegedGateway1.Endpoint, privilegedGateway1.PublicKey); err != nil {
-		t.Fatalf("Adding gateway: %v", err)
-	}
-	assert.NoError(t, db.UpdateGateway(ctx, privilegedGateway1.Name, nil, []string{"authorized"}, true))
+		// todo don't use username as gateway
+		privilegedGateway1 := pb.Gateway{Name: "privileged1", Endpoint: "ep1", PublicKey: "pubkey1"}
+		if err := db.AddGateway(ctx, privilegedGateway1.Name, privilegedGateway1.Endpoint, privilegedGateway1.PublicKey); err != nil {
+			t.Fatalf("Adding gateway: %v", err)
+		}
+		assert.NoError(t, db.UpdateGateway(ctx, privilegedGateway1.Name, nil, []string{"authorized"}, true))
 
-	privilegedGateway2 := pb.Gateway{Name: "privileged2", Endpoint: "ep1", PublicKey: "pubkey2"}
-	if err := db.AddGateway(ctx, privilegedGateway2.Name, privilegedGateway2.Endpoint, privilegedGateway2.PublicKey); err != nil {
-		t.Fatalf("Adding gateway: %v", err)
-	}
-	assert.NoError(t, db.UpdateGateway(ctx, privilegedGateway2.Name, nil, []string{"authorized"}, true))
+		privilegedGateway2 := pb.Gateway{Name: "privileged2", Endpoint: "ep1", PublicKey: "pubkey2"}
+		if err := db.AddGateway(ctx, privilegedGateway2.Name, privilegedGateway2.Endpoint, privilegedGateway2.PublicKey); err != nil {
+			t.Fatalf("Adding gateway: %v", err)
+		}
+		assert.NoError(t, db.UpdateGateway(ctx, privilegedGateway2.Name, nil, []string{"authorized"}, true))
 
-	privilegedGatewayConfig := getGatewayConfig(t, router, "privileged1", "password")
-	assert.Len(t, privilegedGatewayConfig.Devices, 1)
-	assert.Equal(t, privilegedGatewayConfig.Devices[0].PublicKey, healthyDevice.PublicKey)
+		privilegedGatewayConfig := getGatewayConfig(t, router, "privileged1", "password")
+		assert.Len(t, privilegedGatewayConfig.Devices, 1)
+		assert.Equal(t, privilegedGatewayConfig.Devices[0].PublicKey, healthyDevice.PublicKey)
 
-	unprivilegedGatewayConfig := getGatewayConfig(t, router, "privileged2", "password")
-	assert.Len(t, unprivilegedGatewayConfig.Devices, 0)
+		unprivilegedGatewayConfig := getGatewayConfig(t, router, "privileged2", "password")
+		assert.Len(t, unprivilegedGatewayConfig.Devices, 0)
 
-	server.Close()
+		server.Close()
+	})
 }
 
-func addDevice(t *testing.T, db *database.APIServerDB, ctx context.Context, serial, username, publicKey string, healthy bool, lastSeen int64) *database.Device {
+func addDevice(t *testing.T, db database.Repository, ctx context.Context, serial, username, publicKey string, healthy bool, lastSeen int64) *database.Device {
 	device := database.Device{
 		Serial:         serial,
 		PublicKey:      publicKey,
@@ -182,7 +238,7 @@ func addDevice(t *testing.T, db *database.APIServerDB, ctx context.Context, seri
 	return deviceWithId
 }
 
-func addSessionInfo(t *testing.T, db *database.APIServerDB, ctx context.Context, databaseDevice *database.Device, userId string, groups []string) *database.SessionInfo {
+func addSessionInfo(t *testing.T, db database.Repository, ctx context.Context, databaseDevice *database.Device, userId string, groups []string) *database.SessionInfo {
 	databaseSessionInfo := database.SessionInfo{
 		Key:      "dbSessionKey",
 		Expiry:   time.Now().Add(time.Minute).Unix(),
@@ -198,73 +254,74 @@ func addSessionInfo(t *testing.T, db *database.APIServerDB, ctx context.Context,
 }
 
 func TestUpdateDeviceHealth(t *testing.T) {
-	db, router := setup(t, nil)
-	device := database.Device{Username: "user@acme.org", Serial: "serial", PublicKey: "pubkey", Platform: "darwin", Healthy: boolp(true)}
-	ctx := context.Background()
-	if err := db.AddDevice(ctx, device); err != nil {
-		t.Fatalf("Adding device: %v", err)
-	}
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		device := database.Device{Username: "user@acme.org", Serial: "serial", PublicKey: "pubkey", Platform: "darwin", Healthy: boolp(true)}
+		ctx := context.Background()
+		if err := db.AddDevice(ctx, device); err != nil {
+			t.Fatalf("Adding device: %v", err)
+		}
 
-	devices := getDevices(t, router)
-	assert.Len(t, devices, 1)
-	assert.False(t, *devices[0].Healthy)
+		devices := getDevices(t, router)
+		assert.Len(t, devices, 1)
+		assert.False(t, *devices[0].Healthy)
 
-	devicesJSON := []database.Device{device}
-	b, err := json.Marshal(&devicesJSON)
-	if err != nil {
-		t.Fatalf("Marshalling device JSON: %v", err)
-	}
+		devicesJSON := []database.Device{device}
+		b, err := json.Marshal(&devicesJSON)
+		if err != nil {
+			t.Fatalf("Marshalling device JSON: %v", err)
+		}
 
-	req, _ := http.NewRequest("PUT", "/devices/health", bytes.NewReader(b))
-	resp := executeRequest(req, router)
-	assert.Equal(t, http.StatusOK, resp.Code)
+		req, _ := http.NewRequest("PUT", "/devices/health", bytes.NewReader(b))
+		resp := executeRequest(req, router)
+		assert.Equal(t, http.StatusOK, resp.Code)
 
-	devices = getDevices(t, router)
+		devices = getDevices(t, router)
 
-	assert.Len(t, devices, 1)
-	assert.True(t, *devices[0].Healthy)
+		assert.Len(t, devices, 1)
+		assert.True(t, *devices[0].Healthy)
+	})
 }
 
 func TestGetDeviceConfigSessionNotInCache(t *testing.T) {
-	db, router := setup(t, nil)
-
-	ctx := context.Background()
-
-	device := database.Device{
-		Serial:    "serial",
-		PublicKey: "pubkey",
-		Username:  "user",
-		Platform:  "darwin",
-	}
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx := context.Background()
+
+		device := database.Device{
+			Serial:    "serial",
+			PublicKey: "pubkey",
+			Username:  "user",
+			Platform:  "darwin",
+		}
 
-	if err := db.AddDevice(ctx, device); err != nil {
-		t.Fatalf("Adding device: %v", err)
-	}
+		if err := db.AddDevice(ctx, device); err != nil {
+			t.Fatalf("Adding device: %v", err)
+		}
 
-	device.Healthy = boolp(true)
+		device.Healthy = boolp(true)
 
-	err := db.UpdateDeviceStatus([]database.Device{device})
-	assert.NoError(t, err)
+		err := db.UpdateDeviceStatus([]database.Device{device})
+		assert.NoError(t, err)
 
-	// Read from db as we need the device ID
-	databaseDevice, err := db.ReadDevice(device.PublicKey)
-	if err != nil {
-		t.Fatalf("Reading device from db: %v", err)
-	}
+		// Read from db as we need the device ID
+		databaseDevice, err := db.ReadDevice(device.PublicKey)
+		if err != nil {
+			t.Fatalf("Reading device from db: %v", err)
+		}
 
-	databaseSessionInfo := database.SessionInfo{
-		Key:    "dbSessionKey",
-		Expiry: time.Now().Add(time.Minute).Unix(),
-		Device: databaseDevice,
-		Groups: []string{"group1", "group2"},
-	}
-	if err := db.AddSessionInfo(ctx, &databaseSessionInfo); err != nil {
-		t.Fatalf("Adding SessionInfo: %v", err)
-	}
+		databaseSessionInfo := database.SessionInfo{
+			Key:    "dbSessionKey",
+			Expiry: time.Now().Add(time.Minute).Unix(),
+			Device: databaseDevice,
+			Groups: []string{"group1", "group2"},
+		}
+		if err := db.AddSessionInfo(ctx, &databaseSessionInfo); err != nil {
+			t.Fatalf("Adding SessionInfo: %v", err)
+		}
 
-	gateways := getDeviceConfig(t, router, "dbSessionKey")
+		gateways := getDeviceConfig(t, router, "dbSessionKey")
 
-	assert.Len(t, gateways, 0)
+		assert.Len(t, gateways, 0)
+	})
 }
 
 func mockJita(t *testing.T, gatewayName string, privilegedUsers []jita.PrivilegedUser) *http.ServeMux {
@@ -281,40 +338,30 @@ func mockJita(t *testing.T, gatewayName string, privilegedUsers []jita.Privilege
 	return mux
 }
 
-func setup(t *testing.T, j *jita.Jita) (*database.APIServerDB, chi.Router) {
-	if os.Getenv("RUN_INTEGRATION_TESTS") == "" {
-		t.Skip("Skipping integration test")
-	}
-	ctx := context.Background()
-
-	testDBDSN := "user=postgres password=postgres host=localhost port=5433 sslmode=disable"
-
-	db, err := testdatabase.New(ctx, testDBDSN)
-	if err != nil {
-		t.Fatalf("Instantiating database: %v", err)
-	}
-
-	sessionInfo := database.SessionInfo{
-		Key:    "keyyolo123",
-		Expiry: time.Now().Add(1 * time.Minute).Unix(),
-		Device: &database.Device{
-			ID:       1,
-			Serial:   "serial",
-			Platform: "platform",
-			Username: "username",
-		},
-		Groups: []string{"group1"},
-	}
-
-	assert.NoError(t, err)
+// forEachSetup runs the test against each database, with an API using it.
+func forEachSetup(t *testing.T, j *jita.Jita, test func(t *testing.T, db database.Repository, router chi.Router)) {
+	testdatabase.ForEach(t, database.DefaultConfig(), func(t *testing.T, db database.Repository) {
+		sessionInfo := database.SessionInfo{
+			Key:    "keyyolo123",
+			Expiry: time.Now().Add(1 * time.Minute).Unix(),
+			Device: &database.Device{
+				ID:       1,
+				Serial:   "serial",
+				Platform: "platform",
+				Username: "username",
+			},
+			Groups: []string{"group1"},
+		}
 
-	return db, api.New(api.Config{
-		DB:   db,
-		Jita: j,
-		Sessions: &auth.Sessions{
-			DB:     db,
-			Active: map[string]*database.SessionInfo{sessionInfo.Key: &sessionInfo},
-		},
+		test(t, db, api.New(api.Config{
+			DB:           db,
+			Jita:         j,
+			AdminAPIKeys: map[string]string{"admin": "password"},
+			Sessions: &auth.Sessions{
+				DB:     db,
+				Active: map[string]*database.SessionInfo{sessionInfo.Key: &sessionInfo},
+			},
+		}))
 	})
 }
 
@@ -367,3 +414,39 @@ func executeRequest(req *http.Request, router chi.Router) *httptest.ResponseReco
 func boolp(b bool) *bool {
 	return &b
 }
+
+func TestLogout(t *testing.T) {
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		req, _ := http.NewRequest(http.MethodPost, "/logout", nil)
+		req.Header.Add("x-naisdevice-session-key", "keyyolo123")
+		assert.Equal(t, http.StatusNoContent, executeRequest(req, router).Code)
+
+		req, _ = http.NewRequest(http.MethodPost, "/logout", nil)
+		req.Header.Add("x-naisdevice-session-key", "keyyolo123")
+		assert.Equal(t, http.StatusUnauthorized, executeRequest(req, router).Code, "session is revoked")
+	})
+}
+
+func TestSessionExpiry(t *testing.T) {
+	device := &database.Device{ID: 1, Serial: "serial"}
+	router := api.New(api.Config{
+		Sessions: &auth.Sessions{
+			Active: map[string]*database.SessionInfo{
+				"expired":        {Key: "expired", Expiry: time.Now().Unix(), Device: device},
+				"norefreshtoken": {Key: "norefreshtoken", Expiry: time.Now().Add(time.Minute).Unix(), Device: device},
+			},
+		},
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/deviceconfig", nil)
+	req.Header.Add("x-naisdevice-session-key", "expired")
+	assert.Equal(t, http.StatusUnauthorized, executeRequest(req, router).Code, "expired session is rejected")
+
+	req, _ = http.NewRequest(http.MethodPost, "/renew", nil)
+	req.Header.Add("x-naisdevice-session-key", "expired")
+	assert.Equal(t, http.StatusUnauthorized, executeRequest(req, router).Code, "expired session can not be renewed")
+
+	req, _ = http.NewRequest(http.MethodPost, "/renew", nil)
+	req.Header.Add("x-naisdevice-session-key", "norefreshtoken")
+	assert.Equal(t, http.StatusUnauthorized, executeRequest(req, router).Code, "session without refresh token can not be renewed")
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	chi_middleware "github.com/go-chi/chi/middleware"
+	"github.com/nais/device/pkg/audit"
+)
+
+// auditEvents lists audit events, filtered by the query parameters action, actor, target, since, until and limit.
+// Times are on RFC 3339 format.
+func (a *api) auditEvents(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := audit.Query{
+		Action: params.Get("action"),
+		Actor:  params.Get("actor"),
+		Target: params.Get("target"),
+	}
+
+	for param, t := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if len(params.Get(param)) == 0 {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, params.Get(param))
+		if err != nil {
+			respondf(w, http.StatusBadRequest, "invalid %s, should be on RFC 3339 format\n", param)
+			return
+		}
+		*t = parsed
+	}
+
+	if limit := params.Get("limit"); len(limit) > 0 {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 {
+			respondf(w, http.StatusBadRequest, "invalid limit\n")
+			return
+		}
+		query.Limit = parsed
+	}
+
+	events, err := a.db.ReadAuditEvents(r.Context(), query)
+	respondAdminJSON(w, "reading audit events", events, err)
+}
+
+// ingestAuditEvents records an event from another component, such as the bootstrap-api's webhook sink.
+func (a *api) ingestAuditEvents(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var event audit.Event
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		respondf(w, http.StatusBadRequest, "error during JSON unmarshal: %s\n", err)
+		return
+	}
+
+	if len(event.Component) == 0 || len(event.Action) == 0 || len(event.Outcome) == 0 {
+		respondf(w, http.StatusBadRequest, "missing required fields: component, action and outcome\n")
+		return
+	}
+
+	event.ID = 0
+	a.audit.Record(event)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// auditAdminRequests records admin requests that change anything, along with the administrator making them.
+func (a *api) auditAdminRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ww := chi_middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		outcome := audit.OutcomeSuccess
+		if ww.Status() >= 400 {
+			outcome = audit.OutcomeFailure
+		}
+
+		username, _, _ := r.BasicAuth()
+		a.audit.Record(audit.Event{
+			Action:  audit.ActionAdminRequest,
+			Outcome: outcome,
+			Actor:   username,
+			Target:  r.URL.Path,
+			Details: map[string]string{"method": r.Method, "status": strconv.Itoa(ww.Status())},
+		})
+	})
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nais/device/pkg/audit"
+	"github.com/nais/device/pkg/pb"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// Gateway configurations are recalculated at this interval even without any trigger,
+	// in order to pick up changes we are not notified about, such as JITA grants and devices
+	// that have not been seen by Kolide for a while.
+	gatewayConfigRefreshInterval = 1 * time.Minute
+)
+
+// GRPCServer pushes gateway configuration to subscribing gateway-agents.
+type GRPCServer struct {
+	pb.UnimplementedAPIServerServer
+	api         api
+	apiKeys     map[string]string
+	audit       *audit.Log
+	streams     map[uuid.UUID]chan struct{}
+	streamsLock sync.Mutex
+
+	// admissions are shared by all streams, so that reconnecting gateways and gateways with several streams
+	// are not recorded as admitting the same devices again.
+	admissions *Admissions
+}
+
+func NewGRPCServer(cfg Config) *GRPCServer {
+	admissions := cfg.Admissions
+	if admissions == nil {
+		admissions = NewAdmissions()
+	}
+
+	return &GRPCServer{
+		api:        api{db: cfg.DB, jita: cfg.Jita},
+		apiKeys:    cfg.APIKeys,
+		audit:      cfg.Audit,
+		streams:    make(map[uuid.UUID]chan struct{}),
+		admissions: admissions,
+	}
+}
+
+func (s *GRPCServer) GetGatewayConfiguration(request *pb.GetGatewayConfigurationRequest, stream pb.APIServer_GetGatewayConfigurationServer) error {
+	gatewayName := request.GetGateway()
+
+	if !s.authenticated(gatewayName, request.GetPassword()) {
+		return status.Errorf(codes.Unauthenticated, "invalid credentials for gateway %s", gatewayName)
+	}
+
+	log := log.WithFields(log.Fields{
+		"component": "apiserver",
+		"gateway":   gatewayName,
+	})
+
+	id := uuid.New()
+	trigger := make(chan struct{}, 1)
+	trigger <- struct{}{}
+
+	s.streamsLock.Lock()
+	s.streams[id] = trigger
+	s.streamsLock.Unlock()
+
+	log.Infof("Gateway subscribed to configuration")
+
+	defer func() {
+		s.streamsLock.Lock()
+		delete(s.streams, id)
+		s.streamsLock.Unlock()
+		log.Infof("Gateway configuration stream closed")
+	}()
+
+	refresh := time.NewTicker(gatewayConfigRefreshInterval)
+	defer refresh.Stop()
+
+	var previous *pb.GatewayConfiguration
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-trigger:
+		case <-refresh.C:
+		}
+
+		gatewayConfig, err := s.api.gatewayConfiguration(stream.Context(), gatewayName)
+		if err != nil {
+			log.Errorf("Making gateway config: %v", err)
+			continue
+		}
+
+		current := gatewayConfig.Protobuf()
+		if proto.Equal(current, previous) {
+			log.Debugf("Gateway configuration unchanged, not sending")
+			continue
+		}
+
+		if err := stream.Send(current); err != nil {
+			return err
+		}
+
+		s.recordAdmissions(gatewayName, current)
+		previous = current
+		log.Debugf("Sent gateway configuration with %d devices", len(current.GetDevices()))
+	}
+}
+
+// SendAllGatewayConfigurations wakes up all subscribing gateways, which will receive
+// a new configuration if theirs has changed.
+func (s *GRPCServer) SendAllGatewayConfigurations() {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	for _, trigger := range s.streams {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// WatchGatewayConfigTriggers calls SendAllGatewayConfigurations for every trigger received.
+func (s *GRPCServer) WatchGatewayConfigTriggers(ctx context.Context, triggers <-chan struct{}) {
+	for {
+		select {
+		case <-triggers:
+			s.SendAllGatewayConfigurations()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recordAdmissions records the devices admitted to and dropped from the gateway since the configuration last sent to it.
+func (s *GRPCServer) recordAdmissions(gatewayName string, current *pb.GatewayConfiguration) {
+	admitted, dropped := s.admissions.publish(gatewayName, current.GetDevices())
+
+	record := func(action string, device *pb.Device) {
+		s.audit.Record(audit.Event{
+			Action:  action,
+			Outcome: audit.OutcomeSuccess,
+			Actor:   device.GetUsername(),
+			Target:  gatewayName,
+			Details: map[string]string{"serial": device.GetSerial(), "platform": device.GetPlatform()},
+		})
+	}
+
+	for _, device := range admitted {
+		record(audit.ActionGatewayDeviceAdmitted, device)
+	}
+
+	for _, device := range dropped {
+		record(audit.ActionGatewayDeviceDropped, device)
+	}
+}
+
+// authenticated checks the gateway password against the configured credentials. Gateways are always rejected when
+// no credentials are configured.
+func (s *GRPCServer) authenticated(gatewayName, password string) bool {
+	expected, ok := s.apiKeys[gatewayName]
+	if !ok || len(expected) == 0 || len(password) == 0 {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+}
+
+func (g *GatewayConfig) Protobuf() *pb.GatewayConfiguration {
+	devices := make([]*pb.Device, len(g.Devices))
+	for i := range g.Devices {
+		devices[i] = g.Devices[i].Protobuf()
+		devices[i].AllowedRoutes = g.DeviceRoutes[g.Devices[i].PublicKey]
+	}
+
+	return &pb.GatewayConfiguration{
+		Devices: devices,
+		Routes:  g.RoutePolicies,
+	}
+}
@@ -0,0 +1,219 @@
+package api_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/nais/device/apiserver/api"
+	"github.com/nais/device/apiserver/auth"
+	"github.com/nais/device/apiserver/database"
+	"github.com/nais/device/pkg/audit"
+	"github.com/nais/device/pkg/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGatewayConfigurationStreamUnauthenticated(t *testing.T) {
+	client := grpcClient(t, api.NewGRPCServer(api.Config{
+		APIKeys: map[string]string{"gateway": "password"},
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.GetGatewayConfiguration(ctx, &pb.GetGatewayConfigurationRequest{
+		Gateway:  "gateway",
+		Password: "wrong",
+	})
+	assert.NoError(t, err)
+
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestGatewayConfigurationStream(t *testing.T) {
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		healthyDevice := addDevice(t, db, ctx, "serial1", "healthyUser", "pubKey1", true, time.Now().Unix())
+		_ = addSessionInfo(t, db, ctx, healthyDevice, "userId", []string{"authorized"})
+
+		gateway := pb.Gateway{Name: "gateway", Endpoint: "ep1", PublicKey: "pubkey1"}
+		if err := db.AddGateway(ctx, gateway.Name, gateway.Endpoint, gateway.PublicKey); err != nil {
+			t.Fatalf("Adding gateway: %v", err)
+		}
+		assert.NoError(t, db.UpdateGateway(ctx, gateway.Name, []*pb.Route{{Cidr: "10.0.0.1/32", Protocol: "tcp"}}, []string{"authorized"}, false))
+
+		server := api.NewGRPCServer(api.Config{
+			DB:      db,
+			APIKeys: map[string]string{gateway.Name: "password"},
+		})
+		client := grpcClient(t, server)
+
+		stream, err := client.GetGatewayConfiguration(ctx, &pb.GetGatewayConfigurationRequest{
+			Gateway:  gateway.Name,
+			Password: "password",
+		})
+		assert.NoError(t, err)
+
+		gatewayConfig, err := stream.Recv()
+		assert.NoError(t, err)
+		assert.Len(t, gatewayConfig.GetDevices(), 1)
+		assert.Equal(t, healthyDevice.PublicKey, gatewayConfig.GetDevices()[0].GetPublicKey())
+		assert.Len(t, gatewayConfig.GetRoutes(), 1)
+		assert.Equal(t, "10.0.0.1/32", gatewayConfig.GetRoutes()[0].GetCidr())
+
+		healthyDevice2 := addDevice(t, db, ctx, "serial2", "healthyUser2", "pubKey2", true, time.Now().Unix())
+		_ = addSessionInfo(t, db, ctx, healthyDevice2, "userId2", []string{"authorized"})
+		server.SendAllGatewayConfigurations()
+
+		gatewayConfig, err = stream.Recv()
+		assert.NoError(t, err)
+		assert.Len(t, gatewayConfig.GetDevices(), 2)
+	})
+}
+
+func TestGatewayConfigurationStreamAdmissions(t *testing.T) {
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		events := make(chan audit.Event, 10)
+		auditLog := audit.New("apiserver", audit.SinkFunc(func(ctx context.Context, e audit.Event) error {
+			events <- e
+			return nil
+		}))
+		go auditLog.Run(ctx)
+
+		device := addDevice(t, db, ctx, "serial1", "user1", "pubKey1", true, time.Now().Unix())
+		_ = addSessionInfo(t, db, ctx, device, "userId", []string{"authorized"})
+
+		assert.NoError(t, db.AddGateway(ctx, "gateway", "ep1", "pubkey1"))
+		assert.NoError(t, db.UpdateGateway(ctx, "gateway", nil, []string{"authorized"}, false))
+
+		server := api.NewGRPCServer(api.Config{
+			DB:      db,
+			APIKeys: map[string]string{"gateway": "password"},
+			Audit:   auditLog,
+		})
+		client := grpcClient(t, server)
+
+		subscribe := func() (pb.APIServer_GetGatewayConfigurationClient, context.CancelFunc) {
+			streamCtx, cancel := context.WithCancel(ctx)
+			stream, err := client.GetGatewayConfiguration(streamCtx, &pb.GetGatewayConfigurationRequest{
+				Gateway:  "gateway",
+				Password: "password",
+			})
+			assert.NoError(t, err)
+
+			_, err = stream.Recv()
+			assert.NoError(t, err)
+			return stream, cancel
+		}
+
+		_, cancelStream := subscribe()
+		e := <-events
+		assert.Equal(t, audit.ActionGatewayDeviceAdmitted, e.Action)
+		assert.Equal(t, "user1", e.Actor)
+		cancelStream()
+
+		stream, cancelStream := subscribe()
+		defer cancelStream()
+
+		device2 := addDevice(t, db, ctx, "serial2", "user2", "pubKey2", true, time.Now().Unix())
+		_ = addSessionInfo(t, db, ctx, device2, "userId2", []string{"authorized"})
+		server.SendAllGatewayConfigurations()
+
+		_, err := stream.Recv()
+		assert.NoError(t, err)
+
+		e = <-events
+		assert.Equal(t, audit.ActionGatewayDeviceAdmitted, e.Action)
+		assert.Equal(t, "user2", e.Actor, "reconnecting must not record devices admitted again")
+	})
+}
+
+func TestDeviceConfigAdmissions(t *testing.T) {
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, _ chi.Router) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		device := addDevice(t, db, ctx, "serial1", "user1", "pubKey1", true, time.Now().Unix())
+		_ = addSessionInfo(t, db, ctx, device, "userId", []string{"authorized"})
+
+		assert.NoError(t, db.AddGateway(ctx, "gateway", "ep1", "pubkey1"))
+		assert.NoError(t, db.UpdateGateway(ctx, "gateway", nil, []string{"authorized"}, false))
+
+		cfg := api.Config{
+			DB:         db,
+			APIKeys:    map[string]string{"gateway": "password"},
+			Sessions:   &auth.Sessions{DB: db, Active: make(map[string]*database.SessionInfo)},
+			Admissions: api.NewAdmissions(),
+		}
+		router := api.New(cfg)
+		client := grpcClient(t, api.NewGRPCServer(cfg))
+
+		gateways := getDeviceConfig(t, router, "dbSessionKey")
+		assert.Len(t, gateways, 1)
+		assert.False(t, gateways[0].Admitted, "gateway has not been sent a configuration yet")
+
+		stream, err := client.GetGatewayConfiguration(ctx, &pb.GetGatewayConfigurationRequest{
+			Gateway:  "gateway",
+			Password: "password",
+		})
+		assert.NoError(t, err)
+		_, err = stream.Recv()
+		assert.NoError(t, err)
+
+		gateways = getDeviceConfig(t, router, "dbSessionKey")
+		assert.Len(t, gateways, 1)
+		assert.True(t, gateways[0].Admitted)
+	})
+}
+
+func TestGatewayConfigurationStreamWithoutCredentials(t *testing.T) {
+	client := grpcClient(t, api.NewGRPCServer(api.Config{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.GetGatewayConfiguration(ctx, &pb.GetGatewayConfigurationRequest{
+		Gateway:  "gateway",
+		Password: "password",
+	})
+	assert.NoError(t, err)
+
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "no configured credentials must not let any gateway in")
+}
+
+func grpcClient(t *testing.T, server *api.GRPCServer) pb.APIServerClient {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pb.RegisterAPIServerServer(s, server)
+
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("Dialing bufnet: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return pb.NewAPIServerClient(conn)
+}
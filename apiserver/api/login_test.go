@@ -0,0 +1,98 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/nais/device/apiserver/api"
+	"github.com/nais/device/apiserver/auth"
+	"github.com/nais/device/apiserver/config"
+	"github.com/nais/device/apiserver/database"
+	"github.com/nais/device/apiserver/idp"
+	deviceauth "github.com/nais/device/device-agent/auth"
+	"github.com/nais/device/pkg/fakeidp"
+	"github.com/nais/device/pkg/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoginFlow runs the device-agent login flow against the apiserver, which authenticates the user with a fake
+// identity provider, and uses the resulting session to get the device config.
+func TestLoginFlow(t *testing.T) {
+	forEachSetup(t, nil, func(t *testing.T, db database.Repository, router chi.Router) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		user := fakeidp.User{Username: "user@example.com", ObjectID: "objectId1", Groups: []string{"group1"}}
+		fake, err := fakeidp.New("client", user)
+		assert.NoError(t, err)
+		defer fake.Close()
+
+		provider, err := idp.NewOIDC(ctx, config.OIDC{IssuerURL: fake.URL, ClientID: "client", Scopes: []string{"openid", "offline_access"}})
+		assert.NoError(t, err)
+
+		sessions, err := auth.New(config.Config{}, provider, db, make(chan struct{}, 1))
+		assert.NoError(t, err)
+
+		server := httptest.NewServer(api.New(api.Config{DB: db, Sessions: sessions}))
+		defer server.Close()
+
+		device := addDevice(t, db, ctx, "serial", user.Username, "pubkey", true, time.Now().Unix())
+
+		assert.NoError(t, db.AddGateway(ctx, "gateway", "1.2.3.4:51820", "gatewaykey"))
+		assert.NoError(t, db.UpdateGateway(ctx, "gateway", nil, []string{"group1"}, false))
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		port := listener.Addr().(*net.TCPAddr).Port
+
+		// the browser follows the redirect from the identity provider to the device-agent
+		openBrowser := func() error {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/authurl", nil)
+			req.Header.Set(auth.HeaderKeyListenPort, strconv.Itoa(port))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			authURL, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			resp, err = http.Get(string(authURL))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			return nil
+		}
+
+		sessionInfo, err := deviceauth.RunFlow(ctx, openBrowser, deviceauth.MakeSessionInfoGetter(server.URL, device.Platform, device.Serial, port), listener)
+		assert.NoError(t, err)
+		assert.False(t, sessionInfo.Expired())
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/deviceconfig", nil)
+		req.Header.Set("x-naisdevice-session-key", sessionInfo.Key)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var gateways []pb.Gateway
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&gateways))
+		assert.Len(t, gateways, 1)
+		assert.Equal(t, "gatewaykey", gateways[0].PublicKey)
+
+		persisted, err := db.ReadSessionInfo(ctx, sessionInfo.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, user.ObjectID, persisted.ObjectId)
+		assert.Equal(t, user.Groups, persisted.Groups)
+		assert.NotEmpty(t, persisted.RefreshToken)
+	})
+}
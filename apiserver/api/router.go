@@ -5,20 +5,43 @@ import (
 	chi_middleware "github.com/go-chi/chi/middleware"
 	"github.com/nais/device/apiserver/auth"
 	"github.com/nais/device/apiserver/database"
+	"github.com/nais/device/apiserver/gatewayconfigurer"
 	"github.com/nais/device/apiserver/jita"
 	"github.com/nais/device/apiserver/middleware"
+	"github.com/nais/device/pkg/audit"
 	"net/http"
 )
 
 type Config struct {
-	DB       *database.APIServerDB
-	Jita     *jita.Jita
-	APIKeys  map[string]string
-	Sessions *auth.Sessions
+	DB                 database.Repository
+	Jita               *jita.Jita
+	APIKeys            map[string]string
+	AdminAPIKeys       map[string]string
+	Sessions           *auth.Sessions
+	TriggerGatewaySync chan<- struct{}
+	Audit              *audit.Log
+	// GatewayConfigurer is used to refuse renaming gateways that still have configuration under their old name.
+	GatewayConfigurer *gatewayconfigurer.GatewayConfigurer
+	// Admissions are recorded by the gRPC server and reported to devices, so both must be given the same.
+	Admissions *Admissions
 }
 
 func New(cfg Config) chi.Router {
-	api := api{db: cfg.DB, jita: cfg.Jita}
+	admissions := cfg.Admissions
+	if admissions == nil {
+		admissions = NewAdmissions()
+	}
+
+	api := api{
+		db:                 cfg.DB,
+		jita:               cfg.Jita,
+		sessions:           cfg.Sessions,
+		triggerGatewaySync: cfg.TriggerGatewaySync,
+		audit:              cfg.Audit,
+		apiKeys:            cfg.APIKeys,
+		gatewayConfigurer:  cfg.GatewayConfigurer,
+		admissions:         admissions,
+	}
 	sessions := cfg.Sessions
 
 	latencyHistBuckets := []float64{.001, .005, .01, .025, .05, .1, .5, 1, 3, 5}
@@ -44,8 +67,52 @@ func New(cfg Config) chi.Router {
 	r.Group(func(r chi.Router) {
 		r.Use(sessions.Validator())
 		r.Get("/deviceconfig", api.deviceConfig)
+		r.Post("/logout", sessions.Logout)
+		r.Post("/renew", sessions.Renew)
 	})
 
+	if cfg.AdminAPIKeys != nil {
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(chi_middleware.BasicAuth("naisdevice-admin", cfg.AdminAPIKeys))
+
+			r.Get("/audit", api.auditEvents)
+			r.Post("/audit", api.ingestAuditEvents)
+
+			r.Group(func(r chi.Router) {
+				r.Use(api.auditAdminRequests)
+
+				r.Get("/devices", api.devices)
+				r.Get("/devices/{deviceID}", api.device)
+				r.Delete("/devices/{deviceID}", api.deleteDevice)
+				r.Post("/devices/{deviceID}/disable", api.setDeviceDisabled(true))
+				r.Post("/devices/{deviceID}/enable", api.setDeviceDisabled(false))
+				r.Put("/devices/{deviceID}/publickey", api.setDevicePublicKey)
+				r.Put("/devices/{deviceID}/name", api.setDeviceName)
+				r.Delete("/devices/{deviceID}/sessions", api.revokeDeviceSessions)
+				r.Put("/devices/{deviceID}/owner", api.transferDevice)
+				r.Get("/devices/{deviceID}/transfers", api.ownershipTransfers)
+
+				r.Get("/enrollments", api.enrollments)
+				r.Post("/enrollments/{enrollmentID}/approve", api.decideEnrollment(database.EnrollmentApproved))
+				r.Post("/enrollments/{enrollmentID}/reject", api.decideEnrollment(database.EnrollmentRejected))
+				r.Delete("/enrollments/{enrollmentID}", api.deleteEnrollment)
+
+				r.Get("/sessions", api.sessionInfos)
+				r.Delete("/sessions/{sessionKey}", api.revokeSession)
+				r.Delete("/users/{objectID}/sessions", api.revokeUserSessions)
+
+				r.Get("/gateways", api.gateways)
+				r.Get("/gateways/{gateway}", api.gateway)
+				r.Get("/gateways/{gateway}/admissions", api.gatewayAdmissions)
+				r.Delete("/gateways/{gateway}", api.deleteGateway)
+				r.Post("/gateways/{gateway}/disable", api.setGatewayDisabled(true))
+				r.Post("/gateways/{gateway}/enable", api.setGatewayDisabled(false))
+				r.Put("/gateways/{gateway}/publickey", api.setGatewayPublicKey)
+				r.Put("/gateways/{gateway}/name", api.renameGateway)
+			})
+		})
+	}
+
 	r.Get("/login", sessions.Login)
 	r.Get("/authurl", sessions.AuthURL)
 
@@ -2,15 +2,18 @@ package auth
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/nais/device/apiserver/config"
 	"github.com/nais/device/apiserver/database"
+	"github.com/nais/device/apiserver/idp"
+	"github.com/nais/device/pkg/audit"
 	"github.com/nais/device/pkg/random"
+	"github.com/nais/device/pkg/session"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/endpoints"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -24,32 +27,30 @@ const (
 )
 
 type Sessions struct {
-	DB             *database.APIServerDB
-	OAuthConfig    *oauth2.Config
-	tokenValidator jwt.Keyfunc
-	devMode        bool
+	DB          database.Repository
+	OAuthConfig *oauth2.Config
+	provider    idp.Provider
+	devMode     bool
 
 	State     map[string]bool
 	stateLock sync.Mutex
 
 	Active     map[string]*database.SessionInfo
 	activeLock sync.Mutex
+
+	TriggerGatewaySync chan<- struct{}
+	Audit              *audit.Log
 }
 
-func New(cfg config.Config, validator jwt.Keyfunc, db *database.APIServerDB) (*Sessions, error) {
+func New(cfg config.Config, provider idp.Provider, db database.Repository, triggerGatewaySync chan<- struct{}) (*Sessions, error) {
 	return &Sessions{
-		DB:             db,
-		devMode:        cfg.DevMode,
-		tokenValidator: validator,
-		State:          make(map[string]bool),
-		Active:         make(map[string]*database.SessionInfo),
-		OAuthConfig: &oauth2.Config{
-			// RedirectURL:  "http://localhost",  don't set this
-			ClientID:     cfg.Azure.ClientID,
-			ClientSecret: cfg.Azure.ClientSecret,
-			Scopes:       []string{"openid", fmt.Sprintf("%s/.default", cfg.Azure.ClientID)},
-			Endpoint:     endpoints.AzureAD("62366534-1ec3-4962-8869-9b5535279d0b"),
-		},
+		DB:                 db,
+		TriggerGatewaySync: triggerGatewaySync,
+		devMode:            cfg.DevMode,
+		provider:           provider,
+		State:              make(map[string]bool),
+		Active:             make(map[string]*database.SessionInfo),
+		OAuthConfig:        provider.OAuthConfig(),
 	}, nil
 }
 
@@ -58,25 +59,15 @@ func (s *Sessions) Validator() func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			sessionKey := r.Header.Get("x-naisdevice-session-key")
 
-			s.activeLock.Lock()
-			defer s.activeLock.Unlock()
-			sessionInfo, ok := s.Active[sessionKey]
-			if !ok {
-				si, err := s.DB.ReadSessionInfo(r.Context(), sessionKey)
-				if err != nil {
-					log.Errorf("reading session info from db: %v", err)
-					w.WriteHeader(http.StatusUnauthorized)
-					return
-				}
-
-				s.Active[sessionKey] = si // cache it
-				sessionInfo = si
-				ok = true
+			sessionInfo, err := s.sessionInfo(r.Context(), sessionKey)
+			if err != nil {
+				log.Errorf("reading session info from db: %v", err)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
 			}
 
-			if !ok || !sessionInfo.Expired() {
-				log.Infof("session expired: %v", sessionInfo)
-				log.Infof("s: %v", s.Active)
+			if sessionInfo.Expired() {
+				log.Infof("session expired for device: %s", sessionInfo.Device.Serial)
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
@@ -88,6 +79,241 @@ func (s *Sessions) Validator() func(next http.Handler) http.Handler {
 	}
 }
 
+// sessionInfo returns the session with the given key from the session cache, reading it from the database if it is not cached.
+// The lock is not held while handling the request, as handlers may revoke sessions.
+func (s *Sessions) sessionInfo(ctx context.Context, key string) (*database.SessionInfo, error) {
+	s.activeLock.Lock()
+	defer s.activeLock.Unlock()
+
+	if sessionInfo, ok := s.Active[key]; ok {
+		return sessionInfo, nil
+	}
+
+	sessionInfo, err := s.DB.ReadSessionInfo(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Active[key] = sessionInfo // cache it
+	return sessionInfo, nil
+}
+
+// RevokeSession deletes the session with the given key, and removes it from the session cache.
+func (s *Sessions) RevokeSession(ctx context.Context, key string) error {
+	return s.revoke(func(si *database.SessionInfo) bool {
+		return si.Key == key
+	}, func() error {
+		return s.DB.RemoveSession(ctx, key)
+	})
+}
+
+// RevokeUserSessions deletes all sessions of the user with the given object ID, and removes them from the session cache.
+func (s *Sessions) RevokeUserSessions(ctx context.Context, objectID string) error {
+	return s.revoke(func(si *database.SessionInfo) bool {
+		return si.ObjectId == objectID
+	}, func() error {
+		return s.DB.RemoveUserSessions(ctx, objectID)
+	})
+}
+
+// RevokeDeviceSessions deletes all sessions of the device, and removes them from the session cache.
+func (s *Sessions) RevokeDeviceSessions(ctx context.Context, deviceID int) error {
+	return s.revoke(func(si *database.SessionInfo) bool {
+		return si.Device != nil && si.Device.ID == deviceID
+	}, func() error {
+		return s.DB.RemoveDeviceSessions(ctx, deviceID)
+	})
+}
+
+// revoke removes the matching sessions from the cache and deletes them from the database while holding the cache lock,
+// so that the Validator can not cache them again in between. Gateways are notified so they drop the devices right away.
+func (s *Sessions) revoke(match func(si *database.SessionInfo) bool, remove func() error) error {
+	s.activeLock.Lock()
+	defer s.activeLock.Unlock()
+
+	for key, sessionInfo := range s.Active {
+		if match(sessionInfo) {
+			delete(s.Active, key)
+		}
+	}
+
+	if err := remove(); err != nil {
+		return err
+	}
+
+	s.triggerGatewaySync()
+	return nil
+}
+
+// ReapExpiredSessions periodically removes expired sessions from the session cache and the database.
+func (s *Sessions) ReapExpiredSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.reapExpiredSessions(ctx, time.Now()); err != nil {
+				log.Errorf("Reaping expired sessions: %v", err)
+			}
+		}
+	}
+}
+
+// reapExpiredSessions removes the expired sessions, and notifies gateways so they drop the devices right away.
+func (s *Sessions) reapExpiredSessions(ctx context.Context, now time.Time) error {
+	s.activeLock.Lock()
+	defer s.activeLock.Unlock()
+
+	evicted := 0
+	for key, sessionInfo := range s.Active {
+		if session.Expired(sessionInfo.Expiry, now) {
+			delete(s.Active, key)
+			evicted++
+		}
+	}
+
+	reaped, err := s.DB.RemoveExpiredSessions(ctx, now)
+	if evicted > 0 || reaped > 0 {
+		s.triggerGatewaySync()
+	}
+	if err != nil {
+		return err
+	}
+
+	if reaped > 0 {
+		log.Infof("Reaped %d expired sessions", reaped)
+	}
+
+	return nil
+}
+
+// Logout revokes the session used to make the request.
+func (s *Sessions) Logout(w http.ResponseWriter, r *http.Request) {
+	sessionInfo := r.Context().Value("sessionInfo").(*database.SessionInfo)
+
+	// The session might only exist in the session cache if persisting it failed.
+	if err := s.RevokeSession(r.Context(), sessionInfo.Key); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Errorf("Revoking session on logout: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	s.Audit.Record(audit.Event{
+		Action:  audit.ActionSessionRevoked,
+		Outcome: audit.OutcomeSuccess,
+		Actor:   sessionInfo.Device.Username,
+		Target:  sessionInfo.Device.Serial,
+		Details: map[string]string{"reason": "logout"},
+	})
+	log.Infof("Logged out session for device: %s", sessionInfo.Device.Serial)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Refresh returns a copy of the session with its expiry extended by SessionDuration from now. Unless in dev mode, the
+// user's groups are read from a new token issued for the session's refresh token, so a user that has been disabled
+// or removed from groups in the identity provider loses access on renewal. Expired sessions can not be refreshed.
+func (s *Sessions) Refresh(ctx context.Context, sessionInfo *database.SessionInfo) (*database.SessionInfo, error) {
+	if sessionInfo.Expired() {
+		return nil, fmt.Errorf("session has expired")
+	}
+
+	renewed := *sessionInfo
+	renewed.Expiry = time.Now().Add(SessionDuration).Unix()
+
+	if s.devMode {
+		return &renewed, nil
+	}
+
+	if len(sessionInfo.RefreshToken) == 0 {
+		return nil, fmt.Errorf("session has no refresh token")
+	}
+
+	token, err := s.OAuthConfig.TokenSource(ctx, &oauth2.Token{RefreshToken: sessionInfo.RefreshToken}).Token()
+	if err != nil {
+		return nil, fmt.Errorf("refreshing token: %w", err)
+	}
+
+	identity, err := s.provider.Identity(token)
+	if err != nil {
+		return nil, fmt.Errorf("parsing token: %w", err)
+	}
+
+	if identity.ObjectID != sessionInfo.ObjectId {
+		return nil, fmt.Errorf("refreshed token belongs to another user: %s", identity.ObjectID)
+	}
+
+	renewed.Groups = identity.Groups
+	if len(token.RefreshToken) > 0 {
+		renewed.RefreshToken = token.RefreshToken
+	}
+
+	return &renewed, nil
+}
+
+// Renew extends the session used to make the request, and responds with its new expiry.
+func (s *Sessions) Renew(w http.ResponseWriter, r *http.Request) {
+	sessionInfo := r.Context().Value("sessionInfo").(*database.SessionInfo)
+
+	renewed, err := s.Refresh(r.Context(), sessionInfo)
+	if err != nil {
+		log.Warnf("Renewing session for device %s: %v", sessionInfo.Device.Serial, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Hold the cache lock so a session revoked in the meantime is not cached again.
+	s.activeLock.Lock()
+	_, cached := s.Active[renewed.Key]
+	err = s.DB.RenewSession(r.Context(), renewed)
+	switch {
+	case err == nil:
+		s.Active[renewed.Key] = renewed
+	case errors.Is(err, sql.ErrNoRows) && cached:
+		// the session only exists in the session cache if persisting it on login failed
+		s.Active[renewed.Key] = renewed
+		err = nil
+	}
+	s.activeLock.Unlock()
+
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err != nil {
+		log.Errorf("Persisting renewed session: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// groups might have changed
+	s.triggerGatewaySync()
+
+	b, err := json.Marshal(renewed)
+	if err != nil {
+		log.Errorf("Marshalling json: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_, err = w.Write(b)
+	if err != nil {
+		log.Errorf("writing response: %v", err)
+	}
+
+	log.Infof("Renewed session for device: %s", sessionInfo.Device.Serial)
+}
+
+func (s *Sessions) triggerGatewaySync() {
+	select {
+	case s.TriggerGatewaySync <- struct{}{}:
+	default:
+	}
+}
+
 func (s *Sessions) validAuthState(state string) error {
 	if len(state) == 0 {
 		return fmt.Errorf("no 'state' query param in auth request")
@@ -134,9 +360,23 @@ func (s *Sessions) getToken(ctx context.Context, code, redirectUri string) (*oau
 func (s *Sessions) Login(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
 
+	var username string
+	serial := r.Header.Get("x-naisdevice-serial")
+	platform := r.Header.Get("x-naisdevice-platform")
+	loginFailed := func(format string, args ...interface{}) {
+		authFailed(w, format, args...)
+		s.Audit.Record(audit.Event{
+			Action:  audit.ActionLogin,
+			Outcome: audit.OutcomeDenied,
+			Actor:   username,
+			Target:  serial,
+			Details: map[string]string{"platform": platform, "reason": fmt.Sprintf(format, args...)},
+		})
+	}
+
 	err := s.validAuthState(r.URL.Query().Get("state"))
 	if err != nil {
-		authFailed(w, "Validating auth state: %v", err)
+		loginFailed("Validating auth state: %v", err)
 		return
 	}
 
@@ -148,33 +388,33 @@ func (s *Sessions) Login(w http.ResponseWriter, r *http.Request) {
 	if !s.devMode {
 		listenPort, err := parseListenPort(r.Header.Get(HeaderKeyListenPort))
 		if err != nil {
-			authFailed(w, "unable to parse listening port: %v", err)
+			loginFailed("unable to parse listening port: %v", err)
 			return
 		}
 
 		redirectUri := fmt.Sprintf("http://localhost:%d", listenPort)
 		token, err := s.getToken(ctx, r.URL.Query().Get("code"), redirectUri)
 		if err != nil {
-			authFailed(w, "Exchanging code for token: %v", err)
+			loginFailed("Exchanging code for token: %v", err)
 			return
 		}
 
-		username, objectId, groups, err := s.parseToken(token)
+		identity, err := s.provider.Identity(token)
 		if err != nil {
-			authFailed(w, "Parsing token: %v", err)
+			loginFailed("Parsing token: %v", err)
 			return
 		}
-		sessionInfo.ObjectId = objectId
+		sessionInfo.ObjectId = identity.ObjectID
+		sessionInfo.RefreshToken = token.RefreshToken
 
-		serial := r.Header.Get("x-naisdevice-serial")
-		platform := r.Header.Get("x-naisdevice-platform")
-		device, err := s.DB.ReadDeviceBySerialPlatformUsername(ctx, serial, platform, username)
+		username = identity.Username
+		device, err := s.DB.ReadDeviceBySerialPlatformUsername(ctx, serial, platform, identity.Username)
 		if err != nil {
-			authFailed(w, "getting device: %v", err)
+			loginFailed("getting device: %v", err)
 			return
 		}
 
-		sessionInfo.Groups = groups
+		sessionInfo.Groups = identity.Groups
 		sessionInfo.Device = device
 	} else {
 		sessionInfo.Groups = []string{"group1", "group2"}
@@ -184,7 +424,7 @@ func (s *Sessions) Login(w http.ResponseWriter, r *http.Request) {
 
 	b, err := json.Marshal(sessionInfo)
 	if err != nil {
-		authFailed(w, "Marshalling json: %v", err)
+		loginFailed("Marshalling json: %v", err)
 		return
 	}
 
@@ -194,17 +434,34 @@ func (s *Sessions) Login(w http.ResponseWriter, r *http.Request) {
 
 	err = s.DB.AddSessionInfo(r.Context(), sessionInfo)
 	if err != nil {
-		log.Errorf("Persisting session info %v: %v", sessionInfo, err)
+		log.Errorf("Persisting session info for device %s: %v", sessionInfo.Device.Serial, err)
 		// don't abort auth here as this might be OK
 	}
 
+	// new session means the device might be allowed on more gateways
+	s.triggerGatewaySync()
+
 	w.WriteHeader(http.StatusOK)
 	_, err = w.Write(b)
 	if err != nil {
 		log.Errorf("writing response: %v", err)
 	}
 
-	log.Infof("login: %v", s.Active)
+	s.Audit.Record(audit.Event{
+		Action:  audit.ActionLogin,
+		Outcome: audit.OutcomeSuccess,
+		Actor:   sessionInfo.Device.Username,
+		Target:  sessionInfo.Device.Serial,
+		Details: map[string]string{"platform": sessionInfo.Device.Platform},
+	})
+	s.Audit.Record(audit.Event{
+		Action:  audit.ActionSessionCreated,
+		Outcome: audit.OutcomeSuccess,
+		Actor:   sessionInfo.Device.Username,
+		Target:  sessionInfo.Device.Serial,
+		Details: map[string]string{"expiry": time.Unix(sessionInfo.Expiry, 0).UTC().Format(time.RFC3339), "groups": strconv.Itoa(len(sessionInfo.Groups))},
+	})
+	log.Infof("login: device %s, %d active sessions", sessionInfo.Device.Serial, len(s.Active))
 }
 
 func (s *Sessions) AuthURL(w http.ResponseWriter, r *http.Request) {
@@ -239,26 +496,6 @@ func (s *Sessions) AuthURL(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Sessions) parseToken(token *oauth2.Token) (string, string, []string, error) {
-	var claims jwt.MapClaims
-	_, err := jwt.ParseWithClaims(token.AccessToken, &claims, s.tokenValidator)
-	if err != nil {
-		return "", "", nil, fmt.Errorf("parsing token with claims: %v", err)
-	}
-
-	var groups []string
-	groupInterface := claims["groups"].([]interface{})
-	groups = make([]string, len(groupInterface))
-	for i, v := range groupInterface {
-		groups[i] = v.(string)
-	}
-
-	username := claims["preferred_username"].(string)
-	objectId := claims["oid"].(string)
-
-	return username, objectId, groups, nil
-}
-
 func authFailed(w http.ResponseWriter, format string, args ...interface{}) {
 	w.WriteHeader(http.StatusForbidden)
 	log.Warnf(format, args...)
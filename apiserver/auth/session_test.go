@@ -1,9 +1,14 @@
 package auth_test
 
 import (
+	"context"
 	"fmt"
 	"github.com/nais/device/apiserver/auth"
+	"github.com/nais/device/apiserver/config"
 	"github.com/nais/device/apiserver/database"
+	"github.com/nais/device/apiserver/idp"
+	"github.com/nais/device/pkg/fakeidp"
+	"github.com/nais/device/pkg/keycache"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/endpoints"
@@ -12,6 +17,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestSessions_AuthURL(t *testing.T) {
@@ -58,3 +64,91 @@ func TestSessions_AuthURL(t *testing.T) {
 		assert.Equal(t, fmt.Sprintf(authUrlFormat, defaultPort), authUrlWithoutState)
 	})
 }
+
+func TestSessions_Refresh(t *testing.T) {
+	user := fakeidp.User{Username: "user@example.com", ObjectID: "objectId1", Groups: []string{"group1", "group3"}}
+	fake, err := fakeidp.New("client", user)
+	assert.NoError(t, err)
+	defer fake.Close()
+
+	azure := config.Azure{ClientID: "client", DiscoveryURL: fake.KeysURL()}
+	keys, err := keycache.New(context.Background(), azure.DiscoveryURL)
+	assert.NoError(t, err)
+
+	provider := idp.NewAzure(azure, keys.Keyfunc(azure.ClientID))
+	sessions, err := auth.New(config.Config{}, provider, nil, nil)
+	assert.NoError(t, err)
+	sessions.OAuthConfig.Endpoint = fake.Endpoint()
+
+	sessionInfo := &database.SessionInfo{
+		Key:          "key",
+		Expiry:       time.Now().Add(time.Minute).Unix(),
+		Groups:       []string{"group1", "group2"},
+		ObjectId:     "objectId1",
+		RefreshToken: fake.RefreshToken(user),
+	}
+
+	t.Run("valid session is renewed", func(t *testing.T) {
+		renewed, err := sessions.Refresh(context.Background(), sessionInfo)
+		assert.NoError(t, err)
+		assert.Equal(t, "key", renewed.Key)
+		assert.Equal(t, []string{"group1", "group3"}, renewed.Groups)
+		assert.NotEqual(t, sessionInfo.RefreshToken, renewed.RefreshToken, "refresh token is rotated")
+		assert.InDelta(t, time.Now().Add(auth.SessionDuration).Unix(), renewed.Expiry, 5)
+		assert.Equal(t, []string{"group1", "group2"}, sessionInfo.Groups, "original session is left untouched")
+
+		_, err = sessions.Refresh(context.Background(), sessionInfo)
+		assert.Error(t, err, "refresh token is consumed")
+	})
+
+	t.Run("token for another user is rejected", func(t *testing.T) {
+		other := *sessionInfo
+		other.ObjectId = "objectId2"
+		other.RefreshToken = fake.RefreshToken(user)
+		_, err := sessions.Refresh(context.Background(), &other)
+		assert.Error(t, err)
+	})
+
+	t.Run("expired session is not renewed", func(t *testing.T) {
+		expired := *sessionInfo
+		expired.Expiry = time.Now().Unix()
+		expired.RefreshToken = fake.RefreshToken(user)
+		_, err := sessions.Refresh(context.Background(), &expired)
+		assert.Error(t, err)
+	})
+}
+
+func TestSessions_ReapExpiredSessions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	db := database.NewMemoryDB(database.DefaultConfig())
+	assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "user", PublicKey: "publickey", Serial: "serial", Platform: "linux"}))
+	device, err := db.ReadDevice("publickey")
+	assert.NoError(t, err)
+
+	expired := &database.SessionInfo{Key: "expired", Expiry: time.Now().Add(-time.Minute).Unix(), Device: device}
+	valid := &database.SessionInfo{Key: "valid", Expiry: time.Now().Add(time.Hour).Unix(), Device: device}
+	assert.NoError(t, db.AddSessionInfo(ctx, expired))
+	assert.NoError(t, db.AddSessionInfo(ctx, valid))
+
+	triggers := make(chan struct{}, 1)
+	sessions := &auth.Sessions{
+		DB:                 db,
+		Active:             map[string]*database.SessionInfo{expired.Key: expired, valid.Key: valid},
+		TriggerGatewaySync: triggers,
+	}
+	go sessions.ReapExpiredSessions(ctx, 10*time.Millisecond)
+
+	select {
+	case <-triggers:
+	case <-ctx.Done():
+		t.Fatal("gateways were not notified about the reaped session")
+	}
+
+	sessionInfos, err := db.ReadSessionInfos(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, sessionInfos, 1) {
+		assert.Equal(t, "valid", sessionInfos[0].Key)
+	}
+}
@@ -1,41 +0,0 @@
-package validate
-
-import (
-	"fmt"
-	"github.com/dgrijalva/jwt-go"
-	"github.com/nais/device/apiserver/azure/discovery"
-)
-
-func JWTValidator(certificates map[string]discovery.CertificateList, audience string) jwt.Keyfunc {
-	return func(token *jwt.Token) (interface{}, error) {
-		var certificateList discovery.CertificateList
-		var kid string
-		var ok bool
-
-		if claims, ok := token.Claims.(*jwt.MapClaims); !ok {
-			return nil, fmt.Errorf("unable to retrieve claims from token")
-		} else {
-			if valid := claims.VerifyAudience(audience, true); !valid {
-				return nil, fmt.Errorf("the token is not valid for this application")
-			}
-		}
-
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		if kid, ok = token.Header["kid"].(string); !ok {
-			return nil, fmt.Errorf("field 'kid' is of invalid type %T, should be string", token.Header["kid"])
-		}
-
-		if certificateList, ok = certificates[kid]; !ok {
-			return nil, fmt.Errorf("kid '%s' not found in certificate list", kid)
-		}
-
-		for _, certificate := range certificateList {
-			return certificate.PublicKey, nil
-		}
-
-		return nil, fmt.Errorf("no certificate candidates for kid '%s'", kid)
-	}
-}
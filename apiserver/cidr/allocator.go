@@ -0,0 +1,100 @@
+package cidr
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// allocationAttempts is the number of times Allocate retries when another allocation claims the same address first.
+const allocationAttempts = 10
+
+var ErrNoAvailableIP = errors.New("no available IPs")
+
+// DBTX is satisfied by both *sql.DB and *sql.Tx, so that addresses can be allocated
+// in the same transaction as the row using them is inserted.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Allocator hands out addresses from a network, keeping track of allocated addresses in the ip_allocation table.
+// Only allocated addresses are stored, so the size of the network does not matter. Concurrent allocations,
+// also from different processes, are resolved by the primary key on the table.
+type Allocator struct {
+	network string
+}
+
+func NewAllocator(network string) (*Allocator, error) {
+	_, ipnet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, fmt.Errorf("parsing network: %w", err)
+	}
+
+	return &Allocator{network: ipnet.String()}, nil
+}
+
+// Allocate claims the lowest available address in the network, skipping the network and broadcast addresses.
+// If called within a transaction, the address is released again if the transaction is rolled back.
+func (a *Allocator) Allocate(ctx context.Context, db DBTX) (string, error) {
+	// Every free address is the successor of either the network address or an allocated address,
+	// so only the successors need to be checked.
+	query := `
+SELECT host(candidate.ip + 1)
+FROM (SELECT ip FROM ip_allocation WHERE ip << $1::cidr
+      UNION ALL
+      SELECT host(network($1::cidr))::inet) AS candidate
+WHERE candidate.ip + 1 << $1::cidr
+  AND candidate.ip + 1 <> host(broadcast($1::cidr))::inet
+  AND NOT EXISTS (SELECT 1 FROM ip_allocation WHERE ip = candidate.ip + 1)
+ORDER BY candidate.ip
+LIMIT 1;`
+
+	statement := `
+INSERT INTO ip_allocation (ip)
+VALUES ($1::inet)
+ON CONFLICT DO NOTHING;`
+
+	for attempt := 0; attempt < allocationAttempts; attempt++ {
+		var ip string
+		err := db.QueryRowContext(ctx, query, a.network).Scan(&ip)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%w in range %s", ErrNoAvailableIP, a.network)
+		}
+		if err != nil {
+			return "", fmt.Errorf("finding available ip: %w", err)
+		}
+
+		result, err := db.ExecContext(ctx, statement, ip)
+		if err != nil {
+			return "", fmt.Errorf("allocating ip %s: %w", ip, err)
+		}
+
+		if n, err := result.RowsAffected(); err != nil {
+			return "", fmt.Errorf("allocating ip %s: %w", ip, err)
+		} else if n == 1 {
+			return ip, nil
+		}
+	}
+
+	return "", fmt.Errorf("allocating ip in range %s: gave up after %d attempts", a.network, allocationAttempts)
+}
+
+// Release makes the address available for allocation again.
+func (a *Allocator) Release(ctx context.Context, db DBTX, ip string) error {
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("releasing ip: invalid address %q", ip)
+	}
+
+	statement := `
+DELETE FROM ip_allocation
+WHERE ip = $1::inet;`
+
+	if _, err := db.ExecContext(ctx, statement, ip); err != nil {
+		return fmt.Errorf("releasing ip %s: %w", ip, err)
+	}
+
+	return nil
+}
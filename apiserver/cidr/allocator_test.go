@@ -0,0 +1,182 @@
+package cidr_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nais/device/apiserver/cidr"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAllocations answers the queries made by cidr.Allocator, standing in for the ip_allocation table.
+type fakeAllocations struct {
+	// candidates are returned by the query for the lowest available address, in order, followed by no rows.
+	candidates []string
+	// allocated are the addresses in the table, an insert of these affects no rows.
+	allocated map[string]bool
+	networks  []string
+	released  []string
+}
+
+func (f *fakeAllocations) Connect(context.Context) (driver.Conn, error) { return f, nil }
+func (f *fakeAllocations) Driver() driver.Driver                        { return nil }
+func (f *fakeAllocations) Prepare(string) (driver.Stmt, error)          { return nil, driver.ErrSkip }
+func (f *fakeAllocations) Close() error                                 { return nil }
+func (f *fakeAllocations) Begin() (driver.Tx, error)                    { return nil, errors.New("not supported") }
+
+func (f *fakeAllocations) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	f.networks = append(f.networks, args[0].Value.(string))
+	if len(f.candidates) == 0 {
+		return &fakeRows{}, nil
+	}
+
+	candidate := f.candidates[0]
+	f.candidates = f.candidates[1:]
+	return &fakeRows{values: []string{candidate}}, nil
+}
+
+func (f *fakeAllocations) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ip := args[0].Value.(string)
+
+	switch {
+	case strings.Contains(query, "INSERT"):
+		if f.allocated[ip] {
+			return driver.RowsAffected(0), nil
+		}
+		f.allocated[ip] = true
+		return driver.RowsAffected(1), nil
+	case strings.Contains(query, "DELETE"):
+		delete(f.allocated, ip)
+		f.released = append(f.released, ip)
+		return driver.RowsAffected(1), nil
+	}
+
+	return nil, errors.New("unexpected statement")
+}
+
+type fakeRows struct {
+	values []string
+}
+
+func (r *fakeRows) Columns() []string { return []string{"host"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.values[0]
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestFindAvailableIP(t *testing.T) {
+	t.Run("finds the lowest ip address in range", func(t *testing.T) {
+		availableIP, err := cidr.FindAvailableIP("10.0.0.0/30", map[string]bool{"10.0.0.2": true})
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.1", availableIP)
+	})
+
+	t.Run("fills gaps", func(t *testing.T) {
+		availableIP, err := cidr.FindAvailableIP("10.0.0.0/29", map[string]bool{"10.0.0.1": true, "10.0.0.3": true})
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.2", availableIP)
+	})
+
+	t.Run("returns an error if no ip is available", func(t *testing.T) {
+		_, err := cidr.FindAvailableIP("10.0.0.1/30", map[string]bool{"10.0.0.1": true, "10.0.0.2": true})
+		assert.True(t, errors.Is(err, cidr.ErrNoAvailableIP))
+	})
+
+	t.Run("returns an error for ipv6 networks", func(t *testing.T) {
+		_, err := cidr.FindAvailableIP("fd00::/64", nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestFirstIP(t *testing.T) {
+	ip, err := cidr.FirstIP("10.255.240.0/21")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.255.240.1", ip)
+
+	_, err = cidr.FirstIP("10.255.240.0/31")
+	assert.Error(t, err)
+
+	_, err = cidr.FirstIP("10.255.240.0")
+	assert.Error(t, err)
+}
+
+func TestNewAllocator(t *testing.T) {
+	_, err := cidr.NewAllocator("10.255.240.0/21")
+	assert.NoError(t, err)
+
+	_, err = cidr.NewAllocator("10.255.240.0")
+	assert.Error(t, err)
+}
+
+func TestAllocator(t *testing.T) {
+	ctx := context.Background()
+
+	allocator, err := cidr.NewAllocator("10.0.0.1/24")
+	assert.NoError(t, err)
+
+	t.Run("claims the lowest available address in the normalized network", func(t *testing.T) {
+		allocations := &fakeAllocations{candidates: []string{"10.0.0.1"}, allocated: map[string]bool{}}
+		db := sql.OpenDB(allocations)
+
+		ip, err := allocator.Allocate(ctx, db)
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.1", ip)
+		assert.True(t, allocations.allocated["10.0.0.1"])
+		assert.Equal(t, []string{"10.0.0.0/24"}, allocations.networks)
+	})
+
+	t.Run("retries when the address is claimed concurrently", func(t *testing.T) {
+		allocations := &fakeAllocations{
+			candidates: []string{"10.0.0.1", "10.0.0.2"},
+			allocated:  map[string]bool{"10.0.0.1": true},
+		}
+		db := sql.OpenDB(allocations)
+
+		ip, err := allocator.Allocate(ctx, db)
+		assert.NoError(t, err)
+		assert.Equal(t, "10.0.0.2", ip)
+	})
+
+	t.Run("returns ErrNoAvailableIP when the network is full", func(t *testing.T) {
+		db := sql.OpenDB(&fakeAllocations{allocated: map[string]bool{}})
+
+		_, err := allocator.Allocate(ctx, db)
+		assert.True(t, errors.Is(err, cidr.ErrNoAvailableIP))
+	})
+
+	t.Run("gives up when every candidate is claimed concurrently", func(t *testing.T) {
+		allocations := &fakeAllocations{allocated: map[string]bool{"10.0.0.1": true}}
+		for i := 0; i < 20; i++ {
+			allocations.candidates = append(allocations.candidates, "10.0.0.1")
+		}
+		db := sql.OpenDB(allocations)
+
+		_, err := allocator.Allocate(ctx, db)
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, cidr.ErrNoAvailableIP))
+		assert.Len(t, allocations.candidates, 10)
+	})
+
+	t.Run("releases addresses", func(t *testing.T) {
+		allocations := &fakeAllocations{allocated: map[string]bool{"10.0.0.1": true}}
+		db := sql.OpenDB(allocations)
+
+		assert.NoError(t, allocator.Release(ctx, db, "10.0.0.1"))
+		assert.Equal(t, []string{"10.0.0.1"}, allocations.released)
+		assert.False(t, allocations.allocated["10.0.0.1"])
+
+		assert.Error(t, allocator.Release(ctx, db, "not an ip"))
+		assert.Len(t, allocations.released, 1)
+	})
+}
@@ -1,55 +1,96 @@
 package cidr
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
-	"strings"
 )
 
-func FindAvailableIP(cidr string, allocated []string) (string, error) {
-	allocatedMap := toMap(allocated)
-	ips, _ := cidrIPs(cidr)
-	for _, ip := range ips {
-		if _, found := allocatedMap[ip]; !found {
-			return ip, nil
+// FindAvailableIP returns the lowest address in the IPv4 network that is not allocated,
+// skipping the network and broadcast addresses.
+func FindAvailableIP(network string, allocated map[string]bool) (string, error) {
+	first, broadcast, err := ipv4Range(network)
+	if err != nil {
+		return "", err
+	}
+
+	for n := first + 1; n < broadcast; n++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, n)
+		if !allocated[ip.String()] {
+			return ip.String(), nil
 		}
 	}
-	return "", fmt.Errorf("no available IPs in range %v", cidr)
+
+	return "", fmt.Errorf("%w in range %s", ErrNoAvailableIP, network)
+}
+
+// FirstIP returns the first address after the network address of the IPv4 network.
+func FirstIP(network string) (string, error) {
+	first, broadcast, err := ipv4Range(network)
+	if err != nil {
+		return "", err
+	}
+
+	if broadcast-first < 2 {
+		return "", fmt.Errorf("%s has no host addresses", network)
+	}
+
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, first+1)
+	return ip.String(), nil
 }
 
-func toMap(strings []string) map[string]struct{} {
-	m := make(map[string]struct{})
-	for _, s := range strings {
-		m[s] = struct{}{}
+// ipv4Range returns the network and broadcast addresses of the IPv4 network as integers.
+func ipv4Range(network string) (uint32, uint32, error) {
+	_, ipnet, err := net.ParseCIDR(network)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ip := ipnet.IP.To4()
+	if ip == nil {
+		return 0, 0, fmt.Errorf("%s is not an IPv4 network", network)
 	}
-	return m
+
+	ones, bits := ipnet.Mask.Size()
+	first := binary.BigEndian.Uint32(ip)
+	return first, first + 1<<uint(bits-ones) - 1, nil
 }
 
-func cidrIPs(cidr string) ([]string, error) {
-	ip, ipnet, err := net.ParseCIDR(cidr)
+// MapIP returns the address in toCIDR with the same host part as ip has in fromCIDR,
+// e.g. 10.0.0.5 in 10.0.0.0/24 maps to fd00::5 in fd00::/64.
+func MapIP(ip, fromCIDR, toCIDR string) (string, error) {
+	_, from, err := net.ParseCIDR(fromCIDR)
+	if err != nil {
+		return "", err
+	}
 
+	_, to, err := net.ParseCIDR(toCIDR)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	var ips []string
-	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); inc(ip) {
-		ips = append(ips, ip.String())
+	addr := net.ParseIP(ip)
+	if addr == nil || !from.Contains(addr) {
+		return "", fmt.Errorf("%s is not an address in %s", ip, fromCIDR)
 	}
 
-	if strings.HasSuffix(cidr, "/32") {
-		return ips, nil
-	} else {
-		//remove network address and broadcast address
-		return ips[1 : len(ips)-1], nil
+	fromOnes, fromBits := from.Mask.Size()
+	toOnes, toBits := to.Mask.Size()
+	if toBits-toOnes < fromBits-fromOnes {
+		return "", fmt.Errorf("%s is too small to map addresses from %s", toCIDR, fromCIDR)
 	}
-}
 
-func inc(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
-		}
+	if fromBits == net.IPv4len*8 {
+		addr = addr.To4()
 	}
+
+	mapped := make(net.IP, len(to.IP))
+	copy(mapped, to.IP)
+	for i := 1; i <= len(addr); i++ {
+		mapped[len(mapped)-i] |= addr[len(addr)-i] &^ from.Mask[len(from.Mask)-i]
+	}
+
+	return mapped.String(), nil
 }
@@ -7,21 +7,20 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func TestFindAvailableIP(t *testing.T) {
-	t.Run("finds the lowest ip address in range", func(t *testing.T) {
-		availableIP, err := cidr.FindAvailableIP("10.0.0.0/30", []string{"10.0.0.2"})
+func TestMapIP(t *testing.T) {
+	t.Run("maps host part of ipv4 address into ipv6 prefix", func(t *testing.T) {
+		mapped, err := cidr.MapIP("10.255.241.5", "10.255.240.0/21", "fd75:568f:f19::/64")
 		assert.NoError(t, err)
-		assert.Equal(t, "10.0.0.1", availableIP)
+		assert.Equal(t, "fd75:568f:f19::105", mapped)
 	})
 
-	t.Run("fills gaps", func(t *testing.T) {
-		availableIP, err := cidr.FindAvailableIP("10.0.0.0/30", []string{"10.0.0.1", "10.0.0.3"})
-		assert.NoError(t, err)
-		assert.Equal(t, "10.0.0.2", availableIP)
+	t.Run("returns an error if ip is outside network", func(t *testing.T) {
+		_, err := cidr.MapIP("10.0.0.1", "10.255.240.0/21", "fd75:568f:f19::/64")
+		assert.Error(t, err)
 	})
 
-	t.Run("returns an error if noone is available", func(t *testing.T) {
-		_, err := cidr.FindAvailableIP("10.0.0.1/30", []string{"10.0.0.1", "10.0.0.2"})
+	t.Run("returns an error if target network is too small", func(t *testing.T) {
+		_, err := cidr.MapIP("10.255.240.5", "10.255.240.0/21", "fd75:568f:f19::/120")
 		assert.Error(t, err)
 	})
 }
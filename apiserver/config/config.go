@@ -5,22 +5,28 @@ import (
 	"strings"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/nais/device/pkg/azure"
 )
 
 type Config struct {
 	DbConnDSN                     string
+	InMemoryDatabase              bool
 	BootstrapApiCredentials       string
 	BindAddress                   string
+	GRPCBindAddress               string
 	ConfigDir                     string
 	PrivateKeyPath                string
 	WireGuardConfigPath           string
 	DevMode                       bool
 	Endpoint                      string
+	IdentityProvider              string
 	Azure                         Azure
+	OIDC                          OIDC
 	PrometheusAddr                string
 	PrometheusPublicKey           string
 	PrometheusTunnelIP            string
 	CredentialEntries             []string
+	AdminCredentialEntries        []string
 	BootstrapAPIURL               string
 	LogLevel                      string
 	TokenValidator                jwt.Keyfunc
@@ -29,17 +35,52 @@ type Config struct {
 	JitaUsername                  string
 	JitaPassword                  string
 	JitaUrl                       string
+	TunnelCIDR                    string
+	TunnelIPv6Prefix              string
+	RequireEnrollmentApproval     bool
+	EnrollmentApprovalRules       []string
+	MaxDevicesPerUser             int
+	AuditSinks                    []string
 }
 
+const (
+	IdentityProviderAzure = "azure"
+	IdentityProviderOIDC  = "oidc"
+)
+
 type Azure struct {
 	ClientID     string
 	DiscoveryURL string
 	ClientSecret string
+	TenantID     string
+}
+
+// OIDC configures a generic OpenID Connect identity provider. Empty claim names use the provider defaults.
+type OIDC struct {
+	IssuerURL     string
+	ClientID      string
+	ClientSecret  string
+	Scopes        []string
+	UsernameClaim string
+	ObjectIDClaim string
+	GroupsClaim   string
 }
 
 func (c *Config) Credentials() (map[string]string, error) {
+	return parseCredentials(c.CredentialEntries)
+}
+
+// AdminCredentials returns the credentials allowed to use the admin API, or nil if none are configured.
+func (c *Config) AdminCredentials() (map[string]string, error) {
+	if len(c.AdminCredentialEntries) == 0 {
+		return nil, nil
+	}
+	return parseCredentials(c.AdminCredentialEntries)
+}
+
+func parseCredentials(entries []string) (map[string]string, error) {
 	credentials := make(map[string]string)
-	for _, key := range c.CredentialEntries {
+	for _, key := range entries {
 		entry := strings.Split(key, ":")
 		if len(entry) > 2 {
 			return nil, fmt.Errorf("invalid format on credentials, should be comma-separated entries on format 'user:key'")
@@ -51,10 +92,22 @@ func (c *Config) Credentials() (map[string]string, error) {
 	return credentials, nil
 }
 
+// AuditSinkDatabase stores audit events in the apiserver database, where they can be queried through the admin API.
+const AuditSinkDatabase = "database"
+
 func DefaultConfig() Config {
 	return Config{
-		BindAddress:    "10.255.240.1:80",
-		ConfigDir:      "/usr/local/etc/naisdevice/",
-		PrometheusAddr: ":3000",
+		BindAddress:      "10.255.240.1:80",
+		GRPCBindAddress:  "10.255.240.1:8099",
+		ConfigDir:        "/usr/local/etc/naisdevice/",
+		PrometheusAddr:   ":3000",
+		IdentityProvider: IdentityProviderAzure,
+		Azure: Azure{
+			TenantID: azure.TenantID,
+		},
+		OIDC: OIDC{
+			Scopes: []string{"openid", "profile", "email", "offline_access"},
+		},
+		AuditSinks: []string{AuditSinkDatabase},
 	}
 }
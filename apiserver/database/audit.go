@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nais/device/pkg/audit"
+)
+
+const (
+	defaultAuditEventLimit = 100
+	maxAuditEventLimit     = 1000
+)
+
+// AddAuditEvent stores the event. It has the signature of an audit.SinkFunc.
+func (d *APIServerDB) AddAuditEvent(ctx context.Context, e audit.Event) error {
+	details, err := json.Marshal(e.Details)
+	if err != nil {
+		return fmt.Errorf("encoding details: %w", err)
+	}
+
+	if e.Details == nil {
+		details = []byte("{}")
+	}
+
+	statement := `
+INSERT INTO audit_event (time, component, action, outcome, actor, target, details)
+VALUES ($1, $2, $3, $4, $5, $6, $7);`
+
+	_, err = d.Conn.ExecContext(ctx, statement, e.Time, e.Component, e.Action, e.Outcome, e.Actor, e.Target, details)
+	if err != nil {
+		return fmt.Errorf("inserting audit event: %w", err)
+	}
+
+	return nil
+}
+
+// ReadAuditEvents returns the events matching the query, newest first.
+// At most 100 events are returned unless the query sets another limit, up to 1000.
+func (d *APIServerDB) ReadAuditEvents(ctx context.Context, q audit.Query) ([]audit.Event, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultAuditEventLimit
+	}
+	if limit > maxAuditEventLimit {
+		limit = maxAuditEventLimit
+	}
+
+	var since, until *time.Time
+	if !q.Since.IsZero() {
+		since = &q.Since
+	}
+	if !q.Until.IsZero() {
+		until = &q.Until
+	}
+
+	query := `
+SELECT id, time, component, action, outcome, actor, target, details
+FROM audit_event
+WHERE ($1 = '' OR action = $1)
+  AND ($2 = '' OR actor = $2)
+  AND ($3 = '' OR target = $3)
+  AND ($4::timestamptz IS NULL OR time >= $4)
+  AND ($5::timestamptz IS NULL OR time < $5)
+ORDER BY time DESC, id DESC
+LIMIT $6;`
+
+	rows, err := d.Conn.QueryContext(ctx, query, q.Action, q.Actor, q.Target, since, until, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make([]audit.Event, 0)
+	for rows.Next() {
+		var e audit.Event
+		var details []byte
+		err := rows.Scan(&e.ID, &e.Time, &e.Component, &e.Action, &e.Outcome, &e.Actor, &e.Target, &details)
+		if err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+
+		if err := json.Unmarshal(details, &e.Details); err != nil {
+			return nil, fmt.Errorf("decoding details: %w", err)
+		}
+
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading rows: %w", err)
+	}
+
+	return events, nil
+}
@@ -3,24 +3,71 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
-	"sync"
 	"time"
 
 	_ "github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/dialers/postgres"
 	_ "github.com/lib/pq"
 	"github.com/nais/device/apiserver/cidr"
 	"github.com/nais/device/pkg/pb"
+	"github.com/nais/device/pkg/session"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
-	TunnelCidr = "10.255.240.0/21"
+	DefaultTunnelCidr       = "10.255.240.0/21"
+	DefaultTunnelIPv6Prefix = "fd75:568f:f19::/64"
 )
 
+// Config configures the tunnel addresses given to devices and gateways by APIServerDB and MemoryDB.
+type Config struct {
+	// TunnelCIDR is the IPv4 tunnel network. The first address is the apiserver's, and the rest are allocated to
+	// devices and gateways. Addresses are stored, so changing the network does not re-address existing devices and
+	// gateways.
+	TunnelCIDR string
+	// TunnelIPv6Prefix is the IPv6 ULA prefix of the tunnel network, and must be a /64. New devices and gateways are
+	// given the address in this prefix with the same host part as their IPv4 tunnel address, so 10.255.240.5
+	// corresponds to <prefix>::5. The address is stored, so changing the prefix does not re-address existing devices
+	// and gateways. IPv6 is disabled if empty.
+	TunnelIPv6Prefix string
+	// MaxDevicesPerUser is how many devices a user may enroll, unlimited if zero.
+	MaxDevicesPerUser int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		TunnelCIDR:       DefaultTunnelCidr,
+		TunnelIPv6Prefix: DefaultTunnelIPv6Prefix,
+	}
+}
+
+// APIServerIP returns the tunnel address of the apiserver, which is never allocated to devices or gateways.
+func (c Config) APIServerIP() (string, error) {
+	return cidr.FirstIP(c.TunnelCIDR)
+}
+
 type APIServerDB struct {
-	Conn *sql.DB
+	Conn   *sql.DB
+	Config Config
+}
+
+var (
+	ErrDeviceOwnedByOtherUser = errors.New("device is enrolled by another user")
+	ErrDeviceLimitReached     = errors.New("user has reached the maximum number of devices")
+)
+
+// OwnershipTransfer records that a device was transferred from one user to another.
+type OwnershipTransfer struct {
+	DeviceID      int       `json:"deviceID"`
+	Serial        string    `json:"serial"`
+	Platform      string    `json:"platform"`
+	FromUsername  string    `json:"fromUsername"`
+	ToUsername    string    `json:"toUsername"`
+	TransferredBy string    `json:"transferredBy"`
+	Created       time.Time `json:"created"`
 }
 
 type Device struct {
@@ -32,8 +79,11 @@ type Device struct {
 	Healthy        *bool  `json:"isHealthy"`
 	PublicKey      string `json:"publicKey"`
 	IP             string `json:"ip"`
+	IPv6           string `json:"ipv6"`
 	Username       string `json:"username"`
 	Platform       string `json:"platform"`
+	Name           string `json:"name"`
+	Disabled       bool   `json:"disabled"`
 }
 
 type SessionInfo struct {
@@ -42,19 +92,88 @@ type SessionInfo struct {
 	Device   *Device
 	Groups   []string
 	ObjectId string
+	// RefreshToken is used to renew the session with the identity provider, and is never sent to the device.
+	RefreshToken string `json:"-"`
+}
+
+func (d *Device) Protobuf() *pb.Device {
+	return &pb.Device{
+		Id:        int64(d.ID),
+		Serial:    d.Serial,
+		Psk:       d.PSK,
+		PublicKey: d.PublicKey,
+		Ip:        d.IP,
+		Ipv6:      d.IPv6,
+		Username:  d.Username,
+		Platform:  d.Platform,
+	}
+}
+
+// allocateIPs allocates an IPv4 tunnel address in the transaction, along with the IPv6 tunnel address stored with it.
+func (c Config) allocateIPs(ctx context.Context, tx *sql.Tx) (string, string, error) {
+	allocator, err := cidr.NewAllocator(c.TunnelCIDR)
+	if err != nil {
+		return "", "", fmt.Errorf("tunnel network: %w", err)
+	}
+
+	ip, err := allocator.Allocate(ctx, tx)
+	if err != nil {
+		return "", "", err
+	}
+
+	ipv6, err := c.allocateIPv6(ip)
+	if err != nil {
+		return "", "", err
+	}
+
+	return ip, ipv6, nil
+}
+
+// releaseIP makes the tunnel address available for allocation again when the transaction is committed.
+func (c Config) releaseIP(ctx context.Context, tx *sql.Tx, ip string) error {
+	allocator, err := cidr.NewAllocator(c.TunnelCIDR)
+	if err != nil {
+		return fmt.Errorf("tunnel network: %w", err)
+	}
+
+	return allocator.Release(ctx, tx, ip)
+}
+
+// allocateIPv6 returns the IPv6 tunnel address to store along with a newly allocated IPv4 tunnel address,
+// or an empty string if IPv6 is disabled.
+func (c Config) allocateIPv6(ip string) (string, error) {
+	if len(c.TunnelIPv6Prefix) == 0 {
+		return "", nil
+	}
+
+	ipv6, err := cidr.MapIP(ip, c.TunnelCIDR, c.TunnelIPv6Prefix)
+	if err != nil {
+		return "", fmt.Errorf("mapping %s to IPv6 tunnel address: %w", ip, err)
+	}
+
+	return ipv6, nil
 }
 
+// ipv6 returns the stored IPv6 tunnel address, or an empty string if IPv6 is disabled.
+func (c Config) ipv6(stored string) string {
+	if len(c.TunnelIPv6Prefix) == 0 {
+		return ""
+	}
+	return stored
+}
+
+// Expired reports whether the session has expired. See session.Expired.
 func (si SessionInfo) Expired() bool {
-	return time.Unix(si.Expiry, 0).After(time.Now())
+	return session.Expired(si.Expiry, time.Now())
 }
 
-func New(dsn, driver string) (*APIServerDB, error) {
+func New(dsn, driver string, cfg Config) (*APIServerDB, error) {
 	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("connecting to database: %s", err)
 	}
 
-	apiServerDB := APIServerDB{Conn: db}
+	apiServerDB := APIServerDB{Conn: db, Config: cfg}
 
 	ctx := context.Background()
 	err = apiServerDB.Migrate(ctx)
@@ -62,14 +181,101 @@ func New(dsn, driver string) (*APIServerDB, error) {
 		return nil, fmt.Errorf("migrating database: %w", err)
 	}
 
+	err = apiServerDB.reserveAPIServerIP(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reserving apiserver tunnel address: %w", err)
+	}
+
+	err = apiServerDB.assignTunnelIPv6(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("assigning IPv6 tunnel addresses: %w", err)
+	}
+
 	return &apiServerDB, nil
 }
 
+// reserveAPIServerIP keeps the apiserver address in the configured tunnel network from being allocated.
+// The address in the default network is also reserved by migration.
+func (d *APIServerDB) reserveAPIServerIP(ctx context.Context) error {
+	ip, err := d.Config.APIServerIP()
+	if err != nil {
+		return err
+	}
+
+	statement := `
+INSERT INTO ip_allocation (ip)
+VALUES ($1::inet)
+ON CONFLICT DO NOTHING;`
+
+	_, err = d.Conn.ExecContext(ctx, statement, ip)
+	return err
+}
+
+// assignTunnelIPv6 stores an IPv6 tunnel address for the devices and gateways that do not have one, which are those
+// enrolled before IPv6 addresses were stored, or while IPv6 was disabled. Rows whose IPv4 tunnel address can not be
+// mapped are left without an IPv6 address.
+func (d *APIServerDB) assignTunnelIPv6(ctx context.Context) error {
+	if len(d.Config.TunnelIPv6Prefix) == 0 {
+		return nil
+	}
+
+	for _, table := range []string{"device", "gateway"} {
+		query := fmt.Sprintf(`
+SELECT id, ip
+FROM %s
+WHERE ipv6 IS NULL AND ip IS NOT NULL AND ip <> '';`, table)
+
+		rows, err := d.Conn.QueryContext(ctx, query)
+		if err != nil {
+			return fmt.Errorf("querying %s addresses: %w", table, err)
+		}
+
+		addresses := make(map[int]string)
+		for rows.Next() {
+			var id int
+			var ip string
+			if err := rows.Scan(&id, &ip); err != nil {
+				rows.Close()
+				return fmt.Errorf("scanning row: %w", err)
+			}
+			addresses[id] = ip
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("reading rows: %w", err)
+		}
+
+		statement := fmt.Sprintf(`
+UPDATE %s
+SET ipv6 = $1
+WHERE id = $2 AND ipv6 IS NULL;`, table)
+
+		for id, ip := range addresses {
+			ipv6, err := d.Config.allocateIPv6(ip)
+			if err != nil {
+				log.Errorf("Assigning IPv6 tunnel address to %s with id %d: %v", table, id, err)
+				continue
+			}
+
+			if _, err := d.Conn.ExecContext(ctx, statement, ipv6, id); err != nil {
+				return fmt.Errorf("storing %s IPv6 address: %w", table, err)
+			}
+		}
+
+		if len(addresses) > 0 {
+			log.Infof("Assigned IPv6 tunnel addresses to %d existing %s rows", len(addresses), table)
+		}
+	}
+
+	return nil
+}
+
 func (d *APIServerDB) ReadDevices() ([]Device, error) {
 	ctx := context.Background()
 
 	query := `
-SELECT public_key, username, ip, psk, serial, platform, healthy, last_updated, kolide_last_seen
+SELECT id, public_key, username, ip, COALESCE(ipv6, ''), psk, serial, platform, healthy, last_updated, kolide_last_seen, name, disabled
 FROM device;`
 
 	rows, err := d.Conn.QueryContext(ctx, query)
@@ -88,12 +294,14 @@ FROM device;`
 	for rows.Next() {
 		var device Device
 
-		err := rows.Scan(&device.PublicKey, &device.Username, &device.IP, &device.PSK, &device.Serial, &device.Platform, &device.Healthy, &device.LastUpdated, &device.KolideLastSeen)
+		err := rows.Scan(&device.ID, &device.PublicKey, &device.Username, &device.IP, &device.IPv6, &device.PSK, &device.Serial, &device.Platform, &device.Healthy, &device.LastUpdated, &device.KolideLastSeen, &device.Name, &device.Disabled)
 
 		if err != nil {
 			return nil, fmt.Errorf("scanning row: %s", err)
 		}
 
+		device.IPv6 = d.Config.ipv6(device.IPv6)
+
 		devices = append(devices, device)
 	}
 
@@ -132,15 +340,18 @@ func (d *APIServerDB) UpdateDeviceStatus(devices []Device) error {
 	return nil
 }
 
-var mux sync.Mutex
+func (d *APIServerDB) UpdateGateway(ctx context.Context, name string, routes []*pb.Route, accessGroupIDs []string, requiresPrivilegedAccess bool) error {
+	routePolicies, err := json.Marshal(routes)
+	if err != nil {
+		return fmt.Errorf("marshaling route policies: %w", err)
+	}
 
-func (d *APIServerDB) UpdateGateway(ctx context.Context, name string, routes, accessGroupIDs []string, requiresPrivilegedAccess bool) error {
 	statement := `
 UPDATE gateway 
-SET routes = $1, access_group_ids = $2, requires_privileged_access = $3
-WHERE name = $4;`
+SET routes = $1, access_group_ids = $2, requires_privileged_access = $3, route_policies = $4
+WHERE name = $5;`
 
-	_, err := d.Conn.ExecContext(ctx, statement, strings.Join(routes, ","), strings.Join(accessGroupIDs, ","), requiresPrivilegedAccess, name)
+	_, err = d.Conn.ExecContext(ctx, statement, strings.Join(pb.RouteCIDRs(routes), ","), strings.Join(accessGroupIDs, ","), requiresPrivilegedAccess, string(routePolicies), name)
 	if err != nil {
 		return fmt.Errorf("updating gateway: %w", err)
 	}
@@ -150,30 +361,23 @@ WHERE name = $4;`
 }
 
 func (d *APIServerDB) AddGateway(ctx context.Context, name, endpoint, publicKey string) error {
-	mux.Lock()
-	defer mux.Unlock()
-
-	tx, err := d.Conn.Begin()
+	tx, err := d.Conn.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("start transaction: %w", err)
 	}
 
 	defer tx.Rollback()
-	takenIps, err := d.readExistingIPs()
-	if err != nil {
-		return fmt.Errorf("reading existing ips: %w", err)
-	}
 
-	availableIp, err := cidr.FindAvailableIP(TunnelCidr, takenIps)
+	availableIp, ipv6, err := d.Config.allocateIPs(ctx, tx)
 	if err != nil {
-		return fmt.Errorf("finding available ip: %w", err)
+		return fmt.Errorf("allocating ip: %w", err)
 	}
 
 	statement := `
-INSERT INTO gateway (name, endpoint, public_key, ip)
-VALUES ($1, $2, $3, $4);`
+INSERT INTO gateway (name, endpoint, public_key, ip, ipv6)
+VALUES ($1, $2, $3, $4, NULLIF($5, ''));`
 
-	_, err = d.Conn.ExecContext(ctx, statement, name, endpoint, publicKey, availableIp)
+	_, err = tx.ExecContext(ctx, statement, name, endpoint, publicKey, availableIp, ipv6)
 	if err != nil {
 		return fmt.Errorf("inserting new gateway, statement: '%s', error: %w", statement, err)
 	}
@@ -185,39 +389,365 @@ VALUES ($1, $2, $3, $4);`
 	return nil
 }
 
+// RemoveGateway deletes the gateway and releases its tunnel address.
+func (d *APIServerDB) RemoveGateway(ctx context.Context, name string) error {
+	tx, err := d.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	statement := `
+DELETE FROM gateway
+WHERE name = $1
+RETURNING ip;`
+
+	var ip string
+	err = tx.QueryRowContext(ctx, statement, name).Scan(&ip)
+	if err != nil {
+		return fmt.Errorf("deleting gateway: %w", err)
+	}
+
+	if err := d.Config.releaseIP(ctx, tx, ip); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	log.Infof("Removed gateway: %s", name)
+	return nil
+}
+
+// AddDevice adds a new device, or updates the public key of the user's existing device with the same serial and
+// platform. Only new devices keep the tunnel address allocated for them. A device enrolled by another user must be
+// transferred by an administrator, and users may not add more than MaxDevicesPerUser devices.
 func (d *APIServerDB) AddDevice(ctx context.Context, device Device) error {
-	mux.Lock()
-	defer mux.Unlock()
+	tx, err := d.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+
+	defer tx.Rollback()
 
-	tx, err := d.Conn.Begin()
+	if err := d.lockUserDevices(ctx, tx, device.Username); err != nil {
+		return err
+	}
+
+	rekeyed, err := d.rekeyDevice(ctx, tx, device)
+	if err != nil {
+		return err
+	}
+
+	if !rekeyed {
+		if err := d.insertDevice(ctx, tx, device); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commiting transaction: %w", err)
+	}
+	log.Infof("Added or updated device: %+v", device)
+	return nil
+}
+
+// rekeyDevice updates the public key of the device if it is already enrolled, in which case it keeps its address.
+func (d *APIServerDB) rekeyDevice(ctx context.Context, tx *sql.Tx, device Device) (bool, error) {
+	var username string
+	query := `
+SELECT username
+FROM device
+WHERE serial = $1 AND platform = $2
+FOR UPDATE;`
+	err := tx.QueryRowContext(ctx, query, device.Serial, device.Platform).Scan(&username)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("reading device: %w", err)
+	case username != device.Username:
+		return false, ErrDeviceOwnedByOtherUser
+	}
+
+	statement := `
+UPDATE device
+SET public_key = $3
+WHERE serial = $1 AND platform = $2;`
+	if _, err := tx.ExecContext(ctx, statement, device.Serial, device.Platform, device.PublicKey); err != nil {
+		return false, fmt.Errorf("updating device: %w", err)
+	}
+
+	return true, nil
+}
+
+// insertDevice allocates addresses for a new device and inserts it, unless a concurrent enrollment of the same
+// device got there first, in which case that device is updated instead.
+func (d *APIServerDB) insertDevice(ctx context.Context, tx *sql.Tx, device Device) error {
+	ip, ipv6, err := d.Config.allocateIPs(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("allocating ip: %w", err)
+	}
+
+	// The update is skipped if the device belongs to another user, in which case no row is returned.
+	var storedIP string
+	statement := `
+INSERT INTO device (serial, username, public_key, ip, ipv6, healthy, psk, platform)
+VALUES ($1, $2, $3, $4, NULLIF($5, ''), false, '', $6)
+ON CONFLICT (serial, platform) DO UPDATE SET public_key = EXCLUDED.public_key
+WHERE device.username = EXCLUDED.username
+RETURNING ip;`
+	err = tx.QueryRowContext(ctx, statement, device.Serial, device.Username, device.PublicKey, ip, ipv6, device.Platform).Scan(&storedIP)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrDeviceOwnedByOtherUser
+	case err != nil:
+		return fmt.Errorf("inserting or updating device: %w", err)
+	case storedIP != ip:
+		// The user's existing device was updated, and keeps its address.
+		if err := d.Config.releaseIP(ctx, tx, ip); err != nil {
+			return err
+		}
+	default:
+		if err := d.checkDeviceLimit(ctx, tx, device.Username); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lockUserDevices serializes adding devices for the user until the transaction ends, so that concurrent enrollments
+// cannot both pass the device limit.
+func (d *APIServerDB) lockUserDevices(ctx context.Context, tx *sql.Tx, username string) error {
+	if d.Config.MaxDevicesPerUser <= 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1));", username); err != nil {
+		return fmt.Errorf("locking devices of %s: %w", username, err)
+	}
+
+	return nil
+}
+
+// checkDeviceLimit returns ErrDeviceLimitReached if the user has more than MaxDevicesPerUser devices, including the
+// device just inserted in the transaction.
+func (d *APIServerDB) checkDeviceLimit(ctx context.Context, tx *sql.Tx, username string) error {
+	if d.Config.MaxDevicesPerUser <= 0 {
+		return nil
+	}
+
+	var devices int
+	query := `
+SELECT count(*)
+FROM device
+WHERE username = $1;`
+	if err := tx.QueryRowContext(ctx, query, username).Scan(&devices); err != nil {
+		return fmt.Errorf("counting devices: %w", err)
+	}
+
+	if devices > d.Config.MaxDevicesPerUser {
+		return ErrDeviceLimitReached
+	}
+
+	return nil
+}
+
+// TransferDevice gives the device to another user, recording who transferred it. The device limit does not apply,
+// as transfers are made by administrators.
+func (d *APIServerDB) TransferDevice(ctx context.Context, deviceID int, username, transferredBy string) error {
+	tx, err := d.Conn.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("start transaction: %w", err)
 	}
 
 	defer tx.Rollback()
-	ips, err := d.readExistingIPs()
+
+	var serial, platform, owner string
+	query := `
+SELECT serial, platform, username
+FROM device
+WHERE id = $1
+FOR UPDATE;`
+	err = tx.QueryRowContext(ctx, query, deviceID).Scan(&serial, &platform, &owner)
 	if err != nil {
-		return fmt.Errorf("reading existing ips: %w", err)
+		return fmt.Errorf("reading device: %w", err)
 	}
 
-	ip, err := cidr.FindAvailableIP(TunnelCidr, ips)
+	if owner == username {
+		return nil
+	}
+
+	statement := `
+UPDATE device
+SET username = $1
+WHERE id = $2;`
+	if _, err := tx.ExecContext(ctx, statement, username, deviceID); err != nil {
+		return fmt.Errorf("updating device: %w", err)
+	}
+
+	statement = `
+INSERT INTO device_ownership_transfer (device_id, serial, platform, from_username, to_username, transferred_by)
+VALUES ($1, $2, $3, $4, $5, $6);`
+	_, err = tx.ExecContext(ctx, statement, deviceID, serial, platform, owner, username, transferredBy)
+	if err != nil {
+		return fmt.Errorf("recording transfer: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commiting transaction: %w", err)
+	}
+	log.Infof("Device with id %d transferred from %s to %s by %s", deviceID, owner, username, transferredBy)
+	return nil
+}
+
+// ReadOwnershipTransfers returns the ownership transfers of the device, oldest first.
+func (d *APIServerDB) ReadOwnershipTransfers(ctx context.Context, deviceID int) ([]OwnershipTransfer, error) {
+	query := `
+SELECT device_id, serial, platform, from_username, to_username, transferred_by, created
+FROM device_ownership_transfer
+WHERE device_id = $1
+ORDER BY created;`
+
+	rows, err := d.Conn.QueryContext(ctx, query, deviceID)
 	if err != nil {
-		return fmt.Errorf("finding available ip: %w", err)
+		return nil, fmt.Errorf("querying transfers: %w", err)
 	}
+	defer rows.Close()
+
+	transfers := make([]OwnershipTransfer, 0)
+	for rows.Next() {
+		var t OwnershipTransfer
+		if err := rows.Scan(&t.DeviceID, &t.Serial, &t.Platform, &t.FromUsername, &t.ToUsername, &t.TransferredBy, &t.Created); err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+		transfers = append(transfers, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading rows: %w", err)
+	}
+
+	return transfers, nil
+}
+
+// RemoveDevice deletes the device along with its sessions, and releases its tunnel address.
+func (d *APIServerDB) RemoveDevice(ctx context.Context, deviceID int) error {
+	tx, err := d.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("start transaction: %w", err)
+	}
+
+	defer tx.Rollback()
 
 	statement := `
-INSERT INTO device (serial, username, public_key, ip, healthy, psk, platform)
-VALUES ($1, $2, $3, $4, false, '', $5)
-ON CONFLICT(serial, platform) DO UPDATE SET username = $2, public_key = $3;`
-	_, err = tx.ExecContext(ctx, statement, device.Serial, device.Username, device.PublicKey, ip, device.Platform)
+DELETE FROM session
+WHERE device_id = $1;`
+	_, err = tx.ExecContext(ctx, statement, deviceID)
 	if err != nil {
-		return fmt.Errorf("inserting new device: %w", err)
+		return fmt.Errorf("deleting device sessions: %w", err)
+	}
+
+	statement = `
+DELETE FROM device
+WHERE id = $1
+RETURNING ip;`
+
+	var ip string
+	err = tx.QueryRowContext(ctx, statement, deviceID).Scan(&ip)
+	if err != nil {
+		return fmt.Errorf("deleting device: %w", err)
+	}
+
+	if err := d.Config.releaseIP(ctx, tx, ip); err != nil {
+		return err
 	}
 
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("commiting transaction: %w", err)
 	}
-	log.Infof("Added or updated device: %+v", device)
+	log.Infof("Removed device with id: %d", deviceID)
+	return nil
+}
+
+// SetDeviceDisabled disables or enables the device. Disabled devices are left out of all WireGuard configuration.
+func (d *APIServerDB) SetDeviceDisabled(ctx context.Context, deviceID int, disabled bool) error {
+	statement := `
+UPDATE device
+SET disabled = $1
+WHERE id = $2;`
+
+	return d.updateOne(ctx, statement, disabled, deviceID)
+}
+
+// SetDevicePublicKey replaces the WireGuard public key of the device.
+func (d *APIServerDB) SetDevicePublicKey(ctx context.Context, deviceID int, publicKey string) error {
+	statement := `
+UPDATE device
+SET public_key = $1
+WHERE id = $2;`
+
+	return d.updateOne(ctx, statement, publicKey, deviceID)
+}
+
+func (d *APIServerDB) SetDeviceName(ctx context.Context, deviceID int, name string) error {
+	statement := `
+UPDATE device
+SET name = $1
+WHERE id = $2;`
+
+	return d.updateOne(ctx, statement, name, deviceID)
+}
+
+// SetGatewayDisabled disables or enables the gateway. Disabled gateways are left out of all WireGuard configuration.
+func (d *APIServerDB) SetGatewayDisabled(ctx context.Context, name string, disabled bool) error {
+	statement := `
+UPDATE gateway
+SET disabled = $1
+WHERE name = $2;`
+
+	return d.updateOne(ctx, statement, disabled, name)
+}
+
+// SetGatewayPublicKey replaces the WireGuard public key of the gateway.
+func (d *APIServerDB) SetGatewayPublicKey(ctx context.Context, name, publicKey string) error {
+	statement := `
+UPDATE gateway
+SET public_key = $1
+WHERE name = $2;`
+
+	return d.updateOne(ctx, statement, publicKey, name)
+}
+
+// RenameGateway changes the name of the gateway. The gateway authenticates with its name, so the admin API refuses
+// to rename gateways that still have credentials or an entry in the gateway config bucket under the old name.
+func (d *APIServerDB) RenameGateway(ctx context.Context, name, newName string) error {
+	statement := `
+UPDATE gateway
+SET name = $1
+WHERE name = $2;`
+
+	return d.updateOne(ctx, statement, newName, name)
+}
+
+// updateOne executes the statement, returning sql.ErrNoRows if no rows were affected.
+func (d *APIServerDB) updateOne(ctx context.Context, statement string, args ...interface{}) error {
+	result, err := d.Conn.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return fmt.Errorf("executing update: %w", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("executing update: %w", err)
+	}
+
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+
 	return nil
 }
 
@@ -225,37 +755,41 @@ func (d *APIServerDB) ReadDevice(publicKey string) (*Device, error) {
 	ctx := context.Background()
 
 	query := `
-SELECT id, serial, username, psk, platform, last_updated, kolide_last_seen, healthy, public_key, ip
+SELECT id, serial, username, psk, platform, last_updated, kolide_last_seen, healthy, public_key, ip, COALESCE(ipv6, ''), name, disabled
   FROM device
  WHERE public_key = $1;`
 
 	row := d.Conn.QueryRowContext(ctx, query, publicKey)
 
 	var device Device
-	err := row.Scan(&device.ID, &device.Serial, &device.Username, &device.PSK, &device.Platform, &device.LastUpdated, &device.KolideLastSeen, &device.Healthy, &device.PublicKey, &device.IP)
+	err := row.Scan(&device.ID, &device.Serial, &device.Username, &device.PSK, &device.Platform, &device.LastUpdated, &device.KolideLastSeen, &device.Healthy, &device.PublicKey, &device.IP, &device.IPv6, &device.Name, &device.Disabled)
 
 	if err != nil {
 		return nil, fmt.Errorf("scanning row: %s", err)
 	}
 
+	device.IPv6 = d.Config.ipv6(device.IPv6)
+
 	return &device, nil
 }
 
 func (d *APIServerDB) ReadDeviceById(ctx context.Context, deviceID int) (*Device, error) {
 	query := `
-SELECT id, serial, username, psk, platform, last_updated, kolide_last_seen, healthy, public_key, ip
+SELECT id, serial, username, psk, platform, last_updated, kolide_last_seen, healthy, public_key, ip, COALESCE(ipv6, ''), name, disabled
   FROM device
  WHERE id = $1;`
 
 	row := d.Conn.QueryRowContext(ctx, query, deviceID)
 
 	var device Device
-	err := row.Scan(&device.ID, &device.Serial, &device.Username, &device.PSK, &device.Platform, &device.LastUpdated, &device.KolideLastSeen, &device.Healthy, &device.PublicKey, &device.IP)
+	err := row.Scan(&device.ID, &device.Serial, &device.Username, &device.PSK, &device.Platform, &device.LastUpdated, &device.KolideLastSeen, &device.Healthy, &device.PublicKey, &device.IP, &device.IPv6, &device.Name, &device.Disabled)
 
 	if err != nil {
-		return nil, fmt.Errorf("scanning row: %s", err)
+		return nil, fmt.Errorf("scanning row: %w", err)
 	}
 
+	device.IPv6 = d.Config.ipv6(device.IPv6)
+
 	return &device, nil
 }
 
@@ -263,7 +797,7 @@ func (d *APIServerDB) ReadGateways() ([]pb.Gateway, error) {
 	ctx := context.Background()
 
 	query := `
-SELECT public_key, access_group_ids, endpoint, ip, routes, name, requires_privileged_access
+SELECT public_key, access_group_ids, endpoint, ip, COALESCE(ipv6, ''), routes, name, requires_privileged_access, route_policies, disabled
   FROM gateway;`
 
 	rows, err := d.Conn.QueryContext(ctx, query)
@@ -276,7 +810,8 @@ SELECT public_key, access_group_ids, endpoint, ip, routes, name, requires_privil
 		var gateway pb.Gateway
 		var routes string
 		var accessGroupIDs string
-		err := rows.Scan(&gateway.PublicKey, &accessGroupIDs, &gateway.Endpoint, &gateway.Ip, &routes, &gateway.Name, &gateway.RequiresPrivilegedAccess)
+		var routePolicies string
+		err := rows.Scan(&gateway.PublicKey, &accessGroupIDs, &gateway.Endpoint, &gateway.Ip, &gateway.Ipv6, &routes, &gateway.Name, &gateway.RequiresPrivilegedAccess, &routePolicies, &gateway.Disabled)
 		if err != nil {
 			return nil, fmt.Errorf("scanning gateway: %w", err)
 		}
@@ -285,10 +820,17 @@ SELECT public_key, access_group_ids, endpoint, ip, routes, name, requires_privil
 			gateway.AccessGroupIDs = strings.Split(accessGroupIDs, ",")
 		}
 
+		gateway.Ipv6 = d.Config.ipv6(gateway.Ipv6)
+
 		if len(routes) != 0 {
 			gateway.Routes = strings.Split(routes, ",")
 		}
 
+		gateway.RoutePolicies, err = parseRoutePolicies(gateway.Routes, routePolicies)
+		if err != nil {
+			return nil, fmt.Errorf("parsing route policies for gateway %s: %w", gateway.Name, err)
+		}
+
 		gateways = append(gateways, gateway)
 	}
 
@@ -304,7 +846,7 @@ func (d *APIServerDB) ReadGateway(name string) (*pb.Gateway, error) {
 	ctx := context.Background()
 
 	query := `
-SELECT public_key, access_group_ids, endpoint, ip, routes, name, requires_privileged_access
+SELECT public_key, access_group_ids, endpoint, ip, COALESCE(ipv6, ''), routes, name, requires_privileged_access, route_policies, disabled
   FROM gateway
  WHERE name = $1;`
 
@@ -313,7 +855,8 @@ SELECT public_key, access_group_ids, endpoint, ip, routes, name, requires_privil
 	var gateway pb.Gateway
 	var routes string
 	var accessGroupIDs string
-	err := row.Scan(&gateway.PublicKey, &accessGroupIDs, &gateway.Endpoint, &gateway.Ip, &routes, &gateway.Name, &gateway.RequiresPrivilegedAccess)
+	var routePolicies string
+	err := row.Scan(&gateway.PublicKey, &accessGroupIDs, &gateway.Endpoint, &gateway.Ip, &gateway.Ipv6, &routes, &gateway.Name, &gateway.RequiresPrivilegedAccess, &routePolicies, &gateway.Disabled)
 	if err != nil {
 		return nil, fmt.Errorf("scanning gateway: %w", err)
 	}
@@ -322,40 +865,42 @@ SELECT public_key, access_group_ids, endpoint, ip, routes, name, requires_privil
 		gateway.AccessGroupIDs = strings.Split(accessGroupIDs, ",")
 	}
 
+	gateway.Ipv6 = d.Config.ipv6(gateway.Ipv6)
+
 	if len(routes) != 0 {
 		gateway.Routes = strings.Split(routes, ",")
 	}
 
+	gateway.RoutePolicies, err = parseRoutePolicies(gateway.Routes, routePolicies)
+	if err != nil {
+		return nil, fmt.Errorf("parsing route policies: %w", err)
+	}
+
 	return &gateway, nil
 }
 
-func (d *APIServerDB) readExistingIPs() ([]string, error) {
-	ips := []string{
-		"10.255.240.1", // reserve apiserver ip
-	}
-
-	if devices, err := d.ReadDevices(); err != nil {
-		return nil, fmt.Errorf("reading devices: %w", err)
-	} else {
-		for _, device := range devices {
-			ips = append(ips, device.IP)
+// parseRoutePolicies decodes the route policies stored for a gateway. Gateways that have not
+// been synchronized since route policies were introduced get one unrestricted route per CIDR.
+func parseRoutePolicies(routes []string, routePolicies string) ([]*pb.Route, error) {
+	if len(routePolicies) == 0 {
+		policies := make([]*pb.Route, len(routes))
+		for i, cidr := range routes {
+			policies[i] = &pb.Route{Cidr: cidr}
 		}
+		return policies, nil
 	}
 
-	if gateways, err := d.ReadGateways(); err != nil {
-		return nil, fmt.Errorf("reading gateways: %w", err)
-	} else {
-		for _, gateway := range gateways {
-			ips = append(ips, gateway.Ip)
-		}
+	var policies []*pb.Route
+	if err := json.Unmarshal([]byte(routePolicies), &policies); err != nil {
+		return nil, err
 	}
 
-	return ips, nil
+	return policies, nil
 }
 
 func (d *APIServerDB) ReadDeviceBySerialPlatformUsername(ctx context.Context, serial string, platform string, username string) (*Device, error) {
 	query := `
-SELECT id, username, serial, psk, platform, healthy, last_updated, kolide_last_seen, public_key, ip
+SELECT id, username, serial, psk, platform, healthy, last_updated, kolide_last_seen, public_key, ip, COALESCE(ipv6, ''), name, disabled
   FROM device
  WHERE serial = $1
    AND platform = $2
@@ -365,34 +910,99 @@ SELECT id, username, serial, psk, platform, healthy, last_updated, kolide_last_s
 	var device Device
 	row := d.Conn.QueryRowContext(ctx, query, serial, platform, username)
 
-	err := row.Scan(&device.ID, &device.Username, &device.Serial, &device.PSK, &device.Platform, &device.Healthy, &device.LastUpdated, &device.KolideLastSeen, &device.PublicKey, &device.IP)
+	err := row.Scan(&device.ID, &device.Username, &device.Serial, &device.PSK, &device.Platform, &device.Healthy, &device.LastUpdated, &device.KolideLastSeen, &device.PublicKey, &device.IP, &device.IPv6, &device.Name, &device.Disabled)
 
 	if err != nil {
-		return nil, fmt.Errorf("scanning row: %s", err)
+		return nil, fmt.Errorf("scanning row: %w", err)
 	}
 
+	device.IPv6 = d.Config.ipv6(device.IPv6)
+
 	return &device, nil
 }
 
 func (d *APIServerDB) AddSessionInfo(ctx context.Context, si *SessionInfo) error {
 	query := `
-INSERT INTO session (key, expiry, device_id, groups, object_id)
-             VALUES ($1, $2, $3, $4, $5);
+INSERT INTO session (key, expiry, device_id, groups, object_id, refresh_token)
+             VALUES ($1, $2, $3, $4, $5, $6);
 `
 
-	_, err := d.Conn.ExecContext(ctx, query, si.Key, si.Expiry, si.Device.ID, strings.Join(si.Groups, ","), si.ObjectId)
+	_, err := d.Conn.ExecContext(ctx, query, si.Key, si.Expiry, si.Device.ID, strings.Join(si.Groups, ","), si.ObjectId, si.RefreshToken)
 	if err != nil {
 		return fmt.Errorf("scanning row: %s", err)
 	}
 
-	log.Infof("persisted session: %v", si)
+	log.Infof("persisted session for device with id: %d", si.Device.ID)
 
 	return nil
 }
 
+// RenewSession updates the expiry, groups and refresh token of the session, returning sql.ErrNoRows if it does not exist.
+func (d *APIServerDB) RenewSession(ctx context.Context, si *SessionInfo) error {
+	statement := `
+UPDATE session
+SET expiry = $2, groups = $3, refresh_token = $4
+WHERE key = $1;`
+
+	return d.updateOne(ctx, statement, si.Key, si.Expiry, strings.Join(si.Groups, ","), si.RefreshToken)
+}
+
+// RemoveSession deletes the session with the given key, returning sql.ErrNoRows if there is none.
+func (d *APIServerDB) RemoveSession(ctx context.Context, key string) error {
+	statement := `
+DELETE FROM session
+WHERE key = $1;`
+
+	return d.updateOne(ctx, statement, key)
+}
+
+// RemoveUserSessions deletes all sessions of the user with the given object ID.
+func (d *APIServerDB) RemoveUserSessions(ctx context.Context, objectID string) error {
+	statement := `
+DELETE FROM session
+WHERE object_id = $1;`
+
+	_, err := d.Conn.ExecContext(ctx, statement, objectID)
+	if err != nil {
+		return fmt.Errorf("deleting sessions: %w", err)
+	}
+
+	log.Infof("Removed sessions for user with object id: %s", objectID)
+	return nil
+}
+
+// RemoveDeviceSessions deletes all sessions of the device.
+func (d *APIServerDB) RemoveDeviceSessions(ctx context.Context, deviceID int) error {
+	statement := `
+DELETE FROM session
+WHERE device_id = $1;`
+
+	_, err := d.Conn.ExecContext(ctx, statement, deviceID)
+	if err != nil {
+		return fmt.Errorf("deleting sessions: %w", err)
+	}
+
+	log.Infof("Removed sessions for device with id: %d", deviceID)
+	return nil
+}
+
+// RemoveExpiredSessions deletes all sessions that have expired at the given time, and returns how many were deleted.
+func (d *APIServerDB) RemoveExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	statement := `
+DELETE FROM session
+WHERE expiry <= $1;`
+
+	result, err := d.Conn.ExecContext(ctx, statement, now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("deleting expired sessions: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
 func (d *APIServerDB) ReadSessionInfo(ctx context.Context, key string) (*SessionInfo, error) {
 	query := `
-SELECT key, expiry, device_id, groups, object_id
+SELECT key, expiry, device_id, groups, object_id, refresh_token
 FROM session
 WHERE key = $1;
 `
@@ -402,7 +1012,7 @@ WHERE key = $1;
 	var si SessionInfo
 	var groups string
 	var deviceID int
-	err := row.Scan(&si.Key, &si.Expiry, &deviceID, &groups, &si.ObjectId)
+	err := row.Scan(&si.Key, &si.Expiry, &deviceID, &groups, &si.ObjectId, &si.RefreshToken)
 	if err != nil {
 		return nil, fmt.Errorf("scanning row: %w", err)
 	}
@@ -414,14 +1024,14 @@ WHERE key = $1;
 		return nil, fmt.Errorf("reading device: %w", err)
 	}
 
-	log.Infof("retrieved session info from db: %v", si)
+	log.Infof("retrieved session info from db for device with id: %d", deviceID)
 
 	return &si, nil
 }
 
 func (d *APIServerDB) ReadSessionInfos(ctx context.Context) ([]SessionInfo, error) {
 	query := `
-SELECT key, expiry, device_id, groups, object_id
+SELECT key, expiry, device_id, groups, object_id, refresh_token
 FROM session;
 `
 
@@ -439,7 +1049,7 @@ FROM session;
 		var si SessionInfo
 		var groups string
 		var deviceID int
-		err := rows.Scan(&si.Key, &si.Expiry, &deviceID, &groups, &si.ObjectId)
+		err := rows.Scan(&si.Key, &si.Expiry, &deviceID, &groups, &si.ObjectId, &si.RefreshToken)
 		if err != nil {
 			return nil, fmt.Errorf("scanning row: %w", err)
 		}
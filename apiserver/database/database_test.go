@@ -2,110 +2,508 @@ package database_test
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/nais/device/apiserver/cidr"
 	"github.com/nais/device/apiserver/database"
 	"github.com/nais/device/apiserver/testdatabase"
+	"github.com/nais/device/pkg/audit"
 	"github.com/nais/device/pkg/pb"
 	"github.com/stretchr/testify/assert"
 )
 
-func setup(t *testing.T) *database.APIServerDB {
-	if os.Getenv("RUN_INTEGRATION_TESTS") == "" {
-		t.Skip("Skipping integration test")
-	}
-	ctx := context.Background()
-	db, err := testdatabase.New(ctx, "user=postgres password=postgres host=localhost port=5433 sslmode=disable")
-	if err != nil {
-		t.Fatalf("Instantiating database: %v", err)
-	}
-	return db
+// forEachDatabase runs the test against the in-memory database, and against Postgres when running integration tests.
+func forEachDatabase(t *testing.T, test func(t *testing.T, db database.Repository)) {
+	testdatabase.ForEach(t, database.DefaultConfig(), test)
 }
 
 func TestAddGateway(t *testing.T) {
-	db := setup(t)
+	forEachDatabase(t, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
+		g := pb.Gateway{
+			Endpoint:  "1.2.3.4:56789",
+			PublicKey: "publicKey",
+			Name:      "gateway",
+		}
 
-	ctx := context.Background()
-	g := pb.Gateway{
-		Endpoint:  "1.2.3.4:56789",
-		PublicKey: "publicKey",
-		Name:      "gateway",
-	}
+		t.Run("adding new gateway works", func(t *testing.T) {
+			err := db.AddGateway(ctx, g.Name, g.Endpoint, g.PublicKey)
+			assert.NoError(t, err)
+
+			gateway, err := db.ReadGateway(g.Name)
+			assert.NoError(t, err)
+
+			assert.Equal(t, g.Name, gateway.Name)
+			assert.Equal(t, g.Endpoint, gateway.Endpoint)
+			assert.Equal(t, g.PublicKey, gateway.PublicKey)
+			assert.False(t, gateway.RequiresPrivilegedAccess)
+		})
+
+		t.Run("adding a gateway with same name as existing fails", func(t *testing.T) {
+			existingGateway, err := db.ReadGateway(g.Name)
+			assert.NoError(t, err)
+			assert.Error(t, db.AddGateway(ctx, existingGateway.Name, existingGateway.Endpoint, existingGateway.PublicKey))
+		})
+
+		t.Run("updating existing gateway works", func(t *testing.T) {
+			existingGateway, err := db.ReadGateway(g.Name)
+			assert.NoError(t, err)
+
+			assert.Nil(t, existingGateway.Routes)
+			assert.Nil(t, existingGateway.AccessGroupIDs)
+
+			routes := []*pb.Route{{Cidr: "r"}, {Cidr: "o"}, {Cidr: "u", Protocol: "udp", Ports: []string{"53"}}, {Cidr: "u", Protocol: "icmp"}, {Cidr: "t"}, {Cidr: "e"}, {Cidr: "s"}}
+			accessGroupIDs := []string{"a1", "b2", "c3"}
+
+			assert.NoError(t, db.UpdateGateway(ctx, existingGateway.Name, routes, accessGroupIDs, true))
+
+			updatedGateway, err := db.ReadGateway(g.Name)
+			assert.NoError(t, err)
+
+			assert.Equal(t, []string{"r", "o", "u", "t", "e", "s"}, updatedGateway.Routes)
+			assert.Len(t, updatedGateway.RoutePolicies, len(routes))
+			assert.Equal(t, "udp", updatedGateway.RoutePolicies[2].GetProtocol())
+			assert.Equal(t, []string{"53"}, updatedGateway.RoutePolicies[2].GetPorts())
+			assert.Equal(t, accessGroupIDs, updatedGateway.AccessGroupIDs)
+			assert.True(t, updatedGateway.RequiresPrivilegedAccess)
+		})
+		t.Run("updating non-existant gateway is ok", func(t *testing.T) {
+			routes := []*pb.Route{{Cidr: "r"}, {Cidr: "o"}, {Cidr: "u"}, {Cidr: "t"}, {Cidr: "e"}, {Cidr: "s"}}
+			accessGroupIDs := []string{"a1", "b2", "c3"}
+
+			assert.NoError(t, db.UpdateGateway(ctx, "non-existant", routes, accessGroupIDs, false))
+		})
+	})
+}
+
+func TestAddDevice(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
+		serial := "serial"
+		d := database.Device{Username: "username", PublicKey: "publickey", Serial: serial, Platform: "darwin"}
+		err := db.AddDevice(ctx, d)
+		assert.NoError(t, err)
 
-	t.Run("adding new gateway works", func(t *testing.T) {
-		err := db.AddGateway(ctx, g.Name, g.Endpoint, g.PublicKey)
+		device, err := db.ReadDevice(d.PublicKey)
 		assert.NoError(t, err)
+		assert.Equal(t, "10.255.240.2", device.IP)
+		assert.Equal(t, d.Username, device.Username)
+		assert.Equal(t, d.PublicKey, device.PublicKey)
+		assert.Equal(t, d.Serial, device.Serial)
+		assert.Equal(t, d.Platform, device.Platform)
+		assert.False(t, *device.Healthy)
 
-		gateway, err := db.ReadGateway(g.Name)
+		err = db.AddDevice(ctx, d)
 		assert.NoError(t, err)
 
-		assert.Equal(t, g.Name, gateway.Name)
-		assert.Equal(t, g.Endpoint, gateway.Endpoint)
-		assert.Equal(t, g.PublicKey, gateway.PublicKey)
-		assert.False(t, gateway.RequiresPrivilegedAccess)
+		newPublicKey := "newPublicKey"
+		dUpdated := database.Device{Username: d.Username, PublicKey: newPublicKey, Serial: serial, Platform: "darwin"}
+		err = db.AddDevice(ctx, dUpdated)
+		assert.NoError(t, err)
+
+		device, err = db.ReadDevice(newPublicKey)
+		assert.NoError(t, err)
+		assert.Equal(t, dUpdated.PublicKey, device.PublicKey)
+		assert.Equal(t, "10.255.240.2", device.IP, "existing device keeps its ip")
+
+		hijacked := database.Device{Username: "otherUsername", PublicKey: "otherPublicKey", Serial: serial, Platform: "darwin"}
+		err = db.AddDevice(ctx, hijacked)
+		assert.True(t, errors.Is(err, database.ErrDeviceOwnedByOtherUser))
+
+		device, err = db.ReadDevice(newPublicKey)
+		assert.NoError(t, err)
+		assert.Equal(t, d.Username, device.Username, "device enrolled by another user keeps its owner")
+
+		other := database.Device{Username: d.Username, PublicKey: "otherDevicePublicKey", Serial: "otherSerial", Platform: "darwin"}
+		assert.NoError(t, db.AddDevice(ctx, other))
+		device, err = db.ReadDevice(other.PublicKey)
+		assert.NoError(t, err)
+		assert.Equal(t, "10.255.240.3", device.IP, "updates do not keep addresses allocated")
 	})
+}
+
+func TestAddDeviceFullTunnelNetwork(t *testing.T) {
+	cfg := database.DefaultConfig()
+	cfg.TunnelCIDR = "10.0.0.0/29"
+
+	testdatabase.ForEach(t, cfg, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
+
+		for i := 2; i <= 6; i++ {
+			device := database.Device{Username: "username", PublicKey: fmt.Sprintf("publickey-%d", i), Serial: strconv.Itoa(i), Platform: "linux"}
+			assert.NoError(t, db.AddDevice(ctx, device))
+		}
+
+		err := db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey-7", Serial: "7", Platform: "linux"})
+		assert.True(t, errors.Is(err, cidr.ErrNoAvailableIP))
+
+		rekeyed := database.Device{Username: "username", PublicKey: "rekeyed", Serial: "2", Platform: "linux"}
+		assert.NoError(t, db.AddDevice(ctx, rekeyed), "existing devices can be re-keyed when the tunnel network is full")
 
-	t.Run("adding a gateway with same name as existing fails", func(t *testing.T) {
-		existingGateway, err := db.ReadGateway(g.Name)
+		device, err := db.ReadDevice(rekeyed.PublicKey)
 		assert.NoError(t, err)
-		assert.Error(t, db.AddGateway(ctx, existingGateway.Name, existingGateway.Endpoint, existingGateway.PublicKey))
+		assert.Equal(t, "10.0.0.2", device.IP)
 	})
+}
+
+func TestDeviceLimit(t *testing.T) {
+	cfg := database.DefaultConfig()
+	cfg.MaxDevicesPerUser = 2
+
+	testdatabase.ForEach(t, cfg, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
+
+		assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey-a", Serial: "a", Platform: "linux"}))
+		assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey-b", Serial: "b", Platform: "linux"}))
+
+		err := db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey-c", Serial: "c", Platform: "linux"})
+		assert.True(t, errors.Is(err, database.ErrDeviceLimitReached))
+
+		assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey-a2", Serial: "a", Platform: "linux"}), "existing devices can be re-keyed")
+		assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "otherUsername", PublicKey: "publickey-c", Serial: "c", Platform: "linux"}), "limit is per user")
+	})
+}
+
+func TestDeviceLimitConcurrent(t *testing.T) {
+	cfg := database.DefaultConfig()
+	cfg.MaxDevicesPerUser = 2
+
+	testdatabase.ForEach(t, cfg, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
+		errs := make(chan error)
+		for i := 0; i < 5; i++ {
+			go func(serial string) {
+				errs <- db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey-" + serial, Serial: serial, Platform: "linux"})
+			}(strconv.Itoa(i))
+		}
+
+		added := 0
+		for i := 0; i < 5; i++ {
+			err := <-errs
+			if err == nil {
+				added++
+			} else {
+				assert.True(t, errors.Is(err, database.ErrDeviceLimitReached), err)
+			}
+		}
+		assert.Equal(t, 2, added)
+	})
+}
+
+func TestTransferDevice(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
+
+		assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey", Serial: "serial", Platform: "linux"}))
+		device, err := db.ReadDevice("publickey")
+		assert.NoError(t, err)
+
+		assert.NoError(t, db.TransferDevice(ctx, device.ID, "newUsername", "admin"))
+		assert.NoError(t, db.TransferDevice(ctx, device.ID, "newUsername", "admin"), "transferring to the owner is a no-op")
+
+		device, err = db.ReadDevice("publickey")
+		assert.NoError(t, err)
+		assert.Equal(t, "newUsername", device.Username)
 
-	t.Run("updating existing gateway works", func(t *testing.T) {
-		existingGateway, err := db.ReadGateway(g.Name)
+		transfers, err := db.ReadOwnershipTransfers(ctx, device.ID)
 		assert.NoError(t, err)
+		assert.Len(t, transfers, 1)
+		assert.Equal(t, "username", transfers[0].FromUsername)
+		assert.Equal(t, "newUsername", transfers[0].ToUsername)
+		assert.Equal(t, "admin", transfers[0].TransferredBy)
+		assert.Equal(t, "serial", transfers[0].Serial)
 
-		assert.Nil(t, existingGateway.Routes)
-		assert.Nil(t, existingGateway.AccessGroupIDs)
+		assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "newUsername", PublicKey: "newPublicKey", Serial: "serial", Platform: "linux"}), "new owner can re-key the device")
 
-		routes := []string{"r", "o", "u", "t", "e", "s"}
-		accessGroupIDs := []string{"a1", "b2", "c3"}
+		err = db.TransferDevice(ctx, 1234, "newUsername", "admin")
+		assert.True(t, errors.Is(err, sql.ErrNoRows))
+	})
+}
+
+func TestAuditEvents(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
 
-		assert.NoError(t, db.UpdateGateway(ctx, existingGateway.Name, routes, accessGroupIDs, true))
+		now := time.Now()
+		assert.NoError(t, db.AddAuditEvent(ctx, audit.Event{Time: now.Add(-time.Hour), Component: "apiserver", Action: audit.ActionLogin, Outcome: audit.OutcomeSuccess, Actor: "alice"}))
+		assert.NoError(t, db.AddAuditEvent(ctx, audit.Event{Time: now, Component: "apiserver", Action: audit.ActionLogin, Outcome: audit.OutcomeDenied, Actor: "bob", Details: map[string]string{"reason": "device not healthy"}}))
+		assert.NoError(t, db.AddAuditEvent(ctx, audit.Event{Time: now, Component: "bootstrap-api", Action: audit.ActionEnrollmentQueued, Outcome: audit.OutcomeSuccess, Actor: "alice", Target: "serial"}))
+
+		events, err := db.ReadAuditEvents(ctx, audit.Query{})
+		assert.NoError(t, err)
+		assert.Len(t, events, 3)
 
-		updatedGateway, err := db.ReadGateway(g.Name)
+		events, err = db.ReadAuditEvents(ctx, audit.Query{Action: audit.ActionLogin, Since: now.Add(-time.Minute)})
 		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+		assert.Equal(t, "bob", events[0].Actor)
+		assert.Equal(t, "device not healthy", events[0].Details["reason"])
 
-		assert.Equal(t, routes, updatedGateway.Routes)
-		assert.Equal(t, accessGroupIDs, updatedGateway.AccessGroupIDs)
-		assert.True(t, updatedGateway.RequiresPrivilegedAccess)
+		events, err = db.ReadAuditEvents(ctx, audit.Query{Actor: "alice", Limit: 1})
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+		assert.Equal(t, audit.ActionEnrollmentQueued, events[0].Action, "newest event first")
 	})
-	t.Run("updating non-existant gateway is ok", func(t *testing.T) {
-		routes := []string{"r", "o", "u", "t", "e", "s"}
-		accessGroupIDs := []string{"a1", "b2", "c3"}
+}
+
+func TestIPAllocation(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
 
-		assert.NoError(t, db.UpdateGateway(ctx, "non-existant", routes, accessGroupIDs, false))
+		for _, serial := range []string{"a", "b", "c"} {
+			assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey-" + serial, Serial: serial, Platform: "linux"}))
+		}
+		assert.NoError(t, db.AddGateway(ctx, "gateway", "1.2.3.4:51820", "gateway-publickey"))
+
+		gateway, err := db.ReadGateway("gateway")
+		assert.NoError(t, err)
+		assert.Equal(t, "10.255.240.5", gateway.Ip)
+
+		device, err := db.ReadDevice("publickey-b")
+		assert.NoError(t, err)
+		assert.NoError(t, db.RemoveDevice(ctx, device.ID))
+		_, err = db.ReadDevice("publickey-b")
+		assert.Error(t, err)
+
+		assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey-d", Serial: "d", Platform: "linux"}))
+		device, err = db.ReadDevice("publickey-d")
+		assert.NoError(t, err)
+		assert.Equal(t, "10.255.240.3", device.IP, "released address is reused")
+
+		assert.NoError(t, db.RemoveGateway(ctx, "gateway"))
+		assert.Error(t, db.RemoveGateway(ctx, "gateway"))
+
+		assert.NoError(t, db.AddGateway(ctx, "gateway", "1.2.3.4:51820", "gateway-publickey"))
+		gateway, err = db.ReadGateway("gateway")
+		assert.NoError(t, err)
+		assert.Equal(t, "10.255.240.5", gateway.Ip)
 	})
 }
 
-func TestAddDevice(t *testing.T) {
-	db := setup(t)
+func TestDeviceLifecycle(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
+
+		assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey", Serial: "serial", Platform: "linux"}))
+		device, err := db.ReadDevice("publickey")
+		assert.NoError(t, err)
+		assert.False(t, device.Disabled)
+
+		assert.NoError(t, db.SetDeviceDisabled(ctx, device.ID, true))
+		assert.NoError(t, db.SetDeviceName(ctx, device.ID, "laptop"))
+		assert.NoError(t, db.SetDevicePublicKey(ctx, device.ID, "newpublickey"))
+
+		device, err = db.ReadDeviceById(ctx, device.ID)
+		assert.NoError(t, err)
+		assert.True(t, device.Disabled)
+		assert.Equal(t, "laptop", device.Name)
+		assert.Equal(t, "newpublickey", device.PublicKey)
+
+		assert.True(t, errors.Is(db.SetDeviceDisabled(ctx, 1337, true), sql.ErrNoRows))
+	})
+}
+
+func TestGatewayLifecycle(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
+
+		assert.NoError(t, db.AddGateway(ctx, "gateway", "1.2.3.4:51820", "publickey"))
+		assert.NoError(t, db.SetGatewayDisabled(ctx, "gateway", true))
+		assert.NoError(t, db.SetGatewayPublicKey(ctx, "gateway", "newpublickey"))
+		assert.NoError(t, db.RenameGateway(ctx, "gateway", "renamed"))
+
+		_, err := db.ReadGateway("gateway")
+		assert.Error(t, err)
+
+		gateway, err := db.ReadGateway("renamed")
+		assert.NoError(t, err)
+		assert.True(t, gateway.Disabled)
+		assert.Equal(t, "newpublickey", gateway.PublicKey)
+
+		assert.True(t, errors.Is(db.RenameGateway(ctx, "gateway", "other"), sql.ErrNoRows))
+	})
+}
+
+func TestRenameGatewayConcurrent(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
+
+		names := []string{"a", "b", "c", "d"}
+		for _, name := range names {
+			assert.NoError(t, db.AddGateway(ctx, name, "1.2.3.4:51820", "publickey-"+name))
+		}
+
+		errs := make(chan error)
+		for _, name := range names {
+			go func(name string) {
+				errs <- db.RenameGateway(ctx, name, "renamed")
+			}(name)
+		}
+
+		renamed := 0
+		for range names {
+			if err := <-errs; err == nil {
+				renamed++
+			}
+		}
+		assert.Equal(t, 1, renamed, "only one gateway can take the name")
+
+		gateways, err := db.ReadGateways()
+		assert.NoError(t, err)
+		assert.Len(t, gateways, len(names))
+
+		named := 0
+		for i := range gateways {
+			if gateways[i].Name == "renamed" {
+				named++
+			}
+		}
+		assert.Equal(t, 1, named)
+	})
+}
+
+func TestRemoveSessions(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
+
+		assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey", Serial: "serial", Platform: "linux"}))
+		device, err := db.ReadDevice("publickey")
+		assert.NoError(t, err)
+
+		now := time.Now()
+		for _, si := range []database.SessionInfo{
+			{Key: "expired", Expiry: now.Add(-time.Minute).Unix(), Device: device, ObjectId: "user1"},
+			{Key: "user1", Expiry: now.Add(time.Hour).Unix(), Device: device, ObjectId: "user1"},
+			{Key: "user2", Expiry: now.Add(time.Hour).Unix(), Device: device, ObjectId: "user2"},
+			{Key: "user2-other", Expiry: now.Add(time.Hour).Unix(), Device: device, ObjectId: "user2"},
+		} {
+			si := si
+			assert.NoError(t, db.AddSessionInfo(ctx, &si))
+		}
+
+		reaped, err := db.RemoveExpiredSessions(ctx, now)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), reaped)
+
+		assert.NoError(t, db.RemoveSession(ctx, "user1"))
+		assert.True(t, errors.Is(db.RemoveSession(ctx, "user1"), sql.ErrNoRows))
+
+		assert.NoError(t, db.RemoveUserSessions(ctx, "user2"))
+
+		sessions, err := db.ReadSessionInfos(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, sessions)
+	})
+}
+
+func TestRenewSession(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
+
+		assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey", Serial: "serial", Platform: "linux"}))
+		device, err := db.ReadDevice("publickey")
+		assert.NoError(t, err)
+
+		si := &database.SessionInfo{Key: "key", Expiry: time.Now().Add(time.Minute).Unix(), Device: device, Groups: []string{"group1"}, ObjectId: "user1", RefreshToken: "refresh"}
+		assert.NoError(t, db.AddSessionInfo(ctx, si))
+
+		si.Expiry = time.Now().Add(time.Hour).Unix()
+		si.Groups = []string{"group1", "group2"}
+		si.RefreshToken = "rotated"
+		assert.NoError(t, db.RenewSession(ctx, si))
+
+		renewed, err := db.ReadSessionInfo(ctx, "key")
+		assert.NoError(t, err)
+		assert.Equal(t, si.Expiry, renewed.Expiry)
+		assert.Equal(t, si.Groups, renewed.Groups)
+		assert.Equal(t, "rotated", renewed.RefreshToken)
+
+		assert.True(t, errors.Is(db.RenewSession(ctx, &database.SessionInfo{Key: "unknown"}), sql.ErrNoRows))
+	})
+}
+
+func TestSessionInfo_Expired(t *testing.T) {
+	assert.True(t, database.SessionInfo{Expiry: time.Now().Add(-time.Minute).Unix()}.Expired())
+	assert.True(t, database.SessionInfo{}.Expired())
+	assert.False(t, database.SessionInfo{Expiry: time.Now().Add(time.Minute).Unix()}.Expired())
+}
+
+func TestTunnelIPv6(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db database.Repository) {
+		ctx := context.Background()
+
+		assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey", Serial: "serial", Platform: "linux"}))
+		assert.NoError(t, db.AddGateway(ctx, "gateway", "1.2.3.4:51820", "gateway-publickey"))
+
+		device, err := db.ReadDevice("publickey")
+		assert.NoError(t, err)
+		assert.Equal(t, "10.255.240.2", device.IP)
+		assert.Equal(t, "fd75:568f:f19::2", device.IPv6)
+
+		gateway, err := db.ReadGateway("gateway")
+		assert.NoError(t, err)
+		assert.Equal(t, "fd75:568f:f19::3", gateway.Ipv6)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		db := database.NewMemoryDB(database.Config{TunnelCIDR: database.DefaultTunnelCidr})
+		assert.NoError(t, db.AddDevice(context.Background(), database.Device{Username: "username", PublicKey: "publickey", Serial: "serial", Platform: "linux"}))
+
+		device, err := db.ReadDevice("publickey")
+		assert.NoError(t, err)
+		assert.Empty(t, device.IPv6)
+	})
+}
 
+func TestTunnelCIDR(t *testing.T) {
 	ctx := context.Background()
-	serial := "serial"
-	d := database.Device{Username: "username", PublicKey: "publickey", Serial: serial, Platform: "darwin"}
-	err := db.AddDevice(ctx, d)
-	assert.NoError(t, err)
+	db := database.NewMemoryDB(database.Config{TunnelCIDR: "10.0.0.0/29", TunnelIPv6Prefix: "fd00::/64"})
 
-	device, err := db.ReadDevice(d.PublicKey)
+	apiServerIP, err := database.Config{TunnelCIDR: "10.0.0.0/29"}.APIServerIP()
 	assert.NoError(t, err)
-	assert.Equal(t, d.Username, device.Username)
-	assert.Equal(t, d.PublicKey, device.PublicKey)
-	assert.Equal(t, d.Serial, device.Serial)
-	assert.Equal(t, d.Platform, device.Platform)
-	assert.False(t, *device.Healthy)
+	assert.Equal(t, "10.0.0.1", apiServerIP)
 
-	err = db.AddDevice(ctx, d)
+	assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey", Serial: "serial", Platform: "linux"}))
+	device, err := db.ReadDevice("publickey")
 	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", device.IP)
+	assert.Equal(t, "fd00::2", device.IPv6)
+
+	for i := 3; i <= 6; i++ {
+		assert.NoError(t, db.AddGateway(ctx, fmt.Sprintf("gateway-%d", i), "1.2.3.4:51820", fmt.Sprintf("publickey-%d", i)))
+	}
+
+	err = db.AddGateway(ctx, "gateway-7", "1.2.3.4:51820", "publickey-7")
+	assert.True(t, errors.Is(err, cidr.ErrNoAvailableIP))
+}
+
+func TestTunnelIPv6PrefixChange(t *testing.T) {
+	if os.Getenv("RUN_INTEGRATION_TESTS") == "" {
+		t.Skip("Skipping integration test")
+	}
+
+	ctx := context.Background()
+	db, err := testdatabase.New(ctx, testdatabase.DSN)
+	if err != nil {
+		t.Fatalf("Instantiating database: %v", err)
+	}
+
+	assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey-a", Serial: "a", Platform: "linux"}))
+
+	db.Config.TunnelIPv6Prefix = "fd00:1234::/64"
+	assert.NoError(t, db.AddDevice(ctx, database.Device{Username: "username", PublicKey: "publickey-b", Serial: "b", Platform: "linux"}))
 
-	newUsername, newPublicKey := "newUsername", "newPublicKey"
-	dUpdated := database.Device{Username: newUsername, PublicKey: newPublicKey, Serial: serial, Platform: "darwin"}
-	err = db.AddDevice(ctx, dUpdated)
+	device, err := db.ReadDevice("publickey-a")
 	assert.NoError(t, err)
+	assert.Equal(t, "fd75:568f:f19::2", device.IPv6, "existing devices keep their address when the prefix changes")
 
-	device, err = db.ReadDevice(newPublicKey)
+	device, err = db.ReadDevice("publickey-b")
 	assert.NoError(t, err)
-	assert.Equal(t, dUpdated.Username, device.Username)
-	assert.Equal(t, dUpdated.PublicKey, device.PublicKey)
+	assert.Equal(t, "fd00:1234::3", device.IPv6, "new devices get an address in the new prefix")
 }
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// States of device enrollments requiring approval.
+const (
+	EnrollmentPending  = "pending"
+	EnrollmentApproved = "approved"
+	EnrollmentRejected = "rejected"
+)
+
+// Enrollment is a device enrollment that requires approval. There is one per device, identified by serial and platform.
+type Enrollment struct {
+	ID int `json:"id"`
+	// EnrollmentID identifies the latest enrollment of the device in the bootstrap-api.
+	EnrollmentID string     `json:"enrollmentID"`
+	Serial       string     `json:"serial"`
+	Platform     string     `json:"platform"`
+	Username     string     `json:"username"`
+	PublicKey    string     `json:"publicKey"`
+	State        string     `json:"state"`
+	Created      time.Time  `json:"created"`
+	Decided      *time.Time `json:"decided"`
+	DecidedBy    string     `json:"decidedBy"`
+	// Acknowledged is whether the bootstrap-api has been told the current state.
+	Acknowledged bool `json:"acknowledged"`
+}
+
+const enrollmentColumns = `id, enrollment_id, serial, platform, username, public_key, state, created, decided, decided_by, acknowledged`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEnrollment(row scanner) (*Enrollment, error) {
+	var e Enrollment
+	err := row.Scan(&e.ID, &e.EnrollmentID, &e.Serial, &e.Platform, &e.Username, &e.PublicKey, &e.State, &e.Created, &e.Decided, &e.DecidedBy, &e.Acknowledged)
+	if err != nil {
+		return nil, fmt.Errorf("scanning row: %w", err)
+	}
+
+	return &e, nil
+}
+
+// SaveEnrollment adds the enrollment, or replaces the enrollment of the same device. Either way, it is marked as not
+// yet acknowledged, and the ID and creation time are set on the given enrollment.
+func (d *APIServerDB) SaveEnrollment(ctx context.Context, e *Enrollment) error {
+	statement := `
+INSERT INTO enrollment (enrollment_id, serial, platform, username, public_key, state, decided, decided_by, acknowledged)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, false)
+ON CONFLICT (serial, platform) DO UPDATE
+SET enrollment_id = EXCLUDED.enrollment_id, username = EXCLUDED.username, public_key = EXCLUDED.public_key,
+    state = EXCLUDED.state, decided = EXCLUDED.decided, decided_by = EXCLUDED.decided_by, acknowledged = false
+RETURNING id, created;`
+
+	row := d.Conn.QueryRowContext(ctx, statement, e.EnrollmentID, e.Serial, e.Platform, e.Username, e.PublicKey, e.State, e.Decided, e.DecidedBy)
+	if err := row.Scan(&e.ID, &e.Created); err != nil {
+		return fmt.Errorf("saving enrollment: %w", err)
+	}
+
+	e.Acknowledged = false
+	log.Infof("Saved %s enrollment for device with serial %s", e.State, e.Serial)
+	return nil
+}
+
+// ReadEnrollment returns the enrollment of the device, or sql.ErrNoRows if there is none.
+func (d *APIServerDB) ReadEnrollment(ctx context.Context, serial, platform string) (*Enrollment, error) {
+	query := `
+SELECT ` + enrollmentColumns + `
+FROM enrollment
+WHERE serial = $1 AND platform = $2;`
+
+	return scanEnrollment(d.Conn.QueryRowContext(ctx, query, serial, platform))
+}
+
+// ReadEnrollmentByID returns the enrollment with the given ID, or sql.ErrNoRows if there is none.
+func (d *APIServerDB) ReadEnrollmentByID(ctx context.Context, id int) (*Enrollment, error) {
+	query := `
+SELECT ` + enrollmentColumns + `
+FROM enrollment
+WHERE id = $1;`
+
+	return scanEnrollment(d.Conn.QueryRowContext(ctx, query, id))
+}
+
+// ReadEnrollments returns all enrollments, or only those in the given state if not empty, oldest first.
+func (d *APIServerDB) ReadEnrollments(ctx context.Context, state string) ([]Enrollment, error) {
+	query := `
+SELECT ` + enrollmentColumns + `
+FROM enrollment
+WHERE $1 = '' OR state::varchar = $1
+ORDER BY created;`
+
+	return d.queryEnrollments(ctx, query, state)
+}
+
+// ReadUnacknowledgedEnrollments returns the enrollments whose state the bootstrap-api has not yet been told.
+func (d *APIServerDB) ReadUnacknowledgedEnrollments(ctx context.Context) ([]Enrollment, error) {
+	query := `
+SELECT ` + enrollmentColumns + `
+FROM enrollment
+WHERE NOT acknowledged
+ORDER BY created;`
+
+	return d.queryEnrollments(ctx, query)
+}
+
+func (d *APIServerDB) queryEnrollments(ctx context.Context, query string, args ...interface{}) ([]Enrollment, error) {
+	rows, err := d.Conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying enrollments: %w", err)
+	}
+	defer rows.Close()
+
+	enrollments := make([]Enrollment, 0)
+	for rows.Next() {
+		e, err := scanEnrollment(rows)
+		if err != nil {
+			return nil, err
+		}
+		enrollments = append(enrollments, *e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading rows: %w", err)
+	}
+
+	return enrollments, nil
+}
+
+// SetEnrollmentState decides the enrollment, returning sql.ErrNoRows if there is no such enrollment.
+func (d *APIServerDB) SetEnrollmentState(ctx context.Context, id int, state, decidedBy string) error {
+	statement := `
+UPDATE enrollment
+SET state = $2, decided = now(), decided_by = $3, acknowledged = false
+WHERE id = $1;`
+
+	if err := d.updateOne(ctx, statement, id, state, decidedBy); err != nil {
+		return err
+	}
+
+	log.Infof("Enrollment with id %d %s by %s", id, state, decidedBy)
+	return nil
+}
+
+// AcknowledgeEnrollment marks the bootstrap-api as having been told the enrollment's state. It returns
+// sql.ErrNoRows if the state has changed in the meantime, so that the new state is not marked as acknowledged.
+func (d *APIServerDB) AcknowledgeEnrollment(ctx context.Context, id int, state string) error {
+	statement := `
+UPDATE enrollment
+SET acknowledged = true
+WHERE id = $1 AND state = $2;`
+
+	return d.updateOne(ctx, statement, id, state)
+}
+
+// RemoveEnrollment deletes the enrollment, returning sql.ErrNoRows if there is none.
+// The next enrollment of the device requires approval again.
+func (d *APIServerDB) RemoveEnrollment(ctx context.Context, id int) error {
+	statement := `
+DELETE FROM enrollment
+WHERE id = $1;`
+
+	if err := d.updateOne(ctx, statement, id); err != nil {
+		return err
+	}
+
+	log.Infof("Removed enrollment with id: %d", id)
+	return nil
+}
@@ -0,0 +1,853 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nais/device/apiserver/cidr"
+	"github.com/nais/device/pkg/audit"
+	"github.com/nais/device/pkg/pb"
+	log "github.com/sirupsen/logrus"
+)
+
+// MemoryDB is a Repository kept in memory, for tests and for running the apiserver locally without Postgres.
+// It behaves like APIServerDB, including returning sql.ErrNoRows where APIServerDB does, but nothing is persisted.
+type MemoryDB struct {
+	config Config
+
+	lock         sync.Mutex
+	devices      map[int]*Device
+	gateways     []*memoryGateway // in the order they were added
+	sessions     []memorySession  // in the order they were added
+	transfers    []OwnershipTransfer
+	enrollments  map[int]*Enrollment
+	auditEvents  []audit.Event
+	allocatedIPs map[string]bool
+
+	lastDeviceID     int
+	lastEnrollmentID int
+	lastAuditEventID int64
+}
+
+// memoryGateway holds a gateway the way it is stored by APIServerDB, so that it is read back the same way.
+type memoryGateway struct {
+	name                     string
+	endpoint                 string
+	publicKey                string
+	ip                       string
+	ipv6                     string
+	routes                   string
+	accessGroupIDs           string
+	routePolicies            string
+	requiresPrivilegedAccess bool
+	disabled                 bool
+}
+
+type memorySession struct {
+	key          string
+	expiry       int64
+	deviceID     int
+	groups       string
+	objectID     string
+	refreshToken string
+}
+
+func NewMemoryDB(cfg Config) *MemoryDB {
+	allocatedIPs := make(map[string]bool)
+	if ip, err := cfg.APIServerIP(); err == nil {
+		allocatedIPs[ip] = true
+	}
+
+	return &MemoryDB{
+		config:       cfg,
+		devices:      make(map[int]*Device),
+		enrollments:  make(map[int]*Enrollment),
+		allocatedIPs: allocatedIPs,
+	}
+}
+
+// allocateIP claims the lowest available tunnel address, the same way as APIServerDB.
+func (m *MemoryDB) allocateIP() (string, error) {
+	ip, err := cidr.FindAvailableIP(m.config.TunnelCIDR, m.allocatedIPs)
+	if err != nil {
+		return "", err
+	}
+
+	m.allocatedIPs[ip] = true
+	return ip, nil
+}
+
+// allocateIPs allocates an IPv4 tunnel address along with the IPv6 tunnel address stored with it.
+func (m *MemoryDB) allocateIPs() (string, string, error) {
+	ip, err := m.allocateIP()
+	if err != nil {
+		return "", "", err
+	}
+
+	ipv6, err := m.config.allocateIPv6(ip)
+	if err != nil {
+		delete(m.allocatedIPs, ip)
+		return "", "", err
+	}
+
+	return ip, ipv6, nil
+}
+
+// readDevice returns a copy of the device, so that callers can not modify the stored device.
+func (m *MemoryDB) readDevice(match func(d *Device) bool) (*Device, error) {
+	for _, device := range m.devices {
+		if match(device) {
+			return copyDevice(device), nil
+		}
+	}
+
+	return nil, fmt.Errorf("reading device: %w", sql.ErrNoRows)
+}
+
+func copyDevice(d *Device) *Device {
+	device := *d
+	if d.Healthy != nil {
+		healthy := *d.Healthy
+		device.Healthy = &healthy
+	}
+	if d.LastUpdated != nil {
+		lastUpdated := *d.LastUpdated
+		device.LastUpdated = &lastUpdated
+	}
+	if d.KolideLastSeen != nil {
+		kolideLastSeen := *d.KolideLastSeen
+		device.KolideLastSeen = &kolideLastSeen
+	}
+	return &device
+}
+
+func (m *MemoryDB) ReadDevices() ([]Device, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	devices := make([]Device, 0, len(m.devices))
+	for _, device := range m.devices {
+		devices = append(devices, *copyDevice(device))
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ID < devices[j].ID
+	})
+
+	return devices, nil
+}
+
+func (m *MemoryDB) ReadDevice(publicKey string) (*Device, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.readDevice(func(d *Device) bool {
+		return d.PublicKey == publicKey
+	})
+}
+
+func (m *MemoryDB) ReadDeviceById(_ context.Context, deviceID int) (*Device, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.readDevice(func(d *Device) bool {
+		return d.ID == deviceID
+	})
+}
+
+func (m *MemoryDB) ReadDeviceBySerialPlatformUsername(_ context.Context, serial string, platform string, username string) (*Device, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.readDevice(func(d *Device) bool {
+		return d.Serial == serial && d.Platform == platform && d.Username == username
+	})
+}
+
+// AddDevice adds or re-keys the device. See APIServerDB.AddDevice.
+func (m *MemoryDB) AddDevice(_ context.Context, device Device) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	devices := 0
+	for _, existing := range m.devices {
+		if existing.Serial == device.Serial && existing.Platform == device.Platform {
+			if existing.Username != device.Username {
+				return ErrDeviceOwnedByOtherUser
+			}
+			existing.PublicKey = device.PublicKey
+			return nil
+		}
+
+		if existing.Username == device.Username {
+			devices++
+		}
+	}
+
+	if m.config.MaxDevicesPerUser > 0 && devices >= m.config.MaxDevicesPerUser {
+		return ErrDeviceLimitReached
+	}
+
+	ip, ipv6, err := m.allocateIPs()
+	if err != nil {
+		return fmt.Errorf("allocating ip: %w", err)
+	}
+
+	m.lastDeviceID++
+	healthy := false
+	m.devices[m.lastDeviceID] = &Device{
+		ID:        m.lastDeviceID,
+		Serial:    device.Serial,
+		Username:  device.Username,
+		PublicKey: device.PublicKey,
+		IP:        ip,
+		IPv6:      ipv6,
+		Healthy:   &healthy,
+		Platform:  device.Platform,
+	}
+
+	log.Infof("Added device: %+v", device)
+	return nil
+}
+
+// RemoveDevice deletes the device along with its sessions, and releases its tunnel address.
+func (m *MemoryDB) RemoveDevice(_ context.Context, deviceID int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	device, ok := m.devices[deviceID]
+	if !ok {
+		return fmt.Errorf("deleting device: %w", sql.ErrNoRows)
+	}
+
+	m.removeSessions(func(s memorySession) bool {
+		return s.deviceID == deviceID
+	})
+	delete(m.allocatedIPs, device.IP)
+	delete(m.devices, deviceID)
+
+	return nil
+}
+
+func (m *MemoryDB) UpdateDeviceStatus(devices []Device) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now().Unix()
+	for _, device := range devices {
+		for _, existing := range m.devices {
+			if existing.Serial != device.Serial || existing.Platform != device.Platform {
+				continue
+			}
+
+			updated := copyDevice(&device)
+			existing.Healthy = updated.Healthy
+			existing.KolideLastSeen = updated.KolideLastSeen
+			lastUpdated := now
+			existing.LastUpdated = &lastUpdated
+		}
+	}
+
+	return nil
+}
+
+// updateDevice applies the update to the device, returning sql.ErrNoRows if there is no such device.
+func (m *MemoryDB) updateDevice(deviceID int, update func(d *Device)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	device, ok := m.devices[deviceID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	update(device)
+	return nil
+}
+
+func (m *MemoryDB) SetDeviceDisabled(_ context.Context, deviceID int, disabled bool) error {
+	return m.updateDevice(deviceID, func(d *Device) {
+		d.Disabled = disabled
+	})
+}
+
+func (m *MemoryDB) SetDevicePublicKey(_ context.Context, deviceID int, publicKey string) error {
+	return m.updateDevice(deviceID, func(d *Device) {
+		d.PublicKey = publicKey
+	})
+}
+
+func (m *MemoryDB) SetDeviceName(_ context.Context, deviceID int, name string) error {
+	return m.updateDevice(deviceID, func(d *Device) {
+		d.Name = name
+	})
+}
+
+// TransferDevice gives the device to another user. See APIServerDB.TransferDevice.
+func (m *MemoryDB) TransferDevice(_ context.Context, deviceID int, username, transferredBy string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	device, ok := m.devices[deviceID]
+	if !ok {
+		return fmt.Errorf("reading device: %w", sql.ErrNoRows)
+	}
+
+	if device.Username == username {
+		return nil
+	}
+
+	m.transfers = append(m.transfers, OwnershipTransfer{
+		DeviceID:      deviceID,
+		Serial:        device.Serial,
+		Platform:      device.Platform,
+		FromUsername:  device.Username,
+		ToUsername:    username,
+		TransferredBy: transferredBy,
+		Created:       time.Now(),
+	})
+	device.Username = username
+
+	return nil
+}
+
+func (m *MemoryDB) ReadOwnershipTransfers(_ context.Context, deviceID int) ([]OwnershipTransfer, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	transfers := make([]OwnershipTransfer, 0)
+	for _, t := range m.transfers {
+		if t.DeviceID == deviceID {
+			transfers = append(transfers, t)
+		}
+	}
+
+	return transfers, nil
+}
+
+func (m *MemoryDB) gateway(name string) *memoryGateway {
+	for _, gateway := range m.gateways {
+		if gateway.name == name {
+			return gateway
+		}
+	}
+	return nil
+}
+
+// protobuf fills in the gateway like APIServerDB reads it from the gateway table.
+func (g *memoryGateway) protobuf(gateway *pb.Gateway) error {
+	gateway.Name = g.name
+	gateway.Endpoint = g.endpoint
+	gateway.PublicKey = g.publicKey
+	gateway.Ip = g.ip
+	gateway.Ipv6 = g.ipv6
+	gateway.RequiresPrivilegedAccess = g.requiresPrivilegedAccess
+	gateway.Disabled = g.disabled
+
+	if len(g.accessGroupIDs) != 0 {
+		gateway.AccessGroupIDs = strings.Split(g.accessGroupIDs, ",")
+	}
+
+	if len(g.routes) != 0 {
+		gateway.Routes = strings.Split(g.routes, ",")
+	}
+
+	var err error
+	gateway.RoutePolicies, err = parseRoutePolicies(gateway.Routes, g.routePolicies)
+	if err != nil {
+		return fmt.Errorf("parsing route policies for gateway %s: %w", g.name, err)
+	}
+
+	return nil
+}
+
+func (m *MemoryDB) ReadGateways() ([]pb.Gateway, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var gateways []pb.Gateway
+	for _, gateway := range m.gateways {
+		gateways = append(gateways, pb.Gateway{})
+		if err := gateway.protobuf(&gateways[len(gateways)-1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return gateways, nil
+}
+
+func (m *MemoryDB) ReadGateway(name string) (*pb.Gateway, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	g := m.gateway(name)
+	if g == nil {
+		return nil, fmt.Errorf("reading gateway: %w", sql.ErrNoRows)
+	}
+
+	gateway := &pb.Gateway{}
+	if err := g.protobuf(gateway); err != nil {
+		return nil, err
+	}
+
+	return gateway, nil
+}
+
+func (m *MemoryDB) AddGateway(_ context.Context, name, endpoint, publicKey string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.gateway(name) != nil {
+		return fmt.Errorf("inserting new gateway: gateway %s already exists", name)
+	}
+
+	ip, ipv6, err := m.allocateIPs()
+	if err != nil {
+		return fmt.Errorf("allocating ip: %w", err)
+	}
+
+	m.gateways = append(m.gateways, &memoryGateway{
+		name:      name,
+		endpoint:  endpoint,
+		publicKey: publicKey,
+		ip:        ip,
+		ipv6:      ipv6,
+	})
+
+	log.Infof("Added gateway: %+v", name)
+	return nil
+}
+
+// UpdateGateway sets the routes and access of the gateway. Updating a gateway that does not exist is not an error.
+func (m *MemoryDB) UpdateGateway(_ context.Context, name string, routes []*pb.Route, accessGroupIDs []string, requiresPrivilegedAccess bool) error {
+	routePolicies, err := json.Marshal(routes)
+	if err != nil {
+		return fmt.Errorf("marshaling route policies: %w", err)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if gateway := m.gateway(name); gateway != nil {
+		gateway.routes = strings.Join(pb.RouteCIDRs(routes), ",")
+		gateway.accessGroupIDs = strings.Join(accessGroupIDs, ",")
+		gateway.requiresPrivilegedAccess = requiresPrivilegedAccess
+		gateway.routePolicies = string(routePolicies)
+	}
+
+	return nil
+}
+
+// RemoveGateway deletes the gateway and releases its tunnel address.
+func (m *MemoryDB) RemoveGateway(_ context.Context, name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i, gateway := range m.gateways {
+		if gateway.name == name {
+			delete(m.allocatedIPs, gateway.ip)
+			m.gateways = append(m.gateways[:i], m.gateways[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("deleting gateway: %w", sql.ErrNoRows)
+}
+
+// updateGateway applies the update to the gateway, returning sql.ErrNoRows if there is no such gateway.
+func (m *MemoryDB) updateGateway(name string, update func(g *memoryGateway)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	gateway := m.gateway(name)
+	if gateway == nil {
+		return sql.ErrNoRows
+	}
+
+	update(gateway)
+	return nil
+}
+
+func (m *MemoryDB) SetGatewayDisabled(_ context.Context, name string, disabled bool) error {
+	return m.updateGateway(name, func(g *memoryGateway) {
+		g.disabled = disabled
+	})
+}
+
+func (m *MemoryDB) SetGatewayPublicKey(_ context.Context, name, publicKey string) error {
+	return m.updateGateway(name, func(g *memoryGateway) {
+		g.publicKey = publicKey
+	})
+}
+
+func (m *MemoryDB) RenameGateway(ctx context.Context, name, newName string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if name != newName && m.gateway(newName) != nil {
+		return fmt.Errorf("executing update: gateway %s already exists", newName)
+	}
+
+	gateway := m.gateway(name)
+	if gateway == nil {
+		return sql.ErrNoRows
+	}
+
+	gateway.name = newName
+	return nil
+}
+
+func (m *MemoryDB) sessionInfo(s memorySession) (*SessionInfo, error) {
+	device, err := m.readDevice(func(d *Device) bool {
+		return d.ID == s.deviceID
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionInfo{
+		Key:          s.key,
+		Expiry:       s.expiry,
+		Device:       device,
+		Groups:       strings.Split(s.groups, ","),
+		ObjectId:     s.objectID,
+		RefreshToken: s.refreshToken,
+	}, nil
+}
+
+func (m *MemoryDB) ReadSessionInfo(_ context.Context, key string) (*SessionInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, s := range m.sessions {
+		if s.key == key {
+			return m.sessionInfo(s)
+		}
+	}
+
+	return nil, fmt.Errorf("reading session: %w", sql.ErrNoRows)
+}
+
+func (m *MemoryDB) ReadSessionInfos(_ context.Context) ([]SessionInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var sessionInfos []SessionInfo
+	for _, s := range m.sessions {
+		si, err := m.sessionInfo(s)
+		if err != nil {
+			return nil, err
+		}
+		sessionInfos = append(sessionInfos, *si)
+	}
+
+	return sessionInfos, nil
+}
+
+func (m *MemoryDB) AddSessionInfo(_ context.Context, si *SessionInfo) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.devices[si.Device.ID]; !ok {
+		return fmt.Errorf("adding session: device with id %d does not exist", si.Device.ID)
+	}
+
+	m.sessions = append(m.sessions, memorySession{
+		key:          si.Key,
+		expiry:       si.Expiry,
+		deviceID:     si.Device.ID,
+		groups:       strings.Join(si.Groups, ","),
+		objectID:     si.ObjectId,
+		refreshToken: si.RefreshToken,
+	})
+
+	return nil
+}
+
+// RenewSession updates the expiry, groups and refresh token of the session, returning sql.ErrNoRows if it does not exist.
+func (m *MemoryDB) RenewSession(_ context.Context, si *SessionInfo) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	renewed := false
+	for i := range m.sessions {
+		if m.sessions[i].key == si.Key {
+			m.sessions[i].expiry = si.Expiry
+			m.sessions[i].groups = strings.Join(si.Groups, ",")
+			m.sessions[i].refreshToken = si.RefreshToken
+			renewed = true
+		}
+	}
+
+	if !renewed {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// removeSessions deletes the matching sessions, and returns how many were deleted.
+func (m *MemoryDB) removeSessions(match func(s memorySession) bool) int64 {
+	var removed int64
+	sessions := m.sessions[:0]
+	for _, s := range m.sessions {
+		if match(s) {
+			removed++
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	m.sessions = sessions
+
+	return removed
+}
+
+// RemoveSession deletes the session with the given key, returning sql.ErrNoRows if there is none.
+func (m *MemoryDB) RemoveSession(_ context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	removed := m.removeSessions(func(s memorySession) bool {
+		return s.key == key
+	})
+
+	if removed == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (m *MemoryDB) RemoveUserSessions(_ context.Context, objectID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.removeSessions(func(s memorySession) bool {
+		return s.objectID == objectID
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) RemoveDeviceSessions(_ context.Context, deviceID int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.removeSessions(func(s memorySession) bool {
+		return s.deviceID == deviceID
+	})
+
+	return nil
+}
+
+func (m *MemoryDB) RemoveExpiredSessions(_ context.Context, now time.Time) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.removeSessions(func(s memorySession) bool {
+		return s.expiry <= now.Unix()
+	}), nil
+}
+
+func copyEnrollment(e *Enrollment) *Enrollment {
+	enrollment := *e
+	if e.Decided != nil {
+		decided := *e.Decided
+		enrollment.Decided = &decided
+	}
+	return &enrollment
+}
+
+// readEnrollments returns copies of the matching enrollments, oldest first.
+func (m *MemoryDB) readEnrollments(match func(e *Enrollment) bool) []Enrollment {
+	enrollments := make([]Enrollment, 0)
+	for _, e := range m.enrollments {
+		if match(e) {
+			enrollments = append(enrollments, *copyEnrollment(e))
+		}
+	}
+
+	sort.Slice(enrollments, func(i, j int) bool {
+		if enrollments[i].Created.Equal(enrollments[j].Created) {
+			return enrollments[i].ID < enrollments[j].ID
+		}
+		return enrollments[i].Created.Before(enrollments[j].Created)
+	})
+
+	return enrollments
+}
+
+func (m *MemoryDB) readEnrollment(match func(e *Enrollment) bool) (*Enrollment, error) {
+	enrollments := m.readEnrollments(match)
+	if len(enrollments) == 0 {
+		return nil, fmt.Errorf("reading enrollment: %w", sql.ErrNoRows)
+	}
+
+	return &enrollments[0], nil
+}
+
+func (m *MemoryDB) ReadEnrollment(_ context.Context, serial, platform string) (*Enrollment, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.readEnrollment(func(e *Enrollment) bool {
+		return e.Serial == serial && e.Platform == platform
+	})
+}
+
+func (m *MemoryDB) ReadEnrollmentByID(_ context.Context, id int) (*Enrollment, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.readEnrollment(func(e *Enrollment) bool {
+		return e.ID == id
+	})
+}
+
+func (m *MemoryDB) ReadEnrollments(_ context.Context, state string) ([]Enrollment, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.readEnrollments(func(e *Enrollment) bool {
+		return len(state) == 0 || e.State == state
+	}), nil
+}
+
+func (m *MemoryDB) ReadUnacknowledgedEnrollments(_ context.Context) ([]Enrollment, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.readEnrollments(func(e *Enrollment) bool {
+		return !e.Acknowledged
+	}), nil
+}
+
+// SaveEnrollment adds the enrollment, or replaces the enrollment of the same device. See APIServerDB.SaveEnrollment.
+func (m *MemoryDB) SaveEnrollment(_ context.Context, e *Enrollment) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	saved := copyEnrollment(e)
+	saved.Acknowledged = false
+	saved.ID, saved.Created = 0, time.Now()
+	for _, existing := range m.enrollments {
+		if existing.Serial == e.Serial && existing.Platform == e.Platform {
+			saved.ID, saved.Created = existing.ID, existing.Created
+		}
+	}
+
+	if saved.ID == 0 {
+		m.lastEnrollmentID++
+		saved.ID = m.lastEnrollmentID
+	}
+
+	m.enrollments[saved.ID] = saved
+	e.ID, e.Created, e.Acknowledged = saved.ID, saved.Created, false
+
+	return nil
+}
+
+// updateEnrollment applies the update to the enrollment, returning sql.ErrNoRows if it does not exist or match.
+func (m *MemoryDB) updateEnrollment(id int, match func(e *Enrollment) bool, update func(e *Enrollment)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	e, ok := m.enrollments[id]
+	if !ok || !match(e) {
+		return sql.ErrNoRows
+	}
+
+	update(e)
+	return nil
+}
+
+func (m *MemoryDB) SetEnrollmentState(_ context.Context, id int, state, decidedBy string) error {
+	return m.updateEnrollment(id, func(*Enrollment) bool { return true }, func(e *Enrollment) {
+		now := time.Now()
+		e.State = state
+		e.Decided = &now
+		e.DecidedBy = decidedBy
+		e.Acknowledged = false
+	})
+}
+
+func (m *MemoryDB) AcknowledgeEnrollment(_ context.Context, id int, state string) error {
+	return m.updateEnrollment(id, func(e *Enrollment) bool { return e.State == state }, func(e *Enrollment) {
+		e.Acknowledged = true
+	})
+}
+
+func (m *MemoryDB) RemoveEnrollment(_ context.Context, id int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.enrollments[id]; !ok {
+		return sql.ErrNoRows
+	}
+
+	delete(m.enrollments, id)
+	return nil
+}
+
+func (m *MemoryDB) AddAuditEvent(_ context.Context, e audit.Event) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	details := make(map[string]string, len(e.Details))
+	for k, v := range e.Details {
+		details[k] = v
+	}
+
+	m.lastAuditEventID++
+	e.ID = m.lastAuditEventID
+	e.Details = details
+	m.auditEvents = append(m.auditEvents, e)
+
+	return nil
+}
+
+// ReadAuditEvents returns the events matching the query, newest first, limited like APIServerDB.ReadAuditEvents.
+func (m *MemoryDB) ReadAuditEvents(_ context.Context, q audit.Query) ([]audit.Event, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultAuditEventLimit
+	}
+	if limit > maxAuditEventLimit {
+		limit = maxAuditEventLimit
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	events := make([]audit.Event, 0)
+	for _, e := range m.auditEvents {
+		switch {
+		case len(q.Action) > 0 && e.Action != q.Action,
+			len(q.Actor) > 0 && e.Actor != q.Actor,
+			len(q.Target) > 0 && e.Target != q.Target,
+			!q.Since.IsZero() && e.Time.Before(q.Since),
+			!q.Until.IsZero() && !e.Time.Before(q.Until):
+			continue
+		}
+		events = append(events, e)
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Time.Equal(events[j].Time) {
+			return events[i].ID > events[j].ID
+		}
+		return events[i].Time.After(events[j].Time)
+	})
+
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	return events, nil
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/nais/device/pkg/audit"
+	"github.com/nais/device/pkg/pb"
+)
+
+// Repository stores the devices, gateways, sessions, enrollments and audit events of the apiserver.
+// It is implemented by APIServerDB, backed by Postgres, and by MemoryDB for tests and local development.
+type Repository interface {
+	ReadDevices() ([]Device, error)
+	ReadDevice(publicKey string) (*Device, error)
+	ReadDeviceById(ctx context.Context, deviceID int) (*Device, error)
+	ReadDeviceBySerialPlatformUsername(ctx context.Context, serial string, platform string, username string) (*Device, error)
+	AddDevice(ctx context.Context, device Device) error
+	RemoveDevice(ctx context.Context, deviceID int) error
+	UpdateDeviceStatus(devices []Device) error
+	SetDeviceDisabled(ctx context.Context, deviceID int, disabled bool) error
+	SetDevicePublicKey(ctx context.Context, deviceID int, publicKey string) error
+	SetDeviceName(ctx context.Context, deviceID int, name string) error
+	TransferDevice(ctx context.Context, deviceID int, username, transferredBy string) error
+	ReadOwnershipTransfers(ctx context.Context, deviceID int) ([]OwnershipTransfer, error)
+
+	ReadGateways() ([]pb.Gateway, error)
+	ReadGateway(name string) (*pb.Gateway, error)
+	AddGateway(ctx context.Context, name, endpoint, publicKey string) error
+	UpdateGateway(ctx context.Context, name string, routes []*pb.Route, accessGroupIDs []string, requiresPrivilegedAccess bool) error
+	RemoveGateway(ctx context.Context, name string) error
+	SetGatewayDisabled(ctx context.Context, name string, disabled bool) error
+	SetGatewayPublicKey(ctx context.Context, name, publicKey string) error
+	RenameGateway(ctx context.Context, name, newName string) error
+
+	ReadSessionInfo(ctx context.Context, key string) (*SessionInfo, error)
+	ReadSessionInfos(ctx context.Context) ([]SessionInfo, error)
+	AddSessionInfo(ctx context.Context, si *SessionInfo) error
+	RenewSession(ctx context.Context, si *SessionInfo) error
+	RemoveSession(ctx context.Context, key string) error
+	RemoveUserSessions(ctx context.Context, objectID string) error
+	RemoveDeviceSessions(ctx context.Context, deviceID int) error
+	RemoveExpiredSessions(ctx context.Context, now time.Time) (int64, error)
+
+	ReadEnrollment(ctx context.Context, serial, platform string) (*Enrollment, error)
+	ReadEnrollmentByID(ctx context.Context, id int) (*Enrollment, error)
+	ReadEnrollments(ctx context.Context, state string) ([]Enrollment, error)
+	ReadUnacknowledgedEnrollments(ctx context.Context) ([]Enrollment, error)
+	SaveEnrollment(ctx context.Context, e *Enrollment) error
+	SetEnrollmentState(ctx context.Context, id int, state, decidedBy string) error
+	AcknowledgeEnrollment(ctx context.Context, id int, state string) error
+	RemoveEnrollment(ctx context.Context, id int) error
+
+	AddAuditEvent(ctx context.Context, e audit.Event) error
+	ReadAuditEvents(ctx context.Context, q audit.Query) ([]audit.Event, error)
+}
+
+var (
+	_ Repository = &APIServerDB{}
+	_ Repository = &MemoryDB{}
+)
@@ -0,0 +1,10 @@
+-- Run the entire migration as an atomic operation.
+START TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;
+
+-- Routes including protocol and port restrictions, stored as a JSON array.
+ALTER TABLE gateway ADD COLUMN route_policies varchar DEFAULT '';
+
+-- Mark this database migration as completed.
+INSERT INTO migrations (version, created)
+VALUES (2, now());
+COMMIT;
@@ -0,0 +1,25 @@
+-- Run the entire migration as an atomic operation.
+START TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;
+
+-- Tunnel addresses in use by devices, gateways and the apiserver.
+CREATE TABLE ip_allocation
+(
+    ip inet PRIMARY KEY
+);
+
+-- Reserve the apiserver address, and the addresses already given to devices and gateways.
+INSERT INTO ip_allocation (ip)
+VALUES ('10.255.240.1');
+
+INSERT INTO ip_allocation (ip)
+SELECT ip::inet FROM device WHERE ip IS NOT NULL AND ip <> ''
+ON CONFLICT DO NOTHING;
+
+INSERT INTO ip_allocation (ip)
+SELECT ip::inet FROM gateway WHERE ip IS NOT NULL AND ip <> ''
+ON CONFLICT DO NOTHING;
+
+-- Mark this database migration as completed.
+INSERT INTO migrations (version, created)
+VALUES (3, now());
+COMMIT;
@@ -0,0 +1,12 @@
+-- Run the entire migration as an atomic operation.
+START TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;
+
+-- Disabled devices and gateways are kept, but left out of all WireGuard configuration.
+ALTER TABLE device ADD COLUMN name varchar DEFAULT '';
+ALTER TABLE device ADD COLUMN disabled boolean DEFAULT false;
+ALTER TABLE gateway ADD COLUMN disabled boolean DEFAULT false;
+
+-- Mark this database migration as completed.
+INSERT INTO migrations (version, created)
+VALUES (4, now());
+COMMIT;
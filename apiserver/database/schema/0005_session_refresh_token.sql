@@ -0,0 +1,10 @@
+-- Run the entire migration as an atomic operation.
+START TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;
+
+-- Sessions are renewed with the identity provider using the refresh token from login.
+ALTER TABLE session ADD COLUMN refresh_token varchar DEFAULT '';
+
+-- Mark this database migration as completed.
+INSERT INTO migrations (version, created)
+VALUES (5, now());
+COMMIT;
@@ -0,0 +1,27 @@
+-- Run the entire migration as an atomic operation.
+START TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;
+
+-- Device enrollments that require approval, one per device. The bootstrap-api is told the state of the enrollment
+-- by its enrollment id, and acknowledged is reset whenever the state changes until it has been told.
+CREATE TYPE enrollment_state AS ENUM ('pending', 'approved', 'rejected');
+
+CREATE TABLE enrollment
+(
+    id            serial PRIMARY KEY,
+    enrollment_id varchar          NOT NULL,
+    serial        varchar          NOT NULL,
+    platform      platform         NOT NULL,
+    username      varchar          NOT NULL,
+    public_key    varchar(44)      NOT NULL,
+    state         enrollment_state NOT NULL DEFAULT 'pending',
+    created       timestamp with time zone NOT NULL DEFAULT now(),
+    decided       timestamp with time zone,
+    decided_by    varchar          NOT NULL DEFAULT '',
+    acknowledged  boolean          NOT NULL DEFAULT false,
+    UNIQUE (serial, platform)
+);
+
+-- Mark this database migration as completed.
+INSERT INTO migrations (version, created)
+VALUES (6, now());
+COMMIT;
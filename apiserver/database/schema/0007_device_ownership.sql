@@ -0,0 +1,26 @@
+-- Run the entire migration as an atomic operation.
+START TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;
+
+-- Audit record of devices transferred from one user to another. Serial and platform are kept along with the
+-- device id, so the record outlives the device.
+CREATE TABLE device_ownership_transfer
+(
+    id             serial PRIMARY KEY,
+    device_id      integer  NOT NULL,
+    serial         varchar  NOT NULL,
+    platform       platform NOT NULL,
+    from_username  varchar  NOT NULL,
+    to_username    varchar  NOT NULL,
+    transferred_by varchar  NOT NULL,
+    created        timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX device_ownership_transfer_device_id ON device_ownership_transfer (device_id);
+
+-- Devices are counted per user when enrolling.
+CREATE INDEX device_username ON device (username);
+
+-- Mark this database migration as completed.
+INSERT INTO migrations (version, created)
+VALUES (7, now());
+COMMIT;
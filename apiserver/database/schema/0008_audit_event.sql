@@ -0,0 +1,24 @@
+-- Run the entire migration as an atomic operation.
+START TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;
+
+-- Audit events recorded by the apiserver and the bootstrap-api.
+CREATE TABLE audit_event
+(
+    id        bigserial PRIMARY KEY,
+    time      timestamp with time zone NOT NULL,
+    component varchar NOT NULL,
+    action    varchar NOT NULL,
+    outcome   varchar NOT NULL,
+    actor     varchar NOT NULL DEFAULT '',
+    target    varchar NOT NULL DEFAULT '',
+    details   jsonb   NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX audit_event_time ON audit_event (time);
+CREATE INDEX audit_event_action ON audit_event (action);
+CREATE INDEX audit_event_actor ON audit_event (actor);
+
+-- Mark this database migration as completed.
+INSERT INTO migrations (version, created)
+VALUES (8, now());
+COMMIT;
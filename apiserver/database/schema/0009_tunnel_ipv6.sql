@@ -0,0 +1,12 @@
+-- Run the entire migration as an atomic operation.
+START TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;
+
+-- IPv6 tunnel addresses are stored when allocated, so that changing the IPv6 prefix does not re-address existing
+-- devices and gateways. Existing devices and gateways are given their address by the apiserver on startup.
+ALTER TABLE device ADD COLUMN ipv6 varchar(39) UNIQUE;
+ALTER TABLE gateway ADD COLUMN ipv6 varchar(39) UNIQUE;
+
+-- Mark this database migration as completed.
+INSERT INTO migrations (version, created)
+VALUES (9, now());
+COMMIT;
@@ -12,6 +12,9 @@ CREATE TABLE device
     kolide_last_seen bigint,
     public_key       varchar(44) NOT NULL UNIQUE,
     ip               varchar(15) UNIQUE,
+    name             varchar DEFAULT '',
+    disabled         boolean DEFAULT false,
+    ipv6             varchar(39) UNIQUE,
     UNIQUE (serial, platform)
 );
 
@@ -24,14 +27,73 @@ CREATE TABLE gateway
     public_key                 varchar(44) NOT NULL UNIQUE,
     ip                         varchar(15) UNIQUE,
     routes                     varchar DEFAULT '',
-    requires_privileged_access boolean DEFAULT false
+    requires_privileged_access boolean DEFAULT false,
+    route_policies             varchar DEFAULT '',
+    disabled                   boolean DEFAULT false,
+    ipv6                       varchar(39) UNIQUE
 );
 
 CREATE TABLE session
 (
-    key       varchar,
-    expiry    bigint,
-    device_id integer REFERENCES device (id),
-    groups    varchar,
-    object_id varchar
+    key           varchar,
+    expiry        bigint,
+    device_id     integer REFERENCES device (id),
+    groups        varchar,
+    object_id     varchar,
+    refresh_token varchar DEFAULT ''
 );
+
+CREATE TABLE ip_allocation
+(
+    ip inet PRIMARY KEY
+);
+
+CREATE TYPE enrollment_state AS ENUM ('pending', 'approved', 'rejected');
+
+CREATE TABLE enrollment
+(
+    id            serial PRIMARY KEY,
+    enrollment_id varchar          NOT NULL,
+    serial        varchar          NOT NULL,
+    platform      platform         NOT NULL,
+    username      varchar          NOT NULL,
+    public_key    varchar(44)      NOT NULL,
+    state         enrollment_state NOT NULL DEFAULT 'pending',
+    created       timestamp with time zone NOT NULL DEFAULT now(),
+    decided       timestamp with time zone,
+    decided_by    varchar          NOT NULL DEFAULT '',
+    acknowledged  boolean          NOT NULL DEFAULT false,
+    UNIQUE (serial, platform)
+);
+
+CREATE INDEX device_username ON device (username);
+
+CREATE TABLE device_ownership_transfer
+(
+    id             serial PRIMARY KEY,
+    device_id      integer  NOT NULL,
+    serial         varchar  NOT NULL,
+    platform       platform NOT NULL,
+    from_username  varchar  NOT NULL,
+    to_username    varchar  NOT NULL,
+    transferred_by varchar  NOT NULL,
+    created        timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX device_ownership_transfer_device_id ON device_ownership_transfer (device_id);
+
+CREATE TABLE audit_event
+(
+    id        bigserial PRIMARY KEY,
+    time      timestamp with time zone NOT NULL,
+    component varchar NOT NULL,
+    action    varchar NOT NULL,
+    outcome   varchar NOT NULL,
+    actor     varchar NOT NULL DEFAULT '',
+    target    varchar NOT NULL DEFAULT '',
+    details   jsonb   NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX audit_event_time ON audit_event (time);
+CREATE INDEX audit_event_action ON audit_event (action);
+CREATE INDEX audit_event_actor ON audit_event (actor);
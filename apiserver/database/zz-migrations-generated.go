@@ -4,4 +4,12 @@ package database
 
 var migrations = []string{
 	"-- Run the entire migration as an atomic operation.\nSTART TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;\n\nCREATE TYPE platform AS ENUM ('darwin', 'linux', 'windows');\n\nCREATE TABLE device\n(\n    id               serial PRIMARY KEY,\n    username         varchar,\n    serial           varchar,\n    psk              varchar(44),\n    platform         platform,\n    healthy          boolean,\n    last_updated     bigint,\n    kolide_last_seen bigint,\n    public_key       varchar(44) NOT NULL UNIQUE,\n    ip               varchar(15) UNIQUE,\n    UNIQUE (serial, platform)\n);\n\nCREATE TABLE gateway\n(\n    id                         serial PRIMARY KEY,\n    name                       varchar     NOT NULL UNIQUE,\n    access_group_ids           varchar DEFAULT '',\n    endpoint                   varchar(21),\n    public_key                 varchar(44) NOT NULL UNIQUE,\n    ip                         varchar(15) UNIQUE,\n    routes                     varchar DEFAULT '',\n    requires_privileged_access boolean DEFAULT false\n);\n\nCREATE TABLE session\n(\n    key       varchar,\n    expiry    bigint,\n    device_id integer REFERENCES device (id),\n    groups    varchar,\n    object_id varchar\n);\n\n-- Database migration\nCREATE TABLE migrations\n(\n    \"version\" int primary key          not null,\n    \"created\" timestamp with time zone not null\n);\n\n-- Mark this database migration as completed.\nINSERT INTO migrations (version, created)\nVALUES (1, now());\nCOMMIT;\n",
+	"-- Run the entire migration as an atomic operation.\nSTART TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;\n\n-- Routes including protocol and port restrictions, stored as a JSON array.\nALTER TABLE gateway ADD COLUMN route_policies varchar DEFAULT '';\n\n-- Mark this database migration as completed.\nINSERT INTO migrations (version, created)\nVALUES (2, now());\nCOMMIT;\n",
+	"-- Run the entire migration as an atomic operation.\nSTART TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;\n\n-- Tunnel addresses in use by devices, gateways and the apiserver.\nCREATE TABLE ip_allocation\n(\n    ip inet PRIMARY KEY\n);\n\n-- Reserve the apiserver address, and the addresses already given to devices and gateways.\nINSERT INTO ip_allocation (ip)\nVALUES ('10.255.240.1');\n\nINSERT INTO ip_allocation (ip)\nSELECT ip::inet FROM device WHERE ip IS NOT NULL AND ip <> ''\nON CONFLICT DO NOTHING;\n\nINSERT INTO ip_allocation (ip)\nSELECT ip::inet FROM gateway WHERE ip IS NOT NULL AND ip <> ''\nON CONFLICT DO NOTHING;\n\n-- Mark this database migration as completed.\nINSERT INTO migrations (version, created)\nVALUES (3, now());\nCOMMIT;\n",
+	"-- Run the entire migration as an atomic operation.\nSTART TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;\n\n-- Disabled devices and gateways are kept, but left out of all WireGuard configuration.\nALTER TABLE device ADD COLUMN name varchar DEFAULT '';\nALTER TABLE device ADD COLUMN disabled boolean DEFAULT false;\nALTER TABLE gateway ADD COLUMN disabled boolean DEFAULT false;\n\n-- Mark this database migration as completed.\nINSERT INTO migrations (version, created)\nVALUES (4, now());\nCOMMIT;\n",
+	"-- Run the entire migration as an atomic operation.\nSTART TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;\n\n-- Sessions are renewed with the identity provider using the refresh token from login.\nALTER TABLE session ADD COLUMN refresh_token varchar DEFAULT '';\n\n-- Mark this database migration as completed.\nINSERT INTO migrations (version, created)\nVALUES (5, now());\nCOMMIT;\n",
+	"-- Run the entire migration as an atomic operation.\nSTART TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;\n\n-- Device enrollments that require approval, one per device. The bootstrap-api is told the state of the enrollment\n-- by its enrollment id, and acknowledged is reset whenever the state changes until it has been told.\nCREATE TYPE enrollment_state AS ENUM ('pending', 'approved', 'rejected');\n\nCREATE TABLE enrollment\n(\n    id            serial PRIMARY KEY,\n    enrollment_id varchar          NOT NULL,\n    serial        varchar          NOT NULL,\n    platform      platform         NOT NULL,\n    username      varchar          NOT NULL,\n    public_key    varchar(44)      NOT NULL,\n    state         enrollment_state NOT NULL DEFAULT 'pending',\n    created       timestamp with time zone NOT NULL DEFAULT now(),\n    decided       timestamp with time zone,\n    decided_by    varchar          NOT NULL DEFAULT '',\n    acknowledged  boolean          NOT NULL DEFAULT false,\n    UNIQUE (serial, platform)\n);\n\n-- Mark this database migration as completed.\nINSERT INTO migrations (version, created)\nVALUES (6, now());\nCOMMIT;\n",
+	"-- Run the entire migration as an atomic operation.\nSTART TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;\n\n-- Audit record of devices transferred from one user to another. Serial and platform are kept along with the\n-- device id, so the record outlives the device.\nCREATE TABLE device_ownership_transfer\n(\n    id             serial PRIMARY KEY,\n    device_id      integer  NOT NULL,\n    serial         varchar  NOT NULL,\n    platform       platform NOT NULL,\n    from_username  varchar  NOT NULL,\n    to_username    varchar  NOT NULL,\n    transferred_by varchar  NOT NULL,\n    created        timestamp with time zone NOT NULL DEFAULT now()\n);\n\nCREATE INDEX device_ownership_transfer_device_id ON device_ownership_transfer (device_id);\n\n-- Devices are counted per user when enrolling.\nCREATE INDEX device_username ON device (username);\n\n-- Mark this database migration as completed.\nINSERT INTO migrations (version, created)\nVALUES (7, now());\nCOMMIT;\n",
+	"-- Run the entire migration as an atomic operation.\nSTART TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;\n\n-- Audit events recorded by the apiserver and the bootstrap-api.\nCREATE TABLE audit_event\n(\n    id        bigserial PRIMARY KEY,\n    time      timestamp with time zone NOT NULL,\n    component varchar NOT NULL,\n    action    varchar NOT NULL,\n    outcome   varchar NOT NULL,\n    actor     varchar NOT NULL DEFAULT '',\n    target    varchar NOT NULL DEFAULT '',\n    details   jsonb   NOT NULL DEFAULT '{}'\n);\n\nCREATE INDEX audit_event_time ON audit_event (time);\nCREATE INDEX audit_event_action ON audit_event (action);\nCREATE INDEX audit_event_actor ON audit_event (actor);\n\n-- Mark this database migration as completed.\nINSERT INTO migrations (version, created)\nVALUES (8, now());\nCOMMIT;\n",
+	"-- Run the entire migration as an atomic operation.\nSTART TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;\n\n-- IPv6 tunnel addresses are stored when allocated, so that changing the IPv6 prefix does not re-address existing\n-- devices and gateways. Existing devices and gateways are given their address by the apiserver on startup.\nALTER TABLE device ADD COLUMN ipv6 varchar(39) UNIQUE;\nALTER TABLE gateway ADD COLUMN ipv6 varchar(39) UNIQUE;\n\n-- Mark this database migration as completed.\nINSERT INTO migrations (version, created)\nVALUES (9, now());\nCOMMIT;\n",
 }
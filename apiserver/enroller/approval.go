@@ -0,0 +1,78 @@
+package enroller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nais/device/pkg/bootstrap"
+)
+
+// ApprovalRule approves device enrollments without an administrator. Empty fields match anything.
+type ApprovalRule struct {
+	Platform string
+	Group    string
+}
+
+// ParseApprovalRule parses a rule on format 'platform=<platform>,group=<group id>', where either key may be left out.
+func ParseApprovalRule(s string) (ApprovalRule, error) {
+	var rule ApprovalRule
+	for _, field := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) != 2 || len(parts[1]) == 0 {
+			return rule, fmt.Errorf("invalid approval rule field %q, should be on format 'key=value'", field)
+		}
+
+		switch parts[0] {
+		case "platform":
+			rule.Platform = parts[1]
+		case "group":
+			rule.Group = parts[1]
+		default:
+			return rule, fmt.Errorf("unknown approval rule key %q, should be platform or group", parts[0])
+		}
+	}
+
+	return rule, nil
+}
+
+func ParseApprovalRules(entries []string) ([]ApprovalRule, error) {
+	rules := make([]ApprovalRule, 0, len(entries))
+	for _, entry := range entries {
+		rule, err := ParseApprovalRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (r ApprovalRule) Matches(info bootstrap.DeviceInfo) bool {
+	if len(r.Platform) > 0 && r.Platform != info.Platform {
+		return false
+	}
+
+	if len(r.Group) == 0 {
+		return true
+	}
+
+	for _, group := range info.Groups {
+		if group == r.Group {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r ApprovalRule) String() string {
+	var fields []string
+	if len(r.Platform) > 0 {
+		fields = append(fields, "platform="+r.Platform)
+	}
+	if len(r.Group) > 0 {
+		fields = append(fields, "group="+r.Group)
+	}
+	return strings.Join(fields, ",")
+}
@@ -0,0 +1,40 @@
+package enroller_test
+
+import (
+	"testing"
+
+	"github.com/nais/device/apiserver/enroller"
+	"github.com/nais/device/pkg/bootstrap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseApprovalRule(t *testing.T) {
+	rule, err := enroller.ParseApprovalRule("platform=darwin, group=group1")
+	assert.NoError(t, err)
+	assert.Equal(t, enroller.ApprovalRule{Platform: "darwin", Group: "group1"}, rule)
+	assert.Equal(t, "platform=darwin,group=group1", rule.String())
+
+	for _, invalid := range []string{"", "platform", "platform=", "owner=me", "platform=darwin,"} {
+		_, err := enroller.ParseApprovalRule(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestApprovalRuleMatches(t *testing.T) {
+	info := bootstrap.DeviceInfo{Platform: "linux", Groups: []string{"group1", "group2"}}
+
+	for _, tc := range []struct {
+		rule    enroller.ApprovalRule
+		matches bool
+	}{
+		{enroller.ApprovalRule{}, true},
+		{enroller.ApprovalRule{Platform: "linux"}, true},
+		{enroller.ApprovalRule{Platform: "darwin"}, false},
+		{enroller.ApprovalRule{Group: "group2"}, true},
+		{enroller.ApprovalRule{Group: "group3"}, false},
+		{enroller.ApprovalRule{Platform: "linux", Group: "group1"}, true},
+		{enroller.ApprovalRule{Platform: "windows", Group: "group1"}, false},
+	} {
+		assert.Equal(t, tc.matches, tc.rule.Matches(info), tc.rule.String())
+	}
+}
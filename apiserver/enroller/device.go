@@ -1,96 +1,241 @@
 package enroller
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"fmt"
+	"time"
+
 	"github.com/nais/device/apiserver/database"
+	"github.com/nais/device/pkg/audit"
 	"github.com/nais/device/pkg/bootstrap"
 	log "github.com/sirupsen/logrus"
-	"time"
 )
 
+const kindDevice = "device"
+
+// autoApproved is recorded as the decider of enrollments approved without an administrator.
+const autoApproved = "auto-approval"
+
 func (e *Enroller) WatchDeviceEnrollments(ctx context.Context) {
-	for {
-		select {
-		case <-time.After(1 * time.Second):
-			if err := e.EnrollDevice(ctx); err != nil {
-				log.Errorf("Enrolling devices: %v", err)
-			}
-		case <-ctx.Done():
-			return
-		}
-	}
+	watch(ctx, kindDevice, e.EnrollDevice)
 }
 
+// EnrollDevice waits for pending device enrollments, and enrolls them. It returns an error if any of them failed.
+// Decisions on enrollments requiring approval are passed on to the bootstrap-api first.
 func (e *Enroller) EnrollDevice(ctx context.Context) error {
-	deviceInfos, err := e.fetchDeviceInfos(e.BootstrapAPIURL)
+	failed := 0
+	synced, err := e.DB.ReadUnacknowledgedEnrollments(ctx)
 	if err != nil {
-		return fmt.Errorf("bootstrap: Fetching device infos: %v", err)
+		return fmt.Errorf("bootstrap: Reading unacknowledged enrollments: %w", err)
 	}
 
-	for _, enrollment := range deviceInfos {
-		err := e.DB.AddDevice(ctx, database.Device{
-			Username:  enrollment.Owner,
-			PublicKey: enrollment.PublicKey,
-			Serial:    enrollment.Serial,
-			Platform:  enrollment.Platform,
-		})
-
-		if err != nil {
-			return fmt.Errorf("bootstrap: Adding device: %v", err)
+	for _, enrollment := range synced {
+		if err := e.syncEnrollment(ctx, enrollment); err != nil {
+			log.Errorf("bootstrap: Syncing %s enrollment of device %s: %v", enrollment.State, enrollment.Serial, err)
+			EnrollmentFailures.WithLabelValues(kindDevice).Inc()
+			failed++
 		}
+	}
+
+	var enrollments []bootstrap.DeviceEnrollment
+	if err := e.fetchEnrollments(ctx, kindDevice, &enrollments); err != nil {
+		return fmt.Errorf("bootstrap: Fetching device enrollments: %w", err)
+	}
 
-		device, err := e.DB.ReadDevice(enrollment.PublicKey)
+	for _, enrollment := range enrollments {
+		err := e.enrollDevice(ctx, enrollment)
 		if err != nil {
-			return fmt.Errorf("bootstrap: Getting device: %v", err)
+			log.Errorf("bootstrap: Enrolling device %s (enrollment %s): %v", enrollment.Info.Serial, enrollment.ID, err)
+			EnrollmentFailures.WithLabelValues(kindDevice).Inc()
+			failed++
+			continue
 		}
 
-		bootstrapConfig := bootstrap.Config{
-			DeviceIP:       device.IP,
-			PublicKey:      e.APIServerPublicKey,
-			TunnelEndpoint: e.APIServerEndpoint,
-			APIServerIP:    "10.255.240.1",
-		}
+		Enrollments.WithLabelValues(kindDevice).Inc()
+	}
 
-		err = e.postDeviceConfig(device.Serial, bootstrapConfig)
+	if failed > 0 {
+		return fmt.Errorf("bootstrap: %d of %d device enrollments failed", failed, len(synced)+len(enrollments))
+	}
 
-		if err != nil {
-			return fmt.Errorf("bootstrap: Pushing bootstrap config: %v", err)
+	return nil
+}
+
+func (e *Enroller) enrollDevice(ctx context.Context, enrollment bootstrap.DeviceEnrollment) error {
+	if !e.RequireApproval {
+		return e.addDevice(ctx, enrollment.ID, enrollment.Info)
+	}
+
+	record, err := e.decide(ctx, enrollment)
+	if err != nil {
+		return err
+	}
+
+	if err := e.DB.SaveEnrollment(ctx, record); err != nil {
+		return err
+	}
+
+	return e.syncEnrollment(ctx, *record)
+}
+
+// decide returns the enrollment record for a device enrollment requiring approval. Known devices and devices matching
+// an approval rule are approved right away. Otherwise, the device keeps any earlier decision made for the same user
+// and key, and is pending approval if there is none.
+func (e *Enroller) decide(ctx context.Context, enrollment bootstrap.DeviceEnrollment) (*database.Enrollment, error) {
+	info := enrollment.Info
+	record := &database.Enrollment{
+		EnrollmentID: enrollment.ID,
+		Serial:       info.Serial,
+		Platform:     info.Platform,
+		Username:     info.Owner,
+		PublicKey:    info.PublicKey,
+		State:        database.EnrollmentPending,
+	}
+
+	approve := func(decidedBy, reason string) (*database.Enrollment, error) {
+		e.Audit.Record(audit.Event{
+			Action:  audit.ActionEnrollmentDecided,
+			Outcome: audit.OutcomeSuccess,
+			Actor:   decidedBy,
+			Target:  info.Serial,
+			Details: map[string]string{"platform": info.Platform, "username": info.Owner, "state": database.EnrollmentApproved, "reason": reason},
+		})
+		now := time.Now()
+		record.State = database.EnrollmentApproved
+		record.Decided = &now
+		record.DecidedBy = decidedBy
+		return record, nil
+	}
+
+	_, err := e.DB.ReadDeviceBySerialPlatformUsername(ctx, info.Serial, info.Platform, info.Owner)
+	switch {
+	case err == nil:
+		return approve(autoApproved, "known device")
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, fmt.Errorf("reading device: %w", err)
+	}
+
+	for _, rule := range e.ApprovalRules {
+		if rule.Matches(info) {
+			log.Infof("bootstrap: Device %s of %s approved by rule %s", info.Serial, info.Owner, rule)
+			return approve(autoApproved, "rule "+rule.String())
 		}
+	}
 
-		log.Infof("bootstrap: Bootstrapped device: %+v", bootstrapConfig)
+	existing, err := e.DB.ReadEnrollment(ctx, info.Serial, info.Platform)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return record, nil
+	case err != nil:
+		return nil, fmt.Errorf("reading enrollment: %w", err)
 	}
 
-	return nil
+	if existing.Username == info.Owner && existing.PublicKey == info.PublicKey {
+		record.State = existing.State
+		record.Decided = existing.Decided
+		record.DecidedBy = existing.DecidedBy
+	}
+
+	return record, nil
 }
-func (e *Enroller) postDeviceConfig(serial string, bootstrapConfig bootstrap.Config) error {
-	b, err := json.Marshal(bootstrapConfig)
-	if err != nil {
-		return fmt.Errorf("marshalling config: %w", err)
+
+// syncEnrollment passes the state of the enrollment on to the bootstrap-api, and marks it as acknowledged.
+// Enrollments that have expired from the bootstrap-api are acknowledged as well, as they are decided again when the
+// device retries.
+func (e *Enroller) syncEnrollment(ctx context.Context, enrollment database.Enrollment) error {
+	var err error
+	switch enrollment.State {
+	case database.EnrollmentApproved:
+		err = e.addDevice(ctx, enrollment.EnrollmentID, bootstrap.DeviceInfo{
+			Serial:    enrollment.Serial,
+			PublicKey: enrollment.PublicKey,
+			Platform:  enrollment.Platform,
+			Owner:     enrollment.Username,
+		})
+	case database.EnrollmentPending:
+		err = e.setState(ctx, kindDevice, enrollment.EnrollmentID, bootstrap.EnrollmentPendingApproval, "")
+	case database.EnrollmentRejected:
+		err = e.setState(ctx, kindDevice, enrollment.EnrollmentID, bootstrap.EnrollmentRejected, "")
+	default:
+		return fmt.Errorf("unknown enrollment state %q", enrollment.State)
 	}
 
-	r, err := e.Client.Post(fmt.Sprintf("%s/api/v2/device/config/%s", e.BootstrapAPIURL, serial), "application/json", bytes.NewReader(b))
-	if err != nil {
-		return fmt.Errorf("posting bootstrap config: %w", err)
+	if errors.Is(err, errEnrollmentNotFound) {
+		log.Infof("bootstrap: Enrollment %s of device %s has expired from the bootstrap-api", enrollment.EnrollmentID, enrollment.Serial)
+	} else if err != nil {
+		return err
 	}
 
-	log.Infof("bootstrap: Bootstrapped %+v, response: %v", bootstrapConfig, r)
-	return nil
+	err = e.DB.AcknowledgeEnrollment(ctx, enrollment.ID, enrollment.State)
+	if errors.Is(err, sql.ErrNoRows) {
+		// decided again in the meantime, the new state is synced on the next round
+		return nil
+	}
+
+	return err
 }
 
-func (e *Enroller) fetchDeviceInfos(bootstrapURL string) ([]bootstrap.DeviceInfo, error) {
-	r, err := e.Client.Get(bootstrapURL + "/api/v2/device/info")
+// addDevice enrolls the device, and acknowledges the enrollment with its config. Enrollments of devices belonging to
+// another user, or exceeding the user's device limit, are rejected instead.
+func (e *Enroller) addDevice(ctx context.Context, enrollmentID string, info bootstrap.DeviceInfo) error {
+	err := e.DB.AddDevice(ctx, database.Device{
+		Username:  info.Owner,
+		PublicKey: info.PublicKey,
+		Serial:    info.Serial,
+		Platform:  info.Platform,
+	})
+
+	var reason string
+	switch {
+	case errors.Is(err, database.ErrDeviceOwnedByOtherUser):
+		reason = "this device is enrolled by another user, ask an administrator to transfer it to you"
+	case errors.Is(err, database.ErrDeviceLimitReached):
+		reason = fmt.Sprintf("you have reached the maximum of %d devices, ask an administrator to remove one you no longer use", e.MaxDevicesPerUser)
+	}
+
+	if len(reason) > 0 {
+		log.Warnf("bootstrap: Rejecting enrollment of device %s by %s: %v", info.Serial, info.Owner, err)
+		EnrollmentRejections.WithLabelValues(kindDevice).Inc()
+		e.Audit.Record(audit.Event{
+			Action:  audit.ActionDeviceRejected,
+			Outcome: audit.OutcomeDenied,
+			Actor:   info.Owner,
+			Target:  info.Serial,
+			Details: map[string]string{"platform": info.Platform, "enrollmentID": enrollmentID, "reason": err.Error()},
+		})
+		return e.setState(ctx, kindDevice, enrollmentID, bootstrap.EnrollmentRejected, reason)
+	}
+
 	if err != nil {
-		return nil, fmt.Errorf("getting device infos: %w", err)
+		return fmt.Errorf("adding device: %w", err)
 	}
 
-	var deviceInfos []bootstrap.DeviceInfo
-	err = json.NewDecoder(r.Body).Decode(&deviceInfos)
+	device, err := e.DB.ReadDevice(info.PublicKey)
 	if err != nil {
-		return nil, fmt.Errorf("decoding deviceInfos: %w", err)
+		return fmt.Errorf("getting device: %w", err)
+	}
+
+	bootstrapConfig := bootstrap.Config{
+		DeviceIP:       device.IP,
+		DeviceIPv6:     device.IPv6,
+		PublicKey:      e.APIServerPublicKey,
+		TunnelEndpoint: e.APIServerEndpoint,
+		APIServerIP:    e.APIServerIP,
 	}
 
-	return deviceInfos, nil
+	if err := e.ack(ctx, kindDevice, enrollmentID, bootstrapConfig); err != nil {
+		return err
+	}
+
+	e.Audit.Record(audit.Event{
+		Action:  audit.ActionDeviceEnrolled,
+		Outcome: audit.OutcomeSuccess,
+		Actor:   info.Owner,
+		Target:  info.Serial,
+		Details: map[string]string{"platform": info.Platform, "enrollmentID": enrollmentID, "ip": device.IP},
+	})
+	log.Infof("bootstrap: Bootstrapped device: %+v", bootstrapConfig)
+	return nil
 }
@@ -1,14 +1,146 @@
 package enroller
 
 import (
-	"github.com/nais/device/apiserver/database"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"time"
+
+	"github.com/nais/device/apiserver/database"
+	"github.com/nais/device/pkg/audit"
+	"github.com/nais/device/pkg/bootstrap"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	enrollmentWait    = 20 * time.Second // how long the bootstrap-api holds the request for pending enrollments
+	enrollmentBackoff = 1 * time.Second  // time to wait before retrying when enrolling fails
 )
 
 type Enroller struct {
 	Client             *http.Client
-	DB                 *database.APIServerDB
+	DB                 database.Repository
 	BootstrapAPIURL    string
 	APIServerPublicKey string
 	APIServerEndpoint  string
+	// APIServerIP is the apiserver's tunnel address, given to devices and gateways in their bootstrap config.
+	APIServerIP string
+	// RequireApproval holds device enrollments until approved by an administrator or one of the ApprovalRules.
+	RequireApproval bool
+	ApprovalRules   []ApprovalRule
+	// MaxDevicesPerUser is the device limit enforced by the DB, which is shown to users whose enrollments exceed it.
+	MaxDevicesPerUser int
+	Audit             *audit.Log
+}
+
+// errEnrollmentNotFound is returned when the bootstrap-api no longer has the enrollment, e.g. because it expired.
+var errEnrollmentNotFound = errors.New("enrollment not found in bootstrap-api")
+
+// watch calls enroll until the context is done, backing off after failures.
+// Enrollments that fail are left unacknowledged in the bootstrap-api, and are returned again on the next call.
+func watch(ctx context.Context, kind string, enroll func(ctx context.Context) error) {
+	for ctx.Err() == nil {
+		if err := enroll(ctx); err != nil {
+			log.Errorf("Enrolling %ss: %v", kind, err)
+
+			select {
+			case <-time.After(enrollmentBackoff):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// fetchEnrollments long-polls the bootstrap-api for pending enrollments, and decodes them into enrollments.
+func (e *Enroller) fetchEnrollments(ctx context.Context, kind string, enrollments interface{}) error {
+	url := fmt.Sprintf("%s/api/v2/%s/enrollments?wait=%s", e.BootstrapAPIURL, kind, enrollmentWait)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	r, err := e.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("getting %s enrollments: %w", kind, err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("getting %s enrollments: bootstrap-api returned status %v", kind, r.Status)
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(enrollments); err != nil {
+		return fmt.Errorf("decoding %s enrollments: %w", kind, err)
+	}
+
+	return nil
+}
+
+// ack acknowledges the enrollment with the config, which makes it available to the device or gateway.
+func (e *Enroller) ack(ctx context.Context, kind, id string, bootstrapConfig bootstrap.Config) error {
+	b, err := json.Marshal(bootstrapConfig)
+	if err != nil {
+		return fmt.Errorf("marshalling config: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/api/v2/%s/enrollments/%s/ack", e.BootstrapAPIURL, kind, id)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	r, err := e.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("acknowledging enrollment: %w", err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode == http.StatusNotFound {
+		return errEnrollmentNotFound
+	}
+
+	if r.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(r.Body)
+		return fmt.Errorf("acknowledging enrollment: bootstrap-api returned status %v: %s", r.Status, string(body))
+	}
+
+	return nil
+}
+
+// setState tells the bootstrap-api that the enrollment is awaiting approval or has been rejected, along with the
+// reason shown to the user if it was not rejected by an administrator.
+func (e *Enroller) setState(ctx context.Context, kind, id string, state bootstrap.EnrollmentState, reason string) error {
+	b, err := json.Marshal(bootstrap.EnrollmentStatus{State: state, Reason: reason})
+	if err != nil {
+		return fmt.Errorf("marshalling enrollment status: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/api/v2/%s/enrollments/%s/state", e.BootstrapAPIURL, kind, id)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	r, err := e.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("setting enrollment state: %w", err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode == http.StatusNotFound {
+		return errEnrollmentNotFound
+	}
+
+	if r.StatusCode != http.StatusNoContent {
+		body, _ := ioutil.ReadAll(r.Body)
+		return fmt.Errorf("setting enrollment state: bootstrap-api returned status %v: %s", r.Status, string(body))
+	}
+
+	return nil
 }
@@ -4,13 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/nais/device/apiserver/database"
 	"github.com/nais/device/apiserver/enroller"
 	"github.com/nais/device/apiserver/testdatabase"
 	"github.com/nais/device/pkg/bootstrap"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"sync"
 	"testing"
 )
@@ -28,146 +28,283 @@ const (
 )
 
 func TestWatchGatewayEnrollments(t *testing.T) {
-	if os.Getenv("RUN_INTEGRATION_TESTS") == "" {
-		t.Skip("Skipping integration test")
-	}
+	testdatabase.ForEach(t, database.DefaultConfig(), func(t *testing.T, testDB database.Repository) {
+		once := sync.Once{}
+		success := false
+		mux := http.NewServeMux()
 
-	once := sync.Once{}
-	success := false
-	mux := http.NewServeMux()
+		mux.HandleFunc("/api/v2/gateway/enrollments/enrollment-1/ack", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				t.Fatalf("invalid method")
+			}
 
-	mux.HandleFunc("/api/v2/gateway/config/gateway-1", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			t.Fatalf("invalid method")
-		}
+			var cfg bootstrap.Config
+			err := json.NewDecoder(r.Body).Decode(&cfg)
+			assert.NoError(t, err)
 
-		var cfg bootstrap.Config
-		err := json.NewDecoder(r.Body).Decode(&cfg)
-		assert.NoError(t, err)
+			assert.Equal(t, apiServerPublicKey, cfg.PublicKey)
+			assert.Equal(t, endpoint, cfg.TunnelEndpoint)
 
-		assert.Equal(t, apiServerPublicKey, cfg.PublicKey)
-		assert.Equal(t, endpoint, cfg.TunnelEndpoint)
+			success = true
+			w.WriteHeader(http.StatusCreated)
+		})
 
-		success = true
-		w.WriteHeader(http.StatusCreated)
-	})
+		mux.HandleFunc("/api/v2/gateway/enrollments", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				t.Fatalf("invalid method")
+			}
+
+			gwInfos := []bootstrap.GatewayEnrollment{{
+				ID: "enrollment-1",
+				Info: bootstrap.GatewayInfo{
+					Name:      gatewayName,
+					PublicIP:  gatewayEndpoint,
+					PublicKey: gatewayPublicKey,
+				},
+			}}
+
+			b, err := json.Marshal(&gwInfos)
+			assert.NoError(t, err)
+
+			once.Do(func() {
+				w.Write(b)
+				return
+			})
+
+			fmt.Fprint(w, `[]`)
+		})
 
-	mux.HandleFunc("/api/v2/gateway/info", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			t.Fatalf("invalid method")
+		server := httptest.NewServer(mux)
+		enr := enroller.Enroller{
+			Client:             server.Client(),
+			DB:                 testDB,
+			BootstrapAPIURL:    server.URL,
+			APIServerPublicKey: apiServerPublicKey,
+			APIServerEndpoint:  endpoint,
 		}
 
-		gwInfos := []bootstrap.GatewayInfo{{
-			Name:      gatewayName,
-			PublicIP:  gatewayEndpoint,
-			PublicKey: gatewayPublicKey,
-		}}
+		assert.NoError(t, enr.EnrollGateways(context.Background()))
 
-		b, err := json.Marshal(&gwInfos)
+		gateway, err := testDB.ReadGateway(gatewayName)
 		assert.NoError(t, err)
 
-		once.Do(func() {
-			w.Write(b)
-			return
-		})
+		assert.Equal(t, gatewayEndpoint, gateway.Endpoint)
+		assert.Equal(t, gatewayPublicKey, gateway.PublicKey)
 
-		fmt.Fprint(w, `[]`)
+		if !success {
+			t.Errorf("no success")
+		}
 	})
-	ctx := context.Background()
-
-	testDB, err := testdatabase.New(ctx, "user=postgres password=postgres host=localhost port=5433 sslmode=disable")
-	assert.NoError(t, err)
-	server := httptest.NewServer(mux)
-	enr := enroller.Enroller{
-		Client:             server.Client(),
-		DB:                 testDB,
-		BootstrapAPIURL:    server.URL,
-		APIServerPublicKey: apiServerPublicKey,
-		APIServerEndpoint:  endpoint,
-	}
-
-	assert.NoError(t, enr.EnrollGateways(context.Background()))
-
-	gateway, err := testDB.ReadGateway(gatewayName)
-	assert.NoError(t, err)
-
-	assert.Equal(t, gatewayEndpoint, gateway.Endpoint)
-	assert.Equal(t, gatewayPublicKey, gateway.PublicKey)
-
-	if !success {
-		t.Errorf("no success")
-	}
 }
 
 func TestWatchDeviceEnrollments(t *testing.T) {
-	if os.Getenv("RUN_INTEGRATION_TESTS") == "" {
-		t.Skip("Skipping integration test")
-	}
+	testdatabase.ForEach(t, database.DefaultConfig(), func(t *testing.T, testDB database.Repository) {
+		once := sync.Once{}
+		success := false
+		mux := http.NewServeMux()
+
+		mux.HandleFunc("/api/v2/device/enrollments/enrollment-1/ack", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				t.Fatalf("invalid method")
+			}
+
+			var cfg bootstrap.Config
+			err := json.NewDecoder(r.Body).Decode(&cfg)
+			assert.NoError(t, err)
 
-	once := sync.Once{}
-	success := false
-	mux := http.NewServeMux()
+			assert.Equal(t, apiServerPublicKey, cfg.PublicKey)
+			assert.Equal(t, endpoint, cfg.TunnelEndpoint)
 
-	mux.HandleFunc("/api/v2/device/config/deviceSerial", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			t.Fatalf("invalid method")
+			success = true
+			w.WriteHeader(http.StatusCreated)
+		})
+
+		mux.HandleFunc("/api/v2/device/enrollments", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				t.Fatalf("invalid method")
+			}
+
+			deviceInfos := []bootstrap.DeviceEnrollment{{
+				ID: "enrollment-1",
+				Info: bootstrap.DeviceInfo{
+					Serial:    deviceSerial,
+					PublicKey: devicePublicKey,
+					Platform:  devicePlatform,
+					Owner:     deviceOwner,
+				},
+			}}
+
+			b, err := json.Marshal(&deviceInfos)
+			assert.NoError(t, err)
+
+			once.Do(func() {
+				w.Write(b)
+				return
+			})
+
+			fmt.Fprint(w, `[]`)
+		})
+		server := httptest.NewServer(mux)
+		enr := enroller.Enroller{
+			Client:             server.Client(),
+			DB:                 testDB,
+			BootstrapAPIURL:    server.URL,
+			APIServerPublicKey: apiServerPublicKey,
+			APIServerEndpoint:  endpoint,
 		}
 
-		var cfg bootstrap.Config
-		err := json.NewDecoder(r.Body).Decode(&cfg)
+		assert.NoError(t, enr.EnrollDevice(context.Background()))
+
+		device, err := testDB.ReadDevice(devicePublicKey)
 		assert.NoError(t, err)
 
-		assert.Equal(t, apiServerPublicKey, cfg.PublicKey)
-		assert.Equal(t, endpoint, cfg.TunnelEndpoint)
+		assert.Equal(t, devicePlatform, device.Platform)
+		assert.Equal(t, deviceOwner, device.Username)
+		assert.Equal(t, devicePublicKey, device.PublicKey)
 
-		success = true
-		w.WriteHeader(http.StatusCreated)
+		if !success {
+			t.Errorf("no success")
+		}
 	})
+}
+
+func TestEnrollDeviceRetriesUnacknowledged(t *testing.T) {
+	testdatabase.ForEach(t, database.DefaultConfig(), func(t *testing.T, testDB database.Repository) {
+		lock := sync.Mutex{}
+		acks := 0
+		acked := false
+		mux := http.NewServeMux()
+
+		mux.HandleFunc("/api/v2/device/enrollments/enrollment-1/ack", func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			acks++
+			if acks == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			acked = true
+			w.WriteHeader(http.StatusCreated)
+		})
 
-	mux.HandleFunc("/api/v2/device/info", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			t.Fatalf("invalid method")
+		mux.HandleFunc("/api/v2/device/enrollments", func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			enrollments := []bootstrap.DeviceEnrollment{}
+			if !acked {
+				enrollments = append(enrollments, bootstrap.DeviceEnrollment{
+					ID: "enrollment-1",
+					Info: bootstrap.DeviceInfo{
+						Serial:    deviceSerial,
+						PublicKey: devicePublicKey,
+						Platform:  devicePlatform,
+						Owner:     deviceOwner,
+					},
+				})
+			}
+
+			assert.NoError(t, json.NewEncoder(w).Encode(enrollments))
+		})
+
+		ctx := context.Background()
+		server := httptest.NewServer(mux)
+		enr := enroller.Enroller{
+			Client:             server.Client(),
+			DB:                 testDB,
+			BootstrapAPIURL:    server.URL,
+			APIServerPublicKey: apiServerPublicKey,
+			APIServerEndpoint:  endpoint,
 		}
 
-		deviceInfos := []bootstrap.DeviceInfo{{
-			Serial:    deviceSerial,
-			PublicKey: devicePublicKey,
-			Platform:  devicePlatform,
-			Owner:     deviceOwner,
-		}}
+		assert.Error(t, enr.EnrollDevice(ctx), "failed acknowledgement is reported")
+		assert.NoError(t, enr.EnrollDevice(ctx), "unacknowledged enrollment is retried")
+		assert.NoError(t, enr.EnrollDevice(ctx))
+		assert.Equal(t, 2, acks)
+		assert.True(t, acked)
+	})
+}
 
-		b, err := json.Marshal(&deviceInfos)
-		assert.NoError(t, err)
+func TestEnrollDeviceRequiresApproval(t *testing.T) {
+	testdatabase.ForEach(t, database.DefaultConfig(), func(t *testing.T, testDB database.Repository) {
+		lock := sync.Mutex{}
+		states := make(map[string]bootstrap.EnrollmentState)
+		acked := make(map[string]bool)
+		mux := http.NewServeMux()
+
+		mux.HandleFunc("/api/v2/device/enrollments/", func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			switch r.URL.Path {
+			case "/api/v2/device/enrollments/pending-1/state", "/api/v2/device/enrollments/approved-1/state":
+				var status bootstrap.EnrollmentStatus
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&status))
+				states[r.URL.Path] = status.State
+				w.WriteHeader(http.StatusNoContent)
+			case "/api/v2/device/enrollments/pending-1/ack", "/api/v2/device/enrollments/approved-1/ack":
+				acked[r.URL.Path] = true
+				w.WriteHeader(http.StatusCreated)
+			default:
+				http.NotFound(w, r)
+			}
+		})
 
-		once.Do(func() {
-			w.Write(b)
-			return
+		once := sync.Once{}
+		mux.HandleFunc("/api/v2/device/enrollments", func(w http.ResponseWriter, r *http.Request) {
+			enrollments := []bootstrap.DeviceEnrollment{}
+			once.Do(func() {
+				enrollments = []bootstrap.DeviceEnrollment{
+					{
+						ID:   "pending-1",
+						Info: bootstrap.DeviceInfo{Serial: deviceSerial, PublicKey: devicePublicKey, Platform: devicePlatform, Owner: deviceOwner},
+					},
+					{
+						ID:   "approved-1",
+						Info: bootstrap.DeviceInfo{Serial: "otherSerial", PublicKey: "otherPublicKey", Platform: "darwin", Owner: deviceOwner, Groups: []string{"group1"}},
+					},
+				}
+			})
+
+			assert.NoError(t, json.NewEncoder(w).Encode(enrollments))
 		})
 
-		fmt.Fprint(w, `[]`)
+		ctx := context.Background()
+		server := httptest.NewServer(mux)
+		enr := enroller.Enroller{
+			Client:             server.Client(),
+			DB:                 testDB,
+			BootstrapAPIURL:    server.URL,
+			APIServerPublicKey: apiServerPublicKey,
+			APIServerEndpoint:  endpoint,
+			RequireApproval:    true,
+			ApprovalRules:      []enroller.ApprovalRule{{Platform: "darwin", Group: "group1"}},
+		}
+
+		assert.NoError(t, enr.EnrollDevice(ctx))
+		assert.Equal(t, bootstrap.EnrollmentPendingApproval, states["/api/v2/device/enrollments/pending-1/state"])
+		assert.True(t, acked["/api/v2/device/enrollments/approved-1/ack"], "device matching approval rule is enrolled")
+
+		_, err := testDB.ReadDevice(devicePublicKey)
+		assert.Error(t, err, "pending device is not enrolled")
+
+		enrollment, err := testDB.ReadEnrollment(ctx, deviceSerial, devicePlatform)
+		assert.NoError(t, err)
+		assert.Equal(t, "pending", enrollment.State)
+		assert.True(t, enrollment.Acknowledged)
+
+		assert.NoError(t, testDB.SetEnrollmentState(ctx, enrollment.ID, "approved", "admin"))
+		assert.NoError(t, enr.EnrollDevice(ctx))
+		assert.True(t, acked["/api/v2/device/enrollments/pending-1/ack"], "approved device is enrolled")
+
+		device, err := testDB.ReadDevice(devicePublicKey)
+		assert.NoError(t, err)
+		assert.Equal(t, deviceOwner, device.Username)
+
+		enrollment, err = testDB.ReadEnrollment(ctx, deviceSerial, devicePlatform)
+		assert.NoError(t, err)
+		assert.True(t, enrollment.Acknowledged)
 	})
-	ctx := context.Background()
-	testDB, err := testdatabase.New(ctx, "user=postgres password=postgres host=localhost port=5433 sslmode=disable")
-	assert.NoError(t, err)
-	server := httptest.NewServer(mux)
-	enr := enroller.Enroller{
-		Client:             server.Client(),
-		DB:                 testDB,
-		BootstrapAPIURL:    server.URL,
-		APIServerPublicKey: apiServerPublicKey,
-		APIServerEndpoint:  endpoint,
-	}
-
-	assert.NoError(t, enr.EnrollDevice(context.Background()))
-
-	device, err := testDB.ReadDevice(devicePublicKey)
-	assert.NoError(t, err)
-
-	assert.Equal(t, devicePlatform, device.Platform)
-	assert.Equal(t, deviceOwner, device.Username)
-	assert.Equal(t, devicePublicKey, device.PublicKey)
-
-	if !success {
-		t.Errorf("no success")
-	}
 }
@@ -1,92 +1,77 @@
 package enroller
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"time"
 
+	"github.com/nais/device/pkg/audit"
 	"github.com/nais/device/pkg/bootstrap"
 	log "github.com/sirupsen/logrus"
 )
 
+const kindGateway = "gateway"
+
 func (e *Enroller) WatchGatewayEnrollments(ctx context.Context) {
-	for {
-		select {
-		case <-time.After(1 * time.Second):
-			if err := e.EnrollGateways(ctx); err != nil {
-				log.Errorf("Enrolling gateways: %v", err)
-			}
-		case <-ctx.Done():
-			return
-		}
-	}
+	watch(ctx, kindGateway, e.EnrollGateways)
 }
 
+// EnrollGateways waits for pending gateway enrollments, and enrolls them. It returns an error if any of them failed.
 func (e *Enroller) EnrollGateways(ctx context.Context) error {
-	gatewayInfos, err := e.fetchGatewayInfos()
-	if err != nil {
-		return fmt.Errorf("bootstrap: Fetching gateway infos: %v", err)
+	var enrollments []bootstrap.GatewayEnrollment
+	if err := e.fetchEnrollments(ctx, kindGateway, &enrollments); err != nil {
+		return fmt.Errorf("bootstrap: Fetching gateway enrollments: %w", err)
 	}
 
-	for _, enrollment := range gatewayInfos {
-		err := e.DB.AddGateway(ctx, enrollment.Name, enrollment.PublicIP, enrollment.PublicKey)
-
+	failed := 0
+	for _, enrollment := range enrollments {
+		err := e.enrollGateway(ctx, enrollment)
 		if err != nil {
-			log.Warnf("bootstrap: Adding gateway: %v", err)
+			log.Errorf("bootstrap: Enrolling gateway %s (enrollment %s): %v", enrollment.Info.Name, enrollment.ID, err)
+			EnrollmentFailures.WithLabelValues(kindGateway).Inc()
+			failed++
+			continue
 		}
 
-		gateway, err := e.DB.ReadGateway(enrollment.Name)
-		if err != nil {
-			return fmt.Errorf("bootstrap: Getting gateway: %v", err)
-		}
-
-		bootstrapConfig := bootstrap.Config{
-			DeviceIP:       gateway.Ip,
-			PublicKey:      e.APIServerPublicKey,
-			TunnelEndpoint: e.APIServerEndpoint,
-			APIServerIP:    "10.255.240.1",
-		}
-
-		err = e.postGatewayConfig(e.BootstrapAPIURL, gateway.Name, bootstrapConfig)
-
-		if err != nil {
-			return fmt.Errorf("bootstrap: Pushing bootstrap config: %v", err)
-		}
+		Enrollments.WithLabelValues(kindGateway).Inc()
+	}
 
-		log.Infof("bootstrap: Bootstrapped gateway: %+v", bootstrapConfig)
+	if failed > 0 {
+		return fmt.Errorf("bootstrap: %d of %d gateway enrollments failed", failed, len(enrollments))
 	}
 
 	return nil
 }
 
-func (e *Enroller) postGatewayConfig(bootstrapURL, name string, bootstrapConfig bootstrap.Config) error {
-	b, err := json.Marshal(bootstrapConfig)
+func (e *Enroller) enrollGateway(ctx context.Context, enrollment bootstrap.GatewayEnrollment) error {
+	err := e.DB.AddGateway(ctx, enrollment.Info.Name, enrollment.Info.PublicIP, enrollment.Info.PublicKey)
+
 	if err != nil {
-		return fmt.Errorf("marshalling config: %w", err)
+		log.Warnf("bootstrap: Adding gateway: %v", err)
 	}
 
-	r, err := e.Client.Post(fmt.Sprintf("%s/api/v2/gateway/config/%s", bootstrapURL, name), "application/json", bytes.NewReader(b))
+	gateway, err := e.DB.ReadGateway(enrollment.Info.Name)
 	if err != nil {
-		return fmt.Errorf("posting bootstrap config: %w", err)
+		return fmt.Errorf("getting gateway: %w", err)
 	}
 
-	log.Infof("bootstrap: Bootstrapped %+v, response: %v", bootstrapConfig, r)
-	return nil
-}
-
-func (e *Enroller) fetchGatewayInfos() ([]bootstrap.GatewayInfo, error) {
-	r, err := e.Client.Get(e.BootstrapAPIURL + "/api/v2/gateway/info")
-	if err != nil {
-		return nil, fmt.Errorf("getting gateway infos: %w", err)
+	bootstrapConfig := bootstrap.Config{
+		DeviceIP:       gateway.Ip,
+		DeviceIPv6:     gateway.Ipv6,
+		PublicKey:      e.APIServerPublicKey,
+		TunnelEndpoint: e.APIServerEndpoint,
+		APIServerIP:    e.APIServerIP,
 	}
 
-	var gatewayInfos []bootstrap.GatewayInfo
-	err = json.NewDecoder(r.Body).Decode(&gatewayInfos)
-	if err != nil {
-		return nil, fmt.Errorf("decoding gatewayInfos: %w", err)
+	if err := e.ack(ctx, kindGateway, enrollment.ID, bootstrapConfig); err != nil {
+		return err
 	}
 
-	return gatewayInfos, nil
+	e.Audit.Record(audit.Event{
+		Action:  audit.ActionGatewayEnrolled,
+		Outcome: audit.OutcomeSuccess,
+		Target:  enrollment.Info.Name,
+		Details: map[string]string{"enrollmentID": enrollment.ID, "endpoint": gateway.Endpoint},
+	})
+	log.Infof("bootstrap: Bootstrapped gateway: %+v", bootstrapConfig)
+	return nil
 }
@@ -0,0 +1,30 @@
+package enroller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	Enrollments = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "enrollments",
+		Help:      "count of enrollments acknowledged to the bootstrap-api",
+		Namespace: "naisdevice",
+		Subsystem: "apiserver",
+	}, []string{"kind"})
+	EnrollmentFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "enrollment_failures",
+		Help:      "count of failed enrollment attempts, which are retried",
+		Namespace: "naisdevice",
+		Subsystem: "apiserver",
+	}, []string{"kind"})
+	EnrollmentRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "enrollment_rejections",
+		Help:      "count of enrollments rejected due to device ownership or limits",
+		Namespace: "naisdevice",
+		Subsystem: "apiserver",
+	}, []string{"kind"})
+)
+
+func InitializeMetrics() {
+	prometheus.MustRegister(Enrollments, EnrollmentFailures, EnrollmentRejections)
+}
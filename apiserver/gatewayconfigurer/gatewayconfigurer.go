@@ -2,11 +2,19 @@ package gatewayconfigurer
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/nais/device/apiserver/database"
+	"github.com/nais/device/pkg/audit"
+	"github.com/nais/device/pkg/pb"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
 	"io"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,13 +23,22 @@ type BucketReader interface {
 }
 
 type GatewayConfigurer struct {
-	DB           *database.APIServerDB
-	BucketReader BucketReader
-	SyncInterval time.Duration
+	DB                 database.Repository
+	BucketReader       BucketReader
+	SyncInterval       time.Duration
+	TriggerGatewaySync chan<- struct{}
+	Audit              *audit.Log
 }
 
+// Route is a network forwarded by a gateway. Protocol is one of tcp, udp or icmp and defaults to tcp.
+// Ports can be single ports or ranges such as "8000-8100", and are only valid for tcp and udp.
+// If AccessGroupIds is set, only members of those groups can reach the route, otherwise everyone
+// with access to the gateway can.
 type Route struct {
-	CIDR string `json:"cidr"`
+	CIDR           string   `json:"cidr"`
+	Protocol       string   `json:"protocol"`
+	Ports          []string `json:"ports"`
+	AccessGroupIds []string `json:"access_group_ids"`
 }
 
 type GatewayConfig struct {
@@ -43,26 +60,92 @@ func (g *GatewayConfigurer) SyncContinuously(ctx context.Context) {
 	}
 }
 
-func (g *GatewayConfigurer) SyncConfig(ctx context.Context) error {
+// readConfigs returns the gateway configurations in the bucket by gateway name.
+func (g *GatewayConfigurer) readConfigs(ctx context.Context) (map[string]GatewayConfig, error) {
 	reader, err := g.BucketReader.ReadBucketObject(ctx)
 	if err != nil {
-		return fmt.Errorf("reading bucket object: %v", err)
+		return nil, fmt.Errorf("reading bucket object: %v", err)
 	}
 
 	var gatewayConfigs map[string]GatewayConfig
 	if err := json.NewDecoder(reader).Decode(&gatewayConfigs); err != nil {
-		return fmt.Errorf("unmarshaling gateway config json: %v", err)
+		return nil, fmt.Errorf("unmarshaling gateway config json: %v", err)
+	}
+
+	return gatewayConfigs, nil
+}
+
+// HasConfig reports whether the bucket has a configuration for the gateway.
+func (g *GatewayConfigurer) HasConfig(ctx context.Context, gatewayName string) (bool, error) {
+	gatewayConfigs, err := g.readConfigs(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := gatewayConfigs[gatewayName]
+	return ok, nil
+}
+
+func (g *GatewayConfigurer) SyncConfig(ctx context.Context) error {
+	gatewayConfigs, err := g.readConfigs(ctx)
+	if err != nil {
+		return err
 	}
 
 	for gatewayName, gatewayConfig := range gatewayConfigs {
-		if err := g.DB.UpdateGateway(context.Background(), gatewayName, ToCIDRStringSlice(gatewayConfig.Routes), gatewayConfig.AccessGroupIds, gatewayConfig.RequiresPrivilegedAccess); err != nil {
+		routes, err := ToRoutePolicies(gatewayConfig.Routes)
+		if err != nil {
+			return fmt.Errorf("invalid routes for gateway %s: %w", gatewayName, err)
+		}
+
+		previous, err := g.DB.ReadGateway(gatewayName)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("reading gateway %s: %w", gatewayName, err)
+		}
+
+		if err := g.DB.UpdateGateway(context.Background(), gatewayName, routes, gatewayConfig.AccessGroupIds, gatewayConfig.RequiresPrivilegedAccess); err != nil {
 			return fmt.Errorf("updating gateway: %s with routes: %s and accessGroupIds: %s: %v", gatewayName, gatewayConfig.Routes, gatewayConfig.AccessGroupIds, err)
 		}
+
+		if previous != nil && configChanged(previous, routes, gatewayConfig) {
+			g.Audit.Record(audit.Event{
+				Action:  audit.ActionGatewayConfigChanged,
+				Outcome: audit.OutcomeSuccess,
+				Target:  gatewayName,
+				Details: map[string]string{
+					"routes":                   strings.Join(ToCIDRStringSlice(gatewayConfig.Routes), ","),
+					"accessGroupIds":           strings.Join(gatewayConfig.AccessGroupIds, ","),
+					"requiresPrivilegedAccess": strconv.FormatBool(gatewayConfig.RequiresPrivilegedAccess),
+				},
+			})
+		}
+	}
+
+	select {
+	case g.TriggerGatewaySync <- struct{}{}:
+	default:
 	}
 
 	return nil
 }
 
+// configChanged reports whether the configuration from the bucket differs from the gateway's stored configuration.
+func configChanged(gateway *pb.Gateway, routes []*pb.Route, config GatewayConfig) bool {
+	updated := &pb.Gateway{
+		RoutePolicies:            routes,
+		AccessGroupIDs:           config.AccessGroupIds,
+		RequiresPrivilegedAccess: config.RequiresPrivilegedAccess,
+	}
+
+	current := &pb.Gateway{
+		RoutePolicies:            gateway.RoutePolicies,
+		AccessGroupIDs:           gateway.AccessGroupIDs,
+		RequiresPrivilegedAccess: gateway.RequiresPrivilegedAccess,
+	}
+
+	return !proto.Equal(current, updated)
+}
+
 func ToCIDRStringSlice(routeObjects []Route) []string {
 	var routes []string
 	for _, route := range routeObjects {
@@ -71,3 +154,82 @@ func ToCIDRStringSlice(routeObjects []Route) []string {
 
 	return routes
 }
+
+// ToRoutePolicies validates the routes from the bucket and converts them to their protobuf representation.
+func ToRoutePolicies(routeObjects []Route) ([]*pb.Route, error) {
+	var routes []*pb.Route
+	for _, route := range routeObjects {
+		cidr, err := validateCIDR(route.CIDR)
+		if err != nil {
+			return nil, err
+		}
+
+		protocol := strings.ToLower(route.Protocol)
+		if len(protocol) == 0 {
+			protocol = "tcp"
+		}
+
+		switch protocol {
+		case "tcp", "udp":
+			for _, ports := range route.Ports {
+				if err := validatePorts(ports); err != nil {
+					return nil, fmt.Errorf("route %s: %w", route.CIDR, err)
+				}
+			}
+		case "icmp":
+			if len(route.Ports) > 0 {
+				return nil, fmt.Errorf("route %s: ports are not supported for icmp", route.CIDR)
+			}
+		default:
+			return nil, fmt.Errorf("route %s: unsupported protocol %q", route.CIDR, route.Protocol)
+		}
+
+		routes = append(routes, &pb.Route{
+			Cidr:           cidr,
+			Protocol:       protocol,
+			Ports:          route.Ports,
+			AccessGroupIDs: route.AccessGroupIds,
+		})
+	}
+
+	return routes, nil
+}
+
+// validateCIDR accepts a network in CIDR notation, or a single address, which is returned as a host network.
+func validateCIDR(cidr string) (string, error) {
+	if _, _, err := net.ParseCIDR(cidr); err == nil {
+		return cidr, nil
+	}
+
+	ip := net.ParseIP(cidr)
+	if ip == nil {
+		return "", fmt.Errorf("invalid route cidr %q", cidr)
+	}
+
+	if ip.To4() != nil {
+		return ip.String() + "/32", nil
+	}
+	return ip.String() + "/128", nil
+}
+
+// validatePorts accepts a single port or an inclusive range of ports separated by a dash.
+func validatePorts(ports string) error {
+	bounds := strings.Split(ports, "-")
+	if len(bounds) > 2 {
+		return fmt.Errorf("invalid port range %q", ports)
+	}
+
+	var previous int
+	for _, bound := range bounds {
+		port, err := strconv.Atoi(bound)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %q", bound)
+		}
+		if port < previous {
+			return fmt.Errorf("invalid port range %q", ports)
+		}
+		previous = port
+	}
+
+	return nil
+}
@@ -3,71 +3,64 @@ package gatewayconfigurer_test
 import (
 	"context"
 	"fmt"
+	"github.com/nais/device/apiserver/database"
 	"github.com/nais/device/apiserver/gatewayconfigurer"
 	"github.com/nais/device/apiserver/testdatabase"
 	"github.com/stretchr/testify/assert"
 	"io"
-	"os"
 	"strings"
 	"testing"
 )
 
 func TestGatewayConfigurer_SyncConfig(t *testing.T) {
-	if os.Getenv("RUN_INTEGRATION_TESTS") == "" {
-		t.Skip("Skipping integration test")
-	}
-
 	t.Run("updates gateway config in database according to bucket definition", func(t *testing.T) {
-		ctx := context.Background()
-		testDB, err := testdatabase.New(ctx, "user=postgres password=postgres host=localhost port=5433 sslmode=disable")
-		assert.NoError(t, err)
-		const gatewayName, route, accessGroupId = "name", "r", "agid"
-		assert.NoError(t, testDB.AddGateway(context.Background(), gatewayName, "", ""))
-
-		bucketReader := MockBucketReader{GatewayConfigs: gatewayConfig(gatewayName, route, accessGroupId, true)}
-
-		gc := gatewayconfigurer.GatewayConfigurer{
-			DB:           testDB,
-			BucketReader: bucketReader,
-		}
-
-		gateway, err := testDB.ReadGateway(gatewayName)
-		assert.NoError(t, err)
-		assert.Equal(t, gatewayName, gateway.Name)
-		assert.Nil(t, gateway.Routes)
-		assert.Nil(t, gateway.AccessGroupIDs)
-		assert.False(t, gateway.RequiresPrivilegedAccess)
-
-		assert.NoError(t, gc.SyncConfig(context.Background()))
-
-		updatedGateway, err := testDB.ReadGateway(gatewayName)
-		assert.NoError(t, err)
-		assert.Len(t, updatedGateway.Routes, 1)
-		assert.Equal(t, route, updatedGateway.Routes[0])
-		assert.Len(t, updatedGateway.AccessGroupIDs, 1)
-		assert.Equal(t, accessGroupId, updatedGateway.AccessGroupIDs[0])
-		assert.True(t, updatedGateway.RequiresPrivilegedAccess)
+		testdatabase.ForEach(t, database.DefaultConfig(), func(t *testing.T, testDB database.Repository) {
+			const gatewayName, route, accessGroupId = "name", "10.0.0.0/24", "agid"
+			assert.NoError(t, testDB.AddGateway(context.Background(), gatewayName, "", ""))
+
+			bucketReader := MockBucketReader{GatewayConfigs: gatewayConfig(gatewayName, route, accessGroupId, true)}
+
+			gc := gatewayconfigurer.GatewayConfigurer{
+				DB:           testDB,
+				BucketReader: bucketReader,
+			}
+
+			gateway, err := testDB.ReadGateway(gatewayName)
+			assert.NoError(t, err)
+			assert.Equal(t, gatewayName, gateway.Name)
+			assert.Nil(t, gateway.Routes)
+			assert.Nil(t, gateway.AccessGroupIDs)
+			assert.False(t, gateway.RequiresPrivilegedAccess)
+
+			assert.NoError(t, gc.SyncConfig(context.Background()))
+
+			updatedGateway, err := testDB.ReadGateway(gatewayName)
+			assert.NoError(t, err)
+			assert.Len(t, updatedGateway.Routes, 1)
+			assert.Equal(t, route, updatedGateway.Routes[0])
+			assert.Len(t, updatedGateway.AccessGroupIDs, 1)
+			assert.Equal(t, accessGroupId, updatedGateway.AccessGroupIDs[0])
+			assert.True(t, updatedGateway.RequiresPrivilegedAccess)
+		})
 	})
 
 	t.Run("synchronizing gatewayconfig where gateway not in database is ok", func(t *testing.T) {
-		ctx := context.Background()
-		testDB, err := testdatabase.New(ctx, "user=postgres password=postgres host=localhost port=5433 sslmode=disable")
+		testdatabase.ForEach(t, database.DefaultConfig(), func(t *testing.T, testDB database.Repository) {
+			const gatewayName, route, accessGroupId = "name", "10.0.0.0/24", "agid"
 
-		assert.NoError(t, err)
-		const gatewayName, route, accessGroupId = "name", "r", "agid"
+			bucketReader := MockBucketReader{GatewayConfigs: gatewayConfig(gatewayName, route, accessGroupId, true)}
 
-		bucketReader := MockBucketReader{GatewayConfigs: gatewayConfig(gatewayName, route, accessGroupId, true)}
+			gc := gatewayconfigurer.GatewayConfigurer{
+				DB:           testDB,
+				BucketReader: bucketReader,
+			}
 
-		gc := gatewayconfigurer.GatewayConfigurer{
-			DB:           testDB,
-			BucketReader: bucketReader,
-		}
+			gw, err := testDB.ReadGateway(gatewayName)
+			assert.Error(t, err)
+			assert.Nil(t, gw)
 
-		gw, err := testDB.ReadGateway(gatewayName)
-		assert.Error(t, err)
-		assert.Nil(t, gw)
-
-		assert.NoError(t, gc.SyncConfig(context.Background()))
+			assert.NoError(t, gc.SyncConfig(context.Background()))
+		})
 	})
 
 }
@@ -79,6 +72,41 @@ func TestToCIDRStringSlice(t *testing.T) {
 	assert.Equal(t, cidr, cidrStringSlice[0])
 }
 
+func TestToRoutePolicies(t *testing.T) {
+	routes, err := gatewayconfigurer.ToRoutePolicies([]gatewayconfigurer.Route{
+		{CIDR: "10.0.0.0/24"},
+		{CIDR: "10.0.1.0/24", Protocol: "UDP", Ports: []string{"53", "8000-8100"}},
+		{CIDR: "10.0.2.0/24", Protocol: "icmp"},
+		{CIDR: "10.0.3.1"},
+		{CIDR: "2001:db8::/64"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, routes, 5)
+	assert.Equal(t, "tcp", routes[0].GetProtocol())
+	assert.Equal(t, "udp", routes[1].GetProtocol())
+	assert.Equal(t, []string{"53", "8000-8100"}, routes[1].GetPorts())
+	assert.Equal(t, "icmp", routes[2].GetProtocol())
+	assert.Equal(t, "10.0.3.1/32", routes[3].GetCidr(), "single addresses are host networks")
+	assert.Equal(t, "2001:db8::/64", routes[4].GetCidr())
+
+	invalid := [][]gatewayconfigurer.Route{
+		{{CIDR: "10.0.0.0/24", Protocol: "sctp"}},
+		{{CIDR: "10.0.0.0/24", Protocol: "icmp", Ports: []string{"80"}}},
+		{{CIDR: "10.0.0.0/24", Ports: []string{"0"}}},
+		{{CIDR: "10.0.0.0/24", Ports: []string{"65536"}}},
+		{{CIDR: "10.0.0.0/24", Ports: []string{"http"}}},
+		{{CIDR: "10.0.0.0/24", Ports: []string{"8100-8000"}}},
+		{{CIDR: "10.0.0.0/24", Ports: []string{"1-2-3"}}},
+		{{CIDR: "10.0.0.0/33"}},
+		{{CIDR: "10.0.0.0/24 -j ACCEPT"}},
+		{{CIDR: ""}},
+	}
+	for _, routes := range invalid {
+		_, err := gatewayconfigurer.ToRoutePolicies(routes)
+		assert.Error(t, err, "%+v", routes)
+	}
+}
+
 func gatewayConfig(gatewayName string, route string, accessGroupId string, requiresPrivilegedAccess bool) string {
 	gatewayConfigs := fmt.Sprintf(
 		`{
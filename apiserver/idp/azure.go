@@ -0,0 +1,35 @@
+package idp
+
+import (
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nais/device/apiserver/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+var AzureClaims = ClaimMapping{
+	Username: "preferred_username",
+	ObjectID: "oid",
+	Groups:   "groups",
+}
+
+// NewAzure returns an Azure AD provider, reading the identity from access tokens issued for the app.
+// The keyfunc resolves the keys used to sign the tokens, see keycache.Cache.
+func NewAzure(cfg config.Azure, keyfunc jwt.Keyfunc) Provider {
+	return &claimsProvider{
+		oauthConfig: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Scopes:       []string{"openid", "offline_access", fmt.Sprintf("%s/.default", cfg.ClientID)},
+			Endpoint:     endpoints.AzureAD(cfg.TenantID),
+		},
+		keyfunc:  keyfunc,
+		audience: cfg.ClientID,
+		claims:   AzureClaims,
+		rawToken: func(token *oauth2.Token) (string, error) {
+			return token.AccessToken, nil
+		},
+	}
+}
@@ -0,0 +1,138 @@
+// Package idp abstracts the identity provider users authenticate with, so the apiserver can be run against
+// Azure AD or any OpenID Connect provider, such as Keycloak or Dex.
+package idp
+
+import (
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/oauth2"
+)
+
+// Identity is the user a token was issued to.
+type Identity struct {
+	Username string
+	ObjectID string
+	Groups   []string
+}
+
+type Provider interface {
+	// OAuthConfig returns the OAuth2 configuration for the authorization code flow and for refreshing tokens.
+	OAuthConfig() *oauth2.Config
+	// Identity validates a token issued by the provider, and returns the identity of the user it was issued to.
+	Identity(token *oauth2.Token) (*Identity, error)
+	// Validate validates a JWT issued by the provider, such as a bearer token, and returns the identity of the user
+	// it was issued to. It is the access token for Azure AD, and the ID token for OpenID Connect providers.
+	Validate(raw string) (*Identity, error)
+}
+
+// ClaimMapping names the token claims holding the user's identity.
+type ClaimMapping struct {
+	Username string
+	ObjectID string
+	Groups   string
+}
+
+// claimsProvider is a Provider validating JWTs and mapping their claims to an identity.
+type claimsProvider struct {
+	oauthConfig *oauth2.Config
+	keyfunc     jwt.Keyfunc
+	audience    string
+	// issuer is not verified if empty
+	issuer string
+	claims ClaimMapping
+	// rawToken returns the JWT to read the identity from
+	rawToken func(token *oauth2.Token) (string, error)
+}
+
+func (p *claimsProvider) OAuthConfig() *oauth2.Config {
+	return p.oauthConfig
+}
+
+func (p *claimsProvider) Identity(token *oauth2.Token) (*Identity, error) {
+	raw, err := p.rawToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Validate(raw)
+}
+
+func (p *claimsProvider) Validate(raw string) (*Identity, error) {
+	var claims jwt.MapClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, p.keyfunc)
+	if err != nil {
+		return nil, fmt.Errorf("parsing token with claims: %w", err)
+	}
+
+	if !hasAudience(claims, p.audience) {
+		return nil, fmt.Errorf("the token is not valid for this application")
+	}
+
+	if len(p.issuer) > 0 && !claims.VerifyIssuer(p.issuer, true) {
+		return nil, fmt.Errorf("the token is not issued by %s", p.issuer)
+	}
+
+	return p.claims.identity(claims)
+}
+
+func (m ClaimMapping) identity(claims jwt.MapClaims) (*Identity, error) {
+	username, ok := claims[m.Username].(string)
+	if !ok || len(username) == 0 {
+		return nil, fmt.Errorf("missing username claim '%s'", m.Username)
+	}
+
+	objectID, ok := claims[m.ObjectID].(string)
+	if !ok || len(objectID) == 0 {
+		return nil, fmt.Errorf("missing object id claim '%s'", m.ObjectID)
+	}
+
+	groups, err := stringList(claims[m.Groups])
+	if err != nil {
+		return nil, fmt.Errorf("groups claim '%s': %w", m.Groups, err)
+	}
+
+	return &Identity{
+		Username: username,
+		ObjectID: objectID,
+		Groups:   groups,
+	}, nil
+}
+
+// hasAudience reports whether the audience claim, which is either a string or a list of strings, contains audience.
+func hasAudience(claims jwt.MapClaims, audience string) bool {
+	audiences, err := stringList(claims["aud"])
+	if err != nil {
+		return false
+	}
+
+	for _, aud := range audiences {
+		if aud == audience {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stringList returns a claim that is either missing, a string or a list of strings as a list.
+func stringList(claim interface{}) ([]string, error) {
+	switch claim := claim.(type) {
+	case nil:
+		return []string{}, nil
+	case string:
+		return []string{claim}, nil
+	case []interface{}:
+		list := make([]string, len(claim))
+		for i, v := range claim {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected type %T in list, should be string", v)
+			}
+			list[i] = s
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("unexpected type %T, should be string or list of strings", claim)
+	}
+}
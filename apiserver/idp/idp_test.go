@@ -0,0 +1,124 @@
+package idp_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nais/device/apiserver/config"
+	"github.com/nais/device/apiserver/idp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func TestOIDC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	var issuer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(idp.Discovery{
+				Issuer:                issuer,
+				AuthorizationEndpoint: issuer + "/auth",
+				TokenEndpoint:         issuer + "/token",
+				JWKSURI:               issuer + "/keys",
+			})
+		case "/keys":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"keys": []map[string]string{{
+					"kid": "key1",
+					"kty": "RSA",
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	issuer = server.URL
+
+	provider, err := idp.NewOIDC(context.Background(), config.OIDC{
+		IssuerURL:   issuer,
+		ClientID:    "client",
+		Scopes:      []string{"openid"},
+		GroupsClaim: "roles",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, issuer+"/token", provider.OAuthConfig().Endpoint.TokenURL)
+
+	idToken := func(claims jwt.MapClaims) *oauth2.Token {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "key1"
+		signed, err := token.SignedString(key)
+		assert.NoError(t, err)
+		return (&oauth2.Token{AccessToken: "opaque"}).WithExtra(map[string]interface{}{"id_token": signed})
+	}
+
+	claims := jwt.MapClaims{
+		"iss":                issuer,
+		"aud":                []string{"client", "other"},
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"sub":                "user1",
+		"preferred_username": "user@example.com",
+		"roles":              []string{"group1", "group2"},
+	}
+
+	identity, err := provider.Identity(idToken(claims))
+	assert.NoError(t, err)
+	assert.Equal(t, &idp.Identity{Username: "user@example.com", ObjectID: "user1", Groups: []string{"group1", "group2"}}, identity)
+
+	for name, override := range map[string]jwt.MapClaims{
+		"wrong audience":   {"aud": "other"},
+		"wrong issuer":     {"iss": "https://example.com"},
+		"expired":          {"exp": time.Now().Add(-time.Minute).Unix()},
+		"missing username": {"preferred_username": nil},
+	} {
+		invalid := jwt.MapClaims{}
+		for k, v := range claims {
+			invalid[k] = v
+		}
+		for k, v := range override {
+			invalid[k] = v
+		}
+
+		_, err := provider.Identity(idToken(invalid))
+		assert.Error(t, err, name)
+	}
+
+	_, err = provider.Identity(&oauth2.Token{AccessToken: "opaque"})
+	assert.Error(t, err, "token response without id_token")
+}
+
+func TestAzure(t *testing.T) {
+	secret := []byte("secret")
+	provider := idp.NewAzure(config.Azure{ClientID: "client", TenantID: "tenant"}, func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	})
+
+	assert.Equal(t, "https://login.microsoftonline.com/tenant/oauth2/v2.0/token", provider.OAuthConfig().Endpoint.TokenURL)
+
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"aud":                "client",
+		"oid":                "objectId1",
+		"preferred_username": "user@example.com",
+		"groups":             []string{"group1"},
+	}).SignedString(secret)
+	assert.NoError(t, err)
+
+	identity, err := provider.Identity(&oauth2.Token{AccessToken: accessToken})
+	assert.NoError(t, err)
+	assert.Equal(t, &idp.Identity{Username: "user@example.com", ObjectID: "objectId1", Groups: []string{"group1"}}, identity)
+}
@@ -0,0 +1,126 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nais/device/apiserver/config"
+	"github.com/nais/device/pkg/keycache"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+var DefaultOIDCClaims = ClaimMapping{
+	Username: "preferred_username",
+	ObjectID: "sub",
+	Groups:   "groups",
+}
+
+// Discovery is the subset of the OpenID Connect discovery document used by the provider.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDC returns a generic OpenID Connect provider, discovering its endpoints and signing keys from the issuer.
+// The identity is read from the ID token, which the provider must also return when refreshing tokens.
+// The signing keys are refreshed until the context is done.
+func NewOIDC(ctx context.Context, cfg config.OIDC) (Provider, error) {
+	if len(cfg.IssuerURL) == 0 || len(cfg.ClientID) == 0 {
+		return nil, fmt.Errorf("missing required oidc configuration")
+	}
+
+	discovery, err := Discover(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", cfg.IssuerURL, err)
+	}
+
+	log.Infof("Discover OIDC signing keys from %s", discovery.JWKSURI)
+	keys, err := keycache.New(ctx, discovery.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	go keys.Run(ctx, keycache.DefaultRefreshInterval)
+
+	claims := DefaultOIDCClaims
+	if len(cfg.UsernameClaim) > 0 {
+		claims.Username = cfg.UsernameClaim
+	}
+	if len(cfg.ObjectIDClaim) > 0 {
+		claims.ObjectID = cfg.ObjectIDClaim
+	}
+	if len(cfg.GroupsClaim) > 0 {
+		claims.Groups = cfg.GroupsClaim
+	}
+
+	return &claimsProvider{
+		oauthConfig: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Scopes:       cfg.Scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  discovery.AuthorizationEndpoint,
+				TokenURL: discovery.TokenEndpoint,
+			},
+		},
+		keyfunc:  keys.Keyfunc(cfg.ClientID),
+		audience: cfg.ClientID,
+		issuer:   discovery.Issuer,
+		claims:   claims,
+		rawToken: func(token *oauth2.Token) (string, error) {
+			idToken, ok := token.Extra("id_token").(string)
+			if !ok || len(idToken) == 0 {
+				return "", fmt.Errorf("no id_token in token response")
+			}
+			return idToken, nil
+		},
+	}, nil
+}
+
+// Discover fetches the OpenID Connect discovery document of the issuer.
+func Discover(ctx context.Context, issuerURL string) (*Discovery, error) {
+	issuerURL = strings.TrimSuffix(issuerURL, "/")
+
+	var discovery Discovery
+	if err := getJSON(ctx, issuerURL+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+
+	if discovery.Issuer != issuerURL {
+		return nil, fmt.Errorf("issuer '%s' does not match '%s'", discovery.Issuer, issuerURL)
+	}
+
+	if len(discovery.AuthorizationEndpoint) == 0 || len(discovery.TokenEndpoint) == 0 || len(discovery.JWKSURI) == 0 {
+		return nil, fmt.Errorf("incomplete discovery document")
+	}
+
+	return &discovery, nil
+}
+
+func getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("getting %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("getting %s: http response %v", url, resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding %s: %w", url, err)
+	}
+
+	return nil
+}
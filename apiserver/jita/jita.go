@@ -2,13 +2,20 @@ package jita
 
 import (
 	"fmt"
+	"github.com/nais/device/pkg/audit"
 	"github.com/nais/device/pkg/basicauth"
 	"net/http"
+	"sync"
 )
 
 type Jita struct {
 	HTTPClient *http.Client
 	Url        string
+	Audit      *audit.Log
+
+	// grants holds the users with privileged access to each gateway as of the last lookup, keyed by gateway name.
+	grants     map[string]map[string]bool
+	grantsLock sync.Mutex
 }
 
 func New(username, password, url string) *Jita {
@@ -16,6 +23,7 @@ func New(username, password, url string) *Jita {
 		HTTPClient: &http.Client{
 			Transport: basicauth.Transport{Password: password, Username: username},
 		},
-		Url: fmt.Sprintf("%s/%s", url, "api/v1"),
+		Url:    fmt.Sprintf("%s/%s", url, "api/v1"),
+		grants: make(map[string]map[string]bool),
 	}
 }
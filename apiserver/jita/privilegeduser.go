@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+
+	"github.com/nais/device/pkg/audit"
 )
 
 type PrivilegedUser struct {
@@ -11,7 +13,6 @@ type PrivilegedUser struct {
 }
 
 func (j *Jita) GetPrivilegedUsersForGateway(gateway string) ([]PrivilegedUser, error) {
-
 	resp, err := j.HTTPClient.Get(fmt.Sprintf("%s/%s/%s", j.Url, "gatewayAccess", gateway))
 	if err != nil {
 		return nil, fmt.Errorf("getting privileged users: %w", err)
@@ -23,5 +24,44 @@ func (j *Jita) GetPrivilegedUsersForGateway(gateway string) ([]PrivilegedUser, e
 	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
 		return nil, fmt.Errorf("decoding privileged users: %w", err)
 	}
+	j.observeGrants(gateway, users)
 	return users, nil
 }
+
+// observeGrants records grants that have appeared or ended since the last lookup for the gateway.
+func (j *Jita) observeGrants(gateway string, users []PrivilegedUser) {
+	current := make(map[string]bool, len(users))
+	for _, user := range users {
+		current[user.UserId] = true
+	}
+
+	j.grantsLock.Lock()
+	defer j.grantsLock.Unlock()
+
+	if j.grants == nil {
+		j.grants = make(map[string]map[string]bool)
+	}
+	previous := j.grants[gateway]
+	j.grants[gateway] = current
+
+	record := func(action, userID string) {
+		j.Audit.Record(audit.Event{
+			Action:  action,
+			Outcome: audit.OutcomeSuccess,
+			Actor:   userID,
+			Target:  gateway,
+		})
+	}
+
+	for userID := range current {
+		if !previous[userID] {
+			record(audit.ActionJitaGrantObserved, userID)
+		}
+	}
+
+	for userID := range previous {
+		if !current[userID] {
+			record(audit.ActionJitaGrantEnded, userID)
+		}
+	}
+}
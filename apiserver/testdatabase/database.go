@@ -4,13 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"os"
+	"testing"
+	"time"
+
 	_ "github.com/lib/pq"
 	"github.com/nais/device/apiserver/database"
 	"github.com/nais/device/pkg/random"
 	log "github.com/sirupsen/logrus"
-	"time"
 )
 
+// DSN is the Postgres instance used by integration tests.
+const DSN = "user=postgres password=postgres host=localhost port=5433 sslmode=disable"
+
 // NewTestDatabase creates and returns a new nais device database within the provided database instance
 func New(ctx context.Context, dsn string) (*database.APIServerDB, error) {
 	databaseName, err := createDatabase(dsn)
@@ -23,7 +29,7 @@ func New(ctx context.Context, dsn string) (*database.APIServerDB, error) {
 		return nil, fmt.Errorf("connecting to database: %v", err)
 	}
 
-	db := database.APIServerDB{Conn: conn}
+	db := database.APIServerDB{Conn: conn, Config: database.DefaultConfig()}
 	err = db.Migrate(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("migrating: %w", err)
@@ -31,6 +37,27 @@ func New(ctx context.Context, dsn string) (*database.APIServerDB, error) {
 	return &db, nil
 }
 
+// ForEach runs the test against the in-memory database, and against a new Postgres database when running integration
+// tests. Both are configured with cfg.
+func ForEach(t *testing.T, cfg database.Config, test func(t *testing.T, db database.Repository)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, database.NewMemoryDB(cfg))
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		if os.Getenv("RUN_INTEGRATION_TESTS") == "" {
+			t.Skip("Skipping integration test")
+		}
+
+		db, err := New(context.Background(), DSN)
+		if err != nil {
+			t.Fatalf("Instantiating database: %v", err)
+		}
+		db.Config = cfg
+		test(t, db)
+	})
+}
+
 func createDatabase(dsn string) (string, error) {
 	initialConn, err := connect(dsn)
 	if err != nil {
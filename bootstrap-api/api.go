@@ -7,6 +7,7 @@ import (
 
 	"github.com/go-chi/chi"
 	chi_middleware "github.com/go-chi/chi/middleware"
+	"github.com/nais/device/pkg/audit"
 	"github.com/nais/device/pkg/secretmanager"
 )
 
@@ -51,13 +52,13 @@ func (api *Api) Router() chi.Router {
 	return r
 }
 
-func NewApi(apiserverCredentialEntries map[string]string, azureValidator func(next http.Handler) http.Handler, secretManager SecretManager) *Api {
+func NewApi(apiserverCredentialEntries map[string]string, azureValidator func(next http.Handler) http.Handler, secretManager SecretManager, store EnrollmentStore, auditLog *audit.Log) *Api {
 	api := &Api{}
 
 	apiserverAuth := chi_middleware.BasicAuth("naisdevice", apiserverCredentialEntries)
 
 	api.gatewayApi = &GatewayApi{
-		enrollments:          NewActiveGatewayEnrollments(),
+		enrollments:          NewActiveGatewayEnrollments(store, auditLog),
 		secretManager:        secretManager,
 		enrollmentTokens:     nil,
 		enrollmentTokensLock: &sync.Mutex{},
@@ -65,7 +66,7 @@ func NewApi(apiserverCredentialEntries map[string]string, azureValidator func(ne
 	}
 
 	api.deviceApi = &DeviceApi{
-		enrollments:    NewActiveDeviceEnrollments(),
+		enrollments:    NewActiveDeviceEnrollments(store, auditLog),
 		apiserverAuth:  apiserverAuth,
 		azureValidator: azureValidator,
 	}
@@ -111,6 +112,9 @@ func (api *DeviceApi) RoutesV2() func(chi.Router) {
 			r.Use(api.apiserverAuth)
 			r.Get("/info", api.getDeviceInfos)
 			r.Post("/config/{serial}", api.postBootstrapConfig)
+			r.Get("/enrollments", listEnrollments(api.enrollments.queue))
+			r.Post("/enrollments/{id}/ack", ackEnrollment(api.enrollments.queue))
+			r.Put("/enrollments/{id}/state", setEnrollmentState(api.enrollments.queue))
 		})
 	}
 }
@@ -129,6 +133,8 @@ func (api *GatewayApi) RoutesV2() func(chi.Router) {
 			r.Use(api.apiserverAuth)
 			r.Get("/info", api.getGatewayInfo)
 			r.Post("/config/{name}", api.postGatewayConfig)
+			r.Get("/enrollments", listEnrollments(api.enrollments.queue))
+			r.Post("/enrollments/{id}/ack", ackEnrollment(api.enrollments.queue))
 		})
 	}
 }
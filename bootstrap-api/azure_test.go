@@ -0,0 +1,99 @@
+package bootstrap_api_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nais/device/apiserver/config"
+	"github.com/nais/device/apiserver/idp"
+	bootstrap_api "github.com/nais/device/bootstrap-api"
+	"github.com/nais/device/pkg/fakeidp"
+	"github.com/nais/device/pkg/keycache"
+	"github.com/stretchr/testify/assert"
+)
+
+// identity is what the middleware found in the token.
+type identity struct {
+	username string
+	groups   []string
+}
+
+// serveIdentity returns a handler recording the identity the middleware found in the token.
+func serveIdentity(provider idp.Provider, identity *identity) http.Handler {
+	return bootstrap_api.TokenValidatorMiddleware(provider)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity.username, _ = r.Context().Value("preferred_username").(string)
+		identity.groups, _ = r.Context().Value("groups").([]string)
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func request(handler http.Handler, token string) int {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestTokenValidatorMiddleware(t *testing.T) {
+	user := fakeidp.User{Username: "user@example.com", ObjectID: "objectId1", Groups: []string{"group1"}}
+
+	fake, err := fakeidp.New("client", user)
+	assert.NoError(t, err)
+	defer fake.Close()
+
+	otherIdp, err := fakeidp.New("client", user)
+	assert.NoError(t, err)
+	defer otherIdp.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jwtValidator, err := keycache.NewKeyfunc(ctx, fake.KeysURL(), "client")
+	assert.NoError(t, err)
+
+	var found identity
+	handler := serveIdentity(idp.NewAzure(config.Azure{ClientID: "client"}, jwtValidator), &found)
+
+	token, err := fake.Token(user)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, request(handler, token))
+	assert.Equal(t, "user@example.com", found.username)
+	assert.Equal(t, []string{"group1"}, found.groups)
+
+	token, err = otherIdp.Token(user)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, request(handler, token), "token signed by another key")
+
+	assert.Equal(t, http.StatusForbidden, request(handler, "garbage"))
+}
+
+func TestTokenValidatorMiddlewareOIDC(t *testing.T) {
+	user := fakeidp.User{Username: "user@example.com", ObjectID: "objectId1", Groups: []string{"group1"}}
+
+	fake, err := fakeidp.New("client", user)
+	assert.NoError(t, err)
+	defer fake.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the tokens have no roles claim, which is not an error
+	provider, err := idp.NewOIDC(ctx, config.OIDC{IssuerURL: fake.URL, ClientID: "client", GroupsClaim: "roles"})
+	assert.NoError(t, err)
+
+	var found identity
+	handler := serveIdentity(provider, &found)
+
+	token, err := fake.Token(user)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, request(handler, token))
+	assert.Equal(t, "user@example.com", found.username)
+	assert.Empty(t, found.groups)
+
+	provider, err = idp.NewOIDC(ctx, config.OIDC{IssuerURL: fake.URL, ClientID: "other"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, request(serveIdentity(provider, &found), token), "token for another client")
+}
@@ -1,14 +1,19 @@
 package bootstrap_api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi"
+	"github.com/nais/device/pkg/audit"
 	"github.com/nais/device/pkg/bootstrap"
 	log "github.com/sirupsen/logrus"
 	"net/http"
-	"sync"
 )
 
+// postBootstrapConfig stores the config for the device.
+// Deprecated: only for apiservers that do not support acknowledged enrollments.
 func (api *DeviceApi) postBootstrapConfig(w http.ResponseWriter, r *http.Request) {
 	serial := chi.URLParam(r, "serial")
 
@@ -25,7 +30,13 @@ func (api *DeviceApi) postBootstrapConfig(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	api.enrollments.addBootstrapConfig(serial, bootstrapConfig)
+	err = api.enrollments.addBootstrapConfig(r.Context(), serial, bootstrapConfig)
+	if err != nil {
+		log.Errorf("Storing bootstrap config: %v", err)
+		StoreErrors.WithLabelValues(kindDevice).Inc()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusCreated)
 
@@ -34,22 +45,69 @@ func (api *DeviceApi) postBootstrapConfig(w http.ResponseWriter, r *http.Request
 
 func (api *DeviceApi) getBootstrapConfig(w http.ResponseWriter, r *http.Request) {
 	serial := chi.URLParam(r, "serial")
+	username, _ := r.Context().Value("preferred_username").(string)
+	if len(username) == 0 {
+		log.Errorf("request for bootstrap config of %v without username", serial)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
 	log := log.WithFields(log.Fields{
 		"component": "bootstrap-api",
 		"serial":    serial,
-		"username":  r.Context().Value("preferred_username").(string),
+		"username":  username,
 	})
 
-	bootstrapConfig := api.enrollments.getBootstrapConfig(serial)
+	// wait for the config, unless the enrollment has been rejected
+	var bootstrapConfig *bootstrap.Config
+	var status *bootstrap.EnrollmentStatus
+	_, err := waitFor(r, &api.enrollments.queue.changes, func() (bool, error) {
+		var err error
+		bootstrapConfig, err = api.enrollments.getBootstrapConfig(r.Context(), serial, username)
+		if !errors.Is(err, ErrNotFound) {
+			return err == nil, err
+		}
+
+		status, err = api.enrollments.getStatus(r.Context(), serial, username)
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return err == nil && status.State == bootstrap.EnrollmentRejected, err
+	})
 
-	if bootstrapConfig == nil {
+	var badRequest *badWaitError
+	if errors.As(err, &badRequest) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		log.Errorf("Reading bootstrap config: %v", err)
+		StoreErrors.WithLabelValues(kindDevice).Inc()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case bootstrapConfig != nil:
+	case status != nil && status.State == bootstrap.EnrollmentRejected:
+		log.Warnf("enrollment rejected for serial: %v: %s", serial, status.Reason)
+		respondEnrollmentStatus(w, http.StatusForbidden, *status)
+		return
+	case status != nil && status.State == bootstrap.EnrollmentPendingApproval:
+		respondEnrollmentStatus(w, http.StatusAccepted, *status)
+		return
+	default:
+		if len(r.URL.Query().Get("wait")) > 0 {
+			ConfigWaitTimeouts.WithLabelValues(kindDevice).Inc()
+		}
 		w.WriteHeader(http.StatusNotFound)
 		log.Warnf("no bootstrap config for serial: %v", serial)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	err := json.NewEncoder(w).Encode(bootstrapConfig)
+	err = json.NewEncoder(w).Encode(bootstrapConfig)
 	if err != nil {
 		log.Errorf("Unable to get bootstrap config: Encoding json: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -69,31 +127,52 @@ func (api *DeviceApi) postDeviceInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deviceInfo.Owner = r.Context().Value("preferred_username").(string)
+	deviceInfo.Owner, _ = r.Context().Value("preferred_username").(string)
 	if len(deviceInfo.Owner) == 0 {
 		log.Errorf("deviceInfo without owner, abort enroll")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	api.enrollments.addDeviceInfo(deviceInfo)
+	// groups are used for approving the enrollment, so they must come from the token
+	deviceInfo.Groups, _ = r.Context().Value("groups").([]string)
+
+	enrollment, err := api.enrollments.addDeviceInfo(r.Context(), deviceInfo)
+	if err != nil {
+		log.Errorf("Storing device info: %v", err)
+		StoreErrors.WithLabelValues(kindDevice).Inc()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
 	log.WithFields(log.Fields{
 		"component": "bootstrap-api",
 		"serial":    deviceInfo.Serial,
 		"username":  deviceInfo.Owner,
 		"platform":  deviceInfo.Platform,
+		"id":        enrollment.ID,
 	}).Infof("Enrollment request for apiserver queued")
 
 	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(enrollment); err != nil {
+		log.Errorf("Encoding json: %v", err)
+	}
 }
 
+// getDeviceInfos hands over the pending enrollments without waiting for them to be acknowledged.
+// Deprecated: only for apiservers that do not support acknowledged enrollments.
 func (api *DeviceApi) getDeviceInfos(w http.ResponseWriter, r *http.Request) {
-	deviceInfos := api.enrollments.getDeviceInfos()
+	deviceInfos, err := api.enrollments.getDeviceInfos(r.Context())
+	if err != nil {
+		log.Errorf("Reading device infos: %v", err)
+		StoreErrors.WithLabelValues(kindDevice).Inc()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	log.Infof("%s %s: %v", r.Method, r.URL, deviceInfos)
 
 	w.WriteHeader(http.StatusOK)
-	err := json.NewEncoder(w).Encode(deviceInfos)
+	err = json.NewEncoder(w).Encode(deviceInfos)
 	if err != nil {
 		log.Errorf("Encoding json: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -101,54 +180,63 @@ func (api *DeviceApi) getDeviceInfos(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ActiveDeviceEnrollments are device enrollments waiting for the apiserver, and bootstrap configs waiting for the device.
+// Both are keyed by serial, so a device retrying its enrollment does not enroll twice.
 type ActiveDeviceEnrollments struct {
-	deviceInfos     []bootstrap.DeviceInfo
-	deviceInfosLock sync.Mutex
-
-	bootstrapConfigs     map[string]bootstrap.Config
-	bootstrapConfigsLock sync.Mutex
+	queue *enrollmentQueue
 }
 
-func NewActiveDeviceEnrollments() *ActiveDeviceEnrollments {
+func NewActiveDeviceEnrollments(store EnrollmentStore, auditLog *audit.Log) *ActiveDeviceEnrollments {
 	return &ActiveDeviceEnrollments{
-		bootstrapConfigs: make(map[string]bootstrap.Config),
+		queue: &enrollmentQueue{
+			store:        store,
+			kind:         kindDevice,
+			infoBucket:   bucketDeviceInfo,
+			configBucket: bucketDeviceConfig,
+			idBucket:     bucketDeviceEnrollmentID,
+			audit:        auditLog,
+		},
 	}
 }
 
-func (a *ActiveDeviceEnrollments) getDeviceInfos() []bootstrap.DeviceInfo {
-	a.deviceInfosLock.Lock()
-	defer a.deviceInfosLock.Unlock()
-
-	var deviceInfosToReturn []bootstrap.DeviceInfo
-	deviceInfosToReturn = append(deviceInfosToReturn, a.deviceInfos...)
+func (a *ActiveDeviceEnrollments) getDeviceInfos(ctx context.Context) ([]bootstrap.DeviceInfo, error) {
+	enrollments, err := a.queue.takeAll(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	a.deviceInfos = nil
+	var deviceInfos []bootstrap.DeviceInfo
+	for _, enrollment := range enrollments {
+		var deviceInfo bootstrap.DeviceInfo
+		if err := json.Unmarshal(enrollment.Info, &deviceInfo); err != nil {
+			return nil, fmt.Errorf("decoding device info: %w", err)
+		}
+		deviceInfos = append(deviceInfos, deviceInfo)
+	}
 
-	return deviceInfosToReturn
+	return deviceInfos, nil
 }
 
-func (a *ActiveDeviceEnrollments) addDeviceInfo(deviceInfo bootstrap.DeviceInfo) {
-	a.deviceInfosLock.Lock()
-	defer a.deviceInfosLock.Unlock()
-
-	a.deviceInfos = append(a.deviceInfos, deviceInfo)
+func (a *ActiveDeviceEnrollments) addDeviceInfo(ctx context.Context, deviceInfo bootstrap.DeviceInfo) (*enrollment, error) {
+	return a.queue.enqueue(ctx, deviceInfo.Serial, deviceInfo.Owner, deviceInfo)
 }
 
-func (a *ActiveDeviceEnrollments) addBootstrapConfig(serial string, bootstrapConfig bootstrap.Config) {
-	a.bootstrapConfigsLock.Lock()
-	defer a.bootstrapConfigsLock.Unlock()
+func (a *ActiveDeviceEnrollments) addBootstrapConfig(ctx context.Context, serial string, bootstrapConfig bootstrap.Config) error {
+	return a.queue.putConfig(ctx, serial, "", bootstrapConfig)
+}
 
-	a.bootstrapConfigs[serial] = bootstrapConfig
+func (a *ActiveDeviceEnrollments) getBootstrapConfig(ctx context.Context, serial, owner string) (*bootstrap.Config, error) {
+	return a.queue.config(ctx, serial, owner)
 }
 
-func (a *ActiveDeviceEnrollments) getBootstrapConfig(serial string) *bootstrap.Config {
-	a.bootstrapConfigsLock.Lock()
-	defer a.bootstrapConfigsLock.Unlock()
+func (a *ActiveDeviceEnrollments) getStatus(ctx context.Context, serial, owner string) (*bootstrap.EnrollmentStatus, error) {
+	return a.queue.status(ctx, serial, owner)
+}
 
-	if val, ok := a.bootstrapConfigs[serial]; ok {
-		delete(a.bootstrapConfigs, serial)
-		return &val
-	} else {
-		return nil
+func respondEnrollmentStatus(w http.ResponseWriter, code int, status bootstrap.EnrollmentStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Errorf("Encoding json: %v", err)
 	}
 }
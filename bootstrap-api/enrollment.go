@@ -0,0 +1,530 @@
+package bootstrap_api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/nais/device/pkg/audit"
+	"github.com/nais/device/pkg/bootstrap"
+	"github.com/nais/device/pkg/random"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Enrollments are acknowledged by the apiserver, so none are lost if it fails while enrolling:
+
+1. device/gateway -> Info                -> bootstrap-api (queued as a pending enrollment with an ID)
+2. apiserver      <- pending enrollments <- bootstrap-api (left in place until acknowledged)
+3. apiserver      -> ack ID with Config  -> bootstrap-api (removes the pending enrollment)
+4. device/gateway <- Config              <- bootstrap-api
+
+Both GETs take a wait parameter, holding the request until there is something to return. Waiting requests are
+woken by changes made by this replica, and check the store for changes made by other replicas every waitPollInterval.
+
+Device enrollments requiring approval are not acknowledged until approved. Until then, the apiserver sets their
+state, which removes them from the pending enrollments and is returned to the device instead of its config.
+*/
+
+const (
+	MaxWait          = 25 * time.Second // longest a request can be held waiting, as load balancers time out idle requests
+	waitPollInterval = 5 * time.Second  // how often to check the store for changes by other replicas while waiting
+	ackAttempts      = 3                // times ack retries when the enrollment changes while it is acknowledged
+)
+
+const (
+	kindDevice  = "device"
+	kindGateway = "gateway"
+)
+
+// enrollment is a pending device or gateway enrollment. The info is kept as it was sent, so it is the same for
+// both kinds, and encodes as a bootstrap.DeviceEnrollment or bootstrap.GatewayEnrollment.
+type enrollment struct {
+	ID      string                    `json:"id"`
+	Created time.Time                 `json:"created"`
+	State   bootstrap.EnrollmentState `json:"state,omitempty"`
+	Reason  string                    `json:"reason,omitempty"`
+	Info    json.RawMessage           `json:"info"`
+	// Owner is the user enrolling the device, or the name of the gateway.
+	Owner string `json:"owner,omitempty"`
+}
+
+// storedConfig is a config waiting to be retrieved by the owner of the enrollment it was acknowledged for.
+// Configs without an owner are stored by apiservers that do not acknowledge enrollments.
+type storedConfig struct {
+	Owner  string            `json:"owner,omitempty"`
+	Config *bootstrap.Config `json:"config"`
+}
+
+// enrollmentQueue holds the pending enrollments and configs of either devices or gateways, by serial or name.
+// The key of each enrollment is also stored by enrollment ID, so that enrollments can be looked up by ID.
+type enrollmentQueue struct {
+	store        EnrollmentStore
+	kind         string
+	infoBucket   string
+	configBucket string
+	idBucket     string
+	audit        *audit.Log
+	changes      notifier
+}
+
+// notifier wakes up requests waiting for changes to the enrollments.
+type notifier struct {
+	lock    sync.Mutex
+	changed chan struct{}
+}
+
+// wait returns a channel that is closed on the next change.
+func (n *notifier) wait() <-chan struct{} {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.changed == nil {
+		n.changed = make(chan struct{})
+	}
+	return n.changed
+}
+
+func (n *notifier) notify() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.changed != nil {
+		close(n.changed)
+		n.changed = nil
+	}
+}
+
+// enqueue adds a pending enrollment by the actor, replacing any earlier enrollment and config for the key.
+// Enqueueing the same info again keeps the pending enrollment along with its state, so that retries are not
+// enrolled twice. Rejected enrollments are queued again, as the reason for rejecting them may have been resolved.
+func (q *enrollmentQueue) enqueue(ctx context.Context, key, actor string, info interface{}) (*enrollment, error) {
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s info: %w", q.kind, err)
+	}
+
+	var existing enrollment
+	err = getJSON(ctx, q.store, q.infoBucket, key, &existing)
+	switch {
+	case err == nil && bytes.Equal(existing.Info, raw) && existing.State != bootstrap.EnrollmentRejected:
+		if err := q.put(ctx, key, &existing); err != nil {
+			return nil, err
+		}
+		return &existing, nil
+	case err != nil && !errors.Is(err, ErrNotFound):
+		return nil, err
+	}
+
+	if err := q.store.Delete(ctx, q.configBucket, key); err != nil {
+		return nil, err
+	}
+
+	e := &enrollment{
+		ID:      random.RandomString(16, random.LettersAndNumbers),
+		Created: time.Now(),
+		Info:    raw,
+		Owner:   actor,
+	}
+
+	if err := q.put(ctx, key, e); err != nil {
+		return nil, err
+	}
+
+	q.changes.notify()
+	EnrollmentsQueued.WithLabelValues(q.kind).Inc()
+	q.record(audit.ActionEnrollmentQueued, actor, key, e.ID, nil)
+	return e, nil
+}
+
+// put stores the enrollment by key, and the key by enrollment ID.
+func (q *enrollmentQueue) put(ctx context.Context, key string, e *enrollment) error {
+	if err := q.store.Put(ctx, q.idBucket, e.ID, []byte(key)); err != nil {
+		return err
+	}
+
+	return putJSON(ctx, q.store, q.infoBucket, key, e)
+}
+
+// all returns the enrollments not yet acknowledged by the apiserver by key, oldest first.
+func (q *enrollmentQueue) all(ctx context.Context) ([]string, []enrollment, error) {
+	values, err := q.store.List(ctx, q.infoBucket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return decodeEnrollments(q.kind, values)
+}
+
+// pending returns the enrollments waiting for the apiserver, oldest first.
+func (q *enrollmentQueue) pending(ctx context.Context) ([]enrollment, error) {
+	_, enrollments, err := q.all(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]enrollment, 0, len(enrollments))
+	for _, e := range enrollments {
+		if e.State == bootstrap.EnrollmentQueued {
+			pending = append(pending, e)
+		}
+	}
+
+	return pending, nil
+}
+
+// find returns the key and enrollment with the given ID along with its stored value, or ErrNotFound.
+func (q *enrollmentQueue) find(ctx context.Context, id string) (string, *enrollment, []byte, error) {
+	key, err := q.store.Get(ctx, q.idBucket, id)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	value, err := q.store.Get(ctx, q.infoBucket, string(key))
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	var e enrollment
+	if err := json.Unmarshal(value, &e); err != nil {
+		return "", nil, nil, fmt.Errorf("decoding %s enrollment: %w", q.kind, err)
+	}
+
+	// the enrollment has been replaced by a newer one for the same key
+	if e.ID != id {
+		return "", nil, nil, ErrNotFound
+	}
+
+	return string(key), &e, value, nil
+}
+
+// setState sets the state of the enrollment with the given ID, or returns ErrNotFound.
+func (q *enrollmentQueue) setState(ctx context.Context, id string, status bootstrap.EnrollmentStatus) (string, error) {
+	key, e, _, err := q.find(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	e.State = status.State
+	e.Reason = status.Reason
+	if err := putJSON(ctx, q.store, q.infoBucket, key, e); err != nil {
+		return "", err
+	}
+
+	q.changes.notify()
+	return key, nil
+}
+
+// status returns the state of the owner's enrollment for the key, or ErrNotFound if there is none.
+func (q *enrollmentQueue) status(ctx context.Context, key, owner string) (*bootstrap.EnrollmentStatus, error) {
+	var e enrollment
+	if err := getJSON(ctx, q.store, q.infoBucket, key, &e); err != nil {
+		return nil, err
+	}
+
+	if e.Owner != owner {
+		return nil, ErrNotFound
+	}
+
+	return &bootstrap.EnrollmentStatus{State: e.State, Reason: e.Reason}, nil
+}
+
+// takeAll removes and returns all pending enrollments. It serves apiservers that do not acknowledge enrollments.
+func (q *enrollmentQueue) takeAll(ctx context.Context) ([]enrollment, error) {
+	values, err := q.store.TakeAll(ctx, q.infoBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	_, enrollments, err := decodeEnrollments(q.kind, values)
+	return enrollments, err
+}
+
+// ack removes the pending enrollment with the given ID, and stores the config for it. The enrollment is only removed
+// if it is unchanged since it was read, so the config is not stored for an enrollment replaced by a newer one.
+// It returns ErrNotFound if there is no such enrollment.
+func (q *enrollmentQueue) ack(ctx context.Context, id string, config bootstrap.Config) (string, error) {
+	for attempt := 0; attempt < ackAttempts; attempt++ {
+		key, e, value, err := q.find(ctx, id)
+		if err != nil {
+			return "", err
+		}
+
+		deleted, err := q.store.DeleteIf(ctx, q.infoBucket, key, value)
+		if err != nil {
+			return "", err
+		}
+
+		// changed since it was read, e.g. by a new enrollment or state
+		if !deleted {
+			continue
+		}
+
+		if err := q.putConfig(ctx, key, e.Owner, config); err != nil {
+			// put the enrollment back, so that the apiserver can acknowledge it again
+			if err := q.store.Put(ctx, q.infoBucket, key, value); err != nil {
+				log.Errorf("Restoring %s enrollment %s: %v", q.kind, id, err)
+			}
+			return "", err
+		}
+
+		if err := q.store.Delete(ctx, q.idBucket, id); err != nil {
+			log.Warnf("Deleting %s enrollment id %s: %v", q.kind, id, err)
+		}
+
+		EnrollmentDuration.WithLabelValues(q.kind).Observe(time.Since(e.Created).Seconds())
+		return key, nil
+	}
+
+	return "", fmt.Errorf("%s enrollment %s changed while acknowledging it", q.kind, id)
+}
+
+// record records an audit event for the enrollment with the given key and ID.
+func (q *enrollmentQueue) record(action, actor, key, id string, details map[string]string) {
+	if details == nil {
+		details = make(map[string]string)
+	}
+	details["kind"] = q.kind
+	details["enrollmentID"] = id
+
+	q.audit.Record(audit.Event{
+		Action:  action,
+		Outcome: audit.OutcomeSuccess,
+		Actor:   actor,
+		Target:  key,
+		Details: details,
+	})
+}
+
+func (q *enrollmentQueue) putConfig(ctx context.Context, key, owner string, config bootstrap.Config) error {
+	if err := putJSON(ctx, q.store, q.configBucket, key, storedConfig{Owner: owner, Config: &config}); err != nil {
+		return err
+	}
+
+	q.changes.notify()
+	return nil
+}
+
+// config returns the owner's config until it expires, so it can be retrieved again if the response is lost.
+// Configs without an owner can be retrieved once. ErrNotFound is returned if there is no config for the owner.
+func (q *enrollmentQueue) config(ctx context.Context, key, owner string) (*bootstrap.Config, error) {
+	var stored storedConfig
+	if err := getJSON(ctx, q.store, q.configBucket, key, &stored); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case stored.Config == nil:
+		return nil, ErrNotFound
+	case len(stored.Owner) == 0:
+		if err := q.store.Delete(ctx, q.configBucket, key); err != nil {
+			return nil, err
+		}
+	case stored.Owner != owner:
+		log.Warnf("%s config for %s requested by %s, but enrolled by %s", q.kind, key, owner, stored.Owner)
+		return nil, ErrNotFound
+	}
+
+	return stored.Config, nil
+}
+
+func decodeEnrollments(kind string, values map[string][]byte) ([]string, []enrollment, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	enrollments := make([]enrollment, len(keys))
+	for i, key := range keys {
+		if err := json.Unmarshal(values[key], &enrollments[i]); err != nil {
+			return nil, nil, fmt.Errorf("decoding %s enrollment: %w", kind, err)
+		}
+	}
+
+	sort.Sort(byCreated{keys, enrollments})
+	return keys, enrollments, nil
+}
+
+type byCreated struct {
+	keys        []string
+	enrollments []enrollment
+}
+
+func (b byCreated) Len() int { return len(b.keys) }
+func (b byCreated) Less(i, j int) bool {
+	return b.enrollments[i].Created.Before(b.enrollments[j].Created)
+}
+func (b byCreated) Swap(i, j int) {
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+	b.enrollments[i], b.enrollments[j] = b.enrollments[j], b.enrollments[i]
+}
+
+// listEnrollments serves the pending enrollments to the apiserver, waiting for one if there are none.
+func listEnrollments(q *enrollmentQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var enrollments []enrollment
+		_, err := waitFor(r, &q.changes, func() (bool, error) {
+			var err error
+			enrollments, err = q.pending(r.Context())
+			return len(enrollments) > 0, err
+		})
+
+		var badRequest *badWaitError
+		if errors.As(err, &badRequest) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err != nil {
+			log.Errorf("Listing %s enrollments: %v", q.kind, err)
+			StoreErrors.WithLabelValues(q.kind).Inc()
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(enrollments); err != nil {
+			log.Errorf("Encoding json: %v", err)
+		}
+	}
+}
+
+// ackEnrollment receives the config for a pending enrollment from the apiserver.
+func ackEnrollment(q *enrollmentQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		var config bootstrap.Config
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			log.Errorf("Decoding json: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		key, err := q.ack(r.Context(), id, config)
+		if errors.Is(err, ErrNotFound) {
+			log.Warnf("No pending %s enrollment with id %s", q.kind, id)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			log.Errorf("Acknowledging %s enrollment: %v", q.kind, err)
+			StoreErrors.WithLabelValues(q.kind).Inc()
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"component": "bootstrap-api",
+			"kind":      q.kind,
+			"key":       key,
+			"id":        id,
+		}).Infof("Enrollment acknowledged by apiserver")
+
+		apiserver, _, _ := r.BasicAuth()
+		q.record(audit.ActionEnrollmentAcknowledged, apiserver, key, id, nil)
+
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// setEnrollmentState receives the state of a pending enrollment that the apiserver does not yet acknowledge.
+func setEnrollmentState(q *enrollmentQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		var status bootstrap.EnrollmentStatus
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+			log.Errorf("Decoding json: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch status.State {
+		case bootstrap.EnrollmentPendingApproval, bootstrap.EnrollmentRejected:
+		default:
+			http.Error(w, fmt.Sprintf("invalid enrollment state: %q", status.State), http.StatusBadRequest)
+			return
+		}
+
+		key, err := q.setState(r.Context(), id, status)
+		if errors.Is(err, ErrNotFound) {
+			log.Warnf("No pending %s enrollment with id %s", q.kind, id)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			log.Errorf("Setting %s enrollment state: %v", q.kind, err)
+			StoreErrors.WithLabelValues(q.kind).Inc()
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"component": "bootstrap-api",
+			"kind":      q.kind,
+			"key":       key,
+			"id":        id,
+			"state":     status.State,
+			"reason":    status.Reason,
+		}).Infof("Enrollment state set by apiserver")
+
+		apiserver, _, _ := r.BasicAuth()
+		q.record(audit.ActionEnrollmentStateChanged, apiserver, key, id, map[string]string{"state": string(status.State), "reason": status.Reason})
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type badWaitError struct {
+	value string
+}
+
+func (e *badWaitError) Error() string {
+	return fmt.Sprintf("invalid wait duration: %q", e.value)
+}
+
+// waitFor calls ready on changes until it returns true, or the duration given by the request's wait parameter has
+// passed. Without a wait parameter, ready is called once. It returns whether ready returned true.
+func waitFor(r *http.Request, changes *notifier, ready func() (bool, error)) (bool, error) {
+	var wait time.Duration
+	if value := r.URL.Query().Get("wait"); len(value) > 0 {
+		var err error
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 {
+			return false, &badWaitError{value: value}
+		}
+	}
+
+	if wait > MaxWait {
+		wait = MaxWait
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+
+	for {
+		// wait for changes made after ready was called
+		changed := changes.wait()
+
+		ok, err := ready()
+		if ok || err != nil {
+			return ok, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-changed:
+		case <-time.After(waitPollInterval):
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package bootstrap_api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	bootstrap_api "github.com/nais/device/bootstrap-api"
+	"github.com/nais/device/pkg/audit"
+	"github.com/nais/device/pkg/bootstrap"
+	"github.com/stretchr/testify/assert"
+)
+
+type doFunc func(method, path string, body interface{}, v interface{}) int
+
+// setupEnrollment starts a bootstrap-api, returning a function that makes requests to it and decodes the response.
+// Device requests are made by user@example.com, unless another user is given by the "as" query parameter.
+func setupEnrollment(t *testing.T, auditLog *audit.Log) (doFunc, func()) {
+	deviceAuthMock := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username := "user@example.com"
+			if as := r.URL.Query().Get("as"); len(as) > 0 {
+				username = as
+			}
+
+			ctx := context.WithValue(r.Context(), "preferred_username", username)
+			ctx = context.WithValue(ctx, "groups", []string{"group1"})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+
+	store := bootstrap_api.NewMemoryStore(bootstrap_api.DefaultEnrollmentTTL)
+	api := bootstrap_api.NewApi(map[string]string{apiserverUsername: apiserverPassword}, deviceAuthMock, &FakeSecretManager{}, store, auditLog)
+	server := httptest.NewServer(api.Router())
+
+	do := func(method, path string, body interface{}, v interface{}) int {
+		b, err := json.Marshal(body)
+		assert.NoError(t, err)
+
+		req, err := http.NewRequest(method, server.URL+path, bytes.NewReader(b))
+		assert.NoError(t, err)
+		req.SetBasicAuth(apiserverUsername, apiserverPassword)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		if v != nil {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+
+	return do, server.Close
+}
+
+func TestDeviceEnrollmentHandshake(t *testing.T) {
+	do, stop := setupEnrollment(t, nil)
+	defer stop()
+
+	deviceInfo := bootstrap.DeviceInfo{Serial: "serial", PublicKey: "publicKey", Platform: "linux"}
+	bootstrapConfig := bootstrap.Config{DeviceIP: "10.255.240.2", PublicKey: "apiserverPublicKey"}
+
+	var enrollment bootstrap.DeviceEnrollment
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/info", deviceInfo, &enrollment))
+	assert.NotEmpty(t, enrollment.ID)
+	assert.Equal(t, "user@example.com", enrollment.Info.Owner)
+	assert.Equal(t, []string{"group1"}, enrollment.Info.Groups)
+
+	t.Run("retried enrollment keeps its id", func(t *testing.T) {
+		var retried bootstrap.DeviceEnrollment
+		assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/info", deviceInfo, &retried))
+		assert.Equal(t, enrollment.ID, retried.ID)
+	})
+
+	t.Run("pending enrollments are kept until acknowledged", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			var pending []bootstrap.DeviceEnrollment
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/device/enrollments", nil, &pending))
+			assert.Len(t, pending, 1)
+			assert.Equal(t, enrollment.ID, pending[0].ID)
+			assert.Equal(t, deviceInfo.Serial, pending[0].Info.Serial)
+		}
+	})
+
+	t.Run("device waiting for config gets it when acknowledged", func(t *testing.T) {
+		received := make(chan bootstrap.Config)
+		go func() {
+			var config bootstrap.Config
+			assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/device/config/serial?wait=5s", nil, &config))
+			received <- config
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/enrollments/"+enrollment.ID+"/ack", bootstrapConfig, nil))
+
+		select {
+		case config := <-received:
+			assert.Equal(t, bootstrapConfig, config)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for config")
+		}
+
+		var pending []bootstrap.DeviceEnrollment
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/device/enrollments", nil, &pending))
+		assert.Empty(t, pending)
+	})
+
+	t.Run("config can be retrieved again", func(t *testing.T) {
+		var config bootstrap.Config
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/device/config/serial", nil, &config))
+		assert.Equal(t, bootstrapConfig, config)
+	})
+
+	t.Run("unknown enrollment is not acknowledged", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/v2/device/enrollments/unknown/ack", bootstrapConfig, nil))
+	})
+
+	t.Run("waiting for config times out", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/v2/device/config/other?wait=100ms", nil, nil))
+	})
+
+	t.Run("invalid wait is rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/api/v2/device/enrollments?wait=forever", nil, nil))
+	})
+}
+
+func TestDeviceConfigOwner(t *testing.T) {
+	do, stop := setupEnrollment(t, nil)
+	defer stop()
+
+	deviceInfo := bootstrap.DeviceInfo{Serial: "serial", PublicKey: "publicKey", Platform: "linux"}
+	bootstrapConfig := bootstrap.Config{DeviceIP: "10.255.240.2", PublicKey: "apiserverPublicKey"}
+
+	var enrollment bootstrap.DeviceEnrollment
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/info", deviceInfo, &enrollment))
+
+	t.Run("status is only returned to the owner", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, do(http.MethodPut, "/api/v2/device/enrollments/"+enrollment.ID+"/state", bootstrap.EnrollmentStatus{State: bootstrap.EnrollmentPendingApproval}, nil))
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/v2/device/config/serial?as=other@example.com", nil, nil))
+		assert.Equal(t, http.StatusAccepted, do(http.MethodGet, "/api/v2/device/config/serial", nil, nil))
+	})
+
+	t.Run("config is only returned to the owner", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/enrollments/"+enrollment.ID+"/ack", bootstrapConfig, nil))
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/v2/device/config/serial?as=other@example.com", nil, nil))
+
+		var config bootstrap.Config
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/device/config/serial", nil, &config))
+		assert.Equal(t, bootstrapConfig, config)
+	})
+
+	t.Run("config from apiserver not acknowledging enrollments is returned once", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/config/other", bootstrapConfig, nil))
+
+		var config bootstrap.Config
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/device/config/other", nil, &config))
+		assert.Equal(t, bootstrapConfig, config)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/v2/device/config/other", nil, nil))
+	})
+}
+
+func TestDeviceEnrollmentApproval(t *testing.T) {
+	do, stop := setupEnrollment(t, nil)
+	defer stop()
+
+	deviceInfo := bootstrap.DeviceInfo{Serial: "serial", PublicKey: "publicKey", Platform: "linux"}
+	bootstrapConfig := bootstrap.Config{DeviceIP: "10.255.240.2", PublicKey: "apiserverPublicKey"}
+
+	var enrollment bootstrap.DeviceEnrollment
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/info", deviceInfo, &enrollment))
+
+	setState := func(state bootstrap.EnrollmentState) int {
+		return do(http.MethodPut, "/api/v2/device/enrollments/"+enrollment.ID+"/state", bootstrap.EnrollmentStatus{State: state}, nil)
+	}
+
+	t.Run("enrollment pending approval is no longer pending for the apiserver", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, setState(bootstrap.EnrollmentPendingApproval))
+
+		var status bootstrap.EnrollmentStatus
+		assert.Equal(t, http.StatusAccepted, do(http.MethodGet, "/api/v2/device/config/serial", nil, &status))
+		assert.Equal(t, bootstrap.EnrollmentPendingApproval, status.State)
+
+		var pending []bootstrap.DeviceEnrollment
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/device/enrollments", nil, &pending))
+		assert.Empty(t, pending)
+	})
+
+	t.Run("retried enrollment keeps its state", func(t *testing.T) {
+		var retried bootstrap.DeviceEnrollment
+		assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/info", deviceInfo, &retried))
+		assert.Equal(t, enrollment.ID, retried.ID)
+		assert.Equal(t, bootstrap.EnrollmentPendingApproval, retried.State)
+	})
+
+	t.Run("rejected enrollment is returned without waiting", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, setState(bootstrap.EnrollmentRejected))
+
+		start := time.Now()
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/v2/device/config/serial?wait=5s", nil, nil))
+		assert.True(t, time.Since(start) < time.Second)
+	})
+
+	t.Run("invalid state is rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, setState(bootstrap.EnrollmentQueued))
+	})
+
+	t.Run("approved enrollment is acknowledged", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/enrollments/"+enrollment.ID+"/ack", bootstrapConfig, nil))
+
+		var config bootstrap.Config
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/device/config/serial", nil, &config))
+		assert.Equal(t, bootstrapConfig, config)
+	})
+}
+
+func TestDeviceEnrollmentRejectedWithReason(t *testing.T) {
+	do, stop := setupEnrollment(t, nil)
+	defer stop()
+
+	deviceInfo := bootstrap.DeviceInfo{Serial: "serial", PublicKey: "publicKey", Platform: "linux"}
+
+	var enrollment bootstrap.DeviceEnrollment
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/info", deviceInfo, &enrollment))
+
+	rejected := bootstrap.EnrollmentStatus{State: bootstrap.EnrollmentRejected, Reason: "device is enrolled by another user"}
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPut, "/api/v2/device/enrollments/"+enrollment.ID+"/state", rejected, nil))
+
+	t.Run("reason is returned to the device", func(t *testing.T) {
+		var status bootstrap.EnrollmentStatus
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/v2/device/config/serial", nil, &status))
+		assert.Equal(t, rejected, status)
+	})
+
+	t.Run("retried rejected enrollment is queued again", func(t *testing.T) {
+		var retried bootstrap.DeviceEnrollment
+		assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/info", deviceInfo, &retried))
+		assert.NotEqual(t, enrollment.ID, retried.ID)
+		assert.Equal(t, bootstrap.EnrollmentQueued, retried.State)
+
+		var pending []bootstrap.DeviceEnrollment
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v2/device/enrollments", nil, &pending))
+		assert.Len(t, pending, 1)
+	})
+}
+
+func TestDeviceEnrollmentAudit(t *testing.T) {
+	events := make(chan audit.Event, 3)
+	auditLog := audit.New("bootstrap-api", audit.SinkFunc(func(ctx context.Context, e audit.Event) error {
+		events <- e
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go auditLog.Run(ctx)
+
+	do, stop := setupEnrollment(t, auditLog)
+	defer stop()
+
+	deviceInfo := bootstrap.DeviceInfo{Serial: "serial", PublicKey: "publicKey", Platform: "linux"}
+
+	var enrollment bootstrap.DeviceEnrollment
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/info", deviceInfo, &enrollment))
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/info", deviceInfo, &enrollment), "retries are not recorded")
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPut, "/api/v2/device/enrollments/"+enrollment.ID+"/state", bootstrap.EnrollmentStatus{State: bootstrap.EnrollmentPendingApproval}, nil))
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/v2/device/enrollments/"+enrollment.ID+"/ack", bootstrap.Config{}, nil))
+
+	for _, expected := range []struct {
+		action, actor string
+	}{
+		{audit.ActionEnrollmentQueued, "user@example.com"},
+		{audit.ActionEnrollmentStateChanged, apiserverUsername},
+		{audit.ActionEnrollmentAcknowledged, apiserverUsername},
+	} {
+		select {
+		case e := <-events:
+			assert.Equal(t, expected.action, e.Action)
+			assert.Equal(t, expected.actor, e.Actor)
+			assert.Equal(t, "serial", e.Target)
+			assert.Equal(t, enrollment.ID, e.Details["enrollmentID"])
+			assert.Equal(t, "bootstrap-api", e.Component)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s event", expected.action)
+		}
+	}
+}
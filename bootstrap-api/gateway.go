@@ -1,16 +1,16 @@
 package bootstrap_api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi"
+	"github.com/nais/device/pkg/audit"
 	"github.com/nais/device/pkg/bootstrap"
-	"github.com/nais/device/pkg/version"
-	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -22,22 +22,12 @@ import (
 2. apiserver <- GatewayInfo   <- bootstrap-api
 3. apiserver -> GatewayConfig -> bootstrap-api
 4. gateway   <- GatewayConfig <- bootstrap-api
+
+Steps 2 and 3 are superseded by acknowledged enrollments, see enrollment.go.
 */
 
 const GatewayNameContextKey = "gateway-name"
 
-var failedSecretManagerSynchronizations prometheus.Counter
-
-func init() {
-	failedSecretManagerSynchronizations = prometheus.NewCounter(prometheus.CounterOpts{
-		Name:        "failed_secret_manager_synchronizations",
-		Help:        "count of failed secret manager synchronizations",
-		Namespace:   "naisdevice",
-		Subsystem:   "bootstrap_api",
-		ConstLabels: prometheus.Labels{"name": "bootstrap-api", "version": version.Version},
-	})
-}
-
 // step 1. gateway posts gateway info
 func (api *GatewayApi) postGatewayInfo(w http.ResponseWriter, r *http.Request) {
 	var gatewayInfo bootstrap.GatewayInfo
@@ -49,23 +39,40 @@ func (api *GatewayApi) postGatewayInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	api.enrollments.addGatewayInfo(gatewayInfo)
+	enrollment, err := api.enrollments.addGatewayInfo(r.Context(), gatewayInfo)
+	if err != nil {
+		log.Errorf("Storing gateway info: %v", err)
+		StoreErrors.WithLabelValues(kindGateway).Inc()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
 	log.WithFields(log.Fields{
 		"component": "bootstrap-api",
 		"serial":    gatewayInfo.Name,
 		"public_ip": gatewayInfo.PublicIP,
+		"id":        enrollment.ID,
 	}).Infof("Gateway enrollment request for apiserver queued")
 
 	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(enrollment); err != nil {
+		log.Errorf("Encoding json: %v", err)
+	}
 }
 
 // step 2: apiserver gets gateway infos
+// Deprecated: only for apiservers that do not support acknowledged enrollments.
 func (api *GatewayApi) getGatewayInfo(w http.ResponseWriter, r *http.Request) {
-	gatewayInfos := api.enrollments.getGatewayInfos()
+	gatewayInfos, err := api.enrollments.getGatewayInfos(r.Context())
+	if err != nil {
+		log.Errorf("Reading gateway infos: %v", err)
+		StoreErrors.WithLabelValues(kindGateway).Inc()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	log.Infof("%s %s: %v", r.Method, r.URL, gatewayInfos)
 
-	err := json.NewEncoder(w).Encode(gatewayInfos)
+	err = json.NewEncoder(w).Encode(gatewayInfos)
 	if err != nil {
 		log.Errorf("Encoding json: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -74,6 +81,7 @@ func (api *GatewayApi) getGatewayInfo(w http.ResponseWriter, r *http.Request) {
 }
 
 // step 3. apiserver posts gateway config
+// Deprecated: only for apiservers that do not support acknowledged enrollments.
 func (api *GatewayApi) postGatewayConfig(w http.ResponseWriter, r *http.Request) {
 	gatewayName := chi.URLParam(r, "name")
 
@@ -89,7 +97,13 @@ func (api *GatewayApi) postGatewayConfig(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	api.enrollments.addGatewayConfig(gatewayConfig, gatewayName)
+	err = api.enrollments.addGatewayConfig(r.Context(), gatewayConfig, gatewayName)
+	if err != nil {
+		log.Errorf("Storing gateway config: %v", err)
+		StoreErrors.WithLabelValues(kindGateway).Inc()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusCreated)
 
@@ -104,9 +118,33 @@ func (api *GatewayApi) getGatewayConfig(w http.ResponseWriter, r *http.Request)
 		"component": "bootstrap-api",
 	})
 
-	gatewayConfig := api.enrollments.getGatewayConfig(gatewayName)
+	var gatewayConfig *bootstrap.Config
+	found, err := waitFor(r, &api.enrollments.queue.changes, func() (bool, error) {
+		var err error
+		gatewayConfig, err = api.enrollments.getGatewayConfig(r.Context(), gatewayName)
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+
+	var badRequest *badWaitError
+	if errors.As(err, &badRequest) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		log.Errorf("Reading gateway config: %v", err)
+		StoreErrors.WithLabelValues(kindGateway).Inc()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	if gatewayConfig == nil {
+	if !found {
+		if len(r.URL.Query().Get("wait")) > 0 {
+			ConfigWaitTimeouts.WithLabelValues(kindGateway).Inc()
+		}
 		w.WriteHeader(http.StatusNotFound)
 		log.Warnf("No gateway config for provided token found")
 		return
@@ -128,56 +166,53 @@ func (api *GatewayApi) getGatewayConfig(w http.ResponseWriter, r *http.Request)
 	log.WithField("event", "retrieved_gateway_config").Infof("Successfully returned gateway config")
 }
 
+// ActiveGatewayEnrollments are gateway enrollments waiting for the apiserver, and configs waiting for the gateway.
+// Both are keyed by gateway name, so a gateway retrying its enrollment does not enroll twice.
 type ActiveGatewayEnrollments struct {
-	gatewayInfos     []bootstrap.GatewayInfo
-	gatewayInfosLock sync.Mutex
-
-	bootstrapGatewayConfigs     map[string]bootstrap.Config
-	bootstrapGatewayConfigsLock sync.Mutex
+	queue *enrollmentQueue
 }
 
-func NewActiveGatewayEnrollments() *ActiveGatewayEnrollments {
+func NewActiveGatewayEnrollments(store EnrollmentStore, auditLog *audit.Log) *ActiveGatewayEnrollments {
 	return &ActiveGatewayEnrollments{
-		bootstrapGatewayConfigs: make(map[string]bootstrap.Config),
+		queue: &enrollmentQueue{
+			store:        store,
+			kind:         kindGateway,
+			infoBucket:   bucketGatewayInfo,
+			configBucket: bucketGatewayConfig,
+			idBucket:     bucketGatewayEnrollmentID,
+			audit:        auditLog,
+		},
 	}
 }
 
-func (a *ActiveGatewayEnrollments) getGatewayInfos() []bootstrap.GatewayInfo {
-	a.gatewayInfosLock.Lock()
-	defer a.gatewayInfosLock.Unlock()
-
-	var gatewayInfosToReturn []bootstrap.GatewayInfo
-	gatewayInfosToReturn = append(gatewayInfosToReturn, a.gatewayInfos...)
+func (a *ActiveGatewayEnrollments) getGatewayInfos(ctx context.Context) ([]bootstrap.GatewayInfo, error) {
+	enrollments, err := a.queue.takeAll(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	a.gatewayInfos = nil
+	var gatewayInfos []bootstrap.GatewayInfo
+	for _, enrollment := range enrollments {
+		var gatewayInfo bootstrap.GatewayInfo
+		if err := json.Unmarshal(enrollment.Info, &gatewayInfo); err != nil {
+			return nil, fmt.Errorf("decoding gateway info: %w", err)
+		}
+		gatewayInfos = append(gatewayInfos, gatewayInfo)
+	}
 
-	return gatewayInfosToReturn
+	return gatewayInfos, nil
 }
 
-func (a *ActiveGatewayEnrollments) addGatewayInfo(gatewayInfo bootstrap.GatewayInfo) {
-	a.gatewayInfosLock.Lock()
-	defer a.gatewayInfosLock.Unlock()
-
-	a.gatewayInfos = append(a.gatewayInfos, gatewayInfo)
+func (a *ActiveGatewayEnrollments) addGatewayInfo(ctx context.Context, gatewayInfo bootstrap.GatewayInfo) (*enrollment, error) {
+	return a.queue.enqueue(ctx, gatewayInfo.Name, gatewayInfo.Name, gatewayInfo)
 }
 
-func (a *ActiveGatewayEnrollments) addGatewayConfig(bootstrapGatewayConfig bootstrap.Config, name string) {
-	a.bootstrapGatewayConfigsLock.Lock()
-	defer a.bootstrapGatewayConfigsLock.Unlock()
-
-	a.bootstrapGatewayConfigs[name] = bootstrapGatewayConfig
+func (a *ActiveGatewayEnrollments) addGatewayConfig(ctx context.Context, bootstrapGatewayConfig bootstrap.Config, name string) error {
+	return a.queue.putConfig(ctx, name, "", bootstrapGatewayConfig)
 }
 
-func (a *ActiveGatewayEnrollments) getGatewayConfig(gatewayName string) *bootstrap.Config {
-	a.bootstrapGatewayConfigsLock.Lock()
-	defer a.bootstrapGatewayConfigsLock.Unlock()
-
-	if val, ok := a.bootstrapGatewayConfigs[gatewayName]; ok {
-		delete(a.bootstrapGatewayConfigs, gatewayName)
-		return &val
-	} else {
-		return nil
-	}
+func (a *ActiveGatewayEnrollments) getGatewayConfig(ctx context.Context, gatewayName string) (*bootstrap.Config, error) {
+	return a.queue.config(ctx, gatewayName, gatewayName)
 }
 
 func (api *GatewayApi) authenticated(providedGatewayName, providedToken string) bool {
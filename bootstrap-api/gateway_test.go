@@ -231,7 +231,7 @@ func setup(listener net.Listener, sm bootstrap_api.SecretManager, stop chan stru
 		})
 	}
 
-	api := bootstrap_api.NewApi(c, azureAuthMock, sm)
+	api := bootstrap_api.NewApi(c, azureAuthMock, sm, bootstrap_api.NewMemoryStore(bootstrap_api.DefaultEnrollmentTTL), nil)
 
 	go func() {
 		wg.Add(1)
@@ -0,0 +1,45 @@
+package bootstrap_api
+
+import (
+	"github.com/nais/device/pkg/version"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	EnrollmentsQueued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "enrollments_queued",
+		Help:      "count of enrollments queued for the apiserver, not counting retries",
+		Namespace: "naisdevice",
+		Subsystem: "bootstrap_api",
+	}, []string{"kind"})
+	EnrollmentDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      "enrollment_duration_seconds",
+		Help:      "time from an enrollment is queued until the apiserver acknowledges it",
+		Namespace: "naisdevice",
+		Subsystem: "bootstrap_api",
+		Buckets:   []float64{0.1, 0.5, 1, 2, 5, 10, 30, 60, 300},
+	}, []string{"kind"})
+	ConfigWaitTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "config_wait_timeouts",
+		Help:      "count of requests for a config that timed out waiting for the apiserver",
+		Namespace: "naisdevice",
+		Subsystem: "bootstrap_api",
+	}, []string{"kind"})
+	StoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "store_errors",
+		Help:      "count of requests failed due to enrollment store errors",
+		Namespace: "naisdevice",
+		Subsystem: "bootstrap_api",
+	}, []string{"kind"})
+	failedSecretManagerSynchronizations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "failed_secret_manager_synchronizations",
+		Help:        "count of failed secret manager synchronizations",
+		Namespace:   "naisdevice",
+		Subsystem:   "bootstrap_api",
+		ConstLabels: prometheus.Labels{"name": "bootstrap-api", "version": version.Version},
+	})
+)
+
+func InitializeMetrics() {
+	prometheus.MustRegister(EnrollmentsQueued, EnrollmentDuration, ConfigWaitTimeouts, StoreErrors, failedSecretManagerSynchronizations)
+}
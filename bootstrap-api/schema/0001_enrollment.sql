@@ -0,0 +1,24 @@
+-- Run the entire migration as an atomic operation.
+START TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;
+
+-- Named so that it does not clash with the apiserver's migrations if they share a database.
+CREATE TABLE bootstrap_migrations
+(
+    "version" int primary key          not null,
+    "created" timestamp with time zone not null
+);
+
+-- Enrollments in progress by bucket and key. The table exists if it was created by an earlier bootstrap-api.
+CREATE TABLE IF NOT EXISTS enrollment
+(
+    bucket  varchar                  NOT NULL,
+    key     varchar                  NOT NULL,
+    value   bytea                    NOT NULL,
+    expires timestamp with time zone NOT NULL,
+    PRIMARY KEY (bucket, key)
+);
+
+-- Mark this database migration as completed.
+INSERT INTO bootstrap_migrations (version, created)
+VALUES (1, now());
+COMMIT;
@@ -0,0 +1,167 @@
+package bootstrap_api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultEnrollmentTTL is how long enrollments are kept, both while waiting for the apiserver and for the
+// device or gateway to retrieve its config.
+const DefaultEnrollmentTTL = 1 * time.Hour
+
+const (
+	bucketDeviceInfo          = "deviceinfo"
+	bucketDeviceConfig        = "deviceconfig"
+	bucketDeviceEnrollmentID  = "deviceenrollmentid"
+	bucketGatewayInfo         = "gatewayinfo"
+	bucketGatewayConfig       = "gatewayconfig"
+	bucketGatewayEnrollmentID = "gatewayenrollmentid"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// EnrollmentStore holds enrollments in progress as values by key in separate buckets.
+// Entries expire after the store's TTL, and writing an entry that exists replaces it and renews its TTL,
+// so that enrollment requests can be safely retried.
+type EnrollmentStore interface {
+	Put(ctx context.Context, bucket, key string, value []byte) error
+	// Get returns ErrNotFound if there is no such entry, or it has expired.
+	Get(ctx context.Context, bucket, key string) ([]byte, error)
+	Delete(ctx context.Context, bucket, key string) error
+	// DeleteIf deletes the entry only if it has the given value, and reports whether it did.
+	DeleteIf(ctx context.Context, bucket, key string, value []byte) (bool, error)
+	// List returns all entries in the bucket by key, leaving them in place.
+	List(ctx context.Context, bucket string) (map[string][]byte, error)
+	// TakeAll removes all entries in the bucket and returns them by key.
+	TakeAll(ctx context.Context, bucket string) (map[string][]byte, error)
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// memoryStore is an EnrollmentStore that loses its enrollments on restart, and is not shared between replicas.
+type memoryStore struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	buckets map[string]map[string]memoryEntry
+}
+
+func NewMemoryStore(ttl time.Duration) EnrollmentStore {
+	return &memoryStore{
+		ttl:     ttl,
+		buckets: make(map[string]map[string]memoryEntry),
+	}
+}
+
+func (m *memoryStore) Put(_ context.Context, bucket, key string, value []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.buckets[bucket]; !ok {
+		m.buckets[bucket] = make(map[string]memoryEntry)
+	}
+
+	m.buckets[bucket][key] = memoryEntry{value: value, expires: time.Now().Add(m.ttl)}
+	m.removeExpired(bucket)
+	return nil
+}
+
+func (m *memoryStore) Get(_ context.Context, bucket, key string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	entry, ok := m.buckets[bucket][key]
+	if !ok || !time.Now().Before(entry.expires) {
+		return nil, ErrNotFound
+	}
+
+	return entry.value, nil
+}
+
+func (m *memoryStore) Delete(_ context.Context, bucket, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.buckets[bucket], key)
+	return nil
+}
+
+func (m *memoryStore) DeleteIf(_ context.Context, bucket, key string, value []byte) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	entry, ok := m.buckets[bucket][key]
+	if !ok || !time.Now().Before(entry.expires) || !bytes.Equal(entry.value, value) {
+		return false, nil
+	}
+
+	delete(m.buckets[bucket], key)
+	return true, nil
+}
+
+func (m *memoryStore) List(_ context.Context, bucket string) (map[string][]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.removeExpired(bucket)
+
+	values := make(map[string][]byte)
+	for key, entry := range m.buckets[bucket] {
+		values[key] = entry.value
+	}
+
+	return values, nil
+}
+
+func (m *memoryStore) TakeAll(_ context.Context, bucket string) (map[string][]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.removeExpired(bucket)
+
+	values := make(map[string][]byte)
+	for key, entry := range m.buckets[bucket] {
+		values[key] = entry.value
+	}
+	delete(m.buckets, bucket)
+
+	return values, nil
+}
+
+func (m *memoryStore) removeExpired(bucket string) {
+	now := time.Now()
+	for key, entry := range m.buckets[bucket] {
+		if !now.Before(entry.expires) {
+			delete(m.buckets[bucket], key)
+		}
+	}
+}
+
+func putJSON(ctx context.Context, store EnrollmentStore, bucket, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", bucket, err)
+	}
+
+	return store.Put(ctx, bucket, key, value)
+}
+
+func getJSON(ctx context.Context, store EnrollmentStore, bucket, key string, v interface{}) error {
+	value, err := store.Get(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(value, v); err != nil {
+		return fmt.Errorf("decoding %s: %w", bucket, err)
+	}
+
+	return nil
+}
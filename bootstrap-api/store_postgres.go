@@ -0,0 +1,183 @@
+package bootstrap_api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// postgresStore is an EnrollmentStore that keeps enrollments across restarts, and can be shared between replicas.
+type postgresStore struct {
+	conn *sql.DB
+	ttl  time.Duration
+}
+
+// NewPostgresStore connects to and migrates the database. Expiry is computed by the database,
+// so that replicas with skewed clocks agree on when enrollments expire.
+func NewPostgresStore(ctx context.Context, dsn string, ttl time.Duration) (EnrollmentStore, error) {
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to database: %w", err)
+	}
+
+	if err := migrate(ctx, conn); err != nil {
+		return nil, fmt.Errorf("migrating database: %w", err)
+	}
+
+	return &postgresStore{conn: conn, ttl: ttl}, nil
+}
+
+// migrate runs the migrations in schema/ that have not been run, see schema/README.md.
+func migrate(ctx context.Context, conn *sql.DB) error {
+	var version int
+
+	query := `SELECT MAX(version) FROM bootstrap_migrations`
+	err := conn.QueryRowContext(ctx, query).Scan(&version)
+	if err != nil {
+		// error might be due to no schema.
+		// no way to detect this, so log error and continue with migrations.
+		log.Warnf("unable to get current migration version: %s", err)
+	}
+
+	for version < len(migrations) {
+		log.Infof("migrating database schema to version %d", version+1)
+
+		if _, err := conn.ExecContext(ctx, migrations[version]); err != nil {
+			return fmt.Errorf("migrating to version %d: %w", version+1, err)
+		}
+
+		version++
+	}
+
+	return nil
+}
+
+func (p *postgresStore) Put(ctx context.Context, bucket, key string, value []byte) error {
+	statement := `
+INSERT INTO enrollment (bucket, key, value, expires)
+VALUES ($1, $2, $3, now() + $4 * interval '1 second')
+ON CONFLICT (bucket, key) DO UPDATE SET value = EXCLUDED.value, expires = EXCLUDED.expires;`
+
+	_, err := p.conn.ExecContext(ctx, statement, bucket, key, value, p.ttl.Seconds())
+	if err != nil {
+		return fmt.Errorf("storing enrollment: %w", err)
+	}
+
+	return nil
+}
+
+func (p *postgresStore) Get(ctx context.Context, bucket, key string) ([]byte, error) {
+	query := `
+SELECT value
+FROM enrollment
+WHERE bucket = $1 AND key = $2 AND expires > now();`
+
+	var value []byte
+	err := p.conn.QueryRowContext(ctx, query, bucket, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading enrollment: %w", err)
+	}
+
+	return value, nil
+}
+
+func (p *postgresStore) Delete(ctx context.Context, bucket, key string) error {
+	statement := `
+DELETE FROM enrollment
+WHERE bucket = $1 AND key = $2;`
+
+	_, err := p.conn.ExecContext(ctx, statement, bucket, key)
+	if err != nil {
+		return fmt.Errorf("deleting enrollment: %w", err)
+	}
+
+	return nil
+}
+
+func (p *postgresStore) DeleteIf(ctx context.Context, bucket, key string, value []byte) (bool, error) {
+	statement := `
+DELETE FROM enrollment
+WHERE bucket = $1 AND key = $2 AND value = $3 AND expires > now();`
+
+	result, err := p.conn.ExecContext(ctx, statement, bucket, key, value)
+	if err != nil {
+		return false, fmt.Errorf("deleting enrollment: %w", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("deleting enrollment: %w", err)
+	}
+
+	return deleted == 1, nil
+}
+
+func (p *postgresStore) List(ctx context.Context, bucket string) (map[string][]byte, error) {
+	query := `
+SELECT key, value
+FROM enrollment
+WHERE bucket = $1 AND expires > now();`
+
+	rows, err := p.conn.QueryContext(ctx, query, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("listing enrollments: %w", err)
+	}
+	defer rows.Close()
+
+	values := make(map[string][]byte)
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+		values[key] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading rows: %w", err)
+	}
+
+	return values, nil
+}
+
+// TakeAll also removes expired entries in all buckets, as the apiserver polls for enrollments continuously.
+func (p *postgresStore) TakeAll(ctx context.Context, bucket string) (map[string][]byte, error) {
+	statement := `
+DELETE FROM enrollment
+WHERE bucket = $1 OR expires <= now()
+RETURNING bucket, key, value, expires > now();`
+
+	rows, err := p.conn.QueryContext(ctx, statement, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("taking enrollments: %w", err)
+	}
+	defer rows.Close()
+
+	values := make(map[string][]byte)
+	for rows.Next() {
+		var rowBucket, key string
+		var value []byte
+		var valid bool
+		if err := rows.Scan(&rowBucket, &key, &value, &valid); err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+		if valid && rowBucket == bucket {
+			values[key] = value
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading rows: %w", err)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,130 @@
+package bootstrap_api_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	bootstrap_api "github.com/nais/device/bootstrap-api"
+	"github.com/nais/device/pkg/random"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testEnrollmentStore(t, func(ttl time.Duration) bootstrap_api.EnrollmentStore {
+		return bootstrap_api.NewMemoryStore(ttl)
+	})
+}
+
+func TestPostgresStore(t *testing.T) {
+	if os.Getenv("RUN_INTEGRATION_TESTS") == "" {
+		t.Skip("Skipping integration test")
+	}
+
+	testEnrollmentStore(t, func(ttl time.Duration) bootstrap_api.EnrollmentStore {
+		store, err := bootstrap_api.NewPostgresStore(context.Background(), "user=postgres password=postgres host=localhost port=5433 sslmode=disable", ttl)
+		if err != nil {
+			t.Fatalf("Instantiating store: %v", err)
+		}
+		return store
+	})
+}
+
+func testEnrollmentStore(t *testing.T, newStore func(ttl time.Duration) bootstrap_api.EnrollmentStore) {
+	ctx := context.Background()
+	store := newStore(time.Minute)
+
+	// buckets are unique per run, as the postgres store outlives the test
+	bucket := random.RandomString(10, random.LettersAndNumbers)
+	otherBucket := random.RandomString(10, random.LettersAndNumbers)
+
+	t.Run("get is repeatable", func(t *testing.T) {
+		assert.NoError(t, store.Put(ctx, bucket, "key", []byte("value")))
+
+		for i := 0; i < 2; i++ {
+			value, err := store.Get(ctx, bucket, "key")
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("value"), value)
+		}
+
+		_, err := store.Get(ctx, otherBucket, "key")
+		assert.True(t, errors.Is(err, bootstrap_api.ErrNotFound))
+	})
+
+	t.Run("put replaces entry", func(t *testing.T) {
+		assert.NoError(t, store.Put(ctx, bucket, "key", []byte("value2")))
+
+		value, err := store.Get(ctx, bucket, "key")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value2"), value)
+	})
+
+	t.Run("delete removes entry", func(t *testing.T) {
+		assert.NoError(t, store.Delete(ctx, bucket, "key"))
+		assert.NoError(t, store.Delete(ctx, bucket, "key"), "deleting a missing entry is not an error")
+
+		_, err := store.Get(ctx, bucket, "key")
+		assert.True(t, errors.Is(err, bootstrap_api.ErrNotFound))
+	})
+
+	t.Run("delete if only deletes unchanged entry", func(t *testing.T) {
+		assert.NoError(t, store.Put(ctx, bucket, "key", []byte("value")))
+
+		deleted, err := store.DeleteIf(ctx, bucket, "key", []byte("other"))
+		assert.NoError(t, err)
+		assert.False(t, deleted)
+
+		deleted, err = store.DeleteIf(ctx, bucket, "key", []byte("value"))
+		assert.NoError(t, err)
+		assert.True(t, deleted)
+
+		deleted, err = store.DeleteIf(ctx, bucket, "key", []byte("value"))
+		assert.NoError(t, err)
+		assert.False(t, deleted, "missing entry is not deleted")
+	})
+
+	t.Run("list leaves entries in place", func(t *testing.T) {
+		assert.NoError(t, store.Put(ctx, bucket, "key", []byte("value")))
+
+		for i := 0; i < 2; i++ {
+			values, err := store.List(ctx, bucket)
+			assert.NoError(t, err)
+			assert.Equal(t, map[string][]byte{"key": []byte("value")}, values)
+		}
+
+		assert.NoError(t, store.Delete(ctx, bucket, "key"))
+	})
+
+	t.Run("take all drains bucket", func(t *testing.T) {
+		assert.NoError(t, store.Put(ctx, bucket, "key1", []byte("value1")))
+		assert.NoError(t, store.Put(ctx, bucket, "key2", []byte("value2")))
+		assert.NoError(t, store.Put(ctx, bucket, "key2", []byte("value2")))
+		assert.NoError(t, store.Put(ctx, otherBucket, "key", []byte("value")))
+
+		values, err := store.TakeAll(ctx, bucket)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string][]byte{"key1": []byte("value1"), "key2": []byte("value2")}, values)
+
+		values, err = store.TakeAll(ctx, bucket)
+		assert.NoError(t, err)
+		assert.Empty(t, values)
+
+		_, err = store.Get(ctx, otherBucket, "key")
+		assert.NoError(t, err, "other buckets are left untouched")
+	})
+
+	t.Run("entries expire", func(t *testing.T) {
+		store := newStore(50 * time.Millisecond)
+		assert.NoError(t, store.Put(ctx, bucket, "key", []byte("value")))
+		time.Sleep(100 * time.Millisecond)
+
+		_, err := store.Get(ctx, bucket, "key")
+		assert.True(t, errors.Is(err, bootstrap_api.ErrNotFound))
+
+		values, err := store.TakeAll(ctx, bucket)
+		assert.NoError(t, err)
+		assert.Empty(t, values)
+	})
+}
@@ -0,0 +1,7 @@
+// file generated by go generate
+
+package bootstrap_api
+
+var migrations = []string{
+	"-- Run the entire migration as an atomic operation.\nSTART TRANSACTION ISOLATION LEVEL SERIALIZABLE READ WRITE;\n\n-- Named so that it does not clash with the apiserver's migrations if they share a database.\nCREATE TABLE bootstrap_migrations\n(\n    \"version\" int primary key          not null,\n    \"created\" timestamp with time zone not null\n);\n\n-- Enrollments in progress by bucket and key. The table exists if it was created by an earlier bootstrap-api.\nCREATE TABLE IF NOT EXISTS enrollment\n(\n    bucket  varchar                  NOT NULL,\n    key     varchar                  NOT NULL,\n    value   bytea                    NOT NULL,\n    expires timestamp with time zone NOT NULL,\n    PRIMARY KEY (bucket, key)\n);\n\n-- Mark this database migration as completed.\nINSERT INTO bootstrap_migrations (version, created)\nVALUES (1, now());\nCOMMIT;\n",
+}
@@ -5,35 +5,41 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/nais/device/apiserver/gatewayconfigurer"
 	"github.com/nais/device/apiserver/jita"
+	"github.com/nais/device/pkg/audit"
 	"github.com/nais/device/pkg/basicauth"
 	"github.com/nais/device/pkg/pb"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/nais/device/apiserver/auth"
-	"github.com/nais/device/apiserver/azure/discovery"
-	"github.com/nais/device/apiserver/azure/validate"
 	"github.com/nais/device/apiserver/enroller"
+	"github.com/nais/device/pkg/keycache"
 	"github.com/nais/device/pkg/logger"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/nais/device/apiserver/api"
 	"github.com/nais/device/apiserver/config"
 	"github.com/nais/device/apiserver/database"
+	"github.com/nais/device/apiserver/idp"
 	log "github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
+	"google.golang.org/grpc"
 )
 
 const (
 	gatewayConfigSyncInterval = 1 * time.Minute
+	sessionReapInterval       = 10 * time.Minute
 )
 
 var (
@@ -42,6 +48,7 @@ var (
 
 func init() {
 	flag.StringVar(&cfg.DbConnDSN, "db-connection-dsn", os.Getenv("DB_CONNECTION_DSN"), "database connection DSN")
+	flag.BoolVar(&cfg.InMemoryDatabase, "in-memory-database", cfg.InMemoryDatabase, "Keep all data in memory instead of Postgres, for running locally. Everything is lost on restart")
 	flag.StringVar(&cfg.JitaUsername, "jita-username", os.Getenv("JITA_USERNAME"), "jita username")
 	flag.StringVar(&cfg.JitaPassword, "jita-password", os.Getenv("JITA_PASSWORD"), "jita password")
 	flag.StringVar(&cfg.JitaUrl, "jita-url", os.Getenv("JITA_URL"), "jita URL")
@@ -52,14 +59,31 @@ func init() {
 	flag.StringVar(&cfg.PrometheusTunnelIP, "prometheus-tunnel-ip", cfg.PrometheusTunnelIP, "prometheus tunnel ip")
 	flag.StringVar(&cfg.LogLevel, "log-level", "info", "which log level to output")
 	flag.StringVar(&cfg.BindAddress, "bind-address", cfg.BindAddress, "Bind address")
+	flag.StringVar(&cfg.GRPCBindAddress, "grpc-bind-address", cfg.GRPCBindAddress, "Bind address for gRPC server")
 	flag.StringVar(&cfg.ConfigDir, "config-dir", cfg.ConfigDir, "Path to configuration directory")
 	flag.StringVar(&cfg.Endpoint, "endpoint", cfg.Endpoint, "public endpoint (ip:port)")
 	flag.BoolVar(&cfg.DevMode, "development-mode", cfg.DevMode, "Development mode avoids setting up wireguard and fetching and validating AAD certificates")
 	flag.StringVar(&cfg.Azure.DiscoveryURL, "azure-discovery-url", "", "Azure discovery url")
 	flag.StringVar(&cfg.Azure.ClientID, "azure-client-id", "", "Azure app client id")
 	flag.StringVar(&cfg.Azure.ClientSecret, "azure-client-secret", "", "Azure app client secret")
+	flag.StringVar(&cfg.Azure.TenantID, "azure-tenant-id", cfg.Azure.TenantID, "Azure AD tenant id")
+	flag.StringVar(&cfg.IdentityProvider, "identity-provider", cfg.IdentityProvider, "identity provider users authenticate with, azure or oidc")
+	flag.StringVar(&cfg.OIDC.IssuerURL, "oidc-issuer-url", "", "OpenID Connect issuer url, used for discovery")
+	flag.StringVar(&cfg.OIDC.ClientID, "oidc-client-id", "", "OpenID Connect client id")
+	flag.StringVar(&cfg.OIDC.ClientSecret, "oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "OpenID Connect client secret")
+	flag.StringSliceVar(&cfg.OIDC.Scopes, "oidc-scopes", cfg.OIDC.Scopes, "Comma-separated OpenID Connect scopes to request")
+	flag.StringVar(&cfg.OIDC.UsernameClaim, "oidc-username-claim", idp.DefaultOIDCClaims.Username, "ID token claim holding the username")
+	flag.StringVar(&cfg.OIDC.ObjectIDClaim, "oidc-object-id-claim", idp.DefaultOIDCClaims.ObjectID, "ID token claim holding the unique user id")
+	flag.StringVar(&cfg.OIDC.GroupsClaim, "oidc-groups-claim", idp.DefaultOIDCClaims.Groups, "ID token claim holding the user's groups")
 	flag.StringSliceVar(&cfg.CredentialEntries, "credential-entries", nil, "Comma-separated credentials on format: '<user>:<key>'")
+	flag.StringSliceVar(&cfg.AdminCredentialEntries, "admin-credential-entries", nil, "Comma-separated admin API credentials on format: '<user>:<key>', admin API is disabled if empty")
 	flag.StringVar(&cfg.GatewayConfigBucketName, "gateway-config-bucket-name", "gatewayconfig", "Name of bucket containing gateway config object")
+	flag.StringVar(&cfg.TunnelCIDR, "tunnel-cidr", database.DefaultTunnelCidr, "IPv4 tunnel network, the apiserver has the first address, must match the network routed by devices and gateways")
+	flag.StringVar(&cfg.TunnelIPv6Prefix, "tunnel-ipv6-prefix", database.DefaultTunnelIPv6Prefix, "IPv6 ULA /64 prefix for tunnel addresses, empty to disable IPv6")
+	flag.BoolVar(&cfg.RequireEnrollmentApproval, "enrollment-approval", cfg.RequireEnrollmentApproval, "Require new devices to be approved through the admin API before they are enrolled")
+	flag.StringArrayVar(&cfg.EnrollmentApprovalRules, "enrollment-auto-approve", nil, "Approve device enrollments matching the rule on format 'platform=<platform>,group=<group id>', can be repeated")
+	flag.IntVar(&cfg.MaxDevicesPerUser, "max-devices-per-user", cfg.MaxDevicesPerUser, "Maximum number of devices a user may enroll, unlimited if 0")
+	flag.StringSliceVar(&cfg.AuditSinks, "audit-sinks", cfg.AuditSinks, "Comma-separated audit event sinks: 'database', 'file:<path>' or 'webhook:<url>'")
 	flag.StringVar(&cfg.GatewayConfigBucketObjectName, "gateway-config-bucket-object-name", "gatewayconfig.json", "Name of bucket object containing gateway config JSON")
 
 	flag.Parse()
@@ -74,38 +98,65 @@ func main() {
 	defer cancel()
 
 	api.InitializeMetrics()
+	keycache.InitializeMetrics()
+	enroller.InitializeMetrics()
+	audit.InitializeMetrics()
 	go func() {
 		log.Infof("Prometheus serving metrics at %v", cfg.PrometheusAddr)
 		_ = http.ListenAndServe(cfg.PrometheusAddr, promhttp.Handler())
 	}()
 
-	if err := setupInterface(); err != nil && !cfg.DevMode {
-		log.Fatalf("Setting up WireGuard interface: %v", err)
+	if err := validateIPv6Prefix(cfg.TunnelIPv6Prefix); err != nil {
+		log.Fatalf("Invalid tunnel IPv6 prefix: %v", err)
 	}
 
-	var dbDriver string
+	dbConfig := database.Config{
+		TunnelCIDR:        cfg.TunnelCIDR,
+		TunnelIPv6Prefix:  cfg.TunnelIPv6Prefix,
+		MaxDevicesPerUser: cfg.MaxDevicesPerUser,
+	}
 
-	if cfg.DevMode {
-		dbDriver = "postgres"
-	} else {
-		dbDriver = "cloudsqlpostgres"
+	apiServerIP, err := dbConfig.APIServerIP()
+	if err != nil {
+		log.Fatalf("Invalid tunnel network: %v", err)
 	}
 
-	db, err := database.New(cfg.DbConnDSN, dbDriver)
+	if err := setupInterface(apiServerIP, cfg.TunnelCIDR); err != nil && !cfg.DevMode {
+		log.Fatalf("Setting up WireGuard interface: %v", err)
+	}
+
+	db, err := createRepository(cfg, dbConfig)
 	if err != nil {
 		log.Fatalf("Instantiating database: %s", err)
 	}
 
-	tokenValidator, err := createJWTValidator(cfg)
+	auditLog, err := createAuditLog(db, cfg.AuditSinks)
+	if err != nil {
+		log.Fatalf("Creating audit log: %v", err)
+	}
+	auditDone := make(chan struct{})
+	go func() {
+		auditLog.Run(ctx)
+		close(auditDone)
+	}()
+	go shutdownOnSignal(cancel, auditDone)
+
+	identityProvider, err := createIdentityProvider(ctx, cfg)
 	if err != nil {
-		log.Fatalf("creating JWT validator: %v", err)
+		log.Fatalf("Creating identity provider: %v", err)
 	}
 
-	sessions, err := auth.New(cfg, tokenValidator, db)
+	triggerGatewaySync := make(chan struct{}, 1)
+
+	sessions, err := auth.New(cfg, identityProvider, db, triggerGatewaySync)
 	if err != nil {
 		log.Fatalf("Instantiating sessions: %s", err)
 	}
 
+	sessions.Audit = auditLog
+
+	go sessions.ReapExpiredSessions(ctx, sessionReapInterval)
+
 	privateKey, err := ioutil.ReadFile(cfg.PrivateKeyPath)
 	if err != nil {
 		log.Fatalf("Reading private key: %v", err)
@@ -120,12 +171,22 @@ func main() {
 		parts := strings.Split(cfg.BootstrapApiCredentials, ":")
 		username, password := parts[0], parts[1]
 
+		approvalRules, err := enroller.ParseApprovalRules(cfg.EnrollmentApprovalRules)
+		if err != nil {
+			log.Fatalf("Parsing enrollment approval rules: %v", err)
+		}
+
 		en := enroller.Enroller{
 			Client:             basicauth.Transport{Username: username, Password: password}.Client(),
 			DB:                 db,
 			BootstrapAPIURL:    cfg.BootstrapAPIURL,
 			APIServerPublicKey: string(publicKey),
 			APIServerEndpoint:  cfg.Endpoint,
+			APIServerIP:        apiServerIP,
+			RequireApproval:    cfg.RequireEnrollmentApproval,
+			ApprovalRules:      approvalRules,
+			MaxDevicesPerUser:  cfg.MaxDevicesPerUser,
+			Audit:              auditLog,
 		}
 
 		go en.WatchDeviceEnrollments(ctx)
@@ -133,19 +194,28 @@ func main() {
 	}
 
 	gwc := gatewayconfigurer.GatewayConfigurer{
-		DB:           db,
-		BucketReader: gatewayconfigurer.GoogleBucketReader{BucketName: cfg.GatewayConfigBucketName, BucketObjectName: cfg.GatewayConfigBucketObjectName},
-		SyncInterval: gatewayConfigSyncInterval,
+		DB:                 db,
+		BucketReader:       gatewayconfigurer.GoogleBucketReader{BucketName: cfg.GatewayConfigBucketName, BucketObjectName: cfg.GatewayConfigBucketObjectName},
+		SyncInterval:       gatewayConfigSyncInterval,
+		TriggerGatewaySync: triggerGatewaySync,
+		Audit:              auditLog,
 	}
 
 	go gwc.SyncContinuously(ctx)
 
-	go syncWireguardConfig(cfg.DbConnDSN, dbDriver, string(privateKey), cfg)
+	go syncWireguardConfig(db, string(privateKey), cfg)
+
+	j := jita.New(cfg.JitaUsername, cfg.JitaPassword, cfg.JitaUrl)
+	j.Audit = auditLog
 
 	apiConfig := api.Config{
-		DB:       db,
-		Jita:     jita.New(cfg.JitaUsername, cfg.JitaPassword, cfg.JitaUrl),
-		Sessions: sessions,
+		DB:                 db,
+		Jita:               j,
+		Sessions:           sessions,
+		TriggerGatewaySync: triggerGatewaySync,
+		Audit:              auditLog,
+		GatewayConfigurer:  &gwc,
+		Admissions:         api.NewAdmissions(),
 	}
 
 	apiConfig.APIKeys, err = cfg.Credentials()
@@ -153,6 +223,11 @@ func main() {
 		log.Fatalf("Getting credentials: %v", err)
 	}
 
+	apiConfig.AdminAPIKeys, err = cfg.AdminCredentials()
+	if err != nil {
+		log.Fatalf("Getting admin credentials: %v", err)
+	}
+
 	if !cfg.DevMode {
 		if apiConfig.APIKeys == nil {
 			log.Fatalf("No credentials provided for basic auth")
@@ -161,6 +236,24 @@ func main() {
 
 	router := api.New(apiConfig)
 
+	grpcServer := api.NewGRPCServer(apiConfig)
+	go grpcServer.WatchGatewayConfigTriggers(ctx, triggerGatewaySync)
+
+	grpcListener, err := net.Listen("tcp", cfg.GRPCBindAddress)
+	if err != nil {
+		log.Fatalf("Listening for gRPC connections: %v", err)
+	}
+
+	grpcHandler := grpc.NewServer()
+	pb.RegisterAPIServerServer(grpcHandler, grpcServer)
+
+	go func() {
+		log.Infof("gRPC server running @ %v", cfg.GRPCBindAddress)
+		if err := grpcHandler.Serve(grpcListener); err != nil {
+			log.Fatalf("Serving gRPC: %v", err)
+		}
+	}()
+
 	fmt.Println("running @", cfg.BindAddress)
 	fmt.Println(http.ListenAndServe(cfg.BindAddress, router))
 }
@@ -189,7 +282,14 @@ func generatePublicKey(privateKey []byte, wireGuardPath string) ([]byte, error)
 	return bytes.TrimSuffix(out, []byte("\n")), nil
 }
 
-func setupInterface() error {
+// setupInterface creates the WireGuard interface with the apiserver's address in the tunnel network.
+func setupInterface(apiServerIP, tunnelCIDR string) error {
+	_, network, err := net.ParseCIDR(tunnelCIDR)
+	if err != nil {
+		return err
+	}
+	ones, _ := network.Mask.Size()
+
 	if err := exec.Command("ip", "link", "del", "wg0").Run(); err != nil {
 		log.Infof("Pre-deleting WireGuard interface (ok if this fails): %v", err)
 	}
@@ -209,19 +309,31 @@ func setupInterface() error {
 	commands := [][]string{
 		{"ip", "link", "add", "dev", "wg0", "type", "wireguard"},
 		{"ip", "link", "set", "wg0", "mtu", "1360"},
-		{"ip", "address", "add", "dev", "wg0", "10.255.240.1/21"},
+		{"ip", "address", "add", "dev", "wg0", fmt.Sprintf("%s/%d", apiServerIP, ones)},
 		{"ip", "link", "set", "wg0", "up"},
 	}
 
 	return run(commands)
 }
 
-func syncWireguardConfig(dbConnDSN, driver, privateKey string, conf config.Config) {
-	db, err := database.New(dbConnDSN, driver)
+func validateIPv6Prefix(prefix string) error {
+	if len(prefix) == 0 {
+		return nil
+	}
+
+	ip, ipnet, err := net.ParseCIDR(prefix)
 	if err != nil {
-		log.Fatalf("Instantiating database: %v", err)
+		return err
 	}
 
+	if ones, bits := ipnet.Mask.Size(); ip.To4() != nil || ones != 64 || bits != 128 {
+		return fmt.Errorf("%s is not an IPv6 /64 prefix", prefix)
+	}
+
+	return nil
+}
+
+func syncWireguardConfig(db database.Repository, privateKey string, conf config.Config) {
 	log.Info("Starting config sync")
 	for c := time.Tick(10 * time.Second); ; <-c {
 		log.Debug("Synchronizing configuration")
@@ -268,31 +380,91 @@ PublicKey = %s
 	wgConfig += fmt.Sprintf(peerTemplate, conf.PrometheusTunnelIP, conf.PrometheusPublicKey)
 
 	for _, device := range devices {
+		if device.Disabled {
+			continue
+		}
 		wgConfig += fmt.Sprintf(peerTemplate, device.IP, device.PublicKey)
 	}
 
 	for _, gateway := range gateways {
+		if gateway.Disabled {
+			continue
+		}
 		wgConfig += fmt.Sprintf(peerTemplate, gateway.Ip, gateway.PublicKey)
 	}
 
 	return []byte(wgConfig)
 }
 
-func createJWTValidator(conf config.Config) (jwt.Keyfunc, error) {
+// createRepository connects to and migrates the Postgres database, or creates an in-memory database.
+func createRepository(conf config.Config, dbConfig database.Config) (database.Repository, error) {
+	if conf.InMemoryDatabase {
+		log.Warnf("Using in-memory database, all data is lost when the apiserver stops")
+		return database.NewMemoryDB(dbConfig), nil
+	}
+
+	dbDriver := "cloudsqlpostgres"
+	if conf.DevMode {
+		dbDriver = "postgres"
+	}
+
+	return database.New(conf.DbConnDSN, dbDriver, dbConfig)
+}
+
+func createAuditLog(db database.Repository, specs []string) (*audit.Log, error) {
+	var sinks []audit.Sink
+	for _, spec := range specs {
+		if spec == config.AuditSinkDatabase {
+			sinks = append(sinks, audit.SinkFunc(db.AddAuditEvent))
+			continue
+		}
+
+		sink, err := audit.ParseSink(spec)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return audit.New("apiserver", sinks...), nil
+}
+
+func createIdentityProvider(ctx context.Context, conf config.Config) (idp.Provider, error) {
+	switch conf.IdentityProvider {
+	case config.IdentityProviderAzure:
+		tokenValidator, err := createJWTValidator(ctx, conf)
+		if err != nil {
+			return nil, fmt.Errorf("creating JWT validator: %w", err)
+		}
+		return idp.NewAzure(conf.Azure, tokenValidator), nil
+	case config.IdentityProviderOIDC:
+		if conf.DevMode {
+			return nil, fmt.Errorf("development mode is only supported with the azure identity provider")
+		}
+		return idp.NewOIDC(ctx, conf.OIDC)
+	default:
+		return nil, fmt.Errorf("unknown identity provider: %s", conf.IdentityProvider)
+	}
+}
+
+func createJWTValidator(ctx context.Context, conf config.Config) (jwt.Keyfunc, error) {
 	if conf.DevMode {
 		return func(token *jwt.Token) (interface{}, error) {
 			return []byte("never_used"), nil
 		}, nil
 	}
 
-	if len(conf.Azure.ClientID) == 0 || len(conf.Azure.DiscoveryURL) == 0 {
-		return nil, fmt.Errorf("missing required azure configuration")
-	}
+	return keycache.NewKeyfunc(ctx, conf.Azure.DiscoveryURL, conf.Azure.ClientID)
+}
 
-	certificates, err := discovery.FetchCertificates(conf.Azure)
-	if err != nil {
-		return nil, fmt.Errorf("retrieving azure ad certificates for token validation: %v", err)
-	}
+// shutdownOnSignal cancels the context on SIGINT or SIGTERM, and exits once the audit log has written its queued events.
+func shutdownOnSignal(cancel context.CancelFunc, auditDone <-chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 
-	return validate.JWTValidator(certificates, conf.Azure.ClientID), nil
+	sig := <-signals
+	log.Infof("Received %v, shutting down", sig)
+	cancel()
+	<-auditDone
+	os.Exit(0)
 }
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/nais/device/apiserver/config"
+	"github.com/nais/device/apiserver/idp"
 	"github.com/nais/device/bootstrap-api"
+	"github.com/nais/device/pkg/audit"
+	"github.com/nais/device/pkg/keycache"
 	"github.com/nais/device/pkg/logger"
 	"github.com/nais/device/pkg/secretmanager"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -17,7 +26,9 @@ const SecretSyncInterval = 10 * time.Second
 
 type Config struct {
 	BindAddress            string
-	Azure                  bootstrap_api.Azure
+	IdentityProvider       string
+	Azure                  config.Azure
+	OIDC                   config.OIDC
 	PrometheusAddr         string
 	PrometheusPublicKey    string
 	PrometheusTunnelIP     string
@@ -25,10 +36,14 @@ type Config struct {
 	LogLevel               string
 	SecretManagerProjectID string
 	DevMode                bool
+	EnrollmentStoreDSN     string
+	EnrollmentTTL          time.Duration
+	AuditSinks             []string
 }
 
 var cfg = &Config{
-	Azure: bootstrap_api.Azure{
+	IdentityProvider: config.IdentityProviderAzure,
+	Azure: config.Azure{
 		ClientID:     "",
 		DiscoveryURL: "",
 	},
@@ -36,6 +51,7 @@ var cfg = &Config{
 	BindAddress:       ":8080",
 	PrometheusAddr:    ":3000",
 	LogLevel:          "info",
+	EnrollmentTTL:     bootstrap_api.DefaultEnrollmentTTL,
 }
 
 func init() {
@@ -45,25 +61,37 @@ func init() {
 	flag.StringVar(&cfg.BindAddress, "bind-address", cfg.BindAddress, "Bind address")
 	flag.StringVar(&cfg.Azure.DiscoveryURL, "azure-discovery-url", "", "Azure discovery url")
 	flag.StringVar(&cfg.Azure.ClientID, "azure-client-id", "", "Azure app client id")
+	flag.StringVar(&cfg.IdentityProvider, "identity-provider", cfg.IdentityProvider, "identity provider issuing the tokens devices enroll with, azure or oidc")
+	flag.StringVar(&cfg.OIDC.IssuerURL, "oidc-issuer-url", "", "OpenID Connect issuer url, used for discovery")
+	flag.StringVar(&cfg.OIDC.ClientID, "oidc-client-id", "", "OpenID Connect client id, the audience of the ID tokens")
+	flag.StringVar(&cfg.OIDC.UsernameClaim, "oidc-username-claim", idp.DefaultOIDCClaims.Username, "ID token claim holding the username")
+	flag.StringVar(&cfg.OIDC.ObjectIDClaim, "oidc-object-id-claim", idp.DefaultOIDCClaims.ObjectID, "ID token claim holding the unique user id")
+	flag.StringVar(&cfg.OIDC.GroupsClaim, "oidc-groups-claim", idp.DefaultOIDCClaims.Groups, "ID token claim holding the user's groups")
 	flag.StringVar(&cfg.SecretManagerProjectID, "secret-manager-project-id", "nais-device", "Secret Manager Project ID")
 	flag.StringSliceVar(&cfg.CredentialEntries, "credential-entries", nil, "Comma-separated credentials on format: '<user>:<key>'")
+	flag.StringVar(&cfg.EnrollmentStoreDSN, "enrollment-store-dsn", os.Getenv("ENROLLMENT_STORE_DSN"), "Postgres DSN for storing enrollments in progress, keeps them in memory if empty")
+	flag.DurationVar(&cfg.EnrollmentTTL, "enrollment-ttl", cfg.EnrollmentTTL, "how long enrollments in progress are kept")
+	flag.StringSliceVar(&cfg.AuditSinks, "audit-sinks", nil, "Comma-separated audit event sinks: 'file:<path>' or 'webhook:<url>', such as the apiserver admin API's /admin/audit")
 	flag.BoolVar(&cfg.DevMode, "development-mode", cfg.DevMode, "Development mode avoids setting up wireguard and fetching and validating AAD certificates")
 
 	flag.Parse()
 }
 
 func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	keycache.InitializeMetrics()
+	bootstrap_api.InitializeMetrics()
+	audit.InitializeMetrics()
 	go func() {
 		log.Infof("Prometheus serving metrics at %v", cfg.PrometheusAddr)
 		_ = http.ListenAndServe(cfg.PrometheusAddr, promhttp.Handler())
 	}()
 
-	devMode := true
-	jwtValidator, err := bootstrap_api.CreateJWTValidator(cfg.Azure)
+	identityProvider, err := createIdentityProvider(ctx)
 	if err != nil {
-		if !devMode {
-			log.Fatalf("Creating JWT validator: %v", err)
-		}
+		log.Fatalf("Creating identity provider: %v", err)
 	}
 
 	apiserverCredentials, err := bootstrap_api.Credentials(cfg.CredentialEntries)
@@ -76,9 +104,25 @@ func main() {
 		log.Fatalf("instantiating secret manager: %v", err)
 	}
 
-	tokenValidator := bootstrap_api.TokenValidatorMiddleware(jwtValidator)
+	tokenValidator := bootstrap_api.TokenValidatorMiddleware(identityProvider)
 
-	api := bootstrap_api.NewApi(apiserverCredentials, tokenValidator, sm)
+	store, err := createEnrollmentStore(ctx)
+	if err != nil {
+		log.Fatalf("Creating enrollment store: %v", err)
+	}
+
+	auditLog, err := createAuditLog()
+	if err != nil {
+		log.Fatalf("Creating audit log: %v", err)
+	}
+	auditDone := make(chan struct{})
+	go func() {
+		auditLog.Run(ctx)
+		close(auditDone)
+	}()
+	go shutdownOnSignal(cancel, auditDone)
+
+	api := bootstrap_api.NewApi(apiserverCredentials, tokenValidator, sm, store, auditLog)
 	router := api.Router()
 	stop := make(chan struct{}, 1)
 	go api.SyncEnrollmentSecretsLoop(SecretSyncInterval, stop)
@@ -86,3 +130,55 @@ func main() {
 	log.Info("running @ ", cfg.BindAddress)
 	log.Info(http.ListenAndServe(cfg.BindAddress, router))
 }
+
+func createIdentityProvider(ctx context.Context) (idp.Provider, error) {
+	switch cfg.IdentityProvider {
+	case config.IdentityProviderAzure:
+		devMode := true
+		jwtValidator, err := keycache.NewKeyfunc(ctx, cfg.Azure.DiscoveryURL, cfg.Azure.ClientID)
+		if err != nil {
+			if !devMode {
+				return nil, fmt.Errorf("creating JWT validator: %w", err)
+			}
+		}
+		return idp.NewAzure(cfg.Azure, jwtValidator), nil
+	case config.IdentityProviderOIDC:
+		return idp.NewOIDC(ctx, cfg.OIDC)
+	default:
+		return nil, fmt.Errorf("unknown identity provider: %s", cfg.IdentityProvider)
+	}
+}
+
+func createEnrollmentStore(ctx context.Context) (bootstrap_api.EnrollmentStore, error) {
+	if len(cfg.EnrollmentStoreDSN) == 0 {
+		log.Warnf("No enrollment store DSN configured, enrollments in progress are lost on restart")
+		return bootstrap_api.NewMemoryStore(cfg.EnrollmentTTL), nil
+	}
+
+	return bootstrap_api.NewPostgresStore(ctx, cfg.EnrollmentStoreDSN, cfg.EnrollmentTTL)
+}
+
+func createAuditLog() (*audit.Log, error) {
+	sinks := make([]audit.Sink, 0, len(cfg.AuditSinks))
+	for _, spec := range cfg.AuditSinks {
+		sink, err := audit.ParseSink(spec)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return audit.New("bootstrap-api", sinks...), nil
+}
+
+// shutdownOnSignal cancels the context on SIGINT or SIGTERM, and exits once the audit log has written its queued events.
+func shutdownOnSignal(cancel context.CancelFunc, auditDone <-chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	sig := <-signals
+	log.Infof("Received %v, shutting down", sig)
+	cancel()
+	<-auditDone
+	os.Exit(0)
+}
@@ -31,6 +31,10 @@ func init() {
 	flag.StringVar(&cfg.GrpcAddress, "grpc-address", cfg.GrpcAddress, "unix socket for gRPC server")
 	flag.StringVar(&cfg.DeviceAgentHelperAddress, "device-agent-helper-address", cfg.DeviceAgentHelperAddress, "device-agent-helper unix socket")
 	flag.BoolVar(&cfg.AutoConnect, "connect", false, "auto connect")
+	flag.StringVar(&cfg.OAuth2Config.ClientID, "oauth-client-id", cfg.OAuth2Config.ClientID, "OAuth2 client id used to authenticate with the bootstrap API")
+	flag.StringVar(&cfg.OAuth2Config.Endpoint.AuthURL, "oauth-auth-url", cfg.OAuth2Config.Endpoint.AuthURL, "identity provider authorization endpoint")
+	flag.StringVar(&cfg.OAuth2Config.Endpoint.TokenURL, "oauth-token-url", cfg.OAuth2Config.Endpoint.TokenURL, "identity provider token endpoint")
+	flag.StringSliceVar(&cfg.OAuth2Config.Scopes, "oauth-scopes", cfg.OAuth2Config.Scopes, "Comma-separated OAuth2 scopes to request")
 	flag.Parse()
 	cfg.SetDefaults()
 }
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	g "github.com/nais/device/gateway-agent"
 	"github.com/nais/device/pkg/basicauth"
+	"github.com/nais/device/pkg/gatewayhealth"
+	"github.com/nais/device/pkg/pb"
 	"net/http"
 	"path"
 	"path/filepath"
@@ -14,6 +18,14 @@ import (
 	"github.com/nais/device/pkg/version"
 	log "github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
+	"google.golang.org/grpc"
+)
+
+const (
+	apiServerGRPCPort   = 8099
+	streamRetryInterval = 5 * time.Second
+	restartMin          = 1 * time.Second // time to wait before restarting a stopped service the first time
+	restartMax          = 1 * time.Minute // longest time to wait, also how long a service must run to reset the wait
 )
 
 var (
@@ -24,9 +36,11 @@ func init() {
 	flag.StringVar(&cfg.Name, "name", cfg.Name, "gateway name")
 	flag.StringVar(&cfg.ConfigDir, "config-dir", cfg.ConfigDir, "gateway-agent config directory")
 	flag.StringVar(&cfg.PublicIP, "public-ip", cfg.PublicIP, "public gateway ip")
+	flag.StringVar(&cfg.APIServerGRPCAddress, "api-server-grpc-address", cfg.APIServerGRPCAddress, "apiserver gRPC address, defaults to apiserver tunnel ip on port 8099")
 	flag.StringVar(&cfg.PrometheusAddr, "prometheus-address", cfg.PrometheusAddr, "prometheus listen address")
 	flag.StringVar(&cfg.PrometheusPublicKey, "prometheus-public-key", cfg.PrometheusPublicKey, "prometheus public key")
 	flag.StringVar(&cfg.PrometheusTunnelIP, "prometheus-tunnel-ip", cfg.PrometheusTunnelIP, "prometheus tunnel ip")
+	flag.StringVar(&cfg.HealthCheckAddr, "health-check-address", cfg.HealthCheckAddr, "device health check listen address, defaults to gateway tunnel ip on port 3001")
 	flag.BoolVar(&cfg.DevMode, "development-mode", cfg.DevMode, "development mode avoids setting up interface and configuring WireGuard")
 	flag.StringVar(&cfg.LogLevel, "log-level", "info", "log level")
 	flag.StringVar(&cfg.EnrollmentToken, "enrollment-token", "is not set", "bootstrap-api enrollment token")
@@ -64,8 +78,11 @@ func main() {
 
 	log.Info("starting gateway-agent")
 
+	flowLogger := g.NewFlowLogger()
+	health := g.NewHealthServer(cfg.Name, version.Version)
+
 	if !cfg.DevMode {
-		if err := g.SetupInterface(cfg.BootstrapConfig.DeviceIP); err != nil {
+		if err := g.SetupInterface(cfg.BootstrapConfig.DeviceIP, cfg.BootstrapConfig.DeviceIPv6); err != nil {
 			log.Fatalf("setting up interface: %v", err)
 		}
 		var err error
@@ -74,53 +91,128 @@ func main() {
 			log.Fatalf("setting up iptables %v", err)
 		}
 
+		if len(cfg.BootstrapConfig.DeviceIPv6) > 0 {
+			cfg.IP6Tables, err = iptables.NewWithProtocol(iptables.ProtocolIPv6)
+			if err != nil {
+				log.Fatalf("setting up ip6tables %v", err)
+			}
+		} else {
+			log.Infof("No IPv6 tunnel address in bootstrap config, skipping IPv6 setup")
+		}
+
 		err = g.SetupIptables(cfg)
 		if err != nil {
 			log.Fatalf("Setting up iptables defaults: %v", err)
 		}
+
+		go restartWithBackoff("Flow logging", func() error {
+			return flowLogger.ListenNFLOG(g.FlowLogGroup)
+		})
 	} else {
 		log.Infof("Skipping interface setup")
 	}
 
+	if len(cfg.HealthCheckAddr) == 0 {
+		cfg.HealthCheckAddr = gatewayhealth.Address(cfg.BootstrapConfig.DeviceIP)
+	}
+
+	go restartWithBackoff("Health check server", func() error {
+		return g.ServeHealthChecks(cfg.HealthCheckAddr, health)
+	})
+
+	forwarder := g.NewRouteForwarder(cfg)
 	baseConfig := g.GenerateBaseConfig(cfg)
 
 	if err := g.ActuateWireGuardConfig(baseConfig, cfg.WireGuardConfigPath); err != nil && !cfg.DevMode {
 		log.Fatalf("actuating base config: %v", err)
 	}
-	client := http.Client{Transport: &basicauth.Transport{Username: cfg.Name, Password: cfg.APIServerPassword}}
 
-	for range time.NewTicker(10 * time.Second).C {
-		log.Infof("getting config")
-		gatewayConfig, err := g.GetGatewayConfig(cfg, client)
-		if err != nil {
-			log.Error(err)
-			g.FailedConfigFetches.Inc()
-			continue
-		}
+	if len(cfg.APIServerGRPCAddress) == 0 {
+		cfg.APIServerGRPCAddress = fmt.Sprintf("%s:%d", cfg.BootstrapConfig.APIServerIP, apiServerGRPCPort)
+	}
 
-		g.LastSuccessfulConfigFetch.SetToCurrentTime()
+	log.Infof("connecting to apiserver gRPC endpoint at %s", cfg.APIServerGRPCAddress)
+	conn, err := grpc.Dial(cfg.APIServerGRPCAddress, grpc.WithInsecure())
+	if err != nil {
+		log.Fatalf("connecting to apiserver: %v", err)
+	}
+	defer conn.Close()
 
-		log.Debugf("%+v\n", gatewayConfig)
+	client := pb.NewAPIServerClient(conn)
+	wireGuard := g.NewWireGuard("wg0")
+	gatewayConfigs := make(chan *pb.GatewayConfiguration, 1)
 
-		// skip side-effects for local development
-		if cfg.DevMode {
-			continue
+	go func() {
+		ctx := context.Background()
+		for {
+			err := g.StreamGatewayConfiguration(ctx, cfg, client, gatewayConfigs)
+			log.Errorf("gateway configuration stream: %v", err)
+			g.FailedConfigFetches.Inc()
+			time.Sleep(streamRetryInterval)
 		}
-
-		if c, err := g.ConnectedDeviceCount(); err != nil {
-			log.Errorf("Getting connected device count: %v", err)
-		} else {
-			g.ConnectedDevices.Set(float64(c))
+	}()
+
+	connectedDevicesTicker := time.NewTicker(10 * time.Second)
+
+	for {
+		select {
+		case <-connectedDevicesTicker.C:
+			if cfg.DevMode {
+				continue
+			}
+
+			if c, err := g.ConnectedDeviceCount(); err != nil {
+				log.Errorf("Getting connected device count: %v", err)
+			} else {
+				g.ConnectedDevices.Set(float64(c))
+			}
+
+		case gatewayConfig := <-gatewayConfigs:
+			log.Infof("received new gateway configuration with %d devices", len(gatewayConfig.GetDevices()))
+			log.Debugf("%+v\n", gatewayConfig)
+
+			flowLogger.Update(gatewayConfig)
+			health.Update(gatewayConfig)
+
+			// skip side-effects for local development
+			if cfg.DevMode {
+				continue
+			}
+
+			peers := append(g.StaticPeers(cfg), g.DevicePeers(gatewayConfig.GetDevices())...)
+			diff, err := g.SyncPeers(wireGuard, peers)
+			g.PeersAdded.Add(float64(len(diff.Added)))
+			g.PeersUpdated.Add(float64(len(diff.Updated)))
+			g.PeersRemoved.Add(float64(len(diff.Removed)))
+			if err != nil {
+				log.Errorf("synchronizing WireGuard peers: %v", err)
+			}
+			log.Debugf("WireGuard peers: %d added, %d updated, %d removed", len(diff.Added), len(diff.Updated), len(diff.Removed))
+
+			err = forwarder.ForwardRoutes(gatewayConfig)
+			if err != nil {
+				log.Errorf("forwarding routes: %v", err)
+			}
 		}
+	}
+}
 
-		peerConfig := g.GenerateWireGuardPeers(gatewayConfig.Devices)
-		if err := g.ActuateWireGuardConfig(baseConfig+peerConfig, cfg.WireGuardConfigPath); err != nil {
-			log.Errorf("actuating WireGuard config: %v", err)
+// restartWithBackoff runs the service again whenever it stops, waiting twice as long after each failure in a row.
+func restartWithBackoff(service string, run func() error) {
+	wait := restartMin
+	for {
+		started := time.Now()
+		err := run()
+		if time.Since(started) > restartMax {
+			wait = restartMin
 		}
 
-		err = g.ForwardRoutes(cfg, gatewayConfig.Routes)
-		if err != nil {
-			log.Errorf("forwarding routes: %v", err)
+		log.Errorf("%s stopped, restarting in %v: %v", service, wait, err)
+		time.Sleep(wait)
+
+		wait *= 2
+		if wait > restartMax {
+			wait = restartMax
 		}
 	}
 }
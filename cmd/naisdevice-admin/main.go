@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nais/device/pkg/admin"
+	"github.com/nais/device/pkg/audit"
+	flag "github.com/spf13/pflag"
+)
+
+const usage = `Usage: naisdevice-admin [flags] <resource> <command> [arguments]
+
+Devices:
+  devices list
+  devices get <device id>
+  devices disable|enable <device id>
+  devices revoke <device id>          disable the device and revoke its sessions
+  devices delete <device id>
+  devices rename <device id> <name>
+  devices rekey <device id> <public key>
+  devices transfer <device id> <user> give the device to another user
+  devices transfers <device id>       ownership transfers of the device
+
+Enrollments:
+  enrollments list [state]            state is pending, approved or rejected
+  enrollments approve|reject <enrollment id>
+  enrollments delete <enrollment id>  require approval again on the next enrollment
+
+Sessions:
+  sessions list
+  sessions revoke <device id>         revoke all sessions on the device
+  sessions revoke-user <object id>    revoke all sessions of the user
+  sessions revoke-key <session key>
+
+Gateways:
+  gateways list
+  gateways get <name>
+  gateways admissions <name>          devices the gateway currently admits
+  gateways disable|enable <name>
+  gateways delete <name>
+  gateways rename <name> <new name>
+  gateways rekey <name> <public key>
+
+Audit:
+  audit list [filter...]              filters are action=, actor=, target=, since=<duration
+                                      or RFC 3339 time> and limit=
+
+Flags:
+`
+
+type Config struct {
+	APIServerURL string
+	Username     string
+	Password     string
+	Output       string
+	Timeout      time.Duration
+}
+
+var cfg = Config{
+	APIServerURL: "http://10.255.240.1",
+	Output:       "table",
+	Timeout:      10 * time.Second,
+}
+
+func init() {
+	flag.StringVar(&cfg.APIServerURL, "apiserver-url", envOrDefault("NAISDEVICE_ADMIN_APISERVER_URL", cfg.APIServerURL), "apiserver URL")
+	flag.StringVar(&cfg.Username, "username", os.Getenv("NAISDEVICE_ADMIN_USERNAME"), "admin API username")
+	flag.StringVar(&cfg.Password, "password", os.Getenv("NAISDEVICE_ADMIN_PASSWORD"), "admin API password")
+	flag.StringVarP(&cfg.Output, "output", "o", cfg.Output, "output format, table or json")
+	flag.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "request timeout")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+}
+
+func main() {
+	if cfg.Output != "table" && cfg.Output != "json" {
+		fail(fmt.Errorf("unsupported output format: %s", cfg.Output))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	client := &admin.Client{
+		URL:      cfg.APIServerURL,
+		Username: cfg.Username,
+		Password: cfg.Password,
+	}
+
+	if err := run(ctx, client, flag.Args()); err != nil {
+		fail(err)
+	}
+}
+
+func run(ctx context.Context, client *admin.Client, args []string) error {
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	resource, command, args := args[0], args[1], args[2:]
+
+	switch resource {
+	case "devices", "device":
+		return runDevices(ctx, client, command, args)
+	case "enrollments", "enrollment":
+		return runEnrollments(ctx, client, command, args)
+	case "sessions", "session":
+		return runSessions(ctx, client, command, args)
+	case "gateways", "gateway":
+		return runGateways(ctx, client, command, args)
+	case "audit":
+		return runAudit(ctx, client, command, args)
+	}
+
+	return fmt.Errorf("unknown resource: %s", resource)
+}
+
+func runDevices(ctx context.Context, client *admin.Client, command string, args []string) error {
+	if command == "list" {
+		devices, err := client.Devices(ctx)
+		if err != nil {
+			return err
+		}
+		return printDevices(devices...)
+	}
+
+	if err := expectArgs(command, args, map[string]int{"get": 1, "disable": 1, "enable": 1, "revoke": 1, "delete": 1, "rename": 2, "rekey": 2, "transfer": 2, "transfers": 1}); err != nil {
+		return err
+	}
+
+	deviceID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid device id: %s", args[0])
+	}
+
+	switch command {
+	case "get":
+		device, err := client.Device(ctx, deviceID)
+		if err != nil {
+			return err
+		}
+		return printDevices(*device)
+	case "disable":
+		return client.DisableDevice(ctx, deviceID)
+	case "enable":
+		return client.EnableDevice(ctx, deviceID)
+	case "revoke":
+		if err := client.DisableDevice(ctx, deviceID); err != nil {
+			return err
+		}
+		return client.RevokeSessions(ctx, deviceID)
+	case "delete":
+		return client.DeleteDevice(ctx, deviceID)
+	case "rename":
+		return client.RenameDevice(ctx, deviceID, args[1])
+	case "transfer":
+		return client.TransferDevice(ctx, deviceID, args[1])
+	case "transfers":
+		transfers, err := client.OwnershipTransfers(ctx, deviceID)
+		if err != nil {
+			return err
+		}
+		return printOwnershipTransfers(transfers)
+	default:
+		return client.RekeyDevice(ctx, deviceID, args[1])
+	}
+}
+
+func runEnrollments(ctx context.Context, client *admin.Client, command string, args []string) error {
+	if command == "list" {
+		if len(args) > 1 {
+			return fmt.Errorf("list takes at most 1 argument(s), got %d", len(args))
+		}
+
+		state := ""
+		if len(args) == 1 {
+			state = args[0]
+		}
+
+		enrollments, err := client.Enrollments(ctx, state)
+		if err != nil {
+			return err
+		}
+		return printEnrollments(enrollments)
+	}
+
+	if err := expectArgs(command, args, map[string]int{"approve": 1, "reject": 1, "delete": 1}); err != nil {
+		return err
+	}
+
+	enrollmentID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid enrollment id: %s", args[0])
+	}
+
+	switch command {
+	case "approve":
+		return client.ApproveEnrollment(ctx, enrollmentID)
+	case "reject":
+		return client.RejectEnrollment(ctx, enrollmentID)
+	default:
+		return client.DeleteEnrollment(ctx, enrollmentID)
+	}
+}
+
+func runSessions(ctx context.Context, client *admin.Client, command string, args []string) error {
+	if command == "list" {
+		sessions, err := client.Sessions(ctx)
+		if err != nil {
+			return err
+		}
+		return printSessions(sessions)
+	}
+
+	if err := expectArgs(command, args, map[string]int{"revoke": 1, "revoke-user": 1, "revoke-key": 1}); err != nil {
+		return err
+	}
+
+	switch command {
+	case "revoke-user":
+		return client.RevokeUserSessions(ctx, args[0])
+	case "revoke-key":
+		return client.RevokeSession(ctx, args[0])
+	}
+
+	deviceID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid device id: %s", args[0])
+	}
+
+	return client.RevokeSessions(ctx, deviceID)
+}
+
+func runGateways(ctx context.Context, client *admin.Client, command string, args []string) error {
+	if command == "list" {
+		gateways, err := client.Gateways(ctx)
+		if err != nil {
+			return err
+		}
+		return printGateways(gateways...)
+	}
+
+	if err := expectArgs(command, args, map[string]int{"get": 1, "admissions": 1, "disable": 1, "enable": 1, "delete": 1, "rename": 2, "rekey": 2}); err != nil {
+		return err
+	}
+
+	name := args[0]
+
+	switch command {
+	case "get":
+		gateway, err := client.Gateway(ctx, name)
+		if err != nil {
+			return err
+		}
+		return printGateways(gateway)
+	case "admissions":
+		admissions, err := client.Admissions(ctx, name)
+		if err != nil {
+			return err
+		}
+		return printAdmissions(admissions)
+	case "disable":
+		return client.DisableGateway(ctx, name)
+	case "enable":
+		return client.EnableGateway(ctx, name)
+	case "delete":
+		return client.DeleteGateway(ctx, name)
+	case "rename":
+		return client.RenameGateway(ctx, name, args[1])
+	default:
+		return client.RekeyGateway(ctx, name, args[1])
+	}
+}
+
+func runAudit(ctx context.Context, client *admin.Client, command string, args []string) error {
+	if command != "list" {
+		return fmt.Errorf("unknown command: %s", command)
+	}
+
+	var query audit.Query
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid filter %q, should be on format 'key=value'", arg)
+		}
+
+		key, value := parts[0], parts[1]
+		switch key {
+		case "action":
+			query.Action = value
+		case "actor":
+			query.Actor = value
+		case "target":
+			query.Target = value
+		case "since":
+			since, err := parseSince(value)
+			if err != nil {
+				return err
+			}
+			query.Since = since
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid limit: %s", value)
+			}
+			query.Limit = limit
+		default:
+			return fmt.Errorf("unknown filter: %s", key)
+		}
+	}
+
+	events, err := client.AuditEvents(ctx, query)
+	if err != nil {
+		return err
+	}
+	return printAuditEvents(events)
+}
+
+// parseSince accepts either a duration back in time, such as 24h, or an RFC 3339 time.
+func parseSince(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since %q, should be a duration or an RFC 3339 time", value)
+	}
+
+	return t, nil
+}
+
+// expectArgs checks that the command is known and given the number of arguments it takes.
+func expectArgs(command string, args []string, commands map[string]int) error {
+	n, ok := commands[command]
+	if !ok {
+		return fmt.Errorf("unknown command: %s", command)
+	}
+
+	if len(args) != n {
+		return fmt.Errorf("%s takes %d argument(s), got %d", command, n, len(args))
+	}
+
+	return nil
+}
+
+func envOrDefault(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "naisdevice-admin: %v\n", err)
+	os.Exit(1)
+}
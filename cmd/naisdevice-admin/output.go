@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nais/device/pkg/admin"
+	"github.com/nais/device/pkg/audit"
+	"github.com/nais/device/pkg/pb"
+)
+
+func printDevices(devices ...admin.Device) error {
+	if cfg.Output == "json" {
+		return printJSON(devices)
+	}
+
+	return printTable([]string{"ID", "NAME", "USERNAME", "SERIAL", "PLATFORM", "IP", "HEALTHY", "DISABLED", "KOLIDE LAST SEEN"}, len(devices), func(i int) []interface{} {
+		d := devices[i]
+		return []interface{}{d.ID, d.Name, d.Username, d.Serial, d.Platform, d.IP, d.Healthy != nil && *d.Healthy, d.Disabled, timestamp(d.KolideLastSeen)}
+	})
+}
+
+func printSessions(sessions []admin.Session) error {
+	if cfg.Output == "json" {
+		return printJSON(sessions)
+	}
+
+	return printTable([]string{"DEVICE ID", "USERNAME", "SERIAL", "PLATFORM", "EXPIRES", "GROUPS"}, len(sessions), func(i int) []interface{} {
+		s := sessions[i]
+		return []interface{}{s.DeviceID, s.Username, s.Serial, s.Platform, timestamp(&s.Expiry), len(s.Groups)}
+	})
+}
+
+func printOwnershipTransfers(transfers []admin.OwnershipTransfer) error {
+	if cfg.Output == "json" {
+		return printJSON(transfers)
+	}
+
+	return printTable([]string{"DEVICE ID", "SERIAL", "PLATFORM", "FROM", "TO", "TRANSFERRED BY", "TRANSFERRED"}, len(transfers), func(i int) []interface{} {
+		t := transfers[i]
+		created := t.Created.Unix()
+		return []interface{}{t.DeviceID, t.Serial, t.Platform, t.FromUsername, t.ToUsername, t.TransferredBy, timestamp(&created)}
+	})
+}
+
+func printEnrollments(enrollments []admin.Enrollment) error {
+	if cfg.Output == "json" {
+		return printJSON(enrollments)
+	}
+
+	return printTable([]string{"ID", "USERNAME", "SERIAL", "PLATFORM", "STATE", "CREATED", "DECIDED BY"}, len(enrollments), func(i int) []interface{} {
+		e := enrollments[i]
+		created := e.Created.Unix()
+		return []interface{}{e.ID, e.Username, e.Serial, e.Platform, e.State, timestamp(&created), e.DecidedBy}
+	})
+}
+
+func printAuditEvents(events []audit.Event) error {
+	if cfg.Output == "json" {
+		return printJSON(events)
+	}
+
+	return printTable([]string{"TIME", "COMPONENT", "ACTION", "OUTCOME", "ACTOR", "TARGET", "DETAILS"}, len(events), func(i int) []interface{} {
+		e := events[i]
+		details := make([]string, 0, len(e.Details))
+		for key, value := range e.Details {
+			details = append(details, key+"="+value)
+		}
+		sort.Strings(details)
+		t := e.Time.Unix()
+		return []interface{}{timestamp(&t), e.Component, e.Action, e.Outcome, e.Actor, e.Target, strings.Join(details, " ")}
+	})
+}
+
+func printGateways(gateways ...*pb.Gateway) error {
+	if cfg.Output == "json" {
+		return printJSON(gateways)
+	}
+
+	return printTable([]string{"NAME", "IP", "ENDPOINT", "PUBLIC KEY", "PRIVILEGED", "DISABLED", "ROUTES"}, len(gateways), func(i int) []interface{} {
+		g := gateways[i]
+		return []interface{}{g.GetName(), g.GetIp(), g.GetEndpoint(), g.GetPublicKey(), g.GetRequiresPrivilegedAccess(), g.GetDisabled(), strings.Join(g.GetRoutes(), ",")}
+	})
+}
+
+func printAdmissions(admissions []admin.Admission) error {
+	if cfg.Output == "json" {
+		return printJSON(admissions)
+	}
+
+	return printTable([]string{"DEVICE ID", "USERNAME", "SERIAL", "PLATFORM", "IP", "ROUTES"}, len(admissions), func(i int) []interface{} {
+		a := admissions[i]
+		return []interface{}{a.DeviceID, a.Username, a.Serial, a.Platform, a.IP, strings.Join(a.Routes, ",")}
+	})
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func printTable(header []string, rows int, row func(i int) []interface{}) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	for i := 0; i < rows; i++ {
+		columns := make([]string, 0, len(header))
+		for _, column := range row(i) {
+			columns = append(columns, fmt.Sprint(column))
+		}
+		fmt.Fprintln(w, strings.Join(columns, "\t"))
+	}
+
+	return w.Flush()
+}
+
+func timestamp(unix *int64) string {
+	if unix == nil || *unix == 0 {
+		return "-"
+	}
+	return time.Unix(*unix, 0).Format(time.RFC3339)
+}
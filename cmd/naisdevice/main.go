@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/nais/device/pkg/config"
+	"github.com/nais/device/pkg/pb"
+	flag "github.com/spf13/pflag"
+	"google.golang.org/grpc"
+)
+
+const usage = `Usage: naisdevice [flags] <command>
+
+Commands:
+  connect     log in and connect to the gateways
+  disconnect  log out and disconnect from the gateways
+  status      show the connection state and gateways, --watch follows changes
+  gateways    list the gateways and their health, --watch follows changes
+
+Flags:
+`
+
+type Config struct {
+	GrpcAddress string
+	Output      string
+	Watch       bool
+	Wait        bool
+	Timeout     time.Duration
+}
+
+// ErrUsage is returned by ParseArgs when the flags are invalid or not given exactly one command.
+var ErrUsage = errors.New("expected exactly one command")
+
+func DefaultConfig(configDir string) Config {
+	return Config{
+		GrpcAddress: filepath.Join(configDir, "agent.sock"),
+		Output:      "text",
+		Wait:        true,
+		Timeout:     2 * time.Minute,
+	}
+}
+
+// ParseArgs parses the flags on top of the defaults, and returns the command. Usage is printed to usageOutput when
+// asked for with --help, returning flag.ErrHelp, and when the arguments are invalid.
+func ParseArgs(args []string, defaults Config, usageOutput io.Writer) (Config, string, error) {
+	cfg := defaults
+
+	flags := flag.NewFlagSet("naisdevice", flag.ContinueOnError)
+	flags.SetOutput(usageOutput)
+	flags.StringVar(&cfg.GrpcAddress, "grpc-address", cfg.GrpcAddress, "path to device-agent unix socket")
+	flags.StringVarP(&cfg.Output, "output", "o", cfg.Output, "output format, text or json")
+	flags.BoolVarP(&cfg.Watch, "watch", "w", cfg.Watch, "print the status or gateways again whenever they change")
+	flags.BoolVar(&cfg.Wait, "wait", cfg.Wait, "wait until connected or disconnected")
+	flags.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "how long to wait until connected or disconnected")
+	flags.Usage = func() {
+		fmt.Fprint(usageOutput, usage)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return cfg, "", err
+	} else if err != nil {
+		fmt.Fprintln(usageOutput, err)
+		flags.Usage()
+		return cfg, "", fmt.Errorf("%w: %v", ErrUsage, err)
+	}
+
+	if cfg.Output != "text" && cfg.Output != "json" {
+		return cfg, "", fmt.Errorf("unsupported output format: %s", cfg.Output)
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return cfg, "", ErrUsage
+	}
+
+	return cfg, flags.Arg(0), nil
+}
+
+func main() {
+	configDir, err := config.UserConfigDir()
+	if err != nil {
+		fail(fmt.Errorf("finding configuration directory: %w", err))
+	}
+
+	cfg, command, err := ParseArgs(os.Args[1:], DefaultConfig(configDir), os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case errors.Is(err, ErrUsage):
+		os.Exit(2)
+	case err != nil:
+		fail(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	connection, err := dial(cfg.GrpcAddress)
+	if err != nil {
+		fail(err)
+	}
+	defer connection.Close()
+
+	cli := &CLI{
+		Config: cfg,
+		Client: pb.NewDeviceAgentClient(connection),
+		Out:    os.Stdout,
+	}
+
+	err = cli.Run(ctx, command)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fail(err)
+	}
+}
+
+// dial connects to the device-agent, failing early with a helpful message if it is not running.
+func dial(address string) (*grpc.ClientConn, error) {
+	conn, err := net.Dial("unix", address)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to naisdevice-agent, is it running? %w", err)
+	}
+	conn.Close()
+
+	return grpc.Dial("unix:"+address, grpc.WithInsecure())
+}
+
+// CLI runs commands against the device-agent, printing the results to Out.
+type CLI struct {
+	Config
+	Client pb.DeviceAgentClient
+	Out    io.Writer
+}
+
+func (c *CLI) Run(ctx context.Context, command string) error {
+	switch command {
+	case "connect":
+		return c.changeState(ctx, pb.AgentState_Connected, connectStopped, func(ctx context.Context) error {
+			_, err := c.Client.Login(ctx, &pb.LoginRequest{})
+			return err
+		})
+	case "disconnect":
+		return c.changeState(ctx, pb.AgentState_Disconnected, nil, func(ctx context.Context) error {
+			_, err := c.Client.Logout(ctx, &pb.LogoutRequest{})
+			return err
+		})
+	case "status":
+		return c.watchStatus(ctx, func(status *pb.AgentStatus) (bool, error) {
+			return !c.Watch, c.printStatus(status)
+		})
+	case "gateways", "gateway":
+		return c.watchStatus(ctx, func(status *pb.AgentStatus) (bool, error) {
+			return !c.Watch, c.printGateways(status.GetGateways())
+		})
+	}
+
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+// watchStatus calls handle with the current status of the agent, and then with every change, until handle is done or
+// the context is done. The agent is asked to keep its connections when the stream is closed.
+func (c *CLI) watchStatus(ctx context.Context, handle func(status *pb.AgentStatus) (done bool, err error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.Client.Status(ctx, &pb.AgentStatusRequest{KeepConnectionOnComplete: true})
+	if err != nil {
+		return fmt.Errorf("requesting status from naisdevice-agent: %w", err)
+	}
+
+	for {
+		status, err := stream.Recv()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return fmt.Errorf("receiving status from naisdevice-agent: %w", err)
+		}
+
+		done, err := handle(status)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// connectStopped are the states the agent rests in when it does not get connected, until something else happens.
+var connectStopped = []pb.AgentState{
+	pb.AgentState_Disconnected,
+	pb.AgentState_Unhealthy,
+	pb.AgentState_AuthenticateBackoff,
+	pb.AgentState_AwaitingApproval,
+}
+
+// changeState makes the request unless the agent already is in the wanted state, and then waits for the agent to get
+// there unless told not to. Progress is printed as the agent changes state. Once the request has been made, waiting
+// stops with an error when the agent ends up in one of the stopped states instead.
+func (c *CLI) changeState(ctx context.Context, want pb.AgentState, stopped []pb.AgentState, request func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	requested := false
+	err := c.watchStatus(ctx, func(status *pb.AgentStatus) (bool, error) {
+		if status.GetConnectionState() == want {
+			return true, c.printStatus(status)
+		}
+
+		if requested && hasState(stopped, status.GetConnectionState()) {
+			if len(status.GetReason()) > 0 {
+				return true, fmt.Errorf("naisdevice-agent is %s instead of %s: %s", status.GetConnectionState(), want, status.GetReason())
+			}
+			return true, fmt.Errorf("naisdevice-agent is %s instead of %s", status.GetConnectionState(), want)
+		}
+
+		if requested {
+			if c.Output == "text" {
+				fmt.Fprintln(c.Out, status.ConnectionStateString())
+			}
+			return false, nil
+		}
+
+		requested = true
+		if err := request(ctx); err != nil {
+			return true, fmt.Errorf("requesting %s: %w", want, err)
+		}
+
+		return !c.Wait, nil
+	})
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("naisdevice-agent was not %s within %s", want, c.Timeout)
+	}
+
+	return err
+}
+
+func hasState(states []pb.AgentState, state pb.AgentState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "naisdevice: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,410 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	main "github.com/nais/device/cmd/naisdevice"
+	"github.com/nais/device/pkg/pb"
+	flag "github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeAgent streams the statuses in order, and then blocks until the request is cancelled.
+type fakeAgent struct {
+	statuses  []*pb.AgentStatus
+	statusErr error
+	loginErr  error
+	logins    int
+	logouts   int
+}
+
+func (f *fakeAgent) Status(ctx context.Context, _ *pb.AgentStatusRequest, _ ...grpc.CallOption) (pb.DeviceAgent_StatusClient, error) {
+	if f.statusErr != nil {
+		return nil, f.statusErr
+	}
+	return &fakeStatusStream{ctx: ctx, statuses: f.statuses}, nil
+}
+
+func (f *fakeAgent) ConfigureJITA(context.Context, *pb.ConfigureJITARequest, ...grpc.CallOption) (*pb.ConfigureJITAResponse, error) {
+	return &pb.ConfigureJITAResponse{}, nil
+}
+
+func (f *fakeAgent) Login(context.Context, *pb.LoginRequest, ...grpc.CallOption) (*pb.LoginResponse, error) {
+	f.logins++
+	return &pb.LoginResponse{}, f.loginErr
+}
+
+func (f *fakeAgent) Logout(context.Context, *pb.LogoutRequest, ...grpc.CallOption) (*pb.LogoutResponse, error) {
+	f.logouts++
+	return &pb.LogoutResponse{}, nil
+}
+
+type fakeStatusStream struct {
+	grpc.ClientStream
+	ctx      context.Context
+	statuses []*pb.AgentStatus
+}
+
+func (s *fakeStatusStream) Recv() (*pb.AgentStatus, error) {
+	if len(s.statuses) == 0 {
+		<-s.ctx.Done()
+		return nil, s.ctx.Err()
+	}
+
+	status := s.statuses[0]
+	s.statuses = s.statuses[1:]
+	return status, nil
+}
+
+var connectedSince = time.Date(2021, 3, 4, 10, 11, 12, 0, time.UTC)
+
+func testGateways() []*pb.Gateway {
+	return []*pb.Gateway{
+		{
+			Name:          "gateway-a",
+			Healthy:       true,
+			Ip:            "10.255.240.2",
+			Routes:        []string{"10.0.0.0/24", "10.0.1.0/24"},
+			RoundTripTime: durationpb.New(1500 * time.Microsecond),
+			LastHandshake: timestamppb.New(time.Now().Add(-5 * time.Second)),
+			RxBytes:       2048,
+			TxBytes:       100,
+		},
+		{
+			Name:                     "gateway-b",
+			RequiresPrivilegedAccess: true,
+			Ip:                       "10.255.240.3",
+			LastError:                "no handshake",
+		},
+	}
+}
+
+// trimLines removes the trailing padding tabwriter adds to rows with an empty last column.
+func trimLines(s string) string {
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func cli(agent *fakeAgent, cfg main.Config) (*main.CLI, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	return &main.CLI{Config: cfg, Client: agent, Out: out}, out
+}
+
+func TestParseArgs(t *testing.T) {
+	defaults := main.DefaultConfig("/config")
+
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		config  main.Config
+		command string
+		err     error
+		usage   bool
+	}{
+		{
+			name:    "defaults",
+			args:    []string{"status"},
+			config:  main.Config{GrpcAddress: "/config/agent.sock", Output: "text", Wait: true, Timeout: 2 * time.Minute},
+			command: "status",
+		},
+		{
+			name:    "flags",
+			args:    []string{"-o", "json", "-w", "--wait=false", "--timeout", "10s", "--grpc-address", "/tmp/agent.sock", "gateways"},
+			config:  main.Config{GrpcAddress: "/tmp/agent.sock", Output: "json", Watch: true, Timeout: 10 * time.Second},
+			command: "gateways",
+		},
+		{
+			name:    "flags after the command",
+			args:    []string{"connect", "--wait=false"},
+			config:  main.Config{GrpcAddress: "/config/agent.sock", Output: "text", Timeout: 2 * time.Minute},
+			command: "connect",
+		},
+		{
+			name:  "no command",
+			err:   main.ErrUsage,
+			usage: true,
+		},
+		{
+			name:  "more than one command",
+			args:  []string{"connect", "status"},
+			err:   main.ErrUsage,
+			usage: true,
+		},
+		{
+			name:  "help",
+			args:  []string{"--help"},
+			err:   flag.ErrHelp,
+			usage: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			usage := &bytes.Buffer{}
+			cfg, command, err := main.ParseArgs(tc.args, defaults, usage)
+			assert.True(t, errors.Is(err, tc.err), "%v", err)
+			if tc.err == nil {
+				assert.Equal(t, tc.config, cfg)
+				assert.Equal(t, tc.command, command)
+			}
+			assert.Equal(t, tc.usage, bytes.Contains(usage.Bytes(), []byte("Usage: naisdevice")))
+		})
+	}
+
+	t.Run("unsupported output format", func(t *testing.T) {
+		_, _, err := main.ParseArgs([]string{"-o", "yaml", "status"}, defaults, &bytes.Buffer{})
+		assert.EqualError(t, err, "unsupported output format: yaml")
+	})
+
+	t.Run("unknown flag", func(t *testing.T) {
+		usage := &bytes.Buffer{}
+		_, _, err := main.ParseArgs([]string{"--unknown", "status"}, defaults, usage)
+		assert.True(t, errors.Is(err, main.ErrUsage))
+		assert.Contains(t, usage.String(), "unknown flag: --unknown")
+	})
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, expected := range map[uint64]string{
+		0:                    "0 B",
+		1023:                 "1023 B",
+		1024:                 "1.0 KiB",
+		1536:                 "1.5 KiB",
+		5 * 1024 * 1024:      "5.0 MiB",
+		3 << 30:              "3.0 GiB",
+		1<<40 + 1<<39:        "1.5 TiB",
+		18446744073709551615: "16.0 EiB",
+	} {
+		assert.Equal(t, expected, main.FormatBytes(n), "%d bytes", n)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	status := &pb.AgentStatus{
+		ConnectionState:     pb.AgentState_Connected,
+		ConnectedSince:      timestamppb.New(connectedSince),
+		NewVersionAvailable: true,
+		Gateways:            testGateways(),
+	}
+
+	t.Run("text", func(t *testing.T) {
+		c, out := cli(&fakeAgent{statuses: []*pb.AgentStatus{status}}, main.Config{Output: "text"})
+		assert.NoError(t, c.Run(context.Background(), "status"))
+
+		expected := `State: Connected since 10:11:12
+A new version of naisdevice is available
+Gateways: 1 of 2 healthy
+
+NAME       HEALTHY  PRIVILEGED  IP            RTT    HANDSHAKE  RX       TX     ROUTES                   ERROR
+gateway-a  true     false       10.255.240.2  1.5ms  5s ago     2.0 KiB  100 B  10.0.0.0/24,10.0.1.0/24
+gateway-b  false    true        10.255.240.3  -      never      0 B      0 B                             no handshake
+`
+		assert.Equal(t, expected, trimLines(out.String()))
+	})
+
+	t.Run("text with reason and without gateways", func(t *testing.T) {
+		c, out := cli(&fakeAgent{statuses: []*pb.AgentStatus{{
+			ConnectionState: pb.AgentState_Unhealthy,
+			Reason:          "kolide check failing",
+		}}}, main.Config{Output: "text"})
+		assert.NoError(t, c.Run(context.Background(), "status"))
+		assert.Equal(t, "State: Device is unhealthy; no access to resources\nReason: kolide check failing\n", out.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		c, out := cli(&fakeAgent{statuses: []*pb.AgentStatus{status}}, main.Config{Output: "json"})
+		assert.NoError(t, c.Run(context.Background(), "status"))
+
+		var output struct {
+			State               string
+			Description         string
+			ConnectedSince      *time.Time
+			NewVersionAvailable bool
+			Gateways            []map[string]interface{}
+		}
+		assert.NoError(t, json.Unmarshal(out.Bytes(), &output))
+		assert.Equal(t, "Connected", output.State)
+		assert.Equal(t, "Connected since 10:11:12", output.Description)
+		assert.True(t, connectedSince.Equal(*output.ConnectedSince))
+		assert.True(t, output.NewVersionAvailable)
+		assert.Len(t, output.Gateways, 2)
+		assert.Equal(t, 1.5, output.Gateways[0]["roundTripTimeMillis"])
+		assert.Equal(t, "no handshake", output.Gateways[1]["lastError"])
+		assert.NotContains(t, output.Gateways[1], "lastHandshake")
+	})
+
+	t.Run("json omits connected since unless connected", func(t *testing.T) {
+		c, out := cli(&fakeAgent{statuses: []*pb.AgentStatus{{
+			ConnectionState: pb.AgentState_Disconnected,
+			ConnectedSince:  timestamppb.New(connectedSince),
+		}}}, main.Config{Output: "json"})
+		assert.NoError(t, c.Run(context.Background(), "status"))
+		assert.NotContains(t, out.String(), "connectedSince")
+	})
+
+	t.Run("watch prints every change as a line of json", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		c, out := cli(&fakeAgent{statuses: []*pb.AgentStatus{
+			{ConnectionState: pb.AgentState_Disconnected},
+			{ConnectionState: pb.AgentState_Authenticating},
+		}}, main.Config{Output: "json", Watch: true})
+		assert.True(t, errors.Is(c.Run(ctx, "status"), context.DeadlineExceeded))
+
+		lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+		assert.Len(t, lines, 2)
+		assert.Contains(t, string(lines[1]), `"state":"Authenticating"`)
+	})
+}
+
+func TestGateways(t *testing.T) {
+	status := &pb.AgentStatus{Gateways: testGateways()}
+
+	t.Run("text", func(t *testing.T) {
+		c, out := cli(&fakeAgent{statuses: []*pb.AgentStatus{status}}, main.Config{Output: "text"})
+		assert.NoError(t, c.Run(context.Background(), "gateways"))
+
+		lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+		assert.Len(t, lines, 3)
+		assert.True(t, bytes.HasPrefix(lines[0], []byte("NAME ")))
+		assert.True(t, bytes.HasPrefix(lines[1], []byte("gateway-a ")))
+		assert.True(t, bytes.HasPrefix(lines[2], []byte("gateway-b ")))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		c, out := cli(&fakeAgent{statuses: []*pb.AgentStatus{status}}, main.Config{Output: "json"})
+		assert.NoError(t, c.Run(context.Background(), "gateway"))
+
+		var gateways []struct {
+			Name                     string
+			Healthy                  bool
+			RequiresPrivilegedAccess bool
+			IP                       string
+			Routes                   []string
+			RxBytes                  uint64
+		}
+		assert.NoError(t, json.Unmarshal(out.Bytes(), &gateways))
+		assert.Len(t, gateways, 2)
+		assert.Equal(t, "gateway-a", gateways[0].Name)
+		assert.True(t, gateways[0].Healthy)
+		assert.Equal(t, []string{"10.0.0.0/24", "10.0.1.0/24"}, gateways[0].Routes)
+		assert.Equal(t, uint64(2048), gateways[0].RxBytes)
+		assert.True(t, gateways[1].RequiresPrivilegedAccess)
+	})
+
+	t.Run("json without gateways is an empty list", func(t *testing.T) {
+		c, out := cli(&fakeAgent{statuses: []*pb.AgentStatus{{}}}, main.Config{Output: "json"})
+		assert.NoError(t, c.Run(context.Background(), "gateways"))
+		assert.Equal(t, "[]\n", out.String())
+	})
+}
+
+func TestConnect(t *testing.T) {
+	connected := &pb.AgentStatus{ConnectionState: pb.AgentState_Connected, ConnectedSince: timestamppb.New(connectedSince)}
+
+	t.Run("waits for the agent to connect, printing progress", func(t *testing.T) {
+		agent := &fakeAgent{statuses: []*pb.AgentStatus{
+			{ConnectionState: pb.AgentState_Disconnected},
+			{ConnectionState: pb.AgentState_Authenticating},
+			connected,
+		}}
+		c, out := cli(agent, main.Config{Output: "text", Wait: true, Timeout: time.Second})
+		assert.NoError(t, c.Run(context.Background(), "connect"))
+		assert.Equal(t, 1, agent.logins)
+		assert.Equal(t, "Authenticating...\nState: Connected since 10:11:12\n", out.String())
+	})
+
+	t.Run("already connected", func(t *testing.T) {
+		agent := &fakeAgent{statuses: []*pb.AgentStatus{connected}}
+		c, _ := cli(agent, main.Config{Output: "text", Wait: true, Timeout: time.Second})
+		assert.NoError(t, c.Run(context.Background(), "connect"))
+		assert.Equal(t, 0, agent.logins)
+	})
+
+	t.Run("without waiting", func(t *testing.T) {
+		agent := &fakeAgent{statuses: []*pb.AgentStatus{{ConnectionState: pb.AgentState_Disconnected}}}
+		c, out := cli(agent, main.Config{Output: "text", Timeout: time.Second})
+		assert.NoError(t, c.Run(context.Background(), "connect"))
+		assert.Equal(t, 1, agent.logins)
+		assert.Empty(t, out.String())
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		agent := &fakeAgent{statuses: []*pb.AgentStatus{connected, {ConnectionState: pb.AgentState_Disconnected}}}
+		c, _ := cli(agent, main.Config{Output: "text", Wait: true, Timeout: time.Second})
+		assert.NoError(t, c.Run(context.Background(), "disconnect"))
+		assert.Equal(t, 1, agent.logouts)
+	})
+}
+
+func TestRunErrors(t *testing.T) {
+	disconnected := []*pb.AgentStatus{{ConnectionState: pb.AgentState_Disconnected}}
+
+	for _, tc := range []struct {
+		name     string
+		agent    *fakeAgent
+		command  string
+		expected string
+	}{
+		{
+			name:     "unknown command",
+			agent:    &fakeAgent{},
+			command:  "reconnect",
+			expected: "unknown command: reconnect",
+		},
+		{
+			name:     "agent unavailable",
+			agent:    &fakeAgent{statusErr: errors.New("connection refused")},
+			command:  "status",
+			expected: "requesting status from naisdevice-agent: connection refused",
+		},
+		{
+			name:     "login fails",
+			agent:    &fakeAgent{statuses: disconnected, loginErr: errors.New("already authenticating")},
+			command:  "connect",
+			expected: "requesting Connected: already authenticating",
+		},
+		{
+			name: "agent ends up unhealthy",
+			agent: &fakeAgent{statuses: []*pb.AgentStatus{
+				{ConnectionState: pb.AgentState_Disconnected},
+				{ConnectionState: pb.AgentState_Authenticating},
+				{ConnectionState: pb.AgentState_Unhealthy, Reason: "device not healthy"},
+			}},
+			command:  "connect",
+			expected: "naisdevice-agent is Unhealthy instead of Connected: device not healthy",
+		},
+		{
+			name: "agent falls back to disconnected",
+			agent: &fakeAgent{statuses: []*pb.AgentStatus{
+				{ConnectionState: pb.AgentState_Disconnected},
+				{ConnectionState: pb.AgentState_Bootstrapping},
+				{ConnectionState: pb.AgentState_Disconnected},
+			}},
+			command:  "connect",
+			expected: "naisdevice-agent is Disconnected instead of Connected",
+		},
+		{
+			name:     "not connected in time",
+			agent:    &fakeAgent{statuses: disconnected},
+			command:  "connect",
+			expected: "naisdevice-agent was not Connected within 10ms",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := cli(tc.agent, main.Config{Output: "text", Wait: true, Timeout: 10 * time.Millisecond})
+			assert.EqualError(t, c.Run(context.Background(), tc.command), tc.expected)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nais/device/pkg/pb"
+)
+
+// statusOutput is the JSON representation of the agent status.
+type statusOutput struct {
+	State               string          `json:"state"`
+	Description         string          `json:"description"`
+	Reason              string          `json:"reason,omitempty"`
+	ConnectedSince      *time.Time      `json:"connectedSince,omitempty"`
+	NewVersionAvailable bool            `json:"newVersionAvailable"`
+	Gateways            []gatewayOutput `json:"gateways"`
+}
+
+type gatewayOutput struct {
+	Name                     string   `json:"name"`
+	Healthy                  bool     `json:"healthy"`
+	RequiresPrivilegedAccess bool     `json:"requiresPrivilegedAccess"`
+	IP                       string   `json:"ip"`
+	IPv6                     string   `json:"ipv6,omitempty"`
+	Routes                   []string `json:"routes"`
+
+	RoundTripTimeMillis float64    `json:"roundTripTimeMillis,omitempty"`
+	LastHandshake       *time.Time `json:"lastHandshake,omitempty"`
+	RxBytes             uint64     `json:"rxBytes"`
+	TxBytes             uint64     `json:"txBytes"`
+	LastError           string     `json:"lastError,omitempty"`
+}
+
+func toGatewayOutputs(gateways []*pb.Gateway) []gatewayOutput {
+	outputs := make([]gatewayOutput, 0, len(gateways))
+	for _, g := range gateways {
+		output := gatewayOutput{
+			Name:                     g.GetName(),
+			Healthy:                  g.GetHealthy(),
+			RequiresPrivilegedAccess: g.GetRequiresPrivilegedAccess(),
+			IP:                       g.GetIp(),
+			IPv6:                     g.GetIpv6(),
+			Routes:                   g.GetRoutes(),
+			RxBytes:                  g.GetRxBytes(),
+			TxBytes:                  g.GetTxBytes(),
+			LastError:                g.GetLastError(),
+		}
+		if g.GetRoundTripTime() != nil {
+			output.RoundTripTimeMillis = float64(g.GetRoundTripTime().AsDuration()) / float64(time.Millisecond)
+		}
+		if g.GetLastHandshake() != nil {
+			lastHandshake := g.GetLastHandshake().AsTime()
+			output.LastHandshake = &lastHandshake
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs
+}
+
+func (c *CLI) printStatus(status *pb.AgentStatus) error {
+	if c.Output == "json" {
+		output := statusOutput{
+			State:               status.GetConnectionState().String(),
+			Description:         status.ConnectionStateString(),
+			Reason:              status.GetReason(),
+			NewVersionAvailable: status.GetNewVersionAvailable(),
+			Gateways:            toGatewayOutputs(status.GetGateways()),
+		}
+		if status.GetConnectionState() == pb.AgentState_Connected && status.GetConnectedSince() != nil {
+			connectedSince := status.GetConnectedSince().AsTime()
+			output.ConnectedSince = &connectedSince
+		}
+		return c.printJSON(output)
+	}
+
+	if c.Watch {
+		fmt.Fprintf(c.Out, "\n%s\n", time.Now().Format(time.RFC3339))
+	}
+
+	fmt.Fprintf(c.Out, "State: %s\n", status.ConnectionStateString())
+	if status.GetReason() != "" {
+		fmt.Fprintf(c.Out, "Reason: %s\n", status.GetReason())
+	}
+	if status.GetNewVersionAvailable() {
+		fmt.Fprintln(c.Out, "A new version of naisdevice is available")
+	}
+
+	if len(status.GetGateways()) == 0 {
+		return nil
+	}
+
+	healthy := 0
+	for _, g := range status.GetGateways() {
+		if g.GetHealthy() {
+			healthy++
+		}
+	}
+	fmt.Fprintf(c.Out, "Gateways: %d of %d healthy\n\n", healthy, len(status.GetGateways()))
+
+	return c.printGatewayTable(status.GetGateways())
+}
+
+func (c *CLI) printGateways(gateways []*pb.Gateway) error {
+	if c.Output == "json" {
+		return c.printJSON(toGatewayOutputs(gateways))
+	}
+
+	if c.Watch {
+		fmt.Fprintf(c.Out, "\n%s\n", time.Now().Format(time.RFC3339))
+	}
+
+	return c.printGatewayTable(gateways)
+}
+
+func (c *CLI) printGatewayTable(gateways []*pb.Gateway) error {
+	now := time.Now()
+	return c.printTable([]string{"NAME", "HEALTHY", "PRIVILEGED", "IP", "RTT", "HANDSHAKE", "RX", "TX", "ROUTES", "ERROR"}, len(gateways), func(i int) []interface{} {
+		g := gateways[i]
+
+		rtt := "-"
+		if g.GetRoundTripTime() != nil {
+			rtt = g.GetRoundTripTime().AsDuration().Round(100 * time.Microsecond).String()
+		}
+
+		handshake := "never"
+		if g.GetLastHandshake() != nil {
+			handshake = now.Sub(g.GetLastHandshake().AsTime()).Round(time.Second).String() + " ago"
+		}
+
+		return []interface{}{
+			g.GetName(),
+			g.GetHealthy(),
+			g.GetRequiresPrivilegedAccess(),
+			g.GetIp(),
+			rtt,
+			handshake,
+			FormatBytes(g.GetRxBytes()),
+			FormatBytes(g.GetTxBytes()),
+			strings.Join(g.GetRoutes(), ","),
+			g.GetLastError(),
+		}
+	})
+}
+
+// FormatBytes formats a byte count with binary prefixes, such as 1.5 MiB.
+func FormatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// printJSON prints indented JSON, or one object per line when watching, so that each change can be parsed as it comes.
+func (c *CLI) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(c.Out)
+	if !c.Watch {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder.Encode(v)
+}
+
+func (c *CLI) printTable(header []string, rows int, row func(i int) []interface{}) error {
+	w := tabwriter.NewWriter(c.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	for i := 0; i < rows; i++ {
+		columns := make([]string, 0, len(header))
+		for _, column := range row(i) {
+			columns = append(columns, fmt.Sprint(column))
+		}
+		fmt.Fprintln(w, strings.Join(columns, "\t"))
+	}
+
+	return w.Flush()
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/nais/device/pkg/pb"
@@ -45,7 +48,9 @@ func GetDeviceConfig(sessionKey, apiServerURL string, ctx context.Context) ([]*p
 	}
 
 	if resp.StatusCode == http.StatusForbidden {
-		return nil, fmt.Errorf("http response %v: %w", http.StatusText(resp.StatusCode), &UnhealthyError{})
+		// the apiserver tells why the device is not allowed access
+		reason, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%w: %s", &UnhealthyError{}, strings.TrimSpace(string(reason)))
 	}
 
 	var gateways []*pb.Gateway
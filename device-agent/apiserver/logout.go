@@ -0,0 +1,38 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Logout revokes the session on the apiserver, so that it can not be used again.
+func Logout(sessionKey, apiServerURL string, ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	logoutAPI := fmt.Sprintf("%s/logout", apiServerURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, logoutAPI, nil)
+	if err != nil {
+		return fmt.Errorf("creating post request: %w", err)
+	}
+	req.Header.Add("x-naisdevice-session-key", sessionKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("logging out: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("session already expired or revoked: %w", &UnauthorizedError{})
+	}
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("logging out: http response %v", http.StatusText(resp.StatusCode))
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nais/device/device-agent/auth"
+)
+
+// RenewSession extends the session on the apiserver, and returns it with its new expiry.
+func RenewSession(sessionKey, apiServerURL string, ctx context.Context) (*auth.SessionInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	renewAPI := fmt.Sprintf("%s/renew", apiServerURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, renewAPI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating post request: %w", err)
+	}
+	req.Header.Add("x-naisdevice-session-key", sessionKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("renewing session: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("session can not be renewed: %w", &UnauthorizedError{})
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("renewing session: http response %v", http.StatusText(resp.StatusCode))
+	}
+
+	var sessionInfo auth.SessionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&sessionInfo); err != nil {
+		return nil, fmt.Errorf("unmarshalling response body: %w", err)
+	}
+
+	return &sessionInfo, nil
+}
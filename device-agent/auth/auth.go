@@ -15,6 +15,7 @@ import (
 	"github.com/nais/device/device-agent/open"
 
 	"github.com/nais/device/apiserver/kekw"
+	"github.com/nais/device/pkg/session"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -25,12 +26,18 @@ type SessionInfo struct {
 
 const GetAuthURLMaxAttempts = 10
 
+// Expired reports whether the session has expired, or is missing. See session.Expired.
 func (si *SessionInfo) Expired() bool {
 	if si == nil {
 		return true
 	}
 
-	return time.Unix(si.Expiry, 0).Before(time.Now())
+	return session.Expired(si.Expiry, time.Now())
+}
+
+// NeedsRenewal reports whether the session should be renewed with the apiserver before it expires.
+func (si *SessionInfo) NeedsRenewal() bool {
+	return si != nil && session.NeedsRenewal(si.Expiry, time.Now())
 }
 
 func EnsureAuth(existing *SessionInfo, ctx context.Context, apiserverURL, platform, serial string) (*SessionInfo, error) {
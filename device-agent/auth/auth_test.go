@@ -28,6 +28,17 @@ func TestSessionInfo_Expired(t *testing.T) {
 	assert.False(t, valid.Expired())
 }
 
+func TestSessionInfo_NeedsRenewal(t *testing.T) {
+	var missing *auth.SessionInfo
+	assert.False(t, missing.NeedsRenewal())
+
+	expiring := auth.SessionInfo{Expiry: time.Now().Add(time.Minute).Unix()}
+	assert.True(t, expiring.NeedsRenewal())
+
+	fresh := auth.SessionInfo{Expiry: time.Now().Add(10 * time.Hour).Unix()}
+	assert.False(t, fresh.NeedsRenewal())
+}
+
 func TestRunFlow(t *testing.T) {
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
@@ -22,7 +22,8 @@ func AzureAuthenticatedClient(ctx context.Context, conf oauth2.Config) (*http.Cl
 		return nil, fmt.Errorf("running authorization code flow: %w", err)
 	}
 
-	return conf.Client(ctx, token), nil
+	// the client outlives the authorization code flow, so it must not refresh its token using the flow's context
+	return conf.Client(context.Background(), token), nil
 }
 
 func runAuthFlow(ctx context.Context, conf oauth2.Config) (*oauth2.Token, error) {
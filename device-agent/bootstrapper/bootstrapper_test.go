@@ -2,6 +2,7 @@ package bootstrapper_test
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -60,3 +61,32 @@ func TestBootstrapDevice(t *testing.T) {
 	assert.Equal(t, endpoint, bootstrapConfig.TunnelEndpoint)
 	assert.Equal(t, apiserverIP, bootstrapConfig.APIServerIP)
 }
+
+func TestBootstrapDeviceApproval(t *testing.T) {
+	for _, tc := range []struct {
+		status       int
+		err          error
+		enrollStatus bootstrap.EnrollmentStatus
+	}{
+		{http.StatusAccepted, bootstrapper.ErrAwaitingApproval, bootstrap.EnrollmentStatus{State: bootstrap.EnrollmentPendingApproval}},
+		{http.StatusForbidden, bootstrapper.ErrEnrollmentRejected, bootstrap.EnrollmentStatus{State: bootstrap.EnrollmentRejected}},
+		{http.StatusForbidden, bootstrapper.ErrEnrollmentRejected, bootstrap.EnrollmentStatus{State: bootstrap.EnrollmentRejected, Reason: "enrolled by another user"}},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.RequestURI == "/api/v2/device/info" && r.Method == http.MethodPost:
+				w.WriteHeader(http.StatusCreated)
+			case strings.HasPrefix(r.RequestURI, "/api/v2/device/config/") && r.Method == http.MethodGet:
+				w.WriteHeader(tc.status)
+				json.NewEncoder(w).Encode(tc.enrollStatus)
+			default:
+				t.Fatalf("unexpected method on URI: %v %v", r.Method, r.RequestURI)
+			}
+		}))
+
+		_, err := bootstrapper.BootstrapDevice(&bootstrap.DeviceInfo{Serial: "serial"}, server.URL, server.Client())
+		assert.True(t, errors.Is(err, tc.err), "status %d: %v", tc.status, err)
+		assert.Contains(t, err.Error(), tc.enrollStatus.Reason)
+		server.Close()
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"path/filepath"
 
+	"github.com/nais/device/pkg/azure"
 	"github.com/nais/device/pkg/config"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
@@ -20,6 +21,7 @@ type Config struct {
 	WireGuardConfigPath      string
 	BootstrapConfigPath      string
 	SerialPath               string
+	SessionPath              string
 	LogLevel                 string
 	LogFilePath              string
 	OAuth2Config             oauth2.Config
@@ -37,6 +39,7 @@ func (c *Config) SetDefaults() {
 	c.WireGuardConfigPath = filepath.Join(c.ConfigDir, c.Interface+".conf")
 	c.BootstrapConfigPath = filepath.Join(c.ConfigDir, "bootstrapconfig.json")
 	c.SerialPath = filepath.Join(c.ConfigDir, "product_serial")
+	c.SessionPath = filepath.Join(c.ConfigDir, "session")
 }
 
 func DefaultConfig() Config {
@@ -55,7 +58,7 @@ func DefaultConfig() Config {
 		OAuth2Config: oauth2.Config{
 			ClientID:    "8086d321-c6d3-4398-87da-0d54e3d93967",
 			Scopes:      []string{"openid", "6e45010d-2637-4a40-b91d-d4cbb451fb57/.default", "offline_access"},
-			Endpoint:    endpoints.AzureAD("62366534-1ec3-4962-8869-9b5535279d0b"),
+			Endpoint:    endpoints.AzureAD(azure.TenantID),
 			RedirectURL: "http://localhost:51800",
 		},
 	}
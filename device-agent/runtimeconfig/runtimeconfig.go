@@ -3,8 +3,10 @@ package runtimeconfig
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 
 	"github.com/nais/device/device-agent/bootstrapper"
 	"github.com/nais/device/pkg/bootstrap"
@@ -13,6 +15,7 @@ import (
 	"github.com/nais/device/device-agent/auth"
 	"github.com/nais/device/device-agent/config"
 	"github.com/nais/device/device-agent/serial"
+	"github.com/nais/device/device-agent/sessionstore"
 	"github.com/nais/device/device-agent/wireguard"
 )
 
@@ -22,6 +25,13 @@ type RuntimeConfig struct {
 	Config          config.Config
 	PrivateKey      []byte
 	SessionInfo     *auth.SessionInfo
+	SessionStore    sessionstore.Store
+
+	// SessionRestored is set when SessionInfo was read from disk, and has not yet been validated with the apiserver.
+	SessionRestored bool
+
+	// bootstrapClient is kept while the enrollment awaits approval, so the user only logs in once.
+	bootstrapClient *http.Client
 }
 
 func New(cfg config.Config) (*RuntimeConfig, error) {
@@ -46,16 +56,60 @@ func New(cfg config.Config) (*RuntimeConfig, error) {
 		log.Infof("Read bootstrap config from file: %v", rc.Config.BootstrapConfigPath)
 	}
 
+	if rc.SessionStore, err = sessionstore.NewEncryptedFile(rc.Config.SessionPath, rc.PrivateKey); err != nil {
+		return nil, fmt.Errorf("creating session store: %w", err)
+	}
+
+	rc.restoreSession()
+
 	log.Infof("Runtime config initialized with public key: %s", wireguard.PublicKey(rc.PrivateKey))
 
 	return rc, nil
 }
 
+// SetSessionInfo replaces the session, and stores it so that it can be reused if the agent is restarted.
+func (rc *RuntimeConfig) SetSessionInfo(si *auth.SessionInfo) {
+	rc.SessionInfo = si
+	rc.SessionRestored = false
+
+	var err error
+	if si == nil {
+		err = rc.SessionStore.Remove()
+	} else {
+		err = rc.SessionStore.Save(si)
+	}
+
+	if err != nil {
+		log.Warnf("Storing session: %v", err)
+	}
+}
+
+func (rc *RuntimeConfig) restoreSession() {
+	si, err := rc.SessionStore.Load()
+	switch {
+	case err != nil:
+		log.Warnf("Unable to read stored session, discarding it: %v", err)
+		rc.SetSessionInfo(nil)
+	case si == nil:
+		return
+	case si.Expired():
+		log.Infof("Stored session has expired")
+		rc.SetSessionInfo(nil)
+	default:
+		log.Infof("Read stored session from file: %v", rc.Config.SessionPath)
+		rc.SessionInfo = si
+		rc.SessionRestored = true
+	}
+}
+
 func EnsureBootstrapping(rc *RuntimeConfig, ctx context.Context) (*bootstrap.Config, error) {
 	log.Infoln("Bootstrapping device")
-	client, err := auth.AzureAuthenticatedClient(ctx, rc.Config.OAuth2Config)
-	if err != nil {
-		return nil, fmt.Errorf("authenticating with Azure: %w", err)
+	if rc.bootstrapClient == nil {
+		client, err := auth.AzureAuthenticatedClient(ctx, rc.Config.OAuth2Config)
+		if err != nil {
+			return nil, fmt.Errorf("authenticating with Azure: %w", err)
+		}
+		rc.bootstrapClient = client
 	}
 
 	cfg, err := bootstrapper.BootstrapDevice(
@@ -65,9 +119,14 @@ func EnsureBootstrapping(rc *RuntimeConfig, ctx context.Context) (*bootstrap.Con
 			Platform:  rc.Config.Platform,
 		},
 		rc.Config.BootstrapAPI,
-		client,
+		rc.bootstrapClient,
 	)
 
+	if errors.Is(err, bootstrapper.ErrAwaitingApproval) {
+		return nil, err
+	}
+
+	rc.bootstrapClient = nil
 	if err != nil {
 		return nil, err
 	}
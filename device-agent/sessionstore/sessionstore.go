@@ -0,0 +1,128 @@
+package sessionstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/nais/device/device-agent/auth"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Store persists the session of the device-agent, so that the user does not have to log in again when it restarts.
+type Store interface {
+	// Load returns the stored session, or nil if there is none.
+	Load() (*auth.SessionInfo, error)
+	Save(si *auth.SessionInfo) error
+	Remove() error
+}
+
+const keyInfo = "naisdevice session"
+
+type encryptedFile struct {
+	path string
+	aead cipher.AEAD
+}
+
+// NewEncryptedFile returns a Store keeping the session in a file only readable by the user, encrypted with AES-GCM
+// using a key derived from the WireGuard private key. A session stored with another private key can not be loaded.
+func NewEncryptedFile(path string, privateKey []byte) (Store, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, privateKey, nil, []byte(keyInfo)), key); err != nil {
+		return nil, fmt.Errorf("deriving session key: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	return &encryptedFile{
+		path: path,
+		aead: aead,
+	}, nil
+}
+
+func (f *encryptedFile) Load() (*auth.SessionInfo, error) {
+	b, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading session from disk: %w", err)
+	}
+
+	nonceSize := f.aead.NonceSize()
+	if len(b) < nonceSize {
+		return nil, fmt.Errorf("decrypting session: file is truncated")
+	}
+
+	plaintext, err := f.aead.Open(nil, b[:nonceSize], b[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting session: %w", err)
+	}
+
+	var si auth.SessionInfo
+	if err := json.Unmarshal(plaintext, &si); err != nil {
+		return nil, fmt.Errorf("unmarshaling session: %w", err)
+	}
+
+	return &si, nil
+}
+
+func (f *encryptedFile) Save(si *auth.SessionInfo) error {
+	plaintext, err := json.Marshal(si)
+	if err != nil {
+		return fmt.Errorf("marshaling session: %w", err)
+	}
+
+	nonce := make([]byte, f.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generating nonce: %w", err)
+	}
+
+	// Write to a new file and rename it, so that the permissions are always set and a crash never leaves half a session.
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return fmt.Errorf("creating session file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(f.aead.Seal(nonce, nonce, plaintext, nil))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing session to disk: %w", err)
+	}
+
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return fmt.Errorf("setting session file permissions: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("writing session to disk: %w", err)
+	}
+
+	return nil
+}
+
+func (f *encryptedFile) Remove() error {
+	err := os.Remove(f.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing session from disk: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package sessionstore_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/nais/device/device-agent/auth"
+	"github.com/nais/device/device-agent/sessionstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessionstore")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "session")
+	privateKey := []byte("0123456789abcdef0123456789abcdef")
+	si := &auth.SessionInfo{Key: "secret-session-key", Expiry: 1234}
+
+	store, err := sessionstore.NewEncryptedFile(path, privateKey)
+	assert.NoError(t, err)
+
+	loaded, err := store.Load()
+	assert.NoError(t, err)
+	assert.Nil(t, loaded, "nothing stored yet")
+
+	assert.NoError(t, store.Save(si))
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), si.Key)
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	loaded, err = store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, si, loaded)
+
+	t.Run("overwrites previous session", func(t *testing.T) {
+		renewed := &auth.SessionInfo{Key: si.Key, Expiry: 5678}
+		assert.NoError(t, store.Save(renewed))
+
+		loaded, err := store.Load()
+		assert.NoError(t, err)
+		assert.Equal(t, renewed, loaded)
+
+		files, err := ioutil.ReadDir(dir)
+		assert.NoError(t, err)
+		assert.Len(t, files, 1)
+	})
+
+	t.Run("can not be loaded with another private key", func(t *testing.T) {
+		other, err := sessionstore.NewEncryptedFile(path, []byte("fedcba9876543210fedcba9876543210"))
+		assert.NoError(t, err)
+
+		_, err = other.Load()
+		assert.Error(t, err)
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		assert.NoError(t, store.Remove())
+		assert.NoError(t, store.Remove(), "removing a missing session is not an error")
+
+		loaded, err := store.Load()
+		assert.NoError(t, err)
+		assert.Nil(t, loaded)
+	})
+}
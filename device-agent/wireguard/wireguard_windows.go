@@ -20,5 +20,9 @@ Address = %s
 const mtu = 1360
 
 func MarshalHeader(w io.Writer, x *pb.Configuration) (int, error) {
-	return fmt.Fprintf(w, wireGuardTemplateHeader, x.GetPrivateKey(), mtu, x.GetDeviceIP())
+	addresses := x.GetDeviceIP()
+	if len(x.GetDeviceIPv6()) > 0 {
+		addresses += ", " + x.GetDeviceIPv6() + "/64"
+	}
+	return fmt.Fprintf(w, wireGuardTemplateHeader, x.GetPrivateKey(), mtu, addresses)
 }
@@ -1,53 +1,36 @@
 package gateway_agent
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-)
-
-type GatewayConfig struct {
-	Devices []Device `json:"devices"`
-	Routes  []string `json:"routes"`
-}
 
-type Device struct {
-	PSK       string `json:"psk"`
-	PublicKey string `json:"publicKey"`
-	IP        string `json:"ip"`
-}
+	"github.com/nais/device/pkg/pb"
+)
 
-func GetGatewayConfig(config Config, client http.Client) (*GatewayConfig, error) {
-	gatewayConfigURL := fmt.Sprintf("http://%s/gatewayconfig", config.BootstrapConfig.APIServerIP)
-	req, err := http.NewRequest(http.MethodGet, gatewayConfigURL, nil)
+// StreamGatewayConfiguration subscribes to gateway configuration from the apiserver, and forwards
+// every configuration received to the provided channel until the stream breaks or ctx is cancelled.
+func StreamGatewayConfiguration(ctx context.Context, config Config, client pb.APIServerClient, gatewayConfigs chan<- *pb.GatewayConfiguration) error {
+	stream, err := client.GetGatewayConfiguration(ctx, &pb.GetGatewayConfigurationRequest{
+		Gateway:  config.Name,
+		Password: config.APIServerPassword,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("creating http request: %w", err)
+		return fmt.Errorf("requesting gateway configuration stream: %w", err)
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("getting peer config from apiserver: %w", err)
-	}
+	for {
+		gatewayConfig, err := stream.Recv()
+		if err != nil {
+			return fmt.Errorf("receiving gateway configuration: %w", err)
+		}
 
-	defer resp.Body.Close()
+		RegisteredDevices.Set(float64(len(gatewayConfig.GetDevices())))
+		LastSuccessfulConfigFetch.SetToCurrentTime()
 
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading bytes, %w", err)
+		select {
+		case gatewayConfigs <- gatewayConfig:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("fetching gatewayConfig from apiserver: %v %v %v", resp.StatusCode, resp.Status, string(b))
-	}
-
-	var gatewayConfig GatewayConfig
-	err = json.Unmarshal(b, &gatewayConfig)
-	if err != nil {
-		return nil, fmt.Errorf("unmarshal json from apiserver: bytes: %v, error: %w", string(b), err)
-	}
-
-	RegisteredDevices.Set(float64(len(gatewayConfig.Devices)))
-
-	return &gatewayConfig, nil
 }
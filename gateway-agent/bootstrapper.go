@@ -1,7 +1,6 @@
 package gateway_agent
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/nais/device/device-agent/wireguard"
@@ -46,15 +45,17 @@ func (b *Bootstrapper) EnsureBootstrapConfig() (*bootstrap.Config, error) {
 	return bc, nil
 }
 
+const getBootstrapConfigTimeout = 60 * time.Second // how long to wait for the apiserver to enroll the gateway
+
 func BootstrapGateway(gatewayInfo *bootstrap.GatewayInfo, bootstrapAPI string, client *http.Client) (*bootstrap.Config, error) {
 	gatewayInfoUrl := fmt.Sprintf("%s/api/v2/gateway/info", bootstrapAPI)
-	err := postGatewayInfo(gatewayInfoUrl, gatewayInfo, client)
+	err := bootstrap.PostInfo(gatewayInfoUrl, gatewayInfo, client)
 	if err != nil {
-		return nil, fmt.Errorf("posting device info: %w", err)
+		return nil, fmt.Errorf("posting gateway info: %w", err)
 	}
 
 	bootstrapConfigURL := fmt.Sprintf("%s/api/v2/gateway/config/%s", bootstrapAPI, gatewayInfo.Name)
-	bootstrapConfig, err := getBootstrapConfig(bootstrapConfigURL, client)
+	bootstrapConfig, err := bootstrap.GetConfig(bootstrapConfigURL, client, getBootstrapConfigTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("getting bootstrap config: %w", err)
 	}
@@ -62,48 +63,6 @@ func BootstrapGateway(gatewayInfo *bootstrap.GatewayInfo, bootstrapAPI string, c
 	return bootstrapConfig, nil
 }
 
-func postGatewayInfo(url string, gatewayInfo *bootstrap.GatewayInfo, client *http.Client) error {
-	dib, err := json.Marshal(gatewayInfo)
-	if err != nil {
-		return fmt.Errorf("marshaling device info: %w", err)
-	}
-
-	resp, err := client.Post(url, "application/json", bytes.NewReader(dib))
-	if err != nil {
-		return fmt.Errorf("posting info to bootstrap API (%v): %w", url, err)
-	}
-
-	if resp.StatusCode != http.StatusCreated {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("parsing response: %w", err)
-		}
-		log.Warningf("bad response from bootstrap-api, request body: %v", string(body))
-		return fmt.Errorf("bootstrap api (%v) returned status %v", url, resp.Status)
-	}
-
-	return nil
-}
-
-func getBootstrapConfig(url string, client *http.Client) (*bootstrap.Config, error) {
-	attempts := 3
-
-	for i := 0; i < attempts; i++ {
-		resp, err := client.Get(url)
-
-		if err == nil && resp.StatusCode == 200 {
-			var bootstrapConfig bootstrap.Config
-			if err := json.NewDecoder(resp.Body).Decode(&bootstrapConfig); err == nil {
-				log.Debugf("Got bootstrap config from bootstrap api: %v", bootstrapConfig)
-				return &bootstrapConfig, nil
-			}
-		}
-		time.Sleep(1 * time.Second)
-		continue
-	}
-	return nil, fmt.Errorf("unable to get boostrap config in %v attempts from %v", attempts, url)
-}
-
 func writeToJSONFile(strct interface{}, path string) error {
 	b, err := json.Marshal(&strct)
 	if err != nil {
@@ -2,7 +2,6 @@ package gateway_agent
 
 import (
 	"fmt"
-	"github.com/coreos/go-iptables/iptables"
 	"github.com/nais/device/pkg/bootstrap"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
@@ -22,11 +21,14 @@ type Config struct {
 	PrometheusAddr        string
 	PrometheusPublicKey   string
 	PrometheusTunnelIP    string
+	HealthCheckAddr       string
 	APIServerURL          string
+	APIServerGRPCAddress  string
 	APIServerPassword     string
 	APIServerPasswordPath string
 	LogLevel              string
-	IPTables              *iptables.IPTables
+	IPTables              IPTables
+	IP6Tables             IPTables
 	DefaultInterface      string
 	DefaultInterfaceIP    string
 	BootstrapConfig       *bootstrap.Config
@@ -0,0 +1,23 @@
+package gateway_agent
+
+import (
+	"encoding/binary"
+)
+
+// NFLOGPacket is a packet logged by the NFLOG target, along with its log prefix.
+type NFLOGPacket = nflogPacket
+
+// NativeEndian is the byte order of netlink message headers and attributes.
+var NativeEndian binary.ByteOrder = nativeEndian
+
+func ParseNFLOGMessages(b []byte) ([]NFLOGPacket, error) {
+	return parseNFLOGMessages(b)
+}
+
+func ParseNFLOGAttributes(b []byte) NFLOGPacket {
+	return parseNFLOGAttributes(b)
+}
+
+func ParseNetlinkAck(b []byte) error {
+	return parseNetlinkAck(b)
+}
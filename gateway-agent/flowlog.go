@@ -0,0 +1,223 @@
+package gateway_agent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nais/device/pkg/pb"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// FlowLogGroup is the NFLOG group new connections are sent to by the LOG_ACCEPT chain.
+	FlowLogGroup = 100
+	// FlowLogPrefix identifies the packets logged by the LOG_ACCEPT chain.
+	FlowLogPrefix = "naisdevice-fwd"
+	// unknownUser labels connections from tunnel IPs not in the current gateway configuration.
+	unknownUser = "unknown"
+)
+
+// IP protocol numbers of the protocols routes can be restricted to.
+const (
+	protocolICMP   = 1
+	protocolTCP    = 6
+	protocolUDP    = 17
+	protocolICMPv6 = 58
+)
+
+// Flow is a new connection through the gateway, as parsed from the first packet of the connection.
+type Flow struct {
+	Source          net.IP
+	Destination     net.IP
+	Protocol        string
+	DestinationPort int
+}
+
+// Connection is a flow attributed to the device it came from, along with the route that allowed it.
+type Connection struct {
+	Time     time.Time
+	Flow     Flow
+	Username string
+	Serial   string
+	DeviceID int64
+	Route    string
+}
+
+// ParsePacket parses the IPv4 or IPv6 header of a packet, along with the destination port of TCP and UDP packets.
+// IPv6 extension headers are not followed, so such packets get the protocol of the first extension header.
+func ParsePacket(packet []byte) (*Flow, error) {
+	if len(packet) < 1 {
+		return nil, fmt.Errorf("empty packet")
+	}
+
+	var flow Flow
+	var protocol byte
+	var payload []byte
+
+	switch packet[0] >> 4 {
+	case 4:
+		headerLength := int(packet[0]&0x0f) * 4
+		if len(packet) < 20 || headerLength < 20 || len(packet) < headerLength {
+			return nil, fmt.Errorf("truncated IPv4 header")
+		}
+		protocol = packet[9]
+		flow.Source = net.IP(packet[12:16])
+		flow.Destination = net.IP(packet[16:20])
+		payload = packet[headerLength:]
+	case 6:
+		if len(packet) < 40 {
+			return nil, fmt.Errorf("truncated IPv6 header")
+		}
+		protocol = packet[6]
+		flow.Source = net.IP(packet[8:24])
+		flow.Destination = net.IP(packet[24:40])
+		payload = packet[40:]
+	default:
+		return nil, fmt.Errorf("unknown IP version %d", packet[0]>>4)
+	}
+
+	switch protocol {
+	case protocolTCP, protocolUDP:
+		flow.Protocol = "tcp"
+		if protocol == protocolUDP {
+			flow.Protocol = "udp"
+		}
+		if len(payload) >= 4 {
+			flow.DestinationPort = int(binary.BigEndian.Uint16(payload[2:4]))
+		}
+	case protocolICMP, protocolICMPv6:
+		flow.Protocol = "icmp"
+	default:
+		flow.Protocol = strconv.Itoa(int(protocol))
+	}
+
+	return &flow, nil
+}
+
+// FlowLogger attributes new connections through the gateway to devices and routes, using the current gateway
+// configuration, and logs them as structured events.
+type FlowLogger struct {
+	devices map[string]*pb.Device // by tunnel IPv4 and IPv6 address
+	lock    sync.Mutex
+}
+
+func NewFlowLogger() *FlowLogger {
+	return &FlowLogger{
+		devices: make(map[string]*pb.Device),
+	}
+}
+
+// Update replaces the devices connections are attributed to.
+func (l *FlowLogger) Update(gatewayConfig *pb.GatewayConfiguration) {
+	devices := make(map[string]*pb.Device)
+	for _, device := range gatewayConfig.GetDevices() {
+		if len(device.GetIp()) > 0 {
+			devices[device.GetIp()] = device
+		}
+		if len(device.GetIpv6()) > 0 {
+			devices[net.ParseIP(device.GetIpv6()).String()] = device
+		}
+	}
+
+	l.lock.Lock()
+	l.devices = devices
+	l.lock.Unlock()
+}
+
+// Connection attributes the flow to the device with its source address. Flows from unknown addresses, e.g. devices
+// dropped from the configuration after the connection was accepted, are attributed to the unknown user.
+func (l *FlowLogger) Connection(flow Flow) Connection {
+	l.lock.Lock()
+	device, ok := l.devices[flow.Source.String()]
+	l.lock.Unlock()
+
+	connection := Connection{
+		Time:     time.Now(),
+		Flow:     flow,
+		Username: unknownUser,
+	}
+
+	if !ok {
+		return connection
+	}
+
+	connection.Username = device.GetUsername()
+	connection.Serial = device.GetSerial()
+	connection.DeviceID = device.GetId()
+	if route := matchingRoute(device.GetAllowedRoutes(), flow); route != nil {
+		connection.Route = route.GetCidr()
+	}
+
+	return connection
+}
+
+// HandlePacket logs the connection starting with the packet, and counts it per user and route.
+func (l *FlowLogger) HandlePacket(packet []byte) {
+	flow, err := ParsePacket(packet)
+	if err != nil {
+		log.Debugf("Parsing logged packet: %v", err)
+		FlowParseErrors.Inc()
+		return
+	}
+
+	c := l.Connection(*flow)
+	ConnectionsForwarded.WithLabelValues(c.Username, c.Route).Inc()
+
+	log.WithFields(log.Fields{
+		"component":   "gateway-agent",
+		"event":       "connection",
+		"username":    c.Username,
+		"serial":      c.Serial,
+		"device_id":   c.DeviceID,
+		"source":      c.Flow.Source.String(),
+		"destination": c.Flow.Destination.String(),
+		"protocol":    c.Flow.Protocol,
+		"port":        c.Flow.DestinationPort,
+		"route":       c.Route,
+	}).Infof("New connection through gateway")
+}
+
+// matchingRoute returns the first route the flow is allowed by, or nil if there is none.
+func matchingRoute(routes []*pb.Route, flow Flow) *pb.Route {
+	for _, route := range routes {
+		_, network, err := net.ParseCIDR(route.GetCidr())
+		if err != nil || !network.Contains(flow.Destination) || routeProtocol(route) != flow.Protocol {
+			continue
+		}
+
+		if len(route.GetPorts()) == 0 {
+			return route
+		}
+
+		for _, ports := range route.GetPorts() {
+			if portInRange(flow.DestinationPort, ports) {
+				return route
+			}
+		}
+	}
+
+	return nil
+}
+
+// portInRange reports whether the port is the single port or within the inclusive range, such as "8000-8100".
+func portInRange(port int, ports string) bool {
+	bounds := strings.SplitN(ports, "-", 2)
+	low, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return false
+	}
+
+	high := low
+	if len(bounds) == 2 {
+		if high, err = strconv.Atoi(bounds[1]); err != nil {
+			return false
+		}
+	}
+
+	return port >= low && port <= high
+}
@@ -0,0 +1,91 @@
+package gateway_agent_test
+
+import (
+	"net"
+	"testing"
+
+	gateway_agent "github.com/nais/device/gateway-agent"
+	"github.com/nais/device/pkg/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+// ipv4Packet returns the start of an IPv4 packet without options, followed by the source and destination ports.
+func ipv4Packet(protocol byte, source, destination string, port uint16) []byte {
+	packet := make([]byte, 24)
+	packet[0] = 0x45
+	packet[9] = protocol
+	copy(packet[12:16], net.ParseIP(source).To4())
+	copy(packet[16:20], net.ParseIP(destination).To4())
+	packet[22], packet[23] = byte(port>>8), byte(port)
+	return packet
+}
+
+func ipv6Packet(protocol byte, source, destination string, port uint16) []byte {
+	packet := make([]byte, 44)
+	packet[0] = 0x60
+	packet[6] = protocol
+	copy(packet[8:24], net.ParseIP(source))
+	copy(packet[24:40], net.ParseIP(destination))
+	packet[42], packet[43] = byte(port>>8), byte(port)
+	return packet
+}
+
+func TestParsePacket(t *testing.T) {
+	flow, err := gateway_agent.ParsePacket(ipv4Packet(6, "10.255.240.2", "10.0.0.10", 443))
+	assert.NoError(t, err)
+	assert.Equal(t, "10.255.240.2", flow.Source.String())
+	assert.Equal(t, "10.0.0.10", flow.Destination.String())
+	assert.Equal(t, "tcp", flow.Protocol)
+	assert.Equal(t, 443, flow.DestinationPort)
+
+	flow, err = gateway_agent.ParsePacket(ipv6Packet(17, "fd75:568f:f19::2", "2001:db8::53", 53))
+	assert.NoError(t, err)
+	assert.Equal(t, "fd75:568f:f19::2", flow.Source.String())
+	assert.Equal(t, "udp", flow.Protocol)
+	assert.Equal(t, 53, flow.DestinationPort)
+
+	flow, err = gateway_agent.ParsePacket(ipv4Packet(1, "10.255.240.2", "10.0.0.10", 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "icmp", flow.Protocol)
+
+	for _, packet := range [][]byte{nil, {0x45, 0, 0}, make([]byte, 20), ipv6Packet(6, "::1", "::1", 0)[:30]} {
+		_, err := gateway_agent.ParsePacket(packet)
+		assert.Error(t, err)
+	}
+}
+
+func TestFlowLoggerConnection(t *testing.T) {
+	web := &pb.Route{Cidr: "10.0.0.0/24", Ports: []string{"80", "8000-8100"}}
+	dns := &pb.Route{Cidr: "2001:db8::/64", Protocol: "udp", Ports: []string{"53"}}
+
+	l := gateway_agent.NewFlowLogger()
+	l.Update(&pb.GatewayConfiguration{
+		Devices: []*pb.Device{
+			{Id: 1, Username: "user", Serial: "serial", Ip: "10.255.240.2", Ipv6: "fd75:568f:0f19::2", AllowedRoutes: []*pb.Route{web, dns}},
+		},
+	})
+
+	for _, tc := range []struct {
+		packet   []byte
+		username string
+		route    string
+	}{
+		{ipv4Packet(6, "10.255.240.2", "10.0.0.10", 80), "user", "10.0.0.0/24"},
+		{ipv4Packet(6, "10.255.240.2", "10.0.0.10", 8050), "user", "10.0.0.0/24"},
+		{ipv4Packet(6, "10.255.240.2", "10.0.0.10", 443), "user", ""},
+		{ipv4Packet(17, "10.255.240.2", "10.0.0.10", 80), "user", ""},
+		{ipv6Packet(17, "fd75:568f:f19::2", "2001:db8::53", 53), "user", "2001:db8::/64"},
+		{ipv4Packet(6, "10.255.240.3", "10.0.0.10", 80), "unknown", ""},
+	} {
+		flow, err := gateway_agent.ParsePacket(tc.packet)
+		assert.NoError(t, err)
+
+		c := l.Connection(*flow)
+		assert.Equal(t, tc.username, c.Username, "%s -> %s:%d", flow.Source, flow.Destination, flow.DestinationPort)
+		assert.Equal(t, tc.route, c.Route, "%s -> %s:%d", flow.Source, flow.Destination, flow.DestinationPort)
+	}
+
+	l.Update(&pb.GatewayConfiguration{})
+	flow, _ := gateway_agent.ParsePacket(ipv4Packet(6, "10.255.240.2", "10.0.0.10", 80))
+	assert.Equal(t, "unknown", l.Connection(*flow).Username, "devices dropped from the configuration are no longer known")
+}
@@ -0,0 +1,103 @@
+package gateway_agent
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nais/device/pkg/gatewayhealth"
+	"github.com/nais/device/pkg/pb"
+)
+
+const (
+	healthCheckReadTimeout  = 5 * time.Second
+	healthCheckWriteTimeout = 5 * time.Second
+	healthCheckIdleTimeout  = 1 * time.Minute // devices check every gateway periodically, so keep their connections open for a while
+)
+
+// HealthServer answers health checks from devices, telling them whether they are admitted to the gateway.
+// Devices that are not admitted have no peer on the gateway and cannot reach it, so they learn that from the apiserver;
+// the answer here only differs from the apiserver's while the gateway catches up with a new configuration.
+type HealthServer struct {
+	name       string
+	version    string
+	lock       sync.RWMutex
+	generation uint64
+	admitted   map[string]bool // tunnel addresses of the devices in the current configuration
+}
+
+func NewHealthServer(name, version string) *HealthServer {
+	return &HealthServer{
+		name:     name,
+		version:  version,
+		admitted: make(map[string]bool),
+	}
+}
+
+// Update admits the devices in a new gateway configuration.
+func (h *HealthServer) Update(gatewayConfig *pb.GatewayConfiguration) {
+	admitted := make(map[string]bool)
+	for _, device := range gatewayConfig.GetDevices() {
+		for _, ip := range []string{device.GetIp(), device.GetIpv6()} {
+			if parsed := net.ParseIP(ip); parsed != nil {
+				admitted[parsed.String()] = true
+			}
+		}
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.generation++
+	h.admitted = admitted
+}
+
+func (h *HealthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	h.lock.RLock()
+	status := gatewayhealth.Status{
+		Name:       h.name,
+		Version:    h.version,
+		Generation: h.generation,
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		status.Admitted = h.admitted[ip.String()]
+	}
+	h.lock.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Errorf("Encoding health check response: %v", err)
+	}
+}
+
+// ServeHealthChecks serves health checks at the address until it fails.
+func ServeHealthChecks(address string, h *HealthServer) error {
+	mux := http.NewServeMux()
+	mux.Handle(gatewayhealth.Path, h)
+
+	server := &http.Server{
+		Addr:         address,
+		Handler:      mux,
+		ReadTimeout:  healthCheckReadTimeout,
+		WriteTimeout: healthCheckWriteTimeout,
+		IdleTimeout:  healthCheckIdleTimeout,
+	}
+
+	log.Infof("Serving health checks at %v", address)
+	return server.ListenAndServe()
+}
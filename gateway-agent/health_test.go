@@ -0,0 +1,55 @@
+package gateway_agent_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gateway_agent "github.com/nais/device/gateway-agent"
+	"github.com/nais/device/pkg/gatewayhealth"
+	"github.com/nais/device/pkg/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func healthCheck(t *testing.T, h *gateway_agent.HealthServer, remoteAddr string) gatewayhealth.Status {
+	req := httptest.NewRequest(http.MethodGet, gatewayhealth.Path, nil)
+	req.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var status gatewayhealth.Status
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	return status
+}
+
+func TestHealthServer(t *testing.T) {
+	h := gateway_agent.NewHealthServer("gateway-1", "2020-10-01-abcdef")
+
+	status := healthCheck(t, h, "10.255.240.2:50000")
+	assert.Equal(t, gatewayhealth.Status{Name: "gateway-1", Version: "2020-10-01-abcdef"}, status)
+
+	h.Update(&pb.GatewayConfiguration{
+		Devices: []*pb.Device{
+			{Ip: "10.255.240.2", Ipv6: "fd00::2"},
+		},
+	})
+
+	status = healthCheck(t, h, "10.255.240.2:50000")
+	assert.True(t, status.Admitted)
+	assert.Equal(t, uint64(1), status.Generation)
+
+	status = healthCheck(t, h, "[fd00:0::2]:50000")
+	assert.True(t, status.Admitted, "ipv6 addresses are compared in canonical form")
+
+	status = healthCheck(t, h, "10.255.240.3:50000")
+	assert.False(t, status.Admitted)
+
+	h.Update(&pb.GatewayConfiguration{})
+
+	status = healthCheck(t, h, "10.255.240.2:50000")
+	assert.False(t, status.Admitted, "device removed from configuration")
+	assert.Equal(t, uint64(2), status.Generation)
+}
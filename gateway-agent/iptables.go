@@ -2,69 +2,370 @@ package gateway_agent
 
 import (
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/nais/device/pkg/netutil"
+	"github.com/nais/device/pkg/pb"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// ForwardChain holds one rule per forwarded route, and is jumped to from FORWARD.
+	ForwardChain = "NAISDEVICE-FORWARD"
+	// SNATChain holds one source NAT rule per forwarded route, and is jumped to from POSTROUTING.
+	SNATChain = "NAISDEVICE-SNAT"
+	// LogAcceptChain sends new connections to the FlowLogger through NFLOG, and accepts them.
+	LogAcceptChain = "LOG_ACCEPT"
+)
+
+// IPTables is the subset of go-iptables used by the gateway-agent.
+type IPTables interface {
+	AppendUnique(table, chain string, rulespec ...string) error
+	Delete(table, chain string, rulespec ...string) error
+	NewChain(table, chain string) error
+	ClearChain(table, chain string) error
+	ChangePolicy(table, chain, target string) error
+	List(table, chain string) ([]string, error)
+}
+
+// Rule is a single iptables rule in the given table and chain. IPv6 rules are handled by ip6tables.
+type Rule struct {
+	IPv6  bool
+	Table string
+	Chain string
+	Spec  []string
+}
+
+func (r Rule) key() string {
+	family := "ipv4"
+	if r.IPv6 {
+		family = "ipv6"
+	}
+	return family + " " + r.Table + " " + r.Chain + " " + strings.Join(r.Spec, " ")
+}
+
+// SetupIptables sets up forwarding defaults and the chains managed by RouteForwarder,
+// for IPv6 as well if ip6tables is configured.
 func SetupIptables(cfg Config) error {
-	err := cfg.IPTables.ChangePolicy("filter", "FORWARD", "DROP")
+	if err := setupIptables(cfg.IPTables, cfg.DefaultInterface); err != nil {
+		return err
+	}
+
+	if err := removeLegacyRules(cfg.IPTables, cfg.DefaultInterface, cfg.DefaultInterfaceIP); err != nil {
+		return err
+	}
+
+	if cfg.IP6Tables != nil {
+		if err := setupIptables(cfg.IP6Tables, cfg.DefaultInterface); err != nil {
+			return fmt.Errorf("ip6tables: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func setupIptables(ipt IPTables, defaultInterface string) error {
+	err := ipt.ChangePolicy("filter", "FORWARD", "DROP")
 	if err != nil {
 		return fmt.Errorf("setting FORWARD policy to DROP: %w", err)
 	}
 
 	// Allow ESTABLISHED,RELATED from wg0 to default interface
-	err = cfg.IPTables.AppendUnique("filter", "FORWARD", "-i", "wg0", "-o", cfg.DefaultInterface, "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "ACCEPT")
+	err = ipt.AppendUnique("filter", "FORWARD", "-i", "wg0", "-o", defaultInterface, "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "ACCEPT")
 	if err != nil {
 		return fmt.Errorf("adding default FORWARD outbound-rule: %w", err)
 	}
 
 	// Allow ESTABLISHED,RELATED from default interface to wg0
-	err = cfg.IPTables.AppendUnique("filter", "FORWARD", "-i", cfg.DefaultInterface, "-o", "wg0", "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "ACCEPT")
+	err = ipt.AppendUnique("filter", "FORWARD", "-i", defaultInterface, "-o", "wg0", "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "ACCEPT")
 	if err != nil {
 		return fmt.Errorf("adding default FORWARD inbound-rule: %w", err)
 	}
 
-	// Create and set up LOG_ACCEPT CHAIN
-	err = cfg.IPTables.NewChain("filter", "LOG_ACCEPT")
+	// Create or flush the LOG_ACCEPT chain, replacing the kernel log rule of earlier versions
+	err = ipt.ClearChain("filter", LogAcceptChain)
+	if err != nil {
+		return fmt.Errorf("setting up %s chain: %w", LogAcceptChain, err)
+	}
+	err = ipt.AppendUnique("filter", LogAcceptChain, "-j", "NFLOG", "--nflog-group", strconv.Itoa(FlowLogGroup), "--nflog-prefix", FlowLogPrefix)
+	if err != nil {
+		return fmt.Errorf("adding default %s nflog-rule: %w", LogAcceptChain, err)
+	}
+	err = ipt.AppendUnique("filter", LogAcceptChain, "-j", "ACCEPT")
+	if err != nil {
+		return fmt.Errorf("adding default %s accept-rule: %w", LogAcceptChain, err)
+	}
+
+	// Create or flush the chains managed by RouteForwarder
+	err = ipt.ClearChain("filter", ForwardChain)
+	if err != nil {
+		return fmt.Errorf("setting up %s chain: %w", ForwardChain, err)
+	}
+	err = ipt.ClearChain("nat", SNATChain)
+	if err != nil {
+		return fmt.Errorf("setting up %s chain: %w", SNATChain, err)
+	}
+
+	err = ipt.AppendUnique("filter", "FORWARD", "-i", "wg0", "-o", defaultInterface, "-j", ForwardChain)
 	if err != nil {
-		log.Infof("Creating LOG_ACCEPT chain (probably already exist), error: %v", err)
+		return fmt.Errorf("adding FORWARD jump to %s: %w", ForwardChain, err)
 	}
-	err = cfg.IPTables.AppendUnique("filter", "LOG_ACCEPT", "-j", "LOG", "--log-prefix", "naisdevice-fwd: ", "--log-level", "6")
+	err = ipt.AppendUnique("nat", "POSTROUTING", "-o", defaultInterface, "-j", SNATChain)
 	if err != nil {
-		return fmt.Errorf("adding default LOG_ACCEPT log-rule: %w", err)
+		return fmt.Errorf("adding POSTROUTING jump to %s: %w", SNATChain, err)
+	}
+
+	return nil
+}
+
+// removeLegacyRules deletes the per-route rules that earlier versions added directly to FORWARD and POSTROUTING,
+// so that routes removed from the gateway configuration since the upgrade are no longer forwarded.
+// Earlier versions only used iptables, so there are no legacy rules for ip6tables.
+func removeLegacyRules(ipt IPTables, defaultInterface, defaultInterfaceIP string) error {
+	legacyForward := func(options map[string]string) bool {
+		return options["-j"] == LogAcceptChain
 	}
-	err = cfg.IPTables.AppendUnique("filter", "LOG_ACCEPT", "-j", "ACCEPT")
+
+	legacySNAT := func(options map[string]string) bool {
+		return len(options) == 5 &&
+			len(options["-d"]) > 0 &&
+			options["-o"] == defaultInterface &&
+			options["-p"] == "tcp" &&
+			options["-j"] == "SNAT" &&
+			options["--to-source"] == defaultInterfaceIP
+	}
+
+	if err := deleteRules(ipt, "filter", "FORWARD", legacyForward); err != nil {
+		return err
+	}
+
+	return deleteRules(ipt, "nat", "POSTROUTING", legacySNAT)
+}
+
+// deleteRules deletes the rules in the chain whose options, as listed by iptables -S, match.
+func deleteRules(ipt IPTables, table, chain string, match func(options map[string]string) bool) error {
+	rules, err := ipt.List(table, chain)
 	if err != nil {
-		return fmt.Errorf("adding default LOG_ACCEPT accept-rule: %w", err)
+		return fmt.Errorf("listing %s rules: %w", chain, err)
+	}
+
+	prefix := "-A " + chain + " "
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule, prefix) {
+			continue
+		}
+
+		spec := strings.Fields(strings.TrimPrefix(rule, prefix))
+		if !match(ruleOptions(spec)) {
+			continue
+		}
+
+		log.Infof("Deleting legacy %s rule: %s", chain, strings.Join(spec, " "))
+		if err := ipt.Delete(table, chain, spec...); err != nil {
+			return fmt.Errorf("deleting legacy %s rule: %w", chain, err)
+		}
 	}
 
 	return nil
 }
 
-func ForwardRoutes(cfg Config, routes []string) error {
-	var err error
+// ruleOptions returns the options of a rule spec, mapped to their arguments.
+func ruleOptions(spec []string) map[string]string {
+	options := make(map[string]string)
+	for i := 0; i < len(spec); i++ {
+		if !strings.HasPrefix(spec[i], "-") {
+			continue
+		}
+		var args []string
+		for i+1 < len(spec) && !strings.HasPrefix(spec[i+1], "-") {
+			i++
+			args = append(args, spec[i])
+		}
+		options[spec[i-len(args)]] = strings.Join(args, " ")
+	}
+	return options
+}
 
-	for _, ip := range routes {
-		err = cfg.IPTables.AppendUnique("nat", "POSTROUTING", "-o", cfg.DefaultInterface, "-p", "tcp", "-d", ip, "-j", "SNAT", "--to-source", cfg.DefaultInterfaceIP)
-		if err != nil {
-			return fmt.Errorf("setting up snat: %w", err)
-		}
-
-		err = cfg.IPTables.AppendUnique(
-			"filter",
-			"FORWARD",
-			"--in-interface", "wg0",
-			"--out-interface", cfg.DefaultInterface,
-			"--protocol", "tcp",
-			"--syn",
-			"--destination", ip,
-			"--match", "conntrack",
-			"--ctstate", "NEW",
-			"--jump", "LOG_ACCEPT",
-		)
+// RouteForwarder keeps the rules in ForwardChain and SNATChain in sync with the routes in the gateway configuration.
+// The chains are flushed by SetupIptables, so the rules applied by the forwarder are the only rules in them.
+type RouteForwarder struct {
+	cfg     Config
+	applied map[string]Rule
+}
+
+func NewRouteForwarder(cfg Config) *RouteForwarder {
+	return &RouteForwarder{
+		cfg:     cfg,
+		applied: make(map[string]Rule),
+	}
+}
+
+// ForwardRoutes sets up source NAT for every route in the gateway configuration, and allows each device
+// to reach its allowed routes. Rules for new routes are added before rules that no longer belong are deleted.
+func (f *RouteForwarder) ForwardRoutes(gatewayConfig *pb.GatewayConfiguration) error {
+	desired := make(map[string]Rule)
+	add := func(rules []Rule, err error) {
 		if err != nil {
-			return fmt.Errorf("setting up iptables log rule: %w", err)
+			log.Warnf("Skipping invalid route: %v", err)
+			return
+		}
+		for _, rule := range rules {
+			desired[rule.key()] = rule
 		}
 	}
 
+	for _, route := range gatewayConfig.GetRoutes() {
+		if netutil.IsIPv6(route.GetCidr()) && f.cfg.IP6Tables == nil {
+			log.Warnf("Skipping IPv6 route %s, as IPv6 is not configured on this gateway", route.GetCidr())
+			continue
+		}
+		add(SNATRules(f.cfg, route))
+	}
+
+	for _, device := range gatewayConfig.GetDevices() {
+		for _, route := range device.GetAllowedRoutes() {
+			source := device.GetIp() + "/32"
+			if netutil.IsIPv6(route.GetCidr()) {
+				if f.cfg.IP6Tables == nil || len(device.GetIpv6()) == 0 {
+					continue
+				}
+				source = device.GetIpv6() + "/128"
+			}
+			add(ForwardRules(route, source))
+		}
+	}
+
+	for key, rule := range desired {
+		if _, ok := f.applied[key]; ok {
+			continue
+		}
+		if err := f.iptables(rule).AppendUnique(rule.Table, rule.Chain, rule.Spec...); err != nil {
+			return fmt.Errorf("adding rule %s: %w", key, err)
+		}
+		f.applied[key] = rule
+	}
+
+	for key, rule := range f.applied {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		if err := f.iptables(rule).Delete(rule.Table, rule.Chain, rule.Spec...); err != nil {
+			return fmt.Errorf("deleting rule %s: %w", key, err)
+		}
+		delete(f.applied, key)
+	}
+
 	return nil
 }
+
+func (f *RouteForwarder) iptables(rule Rule) IPTables {
+	if rule.IPv6 {
+		return f.cfg.IP6Tables
+	}
+	return f.cfg.IPTables
+}
+
+// SNATRules returns the rules needed to masquerade traffic to the given route behind the gateway's default interface.
+// IPv6 traffic is masqueraded, as the gateway's public IPv6 address is not known in advance.
+func SNATRules(cfg Config, route *pb.Route) ([]Rule, error) {
+	matches, err := routeMatches(route)
+	if err != nil {
+		return nil, err
+	}
+
+	ipv6 := netutil.IsIPv6(route.GetCidr())
+	rules := make([]Rule, len(matches))
+	for i, match := range matches {
+		target := []string{"--jump", "SNAT", "--to-source", cfg.DefaultInterfaceIP}
+		if ipv6 {
+			target = []string{"--jump", "MASQUERADE"}
+		}
+		rules[i] = Rule{
+			IPv6:  ipv6,
+			Table: "nat",
+			Chain: SNATChain,
+			Spec:  append(match, target...),
+		}
+	}
+
+	return rules, nil
+}
+
+// ForwardRules returns the rules allowing new connections from source to the given route.
+// TCP routes only accept new connections initiated with SYN.
+func ForwardRules(route *pb.Route, source string) ([]Rule, error) {
+	matches, err := routeMatches(route)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]Rule, len(matches))
+	for i, match := range matches {
+		spec := append([]string{"--source", source}, match...)
+		if routeProtocol(route) == "tcp" {
+			spec = append(spec, "--syn")
+		}
+		rules[i] = Rule{
+			IPv6:  netutil.IsIPv6(route.GetCidr()),
+			Table: "filter",
+			Chain: ForwardChain,
+			Spec:  append(spec, "--match", "conntrack", "--ctstate", "NEW", "--jump", LogAcceptChain),
+		}
+	}
+
+	return rules, nil
+}
+
+// routeMatches returns one iptables match per port range of the route, or a single match if it has no ports.
+func routeMatches(route *pb.Route) ([][]string, error) {
+	if !validCIDR(route.GetCidr()) {
+		return nil, fmt.Errorf("route %q: invalid cidr", route.GetCidr())
+	}
+
+	protocol := routeProtocol(route)
+
+	var ports []string
+	switch protocol {
+	case "tcp", "udp":
+		for _, port := range route.GetPorts() {
+			ports = append(ports, strings.Replace(port, "-", ":", 1))
+		}
+		if len(ports) == 0 {
+			ports = []string{""}
+		}
+	case "icmp":
+		ports = []string{""}
+		if netutil.IsIPv6(route.GetCidr()) {
+			protocol = "icmpv6"
+		}
+	default:
+		return nil, fmt.Errorf("route %s: unsupported protocol %q", route.GetCidr(), protocol)
+	}
+
+	matches := make([][]string, len(ports))
+	for i, port := range ports {
+		matches[i] = []string{"--protocol", protocol, "--destination", route.GetCidr()}
+		if len(port) > 0 {
+			matches[i] = append(matches[i], "--dport", port)
+		}
+	}
+
+	return matches, nil
+}
+
+func routeProtocol(route *pb.Route) string {
+	if len(route.GetProtocol()) == 0 {
+		return "tcp"
+	}
+	return route.GetProtocol()
+}
+
+// validCIDR accepts a network in CIDR notation, or a single address.
+func validCIDR(cidr string) bool {
+	if _, _, err := net.ParseCIDR(cidr); err == nil {
+		return true
+	}
+	return net.ParseIP(cidr) != nil
+}
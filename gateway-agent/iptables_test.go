@@ -0,0 +1,211 @@
+package gateway_agent_test
+
+import (
+	"strings"
+	"testing"
+
+	gateway_agent "github.com/nais/device/gateway-agent"
+	"github.com/nais/device/pkg/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeIPTables struct {
+	rules map[string]bool
+}
+
+func newFakeIPTables() *fakeIPTables {
+	return &fakeIPTables{rules: make(map[string]bool)}
+}
+
+func (f *fakeIPTables) key(table, chain string, rulespec ...string) string {
+	return table + " " + chain + " " + strings.Join(rulespec, " ")
+}
+
+func (f *fakeIPTables) AppendUnique(table, chain string, rulespec ...string) error {
+	f.rules[f.key(table, chain, rulespec...)] = true
+	return nil
+}
+
+func (f *fakeIPTables) Delete(table, chain string, rulespec ...string) error {
+	delete(f.rules, f.key(table, chain, rulespec...))
+	return nil
+}
+
+func (f *fakeIPTables) NewChain(_, _ string) error {
+	return nil
+}
+
+func (f *fakeIPTables) ClearChain(table, chain string) error {
+	prefix := table + " " + chain + " "
+	for rule := range f.rules {
+		if strings.HasPrefix(rule, prefix) {
+			delete(f.rules, rule)
+		}
+	}
+	return nil
+}
+
+func (f *fakeIPTables) ChangePolicy(_, _, _ string) error {
+	return nil
+}
+
+func (f *fakeIPTables) List(table, chain string) ([]string, error) {
+	var rules []string
+	for _, rule := range f.chain(table, chain) {
+		rules = append(rules, "-A "+chain+" "+rule)
+	}
+	return rules, nil
+}
+
+func (f *fakeIPTables) chain(table, chain string) []string {
+	var rules []string
+	prefix := table + " " + chain + " "
+	for rule := range f.rules {
+		if strings.HasPrefix(rule, prefix) {
+			rules = append(rules, strings.TrimPrefix(rule, prefix))
+		}
+	}
+	return rules
+}
+
+func TestRouteRules(t *testing.T) {
+	cfg := gateway_agent.Config{DefaultInterfaceIP: "13.37.0.1"}
+
+	rules, err := gateway_agent.SNATRules(cfg, &pb.Route{Cidr: "10.0.0.0/24"})
+	assert.NoError(t, err)
+	assert.Equal(t, []gateway_agent.Rule{
+		{Table: "nat", Chain: gateway_agent.SNATChain, Spec: []string{"--protocol", "tcp", "--destination", "10.0.0.0/24", "--jump", "SNAT", "--to-source", "13.37.0.1"}},
+	}, rules)
+
+	rules, err = gateway_agent.ForwardRules(&pb.Route{Cidr: "10.0.0.0/24"}, "10.255.240.2/32")
+	assert.NoError(t, err)
+	assert.Equal(t, []gateway_agent.Rule{
+		{Table: "filter", Chain: gateway_agent.ForwardChain, Spec: []string{"--source", "10.255.240.2/32", "--protocol", "tcp", "--destination", "10.0.0.0/24", "--syn", "--match", "conntrack", "--ctstate", "NEW", "--jump", "LOG_ACCEPT"}},
+	}, rules)
+
+	udp := &pb.Route{Cidr: "10.0.0.0/24", Protocol: "udp", Ports: []string{"53", "8000-8100"}}
+	rules, err = gateway_agent.SNATRules(cfg, udp)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+	rules, err = gateway_agent.ForwardRules(udp, "10.255.240.2/32")
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+	assert.Equal(t, []string{"--source", "10.255.240.2/32", "--protocol", "udp", "--destination", "10.0.0.0/24", "--dport", "8000:8100", "--match", "conntrack", "--ctstate", "NEW", "--jump", "LOG_ACCEPT"}, rules[1].Spec)
+
+	rules, err = gateway_agent.ForwardRules(&pb.Route{Cidr: "10.0.0.0/24", Protocol: "icmp"}, "10.255.240.2/32")
+	assert.NoError(t, err)
+	assert.Len(t, rules, 1)
+
+	_, err = gateway_agent.SNATRules(cfg, &pb.Route{Cidr: "10.0.0.0/24", Protocol: "sctp"})
+	assert.Error(t, err)
+
+	_, err = gateway_agent.ForwardRules(&pb.Route{Cidr: "10.0.0.0/24 -j ACCEPT"}, "10.255.240.2/32")
+	assert.Error(t, err)
+}
+
+func TestForwardRoutes(t *testing.T) {
+	ipt := newFakeIPTables()
+	cfg := gateway_agent.Config{IPTables: ipt, DefaultInterface: "eth0", DefaultInterfaceIP: "13.37.0.1"}
+	assert.NoError(t, gateway_agent.SetupIptables(cfg))
+	assert.ElementsMatch(t, []string{"-j NFLOG --nflog-group 100 --nflog-prefix naisdevice-fwd", "-j ACCEPT"}, ipt.chain("filter", gateway_agent.LogAcceptChain))
+
+	forwarder := gateway_agent.NewRouteForwarder(cfg)
+
+	open := &pb.Route{Cidr: "10.0.0.0/24"}
+	restricted := &pb.Route{Cidr: "10.0.1.0/24", Protocol: "udp", Ports: []string{"53"}, AccessGroupIDs: []string{"team"}}
+
+	assert.NoError(t, forwarder.ForwardRoutes(&pb.GatewayConfiguration{
+		Routes: []*pb.Route{open, restricted},
+		Devices: []*pb.Device{
+			{Ip: "10.255.240.2", AllowedRoutes: []*pb.Route{open, restricted}},
+			{Ip: "10.255.240.3", AllowedRoutes: []*pb.Route{open}},
+		},
+	}))
+	assert.Len(t, ipt.chain("filter", gateway_agent.ForwardChain), 3)
+	assert.Len(t, ipt.chain("nat", gateway_agent.SNATChain), 2)
+
+	invalid := &pb.Route{Cidr: "10.0.2.0/33"}
+	assert.NoError(t, forwarder.ForwardRoutes(&pb.GatewayConfiguration{
+		Routes: []*pb.Route{open, restricted, invalid},
+		Devices: []*pb.Device{
+			{Ip: "10.255.240.2", AllowedRoutes: []*pb.Route{open, restricted, invalid}},
+			{Ip: "10.255.240.3", AllowedRoutes: []*pb.Route{open}},
+		},
+	}), "invalid routes are skipped")
+	assert.Len(t, ipt.chain("filter", gateway_agent.ForwardChain), 3)
+	assert.Len(t, ipt.chain("nat", gateway_agent.SNATChain), 2)
+
+	assert.NoError(t, forwarder.ForwardRoutes(&pb.GatewayConfiguration{
+		Routes: []*pb.Route{restricted},
+		Devices: []*pb.Device{
+			{Ip: "10.255.240.2", AllowedRoutes: []*pb.Route{restricted}},
+		},
+	}))
+	forward := ipt.chain("filter", gateway_agent.ForwardChain)
+	assert.Len(t, forward, 1)
+	assert.Contains(t, forward[0], "--source 10.255.240.2/32 --protocol udp --destination 10.0.1.0/24 --dport 53")
+	assert.Len(t, ipt.chain("nat", gateway_agent.SNATChain), 1)
+
+	assert.NoError(t, forwarder.ForwardRoutes(&pb.GatewayConfiguration{}))
+	assert.Empty(t, ipt.chain("filter", gateway_agent.ForwardChain))
+	assert.Empty(t, ipt.chain("nat", gateway_agent.SNATChain))
+
+	assert.True(t, ipt.rules["filter FORWARD -i wg0 -o eth0 -j "+gateway_agent.ForwardChain], "jump to managed chain is kept")
+}
+
+func TestForwardRoutesIPv6(t *testing.T) {
+	ipt, ip6t := newFakeIPTables(), newFakeIPTables()
+	cfg := gateway_agent.Config{IPTables: ipt, IP6Tables: ip6t, DefaultInterface: "eth0", DefaultInterfaceIP: "13.37.0.1"}
+	assert.NoError(t, gateway_agent.SetupIptables(cfg))
+
+	v4 := &pb.Route{Cidr: "10.0.0.0/24"}
+	v6 := &pb.Route{Cidr: "2001:db8::/64", Protocol: "icmp"}
+
+	forwarder := gateway_agent.NewRouteForwarder(cfg)
+	assert.NoError(t, forwarder.ForwardRoutes(&pb.GatewayConfiguration{
+		Routes: []*pb.Route{v4, v6},
+		Devices: []*pb.Device{
+			{Ip: "10.255.240.2", Ipv6: "fd75:568f:f19::2", AllowedRoutes: []*pb.Route{v4, v6}},
+			{Ip: "10.255.240.3", AllowedRoutes: []*pb.Route{v4, v6}},
+		},
+	}))
+
+	assert.Len(t, ipt.chain("filter", gateway_agent.ForwardChain), 2)
+	assert.Len(t, ipt.chain("nat", gateway_agent.SNATChain), 1)
+	assert.Equal(t, []string{"--source fd75:568f:f19::2/128 --protocol icmpv6 --destination 2001:db8::/64 --match conntrack --ctstate NEW --jump LOG_ACCEPT"}, ip6t.chain("filter", gateway_agent.ForwardChain))
+	assert.Equal(t, []string{"--protocol icmpv6 --destination 2001:db8::/64 --jump MASQUERADE"}, ip6t.chain("nat", gateway_agent.SNATChain))
+
+	cfg.IP6Tables = nil
+	forwarder = gateway_agent.NewRouteForwarder(cfg)
+	assert.NoError(t, forwarder.ForwardRoutes(&pb.GatewayConfiguration{
+		Routes:  []*pb.Route{v6},
+		Devices: []*pb.Device{{Ip: "10.255.240.2", Ipv6: "fd75:568f:f19::2", AllowedRoutes: []*pb.Route{v6}}},
+	}), "IPv6 routes are skipped when ip6tables is not configured")
+}
+
+func TestSetupIptablesRemovesLegacyRules(t *testing.T) {
+	ipt := newFakeIPTables()
+	legacy := []string{
+		"filter FORWARD -d 10.0.0.1/32 -i wg0 -o eth0 -p tcp -m tcp --tcp-flags FIN,SYN,RST,ACK SYN -m conntrack --ctstate NEW -j LOG_ACCEPT",
+		"nat POSTROUTING -d 10.0.0.1/32 -o eth0 -p tcp -j SNAT --to-source 13.37.0.1",
+	}
+	unrelated := []string{
+		"filter FORWARD -d 10.0.0.2/32 -i wg0 -o eth0 -p tcp -m tcp --dport 443 -j ACCEPT",
+		"nat POSTROUTING -d 10.0.0.2/32 -o eth0 -p tcp -m tcp --dport 443 -j SNAT --to-source 13.37.0.1",
+	}
+	for _, rule := range append(legacy, unrelated...) {
+		ipt.rules[rule] = true
+	}
+
+	cfg := gateway_agent.Config{IPTables: ipt, DefaultInterface: "eth0", DefaultInterfaceIP: "13.37.0.1"}
+	assert.NoError(t, gateway_agent.SetupIptables(cfg))
+
+	for _, rule := range legacy {
+		assert.False(t, ipt.rules[rule], rule)
+	}
+	for _, rule := range unrelated {
+		assert.True(t, ipt.rules[rule], rule)
+	}
+	assert.True(t, ipt.rules["filter FORWARD -i wg0 -o eth0 -j "+gateway_agent.ForwardChain])
+	assert.True(t, ipt.rules["nat POSTROUTING -o eth0 -j "+gateway_agent.SNATChain])
+}
@@ -0,0 +1,232 @@
+package gateway_agent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Netfilter log message types and attributes, from linux/netfilter/nfnetlink_log.h.
+const (
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	nfulaCfgCmd  = 1
+	nfulaCfgMode = 2
+
+	nfulnlCfgCmdBind  = 1
+	nfulnlCopyPacket  = 2
+	nfulaPayload      = 9
+	nfulaPrefix       = 10
+	nlaTypeMask       = 0x3fff
+	sizeofNfGenmsg    = 4
+	nflogReceiveBytes = 1 << 20
+)
+
+// flowLogCopyRange is the number of bytes copied from each logged packet, enough for the largest IPv4 header and
+// the ports that follow it.
+const flowLogCopyRange = 64
+
+// nlmsgMaxSize is the largest batch of netlink messages read at once.
+const nlmsgMaxSize = 1 << 16
+
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// nflogPacket is a packet logged by the NFLOG target, along with its log prefix.
+type nflogPacket struct {
+	Prefix  string
+	Payload []byte
+}
+
+// ListenNFLOG reads the packets logged by the LOG_ACCEPT chain to the NFLOG group, and handles those with the
+// flow log prefix. It only returns if reading fails.
+func (l *FlowLogger) ListenNFLOG(group uint16) error {
+	fd, err := openNFLOG(group)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	buf := make([]byte, nlmsgMaxSize)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return fmt.Errorf("reading from netlink socket: %w", err)
+		}
+
+		packets, err := parseNFLOGMessages(buf[:n])
+		if err != nil {
+			return err
+		}
+
+		for _, packet := range packets {
+			if packet.Prefix == FlowLogPrefix {
+				l.HandlePacket(packet.Payload)
+			}
+		}
+	}
+}
+
+// openNFLOG binds a netfilter netlink socket to the NFLOG group, copying the start of each packet.
+func openNFLOG(group uint16) (int, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return -1, fmt.Errorf("opening netlink socket: %w", err)
+	}
+
+	setup := func() error {
+		if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+			return fmt.Errorf("binding netlink socket: %w", err)
+		}
+
+		// Losing some flow records under load is better than failing, and the kernel drops them either way.
+		if err := unix.SetsockoptInt(fd, unix.SOL_NETLINK, unix.NETLINK_NO_ENOBUFS, 1); err != nil {
+			return fmt.Errorf("setting NETLINK_NO_ENOBUFS: %w", err)
+		}
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, nflogReceiveBytes); err != nil {
+			return fmt.Errorf("setting receive buffer size: %w", err)
+		}
+
+		if err := nflogConfigure(fd, 1, group, nfulaCfgCmd, []byte{nfulnlCfgCmdBind}); err != nil {
+			return fmt.Errorf("binding to NFLOG group %d: %w", group, err)
+		}
+
+		mode := make([]byte, 6)
+		binary.BigEndian.PutUint32(mode, flowLogCopyRange)
+		mode[4] = nfulnlCopyPacket
+		if err := nflogConfigure(fd, 2, group, nfulaCfgMode, mode); err != nil {
+			return fmt.Errorf("setting NFLOG copy mode: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := setup(); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	return fd, nil
+}
+
+// nflogConfigure sends a config message with a single attribute for the group, and waits for it to be acknowledged.
+func nflogConfigure(fd int, seq uint32, group uint16, attrType uint16, value []byte) error {
+	attrLength := unix.SizeofNlAttr + len(value)
+	length := unix.SizeofNlMsghdr + sizeofNfGenmsg + nlaAlign(attrLength)
+	msg := make([]byte, length)
+
+	nativeEndian.PutUint32(msg[0:4], uint32(length))
+	nativeEndian.PutUint16(msg[4:6], unix.NFNL_SUBSYS_ULOG<<8|nfulnlMsgConfig)
+	nativeEndian.PutUint16(msg[6:8], unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	nativeEndian.PutUint32(msg[8:12], seq)
+
+	genmsg := msg[unix.SizeofNlMsghdr:]
+	genmsg[0] = unix.AF_UNSPEC
+	genmsg[1] = unix.NFNETLINK_V0
+	binary.BigEndian.PutUint16(genmsg[2:4], group)
+
+	attr := genmsg[sizeofNfGenmsg:]
+	nativeEndian.PutUint16(attr[0:2], uint16(attrLength))
+	nativeEndian.PutUint16(attr[2:4], attrType)
+	copy(attr[unix.SizeofNlAttr:], value)
+
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, unix.Getpagesize())
+	n, _, err := unix.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return err
+	}
+
+	return parseNetlinkAck(buf[:n])
+}
+
+// parseNetlinkAck returns the error in a netlink acknowledgement, if any.
+func parseNetlinkAck(b []byte) error {
+	if len(b) < unix.SizeofNlMsghdr+4 {
+		return fmt.Errorf("truncated netlink acknowledgement")
+	}
+
+	if nativeEndian.Uint16(b[4:6]) != unix.NLMSG_ERROR {
+		return fmt.Errorf("unexpected netlink message type %d", nativeEndian.Uint16(b[4:6]))
+	}
+
+	if errno := int32(nativeEndian.Uint32(b[unix.SizeofNlMsghdr:])); errno != 0 {
+		return unix.Errno(-errno)
+	}
+
+	return nil
+}
+
+// parseNFLOGMessages returns the logged packets in a batch of netlink messages, ignoring other messages.
+func parseNFLOGMessages(b []byte) ([]nflogPacket, error) {
+	var packets []nflogPacket
+	for len(b) >= unix.SizeofNlMsghdr {
+		length := int(nativeEndian.Uint32(b[0:4]))
+		if length < unix.SizeofNlMsghdr || length > len(b) {
+			return nil, fmt.Errorf("invalid netlink message length %d", length)
+		}
+
+		msgType := nativeEndian.Uint16(b[4:6])
+		body := b[unix.SizeofNlMsghdr:length]
+		if msgType == unix.NFNL_SUBSYS_ULOG<<8|nfulnlMsgPacket && len(body) >= sizeofNfGenmsg {
+			packets = append(packets, parseNFLOGAttributes(body[sizeofNfGenmsg:]))
+		}
+
+		b = b[min(nlaAlign(length), len(b)):]
+	}
+
+	return packets, nil
+}
+
+func parseNFLOGAttributes(b []byte) nflogPacket {
+	var packet nflogPacket
+	for len(b) >= unix.SizeofNlAttr {
+		length := int(nativeEndian.Uint16(b[0:2]))
+		if length < unix.SizeofNlAttr || length > len(b) {
+			break
+		}
+
+		value := b[unix.SizeofNlAttr:length]
+		switch nativeEndian.Uint16(b[2:4]) & nlaTypeMask {
+		case nfulaPrefix:
+			packet.Prefix = string(trimNull(value))
+		case nfulaPayload:
+			packet.Payload = append([]byte(nil), value...)
+		}
+
+		b = b[min(nlaAlign(length), len(b)):]
+	}
+
+	return packet
+}
+
+func trimNull(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
+
+func nlaAlign(length int) int {
+	return (length + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,206 @@
+package gateway_agent_test
+
+import (
+	"testing"
+
+	gateway_agent "github.com/nais/device/gateway-agent"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	nfulnlMsgPacket = unix.NFNL_SUBSYS_ULOG<<8 | 0
+	nfulnlMsgConfig = unix.NFNL_SUBSYS_ULOG<<8 | 1
+	nfulaPayload    = 9
+	nfulaPrefix     = 10
+)
+
+// nlmsg returns a netlink message of the type with the body, padded to the netlink alignment.
+func nlmsg(msgType uint16, body []byte) []byte {
+	length := unix.SizeofNlMsghdr + len(body)
+	msg := make([]byte, align(length))
+	gateway_agent.NativeEndian.PutUint32(msg[0:4], uint32(length))
+	gateway_agent.NativeEndian.PutUint16(msg[4:6], msgType)
+	copy(msg[unix.SizeofNlMsghdr:], body)
+	return msg
+}
+
+// nflogMsg returns a logged packet message with the attributes.
+func nflogMsg(attrs ...[]byte) []byte {
+	body := make([]byte, 4) // nfgenmsg
+	for _, attr := range attrs {
+		body = append(body, attr...)
+	}
+	return nlmsg(nfulnlMsgPacket, body)
+}
+
+// nlattr returns a netlink attribute of the type with the value, padded to the netlink alignment.
+func nlattr(attrType uint16, value []byte) []byte {
+	length := unix.SizeofNlAttr + len(value)
+	attr := make([]byte, align(length))
+	gateway_agent.NativeEndian.PutUint16(attr[0:2], uint16(length))
+	gateway_agent.NativeEndian.PutUint16(attr[2:4], attrType)
+	copy(attr[unix.SizeofNlAttr:], value)
+	return attr
+}
+
+// ack returns a netlink acknowledgement with the error number, zero for success.
+func ack(errno int32) []byte {
+	body := make([]byte, 4+unix.SizeofNlMsghdr)
+	gateway_agent.NativeEndian.PutUint32(body[0:4], uint32(errno))
+	return nlmsg(unix.NLMSG_ERROR, body)
+}
+
+func align(length int) int {
+	return (length + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+func TestParseNFLOGMessages(t *testing.T) {
+	payload := []byte{0x45, 0, 0, 20, 1, 2, 3}
+	packet := nflogMsg(nlattr(nfulaPrefix, []byte(gateway_agent.FlowLogPrefix+"\x00")), nlattr(nfulaPayload, payload))
+
+	short := nlmsg(nfulnlMsgPacket, nil)
+	gateway_agent.NativeEndian.PutUint32(short[0:4], 8)
+
+	for _, tc := range []struct {
+		name     string
+		messages []byte
+		expected []gateway_agent.NFLOGPacket
+		err      bool
+	}{
+		{
+			name:     "packet with prefix and payload",
+			messages: packet,
+			expected: []gateway_agent.NFLOGPacket{{Prefix: gateway_agent.FlowLogPrefix, Payload: payload}},
+		},
+		{
+			name:     "batch of packets",
+			messages: concat(packet, nflogMsg(nlattr(nfulaPrefix, []byte("other\x00")))),
+			expected: []gateway_agent.NFLOGPacket{
+				{Prefix: gateway_agent.FlowLogPrefix, Payload: payload},
+				{Prefix: "other"},
+			},
+		},
+		{
+			name:     "other message types are ignored",
+			messages: concat(nlmsg(nfulnlMsgConfig, make([]byte, 4)), nlmsg(unix.NLMSG_DONE, nil), packet),
+			expected: []gateway_agent.NFLOGPacket{{Prefix: gateway_agent.FlowLogPrefix, Payload: payload}},
+		},
+		{
+			name:     "packet message without nfgenmsg is ignored",
+			messages: nlmsg(nfulnlMsgPacket, []byte{0, 0}),
+		},
+		{
+			name:     "trailing bytes shorter than a header are ignored",
+			messages: concat(packet, []byte{1, 2, 3}),
+			expected: []gateway_agent.NFLOGPacket{{Prefix: gateway_agent.FlowLogPrefix, Payload: payload}},
+		},
+		{
+			name:     "message longer than the batch",
+			messages: packet[:len(packet)-4],
+			err:      true,
+		},
+		{
+			name:     "message shorter than its header",
+			messages: short,
+			err:      true,
+		},
+		{
+			name: "empty batch",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			packets, err := gateway_agent.ParseNFLOGMessages(tc.messages)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, packets)
+		})
+	}
+}
+
+func TestParseNFLOGAttributes(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		attrs    []byte
+		expected gateway_agent.NFLOGPacket
+	}{
+		{
+			name:     "prefix is trimmed at the first null byte",
+			attrs:    nlattr(nfulaPrefix, []byte("prefix\x00garbage")),
+			expected: gateway_agent.NFLOGPacket{Prefix: "prefix"},
+		},
+		{
+			name:     "prefix without null byte",
+			attrs:    nlattr(nfulaPrefix, []byte("prefix")),
+			expected: gateway_agent.NFLOGPacket{Prefix: "prefix"},
+		},
+		{
+			name:     "unknown attributes are skipped",
+			attrs:    concat(nlattr(1, []byte{1, 2, 3, 4, 5}), nlattr(nfulaPayload, []byte{1})),
+			expected: gateway_agent.NFLOGPacket{Payload: []byte{1}},
+		},
+		{
+			name:     "attribute flags are ignored",
+			attrs:    nlattr(unix.NLA_F_NESTED|nfulaPayload, []byte{1, 2}),
+			expected: gateway_agent.NFLOGPacket{Payload: []byte{1, 2}},
+		},
+		{
+			name:     "parsing stops at an attribute longer than the message",
+			attrs:    concat(nlattr(nfulaPrefix, []byte("prefix")), nlattr(nfulaPayload, []byte{1, 2, 3, 4})[:6]),
+			expected: gateway_agent.NFLOGPacket{Prefix: "prefix"},
+		},
+		{
+			name:     "parsing stops at an attribute shorter than its header",
+			attrs:    concat(nlattr(nfulaPrefix, []byte("prefix")), []byte{2, 0, 0, 0}, nlattr(nfulaPayload, []byte{1})),
+			expected: gateway_agent.NFLOGPacket{Prefix: "prefix"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, gateway_agent.ParseNFLOGAttributes(tc.attrs))
+		})
+	}
+}
+
+func TestParseNFLOGAttributesCopiesPayload(t *testing.T) {
+	attrs := nlattr(nfulaPayload, []byte{1, 2})
+	packet := gateway_agent.ParseNFLOGAttributes(attrs)
+	attrs[unix.SizeofNlAttr] = 0xff
+	assert.Equal(t, []byte{1, 2}, packet.Payload, "the payload must not refer to the reused read buffer")
+}
+
+func TestParseNetlinkAck(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		ack      []byte
+		expected error
+		err      bool
+	}{
+		{name: "success", ack: ack(0)},
+		{name: "error number", ack: ack(-int32(unix.EPERM)), expected: unix.EPERM, err: true},
+		{name: "truncated", ack: ack(0)[:unix.SizeofNlMsghdr+2], err: true},
+		{name: "not an acknowledgement", ack: nlmsg(unix.NLMSG_DONE, make([]byte, 4)), err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := gateway_agent.ParseNetlinkAck(tc.ack)
+			if !tc.err {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			if tc.expected != nil {
+				assert.Equal(t, tc.expected, err)
+			}
+		})
+	}
+}
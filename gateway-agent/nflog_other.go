@@ -0,0 +1,12 @@
+// +build !linux
+
+package gateway_agent
+
+import (
+	"fmt"
+)
+
+// ListenNFLOG is only supported on linux.
+func (l *FlowLogger) ListenNFLOG(group uint16) error {
+	return fmt.Errorf("flow logging through NFLOG is only supported on linux")
+}
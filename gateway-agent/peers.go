@@ -0,0 +1,221 @@
+package gateway_agent
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/nais/device/pkg/pb"
+	"github.com/nais/device/pkg/wireguard"
+)
+
+// Peer is a WireGuard peer as seen on the gateway interface.
+type Peer struct {
+	PublicKey  string
+	AllowedIPs []string
+	Endpoint   string
+}
+
+// WireGuard manages the peers of a single WireGuard interface.
+type WireGuard interface {
+	Peers() ([]Peer, error)
+	SetPeer(peer Peer) error
+	RemovePeer(publicKey string) error
+}
+
+// PeerDiff is the set of changes needed to go from the current to the desired peers.
+type PeerDiff struct {
+	Added   []Peer
+	Updated []Peer
+	Removed []string
+}
+
+type wgCommand struct {
+	iface string
+}
+
+// NewWireGuard returns a WireGuard implementation using the `wg` command on the given interface.
+func NewWireGuard(iface string) WireGuard {
+	return &wgCommand{iface: iface}
+}
+
+func (w *wgCommand) Peers() ([]Peer, error) {
+	output, err := exec.Command("wg", "show", w.iface, "dump").Output()
+	if err != nil {
+		return nil, fmt.Errorf("dumping WireGuard interface %s: %w", w.iface, err)
+	}
+
+	dump, err := wireguard.ParseDump(bytes.NewReader(output))
+	if err != nil {
+		return nil, fmt.Errorf("parsing WireGuard interface %s: %w", w.iface, err)
+	}
+
+	peers := make([]Peer, len(dump))
+	for i, peer := range dump {
+		peers[i] = Peer{
+			PublicKey:  peer.PublicKey,
+			AllowedIPs: peer.AllowedIPs,
+			Endpoint:   peer.Endpoint,
+		}
+	}
+
+	return peers, nil
+}
+
+func (w *wgCommand) SetPeer(peer Peer) error {
+	args := []string{"set", w.iface, "peer", peer.PublicKey, "allowed-ips", strings.Join(peer.AllowedIPs, ",")}
+	if len(peer.Endpoint) > 0 {
+		args = append(args, "endpoint", peer.Endpoint)
+	}
+
+	if out, err := exec.Command("wg", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("setting peer %s: %w: %s", peer.PublicKey, err, string(out))
+	}
+
+	return nil
+}
+
+func (w *wgCommand) RemovePeer(publicKey string) error {
+	if out, err := exec.Command("wg", "set", w.iface, "peer", publicKey, "remove").CombinedOutput(); err != nil {
+		return fmt.Errorf("removing peer %s: %w: %s", publicKey, err, string(out))
+	}
+
+	return nil
+}
+
+// StaticPeers returns the peers every gateway has regardless of gateway configuration.
+func StaticPeers(cfg Config) []Peer {
+	return []Peer{
+		{
+			PublicKey:  cfg.BootstrapConfig.PublicKey,
+			AllowedIPs: []string{cfg.BootstrapConfig.APIServerIP + "/32"},
+			Endpoint:   cfg.BootstrapConfig.TunnelEndpoint,
+		},
+		{
+			PublicKey:  cfg.PrometheusPublicKey,
+			AllowedIPs: []string{cfg.PrometheusTunnelIP + "/32"},
+		},
+	}
+}
+
+// DevicePeers returns one peer per device in the gateway configuration.
+func DevicePeers(devices []*pb.Device) []Peer {
+	peers := make([]Peer, len(devices))
+	for i, device := range devices {
+		peers[i] = Peer{
+			PublicKey:  device.GetPublicKey(),
+			AllowedIPs: []string{device.GetIp() + "/32"},
+		}
+		if len(device.GetIpv6()) > 0 {
+			peers[i].AllowedIPs = append(peers[i].AllowedIPs, device.GetIpv6()+"/128")
+		}
+	}
+
+	return peers
+}
+
+// DiffPeers compares the peers currently on the interface with the desired peers.
+// Endpoints are not compared, as device endpoints roam and are learned from handshakes.
+func DiffPeers(current, desired []Peer) PeerDiff {
+	var diff PeerDiff
+
+	existing := make(map[string]Peer, len(current))
+	for _, peer := range current {
+		existing[peer.PublicKey] = peer
+	}
+
+	wanted := make(map[string]bool, len(desired))
+	for _, peer := range desired {
+		wanted[peer.PublicKey] = true
+
+		old, ok := existing[peer.PublicKey]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, peer)
+		case !sameAllowedIPs(old.AllowedIPs, peer.AllowedIPs):
+			diff.Updated = append(diff.Updated, peer)
+		}
+	}
+
+	for _, peer := range current {
+		if !wanted[peer.PublicKey] {
+			diff.Removed = append(diff.Removed, peer.PublicKey)
+		}
+	}
+
+	return diff
+}
+
+// SyncPeers brings the peers on the interface in line with the desired peers, and returns the changes that were
+// successfully applied. Peers without a public key are skipped, and a peer that fails does not stop the others from
+// being applied; all the failures are returned together.
+func SyncPeers(wg WireGuard, desired []Peer) (PeerDiff, error) {
+	var applied PeerDiff
+
+	current, err := wg.Peers()
+	if err != nil {
+		return applied, fmt.Errorf("listing current peers: %w", err)
+	}
+
+	var errors *multierror.Error
+
+	valid := make([]Peer, 0, len(desired))
+	for _, peer := range desired {
+		if len(peer.PublicKey) == 0 {
+			errors = multierror.Append(errors, fmt.Errorf("skipping peer with allowed ips %v: no public key", peer.AllowedIPs))
+			continue
+		}
+		valid = append(valid, peer)
+	}
+
+	diff := DiffPeers(current, valid)
+
+	for _, publicKey := range diff.Removed {
+		if err := wg.RemovePeer(publicKey); err != nil {
+			errors = multierror.Append(errors, err)
+			continue
+		}
+		applied.Removed = append(applied.Removed, publicKey)
+	}
+
+	for _, peer := range diff.Added {
+		if err := wg.SetPeer(peer); err != nil {
+			errors = multierror.Append(errors, err)
+			continue
+		}
+		applied.Added = append(applied.Added, peer)
+	}
+
+	for _, peer := range diff.Updated {
+		if err := wg.SetPeer(peer); err != nil {
+			errors = multierror.Append(errors, err)
+			continue
+		}
+		applied.Updated = append(applied.Updated, peer)
+	}
+
+	return applied, errors.ErrorOrNil()
+}
+
+func sameAllowedIPs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,128 @@
+package gateway_agent_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	gateway_agent "github.com/nais/device/gateway-agent"
+	"github.com/nais/device/pkg/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeWireGuard struct {
+	peers map[string]gateway_agent.Peer
+	calls []string
+	// broken are the public keys wg refuses to set or remove
+	broken map[string]bool
+}
+
+func newFakeWireGuard(peers ...gateway_agent.Peer) *fakeWireGuard {
+	f := &fakeWireGuard{peers: make(map[string]gateway_agent.Peer)}
+	for _, peer := range peers {
+		f.peers[peer.PublicKey] = peer
+	}
+	return f
+}
+
+func (f *fakeWireGuard) Peers() ([]gateway_agent.Peer, error) {
+	var peers []gateway_agent.Peer
+	for _, peer := range f.peers {
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].PublicKey < peers[j].PublicKey })
+	return peers, nil
+}
+
+func (f *fakeWireGuard) SetPeer(peer gateway_agent.Peer) error {
+	f.calls = append(f.calls, fmt.Sprintf("set %s", peer.PublicKey))
+	if f.broken[peer.PublicKey] {
+		return fmt.Errorf("setting peer %s: invalid key", peer.PublicKey)
+	}
+	f.peers[peer.PublicKey] = peer
+	return nil
+}
+
+func (f *fakeWireGuard) RemovePeer(publicKey string) error {
+	f.calls = append(f.calls, fmt.Sprintf("remove %s", publicKey))
+	if f.broken[publicKey] {
+		return fmt.Errorf("removing peer %s: invalid key", publicKey)
+	}
+	delete(f.peers, publicKey)
+	return nil
+}
+
+func TestSyncPeers(t *testing.T) {
+	wg := newFakeWireGuard(
+		gateway_agent.Peer{PublicKey: "unchanged", AllowedIPs: []string{"10.255.240.2/32"}, Endpoint: "1.2.3.4:51820"},
+		gateway_agent.Peer{PublicKey: "moved", AllowedIPs: []string{"10.255.240.3/32"}},
+		gateway_agent.Peer{PublicKey: "removed", AllowedIPs: []string{"10.255.240.4/32"}},
+	)
+
+	desired := gateway_agent.DevicePeers([]*pb.Device{
+		{PublicKey: "unchanged", Ip: "10.255.240.2"},
+		{PublicKey: "moved", Ip: "10.255.240.5"},
+		{PublicKey: "added", Ip: "10.255.240.6"},
+	})
+
+	diff, err := gateway_agent.SyncPeers(wg, desired)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"removed"}, diff.Removed)
+	assert.Len(t, diff.Added, 1)
+	assert.Equal(t, "added", diff.Added[0].PublicKey)
+	assert.Len(t, diff.Updated, 1)
+	assert.Equal(t, "moved", diff.Updated[0].PublicKey)
+	assert.Equal(t, []string{"remove removed", "set added", "set moved"}, wg.calls)
+
+	wg.calls = nil
+	diff, err = gateway_agent.SyncPeers(wg, desired)
+	assert.NoError(t, err)
+	assert.Empty(t, diff.Added)
+	assert.Empty(t, diff.Updated)
+	assert.Empty(t, diff.Removed)
+	assert.Empty(t, wg.calls, "no changes when already in sync")
+}
+
+func TestSyncPeersPartialFailure(t *testing.T) {
+	wg := newFakeWireGuard(
+		gateway_agent.Peer{PublicKey: "stuck", AllowedIPs: []string{"10.255.240.2/32"}},
+		gateway_agent.Peer{PublicKey: "removed", AllowedIPs: []string{"10.255.240.3/32"}},
+	)
+	wg.broken = map[string]bool{"stuck": true, "malformed": true}
+
+	desired := append([]gateway_agent.Peer{
+		{AllowedIPs: []string{"10.255.247.254/32"}}, // static peer without a configured public key
+	}, gateway_agent.DevicePeers([]*pb.Device{
+		{PublicKey: "malformed", Ip: "10.255.240.4"},
+		{PublicKey: "added", Ip: "10.255.240.5"},
+	})...)
+
+	diff, err := gateway_agent.SyncPeers(wg, desired)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no public key")
+	assert.Contains(t, err.Error(), "removing peer stuck")
+	assert.Contains(t, err.Error(), "setting peer malformed")
+
+	assert.Equal(t, []string{"removed"}, diff.Removed)
+	assert.Len(t, diff.Added, 1)
+	assert.Equal(t, "added", diff.Added[0].PublicKey)
+	assert.Equal(t, []string{"remove removed", "remove stuck", "set malformed", "set added"}, wg.calls)
+}
+
+func TestDevicePeers(t *testing.T) {
+	peers := gateway_agent.DevicePeers([]*pb.Device{
+		{PublicKey: "v4", Ip: "10.255.240.2"},
+		{PublicKey: "dualstack", Ip: "10.255.240.3", Ipv6: "fd75:568f:f19::3"},
+	})
+
+	assert.Equal(t, []string{"10.255.240.2/32"}, peers[0].AllowedIPs)
+	assert.Equal(t, []string{"10.255.240.3/32", "fd75:568f:f19::3/128"}, peers[1].AllowedIPs)
+}
+
+func TestDiffPeersIgnoresAllowedIPsOrder(t *testing.T) {
+	current := []gateway_agent.Peer{{PublicKey: "peer", AllowedIPs: []string{"b", "a"}}}
+	desired := []gateway_agent.Peer{{PublicKey: "peer", AllowedIPs: []string{"a", "b"}}}
+
+	diff := gateway_agent.DiffPeers(current, desired)
+	assert.Empty(t, diff.Updated)
+}
@@ -13,6 +13,11 @@ var (
 	RegisteredDevices         prometheus.Gauge
 	ConnectedDevices          prometheus.Gauge
 	CurrentVersion            prometheus.Counter
+	PeersAdded                prometheus.Counter
+	PeersUpdated              prometheus.Counter
+	PeersRemoved              prometheus.Counter
+	ConnectionsForwarded      *prometheus.CounterVec
+	FlowParseErrors           prometheus.Counter
 )
 
 func Serve(address string) {
@@ -56,10 +61,50 @@ func InitializeMetrics(name, version string) {
 		Subsystem:   "gateway_agent",
 		ConstLabels: prometheus.Labels{"name": name, "version": version},
 	})
+	PeersAdded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "peers_added",
+		Help:        "count of WireGuard peers added to the gateway",
+		Namespace:   "naisdevice",
+		Subsystem:   "gateway_agent",
+		ConstLabels: prometheus.Labels{"name": name, "version": version},
+	})
+	PeersUpdated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "peers_updated",
+		Help:        "count of WireGuard peers with changed allowed ips on the gateway",
+		Namespace:   "naisdevice",
+		Subsystem:   "gateway_agent",
+		ConstLabels: prometheus.Labels{"name": name, "version": version},
+	})
+	PeersRemoved = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "peers_removed",
+		Help:        "count of WireGuard peers removed from the gateway",
+		Namespace:   "naisdevice",
+		Subsystem:   "gateway_agent",
+		ConstLabels: prometheus.Labels{"name": name, "version": version},
+	})
+	ConnectionsForwarded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "connections_forwarded",
+		Help:        "count of new connections forwarded by the gateway, by user and route",
+		Namespace:   "naisdevice",
+		Subsystem:   "gateway_agent",
+		ConstLabels: prometheus.Labels{"name": name, "version": version},
+	}, []string{"username", "route"})
+	FlowParseErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "flow_parse_errors",
+		Help:        "count of logged packets that could not be parsed into connections",
+		Namespace:   "naisdevice",
+		Subsystem:   "gateway_agent",
+		ConstLabels: prometheus.Labels{"name": name, "version": version},
+	})
 
 	prometheus.MustRegister(FailedConfigFetches)
 	prometheus.MustRegister(LastSuccessfulConfigFetch)
 	prometheus.MustRegister(RegisteredDevices)
 	prometheus.MustRegister(ConnectedDevices)
 	prometheus.MustRegister(CurrentVersion)
+	prometheus.MustRegister(PeersAdded)
+	prometheus.MustRegister(PeersUpdated)
+	prometheus.MustRegister(PeersRemoved)
+	prometheus.MustRegister(ConnectionsForwarded)
+	prometheus.MustRegister(FlowParseErrors)
 }
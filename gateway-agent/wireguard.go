@@ -8,7 +8,7 @@ import (
 	"regexp"
 )
 
-func SetupInterface(tunnelIP string) error {
+func SetupInterface(tunnelIP, tunnelIPv6 string) error {
 	if err := exec.Command("ip", "link", "del", "wg0").Run(); err != nil {
 		log.Infof("pre-deleting WireGuard interface (ok if this fails): %v", err)
 	}
@@ -33,6 +33,10 @@ func SetupInterface(tunnelIP string) error {
 		{"ip", "link", "set", "wg0", "up"},
 	}
 
+	if len(tunnelIPv6) > 0 {
+		commands = append(commands, []string{"ip", "-6", "address", "add", "dev", "wg0", tunnelIPv6 + "/64"})
+	}
+
 	return run(commands)
 }
 
@@ -55,20 +59,6 @@ AllowedIPs = %s/32
 	return fmt.Sprintf(template, cfg.PrivateKey, cfg.BootstrapConfig.PublicKey, cfg.BootstrapConfig.APIServerIP, cfg.BootstrapConfig.TunnelEndpoint, cfg.PrometheusPublicKey, cfg.PrometheusTunnelIP)
 }
 
-func GenerateWireGuardPeers(devices []Device) string {
-	peerTemplate := `[Peer]
-PublicKey = %s
-AllowedIPs = %s
-`
-	var peers string
-
-	for _, device := range devices {
-		peers += fmt.Sprintf(peerTemplate, device.PublicKey, device.IP)
-	}
-
-	return peers
-}
-
 // ActuateWireGuardConfig runs syncconfig with the provided WireGuard config
 func ActuateWireGuardConfig(wireGuardConfig, wireGuardConfigPath string) error {
 	if err := ioutil.WriteFile(wireGuardConfigPath, []byte(wireGuardConfig), 0600); err != nil {
@@ -0,0 +1,248 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nais/device/pkg/audit"
+	"github.com/nais/device/pkg/pb"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Client talks to the apiserver admin API.
+type Client struct {
+	URL        string
+	Username   string
+	Password   string
+	HTTPClient *http.Client
+}
+
+func (c *Client) Devices(ctx context.Context) ([]Device, error) {
+	var devices []Device
+	err := c.do(ctx, http.MethodGet, "/devices", nil, &devices)
+	return devices, err
+}
+
+func (c *Client) Device(ctx context.Context, deviceID int) (*Device, error) {
+	var device Device
+	return &device, c.do(ctx, http.MethodGet, devicePath(deviceID), nil, &device)
+}
+
+func (c *Client) DisableDevice(ctx context.Context, deviceID int) error {
+	return c.do(ctx, http.MethodPost, devicePath(deviceID)+"/disable", nil, nil)
+}
+
+func (c *Client) EnableDevice(ctx context.Context, deviceID int) error {
+	return c.do(ctx, http.MethodPost, devicePath(deviceID)+"/enable", nil, nil)
+}
+
+func (c *Client) DeleteDevice(ctx context.Context, deviceID int) error {
+	return c.do(ctx, http.MethodDelete, devicePath(deviceID), nil, nil)
+}
+
+func (c *Client) RenameDevice(ctx context.Context, deviceID int, name string) error {
+	return c.do(ctx, http.MethodPut, devicePath(deviceID)+"/name", map[string]string{"name": name}, nil)
+}
+
+func (c *Client) RekeyDevice(ctx context.Context, deviceID int, publicKey string) error {
+	return c.do(ctx, http.MethodPut, devicePath(deviceID)+"/publickey", map[string]string{"publicKey": publicKey}, nil)
+}
+
+// TransferDevice gives the device to another user, revoking the sessions of its previous owner.
+func (c *Client) TransferDevice(ctx context.Context, deviceID int, username string) error {
+	return c.do(ctx, http.MethodPut, devicePath(deviceID)+"/owner", map[string]string{"username": username}, nil)
+}
+
+// OwnershipTransfers returns the ownership transfers of the device, oldest first.
+func (c *Client) OwnershipTransfers(ctx context.Context, deviceID int) ([]OwnershipTransfer, error) {
+	var transfers []OwnershipTransfer
+	err := c.do(ctx, http.MethodGet, devicePath(deviceID)+"/transfers", nil, &transfers)
+	return transfers, err
+}
+
+// Enrollments returns the device enrollments requiring approval, or only those in the given state if not empty.
+func (c *Client) Enrollments(ctx context.Context, state string) ([]Enrollment, error) {
+	path := "/enrollments"
+	if len(state) > 0 {
+		path += "?state=" + url.QueryEscape(state)
+	}
+
+	var enrollments []Enrollment
+	err := c.do(ctx, http.MethodGet, path, nil, &enrollments)
+	return enrollments, err
+}
+
+func (c *Client) ApproveEnrollment(ctx context.Context, enrollmentID int) error {
+	return c.do(ctx, http.MethodPost, enrollmentPath(enrollmentID)+"/approve", nil, nil)
+}
+
+func (c *Client) RejectEnrollment(ctx context.Context, enrollmentID int) error {
+	return c.do(ctx, http.MethodPost, enrollmentPath(enrollmentID)+"/reject", nil, nil)
+}
+
+// DeleteEnrollment forgets the decision, so the device requires approval again on its next enrollment.
+func (c *Client) DeleteEnrollment(ctx context.Context, enrollmentID int) error {
+	return c.do(ctx, http.MethodDelete, enrollmentPath(enrollmentID), nil, nil)
+}
+
+// AuditEvents returns the audit events matching the query, newest first.
+func (c *Client) AuditEvents(ctx context.Context, q audit.Query) ([]audit.Event, error) {
+	params := url.Values{}
+	for key, value := range map[string]string{"action": q.Action, "actor": q.Actor, "target": q.Target} {
+		if len(value) > 0 {
+			params.Set(key, value)
+		}
+	}
+	if !q.Since.IsZero() {
+		params.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		params.Set("until", q.Until.Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	path := "/audit"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	var events []audit.Event
+	err := c.do(ctx, http.MethodGet, path, nil, &events)
+	return events, err
+}
+
+func (c *Client) Sessions(ctx context.Context) ([]Session, error) {
+	var sessions []Session
+	err := c.do(ctx, http.MethodGet, "/sessions", nil, &sessions)
+	return sessions, err
+}
+
+// RevokeSessions revokes all sessions of the device, requiring its user to log in again.
+func (c *Client) RevokeSessions(ctx context.Context, deviceID int) error {
+	return c.do(ctx, http.MethodDelete, devicePath(deviceID)+"/sessions", nil, nil)
+}
+
+// RevokeSession revokes a single session by its key.
+func (c *Client) RevokeSession(ctx context.Context, key string) error {
+	return c.do(ctx, http.MethodDelete, "/sessions/"+url.PathEscape(key), nil, nil)
+}
+
+// RevokeUserSessions revokes the sessions of the user with the given object ID on all devices.
+func (c *Client) RevokeUserSessions(ctx context.Context, objectID string) error {
+	return c.do(ctx, http.MethodDelete, "/users/"+url.PathEscape(objectID)+"/sessions", nil, nil)
+}
+
+func (c *Client) Gateways(ctx context.Context) ([]*pb.Gateway, error) {
+	var gateways []*pb.Gateway
+	err := c.do(ctx, http.MethodGet, "/gateways", nil, &gateways)
+	return gateways, err
+}
+
+func (c *Client) Gateway(ctx context.Context, name string) (*pb.Gateway, error) {
+	gateway := &pb.Gateway{}
+	err := c.do(ctx, http.MethodGet, gatewayPath(name), nil, gateway)
+	return gateway, err
+}
+
+// Admissions returns the devices the gateway currently admits.
+func (c *Client) Admissions(ctx context.Context, gateway string) ([]Admission, error) {
+	var admissions []Admission
+	err := c.do(ctx, http.MethodGet, gatewayPath(gateway)+"/admissions", nil, &admissions)
+	return admissions, err
+}
+
+func (c *Client) DisableGateway(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, gatewayPath(name)+"/disable", nil, nil)
+}
+
+func (c *Client) EnableGateway(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, gatewayPath(name)+"/enable", nil, nil)
+}
+
+func (c *Client) DeleteGateway(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, gatewayPath(name), nil, nil)
+}
+
+func (c *Client) RenameGateway(ctx context.Context, name, newName string) error {
+	return c.do(ctx, http.MethodPut, gatewayPath(name)+"/name", map[string]string{"name": newName}, nil)
+}
+
+func (c *Client) RekeyGateway(ctx context.Context, name, publicKey string) error {
+	return c.do(ctx, http.MethodPut, gatewayPath(name)+"/publickey", map[string]string{"publicKey": publicKey}, nil)
+}
+
+func devicePath(deviceID int) string {
+	return fmt.Sprintf("/devices/%d", deviceID)
+}
+
+func enrollmentPath(enrollmentID int) string {
+	return fmt.Sprintf("/enrollments/%d", enrollmentID)
+}
+
+func gatewayPath(name string) string {
+	return "/gateways/" + url.PathEscape(name)
+}
+
+// do sends a request to the admin API, encoding body and decoding the response into out if they are non-nil.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.URL, "/")+"/admin"+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	req.SetBasicAuth(c.Username, c.Password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%s %s: %w", method, path, ErrNotFound)
+	case resp.StatusCode >= 300:
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nais/device/pkg/admin"
+	"github.com/nais/device/pkg/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		assert.Equal(t, "admin", username)
+		assert.Equal(t, "password", password)
+
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath())
+
+		switch r.URL.Path {
+		case "/admin/devices":
+			json.NewEncoder(w).Encode([]admin.Device{{ID: 1, Username: "user", Serial: "serial"}})
+		case "/admin/devices/1/name":
+			var body map[string]string
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, "laptop", body["name"])
+			w.WriteHeader(http.StatusNoContent)
+		case "/admin/gateways/my gateway/admissions":
+			json.NewEncoder(w).Encode([]admin.Admission{{DeviceID: 1, Username: "user", Routes: []string{"10.0.0.0/24"}}})
+		case "/admin/enrollments":
+			assert.Equal(t, "pending", r.URL.Query().Get("state"))
+			json.NewEncoder(w).Encode([]admin.Enrollment{{ID: 4, Username: "user", State: "pending"}})
+		case "/admin/audit":
+			assert.Equal(t, "login", r.URL.Query().Get("action"))
+			assert.Equal(t, "10", r.URL.Query().Get("limit"))
+			json.NewEncoder(w).Encode([]audit.Event{{ID: 1, Action: "login", Actor: "user"}})
+		case "/admin/enrollments/4/approve":
+			w.WriteHeader(http.StatusNoContent)
+		case "/admin/devices/2/publickey":
+			http.Error(w, "invalid public key", http.StatusBadRequest)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := &admin.Client{URL: server.URL + "/", Username: "admin", Password: "password"}
+	ctx := context.Background()
+
+	devices, err := client.Devices(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []admin.Device{{ID: 1, Username: "user", Serial: "serial"}}, devices)
+
+	assert.NoError(t, client.RenameDevice(ctx, 1, "laptop"))
+
+	admissions, err := client.Admissions(ctx, "my gateway")
+	assert.NoError(t, err)
+	assert.Len(t, admissions, 1)
+	assert.Equal(t, []string{"10.0.0.0/24"}, admissions[0].Routes)
+
+	enrollments, err := client.Enrollments(ctx, "pending")
+	assert.NoError(t, err)
+	assert.Len(t, enrollments, 1)
+	assert.NoError(t, client.ApproveEnrollment(ctx, enrollments[0].ID))
+
+	events, err := client.AuditEvents(ctx, audit.Query{Action: "login", Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "user", events[0].Actor)
+
+	err = client.RekeyDevice(ctx, 2, "key")
+	assert.EqualError(t, err, "PUT /devices/2/publickey: 400 Bad Request: invalid public key")
+
+	_, err = client.Device(ctx, 3)
+	assert.True(t, errors.Is(err, admin.ErrNotFound))
+
+	assert.Equal(t, []string{
+		"GET /admin/devices",
+		"PUT /admin/devices/1/name",
+		"GET /admin/gateways/my%20gateway/admissions",
+		"GET /admin/enrollments",
+		"POST /admin/enrollments/4/approve",
+		"GET /admin/audit",
+		"PUT /admin/devices/2/publickey",
+		"GET /admin/devices/3",
+	}, requests)
+}
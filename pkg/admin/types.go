@@ -0,0 +1,66 @@
+package admin
+
+import "time"
+
+// Device is a device as returned by the apiserver admin API.
+type Device struct {
+	ID             int    `json:"ID"`
+	Serial         string `json:"serial"`
+	LastUpdated    *int64 `json:"lastUpdated"`
+	KolideLastSeen *int64 `json:"kolideLastSeen"`
+	Healthy        *bool  `json:"isHealthy"`
+	PublicKey      string `json:"publicKey"`
+	IP             string `json:"ip"`
+	IPv6           string `json:"ipv6"`
+	Username       string `json:"username"`
+	Platform       string `json:"platform"`
+	Name           string `json:"name"`
+	Disabled       bool   `json:"disabled"`
+}
+
+// Session is an active device session. The session key is never exposed through the admin API.
+type Session struct {
+	DeviceID int      `json:"deviceID"`
+	Username string   `json:"username"`
+	Serial   string   `json:"serial"`
+	Platform string   `json:"platform"`
+	ObjectID string   `json:"objectID"`
+	Groups   []string `json:"groups"`
+	Expiry   int64    `json:"expiry"`
+}
+
+// Admission is a device that a gateway currently admits, along with the routes it may reach.
+type Admission struct {
+	DeviceID int      `json:"deviceID"`
+	Username string   `json:"username"`
+	ObjectID string   `json:"objectID"`
+	Serial   string   `json:"serial"`
+	Platform string   `json:"platform"`
+	IP       string   `json:"ip"`
+	Routes   []string `json:"routes"`
+}
+
+// Enrollment is a device enrollment requiring approval, in state pending, approved or rejected.
+type Enrollment struct {
+	ID           int        `json:"id"`
+	Serial       string     `json:"serial"`
+	Platform     string     `json:"platform"`
+	Username     string     `json:"username"`
+	PublicKey    string     `json:"publicKey"`
+	State        string     `json:"state"`
+	Created      time.Time  `json:"created"`
+	Decided      *time.Time `json:"decided"`
+	DecidedBy    string     `json:"decidedBy"`
+	Acknowledged bool       `json:"acknowledged"`
+}
+
+// OwnershipTransfer records that an administrator transferred a device from one user to another.
+type OwnershipTransfer struct {
+	DeviceID      int       `json:"deviceID"`
+	Serial        string    `json:"serial"`
+	Platform      string    `json:"platform"`
+	FromUsername  string    `json:"fromUsername"`
+	ToUsername    string    `json:"toUsername"`
+	TransferredBy string    `json:"transferredBy"`
+	Created       time.Time `json:"created"`
+}
@@ -0,0 +1,183 @@
+// Package audit records security relevant events, such as logins, enrollments and access decisions, to sinks
+// like a database table, a JSON lines file or a webhook.
+package audit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Actions recorded by the apiserver and the bootstrap-api.
+const (
+	ActionLogin                  = "login"
+	ActionSessionCreated         = "session_created"
+	ActionSessionRevoked         = "session_revoked"
+	ActionEnrollmentQueued       = "enrollment_queued"
+	ActionEnrollmentAcknowledged = "enrollment_acknowledged"
+	ActionEnrollmentStateChanged = "enrollment_state_changed"
+	ActionEnrollmentDecided      = "enrollment_decided"
+	ActionDeviceEnrolled         = "device_enrolled"
+	ActionDeviceRejected         = "device_rejected"
+	ActionGatewayEnrolled        = "gateway_enrolled"
+	ActionGatewayConfigChanged   = "gateway_config_changed"
+	ActionJitaGrantObserved      = "jita_grant_observed"
+	ActionJitaGrantEnded         = "jita_grant_ended"
+	ActionGatewayDeviceAdmitted  = "gateway_device_admitted"
+	ActionGatewayDeviceDropped   = "gateway_device_dropped"
+	ActionAdminRequest           = "admin_request"
+)
+
+// Outcomes of recorded actions.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+const (
+	queueSize    = 1024             // events buffered for each sink before new events are dropped
+	sinkTimeout  = 5 * time.Second  // timeout for writing an event to a single sink
+	drainTimeout = 10 * time.Second // how long Run keeps writing queued events after the context is done
+)
+
+// Event is something that happened, done by the actor to the target. Details hold any further context.
+type Event struct {
+	ID        int64             `json:"id,omitempty"`
+	Time      time.Time         `json:"time"`
+	Component string            `json:"component"`
+	Action    string            `json:"action"`
+	Outcome   string            `json:"outcome"`
+	Actor     string            `json:"actor,omitempty"`
+	Target    string            `json:"target,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// Query selects events. Empty fields match any event.
+type Query struct {
+	Action string
+	Actor  string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// Log queues events for its sinks, so recording an event never blocks the caller. A nil Log discards all events.
+type Log struct {
+	component string
+	sinks     []Sink
+	events    chan Event
+}
+
+func New(component string, sinks ...Sink) *Log {
+	return &Log{
+		component: component,
+		sinks:     sinks,
+		events:    make(chan Event, queueSize),
+	}
+}
+
+// Record queues the event, setting its time and component unless already set.
+// Events are dropped if the sinks can not keep up.
+func (l *Log) Record(e Event) {
+	if l == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if len(e.Component) == 0 {
+		e.Component = l.component
+	}
+
+	select {
+	case l.events <- e:
+	default:
+		log.Warnf("Audit: dropping %s event, queue is full", e.Action)
+		EventsDropped.Inc()
+	}
+}
+
+// Run writes queued events to the sinks until the context is done, then writes the events still queued for up to
+// drainTimeout before returning. Each sink has its own queue, so a slow or unavailable sink does not hold up the others.
+func (l *Log) Run(ctx context.Context) {
+	writeCtx, cancelWrites := context.WithCancel(context.Background())
+	defer cancelWrites()
+
+	var wg sync.WaitGroup
+	queues := make([]chan Event, len(l.sinks))
+	for i, sink := range l.sinks {
+		queues[i] = make(chan Event, queueSize)
+		wg.Add(1)
+		go func(sink Sink, queue <-chan Event) {
+			defer wg.Done()
+			for e := range queue {
+				write(writeCtx, sink, e)
+			}
+		}(sink, queues[i])
+	}
+
+	dispatch := func(e Event) {
+		for _, queue := range queues {
+			select {
+			case queue <- e:
+			default:
+				log.Warnf("Audit: dropping %s event, sink queue is full", e.Action)
+				EventsDropped.Inc()
+			}
+		}
+	}
+
+	func() {
+		for {
+			select {
+			case e := <-l.events:
+				dispatch(e)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// drain events recorded before shutdown
+	for len(l.events) > 0 {
+		dispatch(<-l.events)
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(drainTimeout):
+		log.Warnf("Audit: sinks did not finish writing within %v, giving up", drainTimeout)
+		cancelWrites()
+		<-done
+	}
+}
+
+func write(ctx context.Context, sink Sink, e Event) {
+	ctx, cancel := context.WithTimeout(ctx, sinkTimeout)
+	err := sink.Write(ctx, e)
+	cancel()
+
+	if err != nil {
+		log.Errorf("Audit: writing %s event: %v", e.Action, err)
+		SinkErrors.Inc()
+		return
+	}
+
+	EventsWritten.WithLabelValues(e.Action).Inc()
+}
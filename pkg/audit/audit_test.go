@@ -0,0 +1,161 @@
+package audit_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nais/device/pkg/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+	events := make(chan audit.Event, 1)
+	failing := audit.SinkFunc(func(ctx context.Context, e audit.Event) error {
+		return errors.New("unavailable")
+	})
+	l := audit.New("apiserver", failing, audit.SinkFunc(func(ctx context.Context, e audit.Event) error {
+		events <- e
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.Run(ctx)
+
+	l.Record(audit.Event{Action: audit.ActionLogin, Outcome: audit.OutcomeSuccess, Actor: "user"})
+
+	select {
+	case e := <-events:
+		assert.Equal(t, "apiserver", e.Component)
+		assert.Equal(t, "user", e.Actor)
+		assert.False(t, e.Time.IsZero())
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event, a failing sink must not stop the others")
+	}
+}
+
+func TestLogSlowSink(t *testing.T) {
+	events := make(chan audit.Event, 2)
+	release := make(chan struct{})
+	slow := audit.SinkFunc(func(ctx context.Context, e audit.Event) error {
+		<-release
+		return nil
+	})
+	l := audit.New("apiserver", slow, audit.SinkFunc(func(ctx context.Context, e audit.Event) error {
+		events <- e
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.Run(ctx)
+	defer close(release)
+
+	l.Record(audit.Event{Action: audit.ActionLogin, Actor: "a"})
+	l.Record(audit.Event{Action: audit.ActionLogin, Actor: "b"})
+
+	for _, actor := range []string{"a", "b"} {
+		select {
+		case e := <-events:
+			assert.Equal(t, actor, e.Actor)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for event, a slow sink must not hold up the others")
+		}
+	}
+}
+
+func TestLogDrainsOnShutdown(t *testing.T) {
+	var actors []string
+	l := audit.New("apiserver", audit.SinkFunc(func(ctx context.Context, e audit.Event) error {
+		actors = append(actors, e.Actor)
+		return nil
+	}))
+
+	l.Record(audit.Event{Action: audit.ActionLogin, Actor: "a"})
+	l.Record(audit.Event{Action: audit.ActionLogin, Actor: "b"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l.Run(ctx)
+
+	assert.Equal(t, []string{"a", "b"}, actors, "events recorded before shutdown are written before Run returns")
+}
+
+func TestNilLogDiscardsEvents(t *testing.T) {
+	var l *audit.Log
+	l.Record(audit.Event{Action: audit.ActionLogin})
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	sink, err := audit.ParseSink("file:" + path)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, sink.Write(ctx, audit.Event{Action: audit.ActionLogin, Actor: "a"}))
+	assert.NoError(t, sink.Write(ctx, audit.Event{Action: audit.ActionLogin, Actor: "b"}))
+	assert.NoError(t, sink.(*audit.FileSink).Close())
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var actors []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e audit.Event
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		actors = append(actors, e.Actor)
+	}
+	assert.Equal(t, []string{"a", "b"}, actors)
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received audit.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "user" || password != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	withCredentials := strings.Replace(server.URL, "http://", "http://user:secret@", 1)
+	sink, err := audit.ParseSink("webhook:" + withCredentials + "/admin/audit")
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Write(context.Background(), audit.Event{Action: audit.ActionDeviceEnrolled, Target: "serial"}))
+	assert.Equal(t, "serial", received.Target)
+
+	sink, err = audit.ParseSink("webhook:" + server.URL)
+	assert.NoError(t, err)
+	assert.EqualError(t, sink.Write(context.Background(), audit.Event{}), "webhook returned status 401 Unauthorized: unauthorized")
+}
+
+func TestParseSink(t *testing.T) {
+	for _, spec := range []string{"", "file:", "database", "syslog:localhost"} {
+		_, err := audit.ParseSink(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,30 @@
+package audit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	EventsWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      "events_written",
+		Help:      "count of audit events written to a sink",
+		Namespace: "naisdevice",
+		Subsystem: "audit",
+	}, []string{"action"})
+	EventsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "events_dropped",
+		Help:      "count of audit events dropped because the sinks could not keep up",
+		Namespace: "naisdevice",
+		Subsystem: "audit",
+	})
+	SinkErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "sink_errors",
+		Help:      "count of failures writing an audit event to a sink",
+		Namespace: "naisdevice",
+		Subsystem: "audit",
+	})
+)
+
+func InitializeMetrics() {
+	prometheus.MustRegister(EventsWritten, EventsDropped, SinkErrors)
+}
@@ -0,0 +1,5 @@
+// Package azure holds details of the nais.io Azure AD shared by the apiserver and the device-agent.
+package azure
+
+// TenantID is the tenant of the nais.io Azure AD, the default identity provider.
+const TenantID = "62366534-1ec3-4962-8869-9b5535279d0b"
@@ -0,0 +1,104 @@
+package bootstrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	getConfigWait     = 20 * time.Second // how long the bootstrap-api holds each request until the config is available
+	getConfigInterval = 2 * time.Second  // time to wait between requests
+)
+
+var (
+	// ErrAwaitingApproval is returned while the enrollment waits for an administrator. Bootstrapping should be retried later.
+	ErrAwaitingApproval = errors.New("enrollment is awaiting approval")
+	// ErrEnrollmentRejected is returned when the enrollment has been rejected, wrapped with the reason if one is given.
+	ErrEnrollmentRejected = errors.New("enrollment has been rejected")
+)
+
+// PostInfo posts the device or gateway info to the bootstrap-api, enrolling it.
+func PostInfo(url string, info interface{}, client *http.Client) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("marshaling info: %w", err)
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("posting info to bootstrap API (%v): %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("parsing response: %w", err)
+		}
+		log.Warningf("bad response from bootstrap-api, request body: %v", string(body))
+		return fmt.Errorf("bootstrap api (%v) returned status %v", url, resp.Status)
+	}
+
+	return nil
+}
+
+// GetConfig waits up to timeout for the config, which the bootstrap-api keeps serving until it expires,
+// so a lost response can be retried. It gives up early if the enrollment awaits approval or has been rejected.
+func GetConfig(url string, client *http.Client, timeout time.Duration) (*Config, error) {
+	url = fmt.Sprintf("%s?wait=%s", url, getConfigWait)
+	deadline := time.Now().Add(timeout)
+
+	for {
+		config, err := fetchConfig(url, client)
+		if err == nil {
+			log.Debugf("Got bootstrap config from bootstrap api: %v", config)
+			return config, nil
+		}
+
+		if errors.Is(err, ErrAwaitingApproval) || errors.Is(err, ErrEnrollmentRejected) {
+			return nil, err
+		}
+		log.Debugf("Bootstrap config not yet available: %v", err)
+
+		if time.Now().Add(getConfigInterval).After(deadline) {
+			return nil, fmt.Errorf("unable to get boostrap config within %v from %v: %w", timeout, url, err)
+		}
+		time.Sleep(getConfigInterval)
+	}
+}
+
+func fetchConfig(url string, client *http.Client) (*Config, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusAccepted:
+		return nil, ErrAwaitingApproval
+	case http.StatusForbidden:
+		var status EnrollmentStatus
+		if err := json.NewDecoder(resp.Body).Decode(&status); err == nil && len(status.Reason) > 0 {
+			return nil, fmt.Errorf("%w: %s", ErrEnrollmentRejected, status.Reason)
+		}
+		return nil, ErrEnrollmentRejected
+	default:
+		return nil, fmt.Errorf("bootstrap api returned status %v", resp.Status)
+	}
+
+	var config Config
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return nil, fmt.Errorf("decoding bootstrap config: %w", err)
+	}
+
+	return &config, nil
+}
@@ -1,23 +1,28 @@
 package bootstrap
 
 import (
+	"time"
+
 	"github.com/nais/device/pkg/pb"
 )
 
 // Config is the information the device needs to bootstrap it's connection to the APIServer
 type Config struct {
 	DeviceIP       string `json:"deviceIP"`
+	DeviceIPv6     string `json:"deviceIPv6,omitempty"`
 	PublicKey      string `json:"publicKey"`
 	TunnelEndpoint string `json:"tunnelEndpoint"`
 	APIServerIP    string `json:"apiServerIP"`
 }
 
-// DeviceInfo is the information sent by the device during enrollment
+// DeviceInfo is the information sent by the device during enrollment.
+// Owner and Groups are set by the bootstrap-api from the user's token.
 type DeviceInfo struct {
-	Serial    string `json:"serial"`
-	PublicKey string `json:"publicKey"`
-	Platform  string `json:"platform"`
-	Owner     string `json:"owner"`
+	Serial    string   `json:"serial"`
+	PublicKey string   `json:"publicKey"`
+	Platform  string   `json:"platform"`
+	Owner     string   `json:"owner"`
+	Groups    []string `json:"groups,omitempty"`
 }
 
 // GatewayInfo is the info provided by the gateway-agent in order to bootstrap a gateway
@@ -27,6 +32,37 @@ type GatewayInfo struct {
 	PublicKey string `json:"publicKey"`
 }
 
+// EnrollmentState is the state of an enrollment the apiserver has not yet acknowledged with a config
+type EnrollmentState string
+
+const (
+	EnrollmentQueued          EnrollmentState = ""
+	EnrollmentPendingApproval EnrollmentState = "pending_approval"
+	EnrollmentRejected        EnrollmentState = "rejected"
+)
+
+// EnrollmentStatus is returned to the device instead of its config while the enrollment is pending approval or rejected
+type EnrollmentStatus struct {
+	State EnrollmentState `json:"state"`
+	// Reason explains to the user why the enrollment was rejected, if not by an administrator.
+	Reason string `json:"reason,omitempty"`
+}
+
+// DeviceEnrollment is a device enrollment waiting for the apiserver to acknowledge it with a config
+type DeviceEnrollment struct {
+	ID      string          `json:"id"`
+	Created time.Time       `json:"created"`
+	State   EnrollmentState `json:"state,omitempty"`
+	Info    DeviceInfo      `json:"info"`
+}
+
+// GatewayEnrollment is a gateway enrollment waiting for the apiserver to acknowledge it with a config
+type GatewayEnrollment struct {
+	ID      string      `json:"id"`
+	Created time.Time   `json:"created"`
+	Info    GatewayInfo `json:"info"`
+}
+
 func (cfg *Config) Gateway() *pb.Gateway {
 	return &pb.Gateway{
 		PublicKey: cfg.PublicKey,
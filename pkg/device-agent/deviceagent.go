@@ -18,21 +18,37 @@ type DeviceAgentServer struct {
 	AgentStatus  *pb.AgentStatus
 	DeviceHelper pb.DeviceHelperClient
 	lock         sync.Mutex
-	stateChange  chan pb.AgentState
+	events       chan Event
+	stopped      chan struct{} // closed when the event loop has stopped handling events
 	statusChange chan *pb.AgentStatus
 	streams      map[uuid.UUID]pb.DeviceAgent_StatusServer
 }
 
 func (das *DeviceAgentServer) Login(ctx context.Context, request *pb.LoginRequest) (*pb.LoginResponse, error) {
-	das.stateChange <- pb.AgentState_Bootstrapping
+	if err := das.sendEvent(ctx, EventLogin); err != nil {
+		return nil, err
+	}
 	return &pb.LoginResponse{}, nil
 }
 
+// Logout makes the event loop revoke the session on the apiserver before disconnecting.
 func (das *DeviceAgentServer) Logout(ctx context.Context, request *pb.LogoutRequest) (*pb.LogoutResponse, error) {
-	das.stateChange <- pb.AgentState_Disconnecting
+	if err := das.sendEvent(ctx, EventLogout); err != nil {
+		return nil, err
+	}
 	return &pb.LogoutResponse{}, nil
 }
 
+// sendEvent passes the event on to the event loop, giving up if the request is cancelled before it is accepted.
+func (das *DeviceAgentServer) sendEvent(ctx context.Context, event Event) error {
+	select {
+	case das.events <- event:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
 func (das *DeviceAgentServer) Status(request *pb.AgentStatusRequest, statusServer pb.DeviceAgent_StatusServer) error {
 	id := uuid.New()
 
@@ -40,20 +56,24 @@ func (das *DeviceAgentServer) Status(request *pb.AgentStatusRequest, statusServe
 
 	das.lock.Lock()
 	das.streams[id] = statusServer
+	// send the current status while holding the lock, so that it is not sent after a newer status is broadcast
+	err := statusServer.Send(das.AgentStatus)
 	das.lock.Unlock()
 
 	defer func() {
 		log.Infof("grpc: client connection closed")
 		if !request.GetKeepConnectionOnComplete() {
 			log.Infof("grpc: keepalive not requested, tearing down connections...")
-			das.stateChange <- pb.AgentState_Disconnecting
+			select {
+			case das.events <- EventClientGone:
+			case <-das.stopped:
+			}
 		}
 		das.lock.Lock()
 		delete(das.streams, id)
 		das.lock.Unlock()
 	}()
 
-	err := statusServer.Send(das.AgentStatus)
 	if err != nil {
 		return err
 	}
@@ -84,9 +104,11 @@ func (das *DeviceAgentServer) ConfigureJITA(context.Context, *pb.ConfigureJITARe
 }
 
 func (das *DeviceAgentServer) UpdateAgentStatus(status *pb.AgentStatus) {
+	das.lock.Lock()
 	das.AgentStatus = status
+	das.lock.Unlock()
 
-	err := das.BroadcastAgentStatus(das.AgentStatus)
+	err := das.BroadcastAgentStatus(status)
 	if err != nil {
 		log.Errorf("while broadcasting agent status")
 	}
@@ -95,7 +117,8 @@ func (das *DeviceAgentServer) UpdateAgentStatus(status *pb.AgentStatus) {
 func NewServer(helper pb.DeviceHelperClient) *DeviceAgentServer {
 	return &DeviceAgentServer{
 		DeviceHelper: helper,
-		stateChange:  make(chan pb.AgentState, 32),
+		events:       make(chan Event, 32),
+		stopped:      make(chan struct{}),
 		streams:      make(map[uuid.UUID]pb.DeviceAgent_StatusServer, 0),
 	}
 }
@@ -2,24 +2,21 @@ package device_agent
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/nais/device/device-agent/apiserver"
 	"github.com/nais/device/device-agent/auth"
 	"github.com/nais/device/device-agent/runtimeconfig"
+	"github.com/nais/device/pkg/bootstrap"
+	"github.com/nais/device/pkg/gatewayhealth"
 	"github.com/nais/device/pkg/notify"
 	"github.com/nais/device/pkg/pb"
 	"github.com/nais/device/pkg/version"
@@ -27,240 +24,127 @@ import (
 
 const (
 	healthCheckInterval  = 20 * time.Second // how often to healthcheck gateways
+	healthCheckTimeout   = 2 * time.Second  // timeout for the health check of each gateway
 	syncConfigBackoff    = 15 * time.Second // re-queue interval when config synchronization times out
 	syncConfigInterval   = 5 * time.Minute  // how often to synchronize config with apiserver
 	syncConfigTimeout    = 5 * time.Second  // timeout for config synchronization
+	versionCheckDelay    = 5 * time.Second  // time to wait before the first check for a new version, and after a failed one
 	versionCheckInterval = 1 * time.Hour    // how often to check for a new version of naisdevice
 	versionCheckTimeout  = 3 * time.Second  // timeout for new version check
 	authFlowTimeout      = 30 * time.Second // total timeout for authenticating user (AAD login in browser, redirect to localhost, exchange code for token)
+	logoutTimeout        = 3 * time.Second  // timeout for revoking the session on logout
+	renewSessionTimeout  = 10 * time.Second // timeout for renewing the session before it expires
 	authenticateBackoff  = 10 * time.Second // time to wait between authentication attempts
+	approvalPollInterval = 30 * time.Second // how often to check whether a pending enrollment has been approved
 )
 
-func (das *DeviceAgentServer) ConfigureHelper(ctx context.Context, rc *runtimeconfig.RuntimeConfig, gateways []*pb.Gateway) error {
-	_, err := das.DeviceHelper.Configure(ctx, &pb.Configuration{
-		PrivateKey: base64.StdEncoding.EncodeToString(rc.PrivateKey),
-		DeviceIP:   rc.BootstrapConfig.DeviceIP,
-		Gateways:   gateways,
-	})
-	return err
+// Dependencies are the parts of the outside world the state machine acts on.
+type Dependencies struct {
+	Clock               Clock
+	Helper              pb.DeviceHelperClient
+	APIServer           APIServer
+	Auth                Authenticator
+	Notifier            Notifier
+	CheckGateway        func(ctx context.Context, ip string) (time.Duration, error)
+	NewVersionAvailable func(ctx context.Context) (bool, error)
+	Publish             func(status *pb.AgentStatus)
 }
 
-func (das *DeviceAgentServer) EventLoop(rc *runtimeconfig.RuntimeConfig) {
-	var err error
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// APIServer is the part of the apiserver API used by the agent.
+type APIServer interface {
+	GetDeviceConfig(ctx context.Context, sessionKey string) ([]*pb.Gateway, error)
+	RenewSession(ctx context.Context, sessionKey string) (*auth.SessionInfo, error)
+	Logout(ctx context.Context, sessionKey string) error
+}
+
+// Authenticator enrolls the device with the bootstrap API, and logs the user in to the apiserver.
+type Authenticator interface {
+	Bootstrap(ctx context.Context) (*bootstrap.Config, error)
+	Login(ctx context.Context) (*auth.SessionInfo, error)
+}
+
+type Notifier interface {
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type apiServerClient struct {
+	url string
+}
+
+func (c *apiServerClient) GetDeviceConfig(ctx context.Context, sessionKey string) ([]*pb.Gateway, error) {
+	return apiserver.GetDeviceConfig(sessionKey, c.url, ctx)
+}
+
+func (c *apiServerClient) RenewSession(ctx context.Context, sessionKey string) (*auth.SessionInfo, error) {
+	return apiserver.RenewSession(sessionKey, c.url, ctx)
+}
 
+func (c *apiServerClient) Logout(ctx context.Context, sessionKey string) error {
+	return apiserver.Logout(sessionKey, c.url, ctx)
+}
+
+type authenticator struct {
+	rc *runtimeconfig.RuntimeConfig
+}
+
+func (a *authenticator) Bootstrap(ctx context.Context) (*bootstrap.Config, error) {
+	return runtimeconfig.EnsureBootstrapping(a.rc, ctx)
+}
+
+func (a *authenticator) Login(ctx context.Context) (*auth.SessionInfo, error) {
+	return auth.EnsureAuth(nil, ctx, a.rc.Config.APIServer, a.rc.Config.Platform, a.rc.Serial)
+}
+
+type desktopNotifier struct{}
+
+func (desktopNotifier) Infof(format string, args ...interface{}) {
+	notify.Infof(format, args...)
+}
+
+func (desktopNotifier) Errorf(format string, args ...interface{}) {
+	notify.Errorf(format, args...)
+}
+
+// EventLoop runs the state machine of the agent until it receives a signal to quit.
+func (das *DeviceAgentServer) EventLoop(rc *runtimeconfig.RuntimeConfig) {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 
-	syncConfigTicker := time.NewTicker(syncConfigInterval)
-	healthCheckTicker := time.NewTicker(healthCheckInterval)
-	versionCheckTicker := time.NewTicker(5 * time.Second)
-	authenticateTimer := time.NewTimer(1 * time.Hour)
-	authenticateTimer.Stop()
-
-	status := &pb.AgentStatus{}
-	das.stateChange <- status.ConnectionState
-
-	for {
-		das.UpdateAgentStatus(status)
-
-		select {
-		case sig := <-signals:
-			log.Infof("Received signal %s, exiting...", sig)
-			return
-
-		case <-versionCheckTicker.C:
-			ctx, cancel := context.WithTimeout(context.Background(), versionCheckTimeout)
-			status.NewVersionAvailable, err = newVersionAvailable(ctx)
-			cancel()
-
-			if err != nil {
-				log.Errorf("check for new version: %s", err)
-				continue
-			}
-
-			if status.NewVersionAvailable {
-				notify.Infof("New version of device agent available: https://doc.nais.io/device/install#installation")
-				versionCheckTicker.Stop()
-			} else {
-				versionCheckTicker.Reset(versionCheckInterval)
-			}
-
-		case <-syncConfigTicker.C:
-			if status.ConnectionState == pb.AgentState_Connected {
-				das.stateChange <- pb.AgentState_SyncConfig
-			}
-
-		case <-healthCheckTicker.C:
-			if status.ConnectionState == pb.AgentState_Connected {
-				das.stateChange <- pb.AgentState_HealthCheck
-			}
-
-		case <-authenticateTimer.C:
-			switch status.ConnectionState {
-			case pb.AgentState_AuthenticateBackoff:
-				fallthrough
-			case pb.AgentState_Bootstrapping:
-				das.stateChange <- pb.AgentState_Authenticating
-			default:
-				break
-			}
-
-		case status.ConnectionState = <-das.stateChange:
-			log.Infof("state changed to %s", status.ConnectionState)
-
-			switch status.ConnectionState {
-			case pb.AgentState_Bootstrapping:
-				if rc.BootstrapConfig != nil {
-					log.Infof("Already bootstrapped")
-				} else {
-					ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
-					rc.BootstrapConfig, err = runtimeconfig.EnsureBootstrapping(rc, ctx)
-					cancel()
-					if err != nil {
-						notify.Errorf("Bootstrap: %v", err)
-						das.stateChange <- pb.AgentState_Disconnecting
-						continue
-					}
-				}
-
-				ctx, cancel := context.WithTimeout(context.Background(), helperTimeout)
-				err = das.ConfigureHelper(ctx, rc, []*pb.Gateway{
-					rc.BootstrapConfig.Gateway(),
-				})
-				cancel()
-
-				if err != nil {
-					notify.Errorf(err.Error())
-					das.stateChange <- pb.AgentState_Disconnecting
-					continue
-				}
-
-				authenticateTimer.Reset(1 * time.Microsecond)
-
-			case pb.AgentState_Authenticating:
-				if rc.SessionInfo != nil && !rc.SessionInfo.Expired() {
-					log.Infof("Already have a valid session")
-				} else {
-					log.Infof("No valid session, authenticating")
-					ctx, cancel := context.WithTimeout(context.Background(), authFlowTimeout)
-					rc.SessionInfo, err = auth.EnsureAuth(rc.SessionInfo, ctx, rc.Config.APIServer, rc.Config.Platform, rc.Serial)
-					cancel()
-
-					if err != nil {
-						notify.Errorf("Authenticate with API server: %v", err)
-						das.stateChange <- pb.AgentState_AuthenticateBackoff
-						continue
-					}
-				}
-
-				status.ConnectedSince = timestamppb.Now()
-				das.stateChange <- pb.AgentState_SyncConfig
-				continue
-
-			case pb.AgentState_AuthenticateBackoff:
-				log.Infof("Re-authenticating in %s...", authenticateBackoff)
-				authenticateTimer.Reset(authenticateBackoff)
-
-			case pb.AgentState_Connected:
-				// noop
-
-			case pb.AgentState_Disconnected:
-				status.Gateways = make([]*pb.Gateway, 0)
-
-			case pb.AgentState_Quitting:
-				return
-
-			case pb.AgentState_Disconnecting:
-				authenticateTimer.Stop()
-				log.Info("Tearing down network connections through device-helper...")
-				ctx, cancel := context.WithTimeout(context.Background(), helperTimeout)
-				_, err = das.DeviceHelper.Teardown(ctx, &pb.TeardownRequest{})
-				cancel()
-
-				if err != nil {
-					notify.Errorf(err.Error())
-				}
-				das.stateChange <- pb.AgentState_Disconnected
-
-			case pb.AgentState_HealthCheck:
-				wg := &sync.WaitGroup{}
-
-				total := len(status.GetGateways())
-				log.Infof("Ping %d gateways...", total)
-				for i, gw := range status.GetGateways() {
-					go func(i int, gw *pb.Gateway) {
-						wg.Add(1)
-						err := ping(gw.Ip)
-						pos := fmt.Sprintf("[%02d/%02d]", i+1, total)
-						if err == nil {
-							gw.Healthy = true
-							log.Debugf("%s Successfully pinged gateway %v with ip: %v", pos, gw.Name, gw.Ip)
-						} else {
-							gw.Healthy = false
-							log.Infof("%s unable to ping host %s: %v", pos, gw.Ip, err)
-						}
-						wg.Done()
-					}(i, gw)
-				}
-				wg.Wait()
-
-				das.stateChange <- pb.AgentState_Connected
-
-			case pb.AgentState_SyncConfig:
-				ctx, cancel := context.WithTimeout(context.Background(), syncConfigTimeout)
-				gateways, err := apiserver.GetDeviceConfig(rc.SessionInfo.Key, rc.Config.APIServer, ctx)
-				cancel()
-
-				switch {
-				case errors.Is(err, &apiserver.UnauthorizedError{}):
-					log.Errorf("Unauthorized access from apiserver: %v", err)
-					log.Errorf("Assuming invalid session; disconnecting.")
-					rc.SessionInfo = nil
-					das.stateChange <- pb.AgentState_Disconnecting
-					continue
-
-				case errors.Is(err, &apiserver.UnhealthyError{}):
-					// TODO produce unhealthy status message to "even watcher" stream
-
-					log.Errorf("Device is not healthy: %v", err)
-					// TODO consider moving all notify calls to systray code
-					notify.Errorf("No access as your device is unhealthy. Run '/msg @Kolide status' on Slack and fix the errors")
-					das.stateChange <- pb.AgentState_Unhealthy
-					continue
-
-				case err != nil:
-					log.Errorf("Unable to get gateway config: %v", err)
-					syncConfigTicker.Reset(syncConfigBackoff)
-					das.stateChange <- pb.AgentState_HealthCheck
-					continue
-
-				default:
-					syncConfigTicker.Reset(syncConfigInterval)
-				}
-
-				pb.MergeGatewayHealth(gateways, status.GetGateways())
-				status.Gateways = gateways
-
-				ctx, cancel = context.WithTimeout(context.Background(), helperTimeout)
-				err = das.ConfigureHelper(ctx, rc, append(
-					[]*pb.Gateway{
-						rc.BootstrapConfig.Gateway(),
-					},
-					status.GetGateways()...,
-				))
-				cancel()
-
-				if err != nil {
-					notify.Errorf(err.Error())
-					das.stateChange <- pb.AgentState_Disconnecting
-				} else {
-					das.stateChange <- pb.AgentState_HealthCheck
-				}
-
-			case pb.AgentState_Unhealthy:
-			}
-		}
-	}
+	go func() {
+		sig := <-signals
+		log.Infof("Received signal %s, exiting...", sig)
+		das.events <- EventQuit
+	}()
+
+	machine := NewStateMachine(rc, Dependencies{
+		Clock:               systemClock{},
+		Helper:              das.DeviceHelper,
+		APIServer:           &apiServerClient{url: rc.Config.APIServer},
+		Auth:                &authenticator{rc: rc},
+		Notifier:            desktopNotifier{},
+		CheckGateway:        checkGateway,
+		NewVersionAvailable: newVersionAvailable,
+		Publish:             das.UpdateAgentStatus,
+	})
+
+	machine.Run(context.Background(), das.events)
+	close(das.stopped)
 }
 
 func newVersionAvailable(ctx context.Context) (bool, error) {
@@ -294,12 +178,15 @@ func newVersionAvailable(ctx context.Context) (bool, error) {
 	return false, nil
 }
 
-func ping(addr string) error {
-	c, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%s", addr, "3000"), 2*time.Second)
-	if err != nil {
-		return err
-	}
-	c.Close()
+// gatewayHealthClient never uses a proxy, as gateways are only reachable through the tunnel.
+var gatewayHealthClient = &http.Client{
+	Transport: &http.Transport{Proxy: nil},
+}
 
-	return nil
+// checkGateway returns the round trip time of a health check of the gateway with the tunnel ip. If the gateway answers
+// without admitting the device, the round trip time is returned along with gatewayhealth.ErrNotAdmitted.
+func checkGateway(ctx context.Context, ip string) (time.Duration, error) {
+	start := time.Now()
+	_, err := gatewayhealth.Check(ctx, gatewayHealthClient, gatewayhealth.Address(ip))
+	return time.Since(start), err
 }
@@ -0,0 +1,32 @@
+package device_agent
+
+import (
+	"github.com/nais/device/pkg/pb"
+)
+
+// SetState puts the state machine in the state without doing the work of entering it.
+func (m *StateMachine) SetState(state pb.AgentState) {
+	m.status.ConnectionState = state
+}
+
+// Transitions returns every declared transition as a pair of states.
+func Transitions() [][2]pb.AgentState {
+	var pairs [][2]pb.AgentState
+	for from, tos := range transitions {
+		for _, to := range tos {
+			pairs = append(pairs, [2]pb.AgentState{from, to})
+		}
+	}
+	return pairs
+}
+
+// EventTransitions returns every transition caused by an event as a pair of states.
+func EventTransitions() [][2]pb.AgentState {
+	var pairs [][2]pb.AgentState
+	for _, t := range eventTransitions {
+		for _, from := range t.from {
+			pairs = append(pairs, [2]pb.AgentState{from, t.to})
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,621 @@
+package device_agent
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/nais/device/device-agent/apiserver"
+	"github.com/nais/device/device-agent/bootstrapper"
+	"github.com/nais/device/device-agent/runtimeconfig"
+	"github.com/nais/device/pkg/gatewayhealth"
+	"github.com/nais/device/pkg/pb"
+)
+
+// Event is something happening outside of the state machine, such as a request from a client or a timer expiring.
+type Event int
+
+const (
+	EventLogin         Event = iota // the user wants to connect
+	EventLogout                     // the user wants to disconnect and revoke the session
+	EventClientGone                 // a client that did not ask to keep the connection has gone away
+	EventQuit                       // the agent is shutting down
+	EventAuthenticate               // time to retry authentication
+	EventCheckApproval              // time to check whether the enrollment has been approved
+	EventSyncConfig                 // time to synchronize config with the apiserver
+	EventHealthCheck                // time to healthcheck the gateways
+	EventCheckVersion               // time to check for a new version of naisdevice
+)
+
+func (e Event) String() string {
+	switch e {
+	case EventLogin:
+		return "Login"
+	case EventLogout:
+		return "Logout"
+	case EventClientGone:
+		return "ClientGone"
+	case EventQuit:
+		return "Quit"
+	case EventAuthenticate:
+		return "Authenticate"
+	case EventCheckApproval:
+		return "CheckApproval"
+	case EventSyncConfig:
+		return "SyncConfig"
+	case EventHealthCheck:
+		return "HealthCheck"
+	case EventCheckVersion:
+		return "CheckVersion"
+	default:
+		return fmt.Sprintf("Event(%d)", int(e))
+	}
+}
+
+// transitions lists the states that may follow each state, either because of an event or as the result of the work
+// done when entering the state. Bootstrapping, Authenticating, SyncConfig, HealthCheck and Disconnecting always lead
+// on to another state, the agent rests in the others until an event arrives.
+var transitions = map[pb.AgentState][]pb.AgentState{
+	pb.AgentState_Disconnected: {
+		pb.AgentState_Bootstrapping,
+		pb.AgentState_Quitting,
+	},
+	pb.AgentState_Bootstrapping: {
+		pb.AgentState_Authenticating,
+		pb.AgentState_AwaitingApproval,
+		pb.AgentState_Disconnecting,
+	},
+	pb.AgentState_AwaitingApproval: {
+		pb.AgentState_Bootstrapping,
+		pb.AgentState_Disconnecting,
+		pb.AgentState_Quitting,
+	},
+	pb.AgentState_Authenticating: {
+		pb.AgentState_SyncConfig,
+		pb.AgentState_AuthenticateBackoff,
+	},
+	pb.AgentState_AuthenticateBackoff: {
+		pb.AgentState_Authenticating,
+		pb.AgentState_Bootstrapping,
+		pb.AgentState_Disconnecting,
+		pb.AgentState_Quitting,
+	},
+	pb.AgentState_SyncConfig: {
+		pb.AgentState_HealthCheck,
+		pb.AgentState_Unhealthy,
+		pb.AgentState_Disconnecting,
+	},
+	pb.AgentState_HealthCheck: {
+		pb.AgentState_Connected,
+	},
+	pb.AgentState_Connected: {
+		pb.AgentState_Bootstrapping,
+		pb.AgentState_SyncConfig,
+		pb.AgentState_HealthCheck,
+		pb.AgentState_Disconnecting,
+		pb.AgentState_Quitting,
+	},
+	pb.AgentState_Unhealthy: {
+		pb.AgentState_Bootstrapping,
+		pb.AgentState_Disconnecting,
+		pb.AgentState_Quitting,
+	},
+	pb.AgentState_Disconnecting: {
+		pb.AgentState_Disconnected,
+	},
+	pb.AgentState_Quitting: {},
+}
+
+// CanTransition reports whether the agent may go from one state to the other.
+func CanTransition(from, to pb.AgentState) bool {
+	return containsState(transitions[from], to)
+}
+
+type eventTransition struct {
+	event Event
+	from  []pb.AgentState
+	to    pb.AgentState
+}
+
+// eventTransitions lists the state each event leads to, and the states it does so from. Events arriving in any other
+// state are ignored.
+var eventTransitions = []eventTransition{
+	{
+		event: EventLogin,
+		from:  []pb.AgentState{pb.AgentState_Disconnected, pb.AgentState_Connected, pb.AgentState_Unhealthy, pb.AgentState_AuthenticateBackoff, pb.AgentState_AwaitingApproval},
+		to:    pb.AgentState_Bootstrapping,
+	},
+	{
+		event: EventLogout,
+		from:  []pb.AgentState{pb.AgentState_Connected, pb.AgentState_Unhealthy, pb.AgentState_AuthenticateBackoff, pb.AgentState_AwaitingApproval},
+		to:    pb.AgentState_Disconnecting,
+	},
+	{
+		event: EventClientGone,
+		from:  []pb.AgentState{pb.AgentState_Connected, pb.AgentState_Unhealthy, pb.AgentState_AuthenticateBackoff, pb.AgentState_AwaitingApproval},
+		to:    pb.AgentState_Disconnecting,
+	},
+	{
+		event: EventQuit,
+		from:  []pb.AgentState{pb.AgentState_Disconnected, pb.AgentState_Connected, pb.AgentState_Unhealthy, pb.AgentState_AuthenticateBackoff, pb.AgentState_AwaitingApproval},
+		to:    pb.AgentState_Quitting,
+	},
+	{
+		event: EventAuthenticate,
+		from:  []pb.AgentState{pb.AgentState_AuthenticateBackoff},
+		to:    pb.AgentState_Authenticating,
+	},
+	{
+		event: EventCheckApproval,
+		from:  []pb.AgentState{pb.AgentState_AwaitingApproval},
+		to:    pb.AgentState_Bootstrapping,
+	},
+	{
+		event: EventSyncConfig,
+		from:  []pb.AgentState{pb.AgentState_Connected},
+		to:    pb.AgentState_SyncConfig,
+	},
+	{
+		event: EventHealthCheck,
+		from:  []pb.AgentState{pb.AgentState_Connected},
+		to:    pb.AgentState_HealthCheck,
+	},
+}
+
+// timedEvents are the events that can be scheduled, in the order they are handled when expiring at the same time.
+var timedEvents = []Event{
+	EventAuthenticate,
+	EventCheckApproval,
+	EventSyncConfig,
+	EventHealthCheck,
+	EventCheckVersion,
+}
+
+// StateMachine drives the agent from one state to the next. It is not safe for concurrent use; events from other
+// goroutines are passed to Run, which handles them one at a time.
+type StateMachine struct {
+	rc     *runtimeconfig.RuntimeConfig
+	deps   Dependencies
+	status *pb.AgentStatus
+	timers map[Event]time.Time
+
+	// awaitingApproval is set when the user has been told that the enrollment awaits approval.
+	awaitingApproval bool
+}
+
+func NewStateMachine(rc *runtimeconfig.RuntimeConfig, deps Dependencies) *StateMachine {
+	return &StateMachine{
+		rc:     rc,
+		deps:   deps,
+		status: &pb.AgentStatus{},
+		timers: make(map[Event]time.Time),
+	}
+}
+
+// Status returns the current status of the agent.
+func (m *StateMachine) Status() *pb.AgentStatus {
+	return m.status
+}
+
+// publish passes a copy of the status on, so that it is not changed by the state machine while being sent to clients.
+func (m *StateMachine) publish() {
+	m.deps.Publish(proto.Clone(m.status).(*pb.AgentStatus))
+}
+
+// Scheduled returns when the event is scheduled to happen, if it is.
+func (m *StateMachine) Scheduled(event Event) (time.Time, bool) {
+	at, ok := m.timers[event]
+	return at, ok
+}
+
+// Run handles events as they arrive, and scheduled events as they expire, until the agent quits or the context is done.
+func (m *StateMachine) Run(ctx context.Context, events <-chan Event) {
+	var wakeup <-chan time.Time
+	var wakeupAt time.Time
+
+	m.publish()
+	m.schedule(EventCheckVersion, versionCheckDelay)
+
+	for m.status.ConnectionState != pb.AgentState_Quitting {
+		if at, ok := m.nextTimer(); !ok {
+			wakeup, wakeupAt = nil, time.Time{}
+		} else if !at.Equal(wakeupAt) {
+			wakeup, wakeupAt = m.deps.Clock.After(at.Sub(m.deps.Clock.Now())), at
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			m.Handle(event)
+		case <-wakeup:
+			wakeupAt = time.Time{}
+			m.FireTimers()
+		}
+	}
+}
+
+// Handle makes the agent act on the event, moving on to another state if the event leads to one from the current state.
+func (m *StateMachine) Handle(event Event) {
+	switch event {
+	case EventCheckVersion:
+		m.checkVersion()
+		return
+	case EventLogout:
+		m.revokeSession()
+	}
+
+	for _, t := range eventTransitions {
+		if t.event == event && containsState(t.from, m.status.ConnectionState) {
+			m.status.Reason = ""
+			m.transition(t.to)
+			return
+		}
+	}
+
+	log.Debugf("Ignoring event %s in state %s", event, m.status.ConnectionState)
+}
+
+// FireTimers handles the scheduled events that have expired.
+func (m *StateMachine) FireTimers() {
+	now := m.deps.Clock.Now()
+	for _, event := range timedEvents {
+		at, ok := m.timers[event]
+		if ok && !at.After(now) {
+			delete(m.timers, event)
+			m.Handle(event)
+		}
+	}
+}
+
+func (m *StateMachine) schedule(event Event, after time.Duration) {
+	m.timers[event] = m.deps.Clock.Now().Add(after)
+}
+
+func (m *StateMachine) cancel(events ...Event) {
+	for _, event := range events {
+		delete(m.timers, event)
+	}
+}
+
+func (m *StateMachine) nextTimer() (time.Time, bool) {
+	if len(m.timers) == 0 {
+		return time.Time{}, false
+	}
+
+	deadlines := make([]time.Time, 0, len(m.timers))
+	for _, at := range m.timers {
+		deadlines = append(deadlines, at)
+	}
+	sort.Slice(deadlines, func(i, j int) bool {
+		return deadlines[i].Before(deadlines[j])
+	})
+
+	return deadlines[0], true
+}
+
+// transition enters the state, and keeps following the states it leads to until the agent rests in one.
+func (m *StateMachine) transition(to pb.AgentState) {
+	for {
+		from := m.status.ConnectionState
+		if !CanTransition(from, to) {
+			log.Errorf("BUG: invalid state transition from %s to %s", from, to)
+			return
+		}
+
+		m.status.ConnectionState = to
+		log.Infof("state changed to %s", to)
+		m.publish()
+
+		next := m.enter(to)
+		if next == to {
+			return
+		}
+		to = next
+	}
+}
+
+// enter does the work of the state, and returns the state to go to next, or the same state to rest in it.
+func (m *StateMachine) enter(state pb.AgentState) pb.AgentState {
+	switch state {
+	case pb.AgentState_Bootstrapping:
+		return m.bootstrap()
+	case pb.AgentState_Authenticating:
+		return m.authenticate()
+	case pb.AgentState_AuthenticateBackoff:
+		log.Infof("Re-authenticating in %s...", authenticateBackoff)
+		m.schedule(EventAuthenticate, authenticateBackoff)
+	case pb.AgentState_AwaitingApproval:
+		log.Infof("Enrollment awaiting approval, checking again in %s...", approvalPollInterval)
+		m.schedule(EventCheckApproval, approvalPollInterval)
+	case pb.AgentState_SyncConfig:
+		return m.syncConfig()
+	case pb.AgentState_HealthCheck:
+		return m.healthCheck()
+	case pb.AgentState_Disconnecting:
+		return m.disconnect()
+	case pb.AgentState_Connected:
+		m.status.Reason = ""
+	case pb.AgentState_Disconnected:
+		m.status.Gateways = make([]*pb.Gateway, 0)
+	}
+
+	return state
+}
+
+func (m *StateMachine) bootstrap() pb.AgentState {
+	if m.rc.BootstrapConfig != nil {
+		log.Infof("Already bootstrapped")
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+		bootstrapConfig, err := m.deps.Auth.Bootstrap(ctx)
+		cancel()
+
+		switch {
+		case errors.Is(err, bootstrapper.ErrAwaitingApproval):
+			if !m.awaitingApproval {
+				m.deps.Notifier.Infof("Your device must be approved by an administrator before it can connect")
+				m.awaitingApproval = true
+			}
+			return pb.AgentState_AwaitingApproval
+		case err != nil:
+			return m.fail(pb.AgentState_Disconnecting, "Bootstrap: %v", err)
+		}
+
+		m.rc.BootstrapConfig = bootstrapConfig
+		m.awaitingApproval = false
+	}
+
+	err := m.configureHelper([]*pb.Gateway{
+		m.rc.BootstrapConfig.Gateway(),
+	})
+	if err != nil {
+		return m.fail(pb.AgentState_Disconnecting, "Configure device-helper: %v", err)
+	}
+
+	return pb.AgentState_Authenticating
+}
+
+func (m *StateMachine) authenticate() pb.AgentState {
+	if m.rc.SessionRestored && !m.rc.SessionInfo.Expired() {
+		// The session was stored by a previous run, so make sure it has not been revoked in the meantime. Getting the
+		// device config does not change the session, unlike renewing it, which is left to the config synchronization.
+		ctx, cancel := context.WithTimeout(context.Background(), syncConfigTimeout)
+		_, err := m.deps.APIServer.GetDeviceConfig(ctx, m.rc.SessionInfo.Key)
+		cancel()
+
+		switch {
+		case errors.Is(err, &apiserver.UnauthorizedError{}):
+			log.Infof("Stored session is no longer valid")
+			m.rc.SetSessionInfo(nil)
+		case err != nil && !errors.Is(err, &apiserver.UnhealthyError{}):
+			// keep the session, if it has been revoked the config synchronization will find out
+			log.Warnf("Unable to validate stored session: %v", err)
+		default:
+			log.Infof("Reusing stored session, expires at %s", time.Unix(m.rc.SessionInfo.Expiry, 0))
+			m.rc.SessionRestored = false
+		}
+	}
+
+	if m.rc.SessionInfo != nil && !m.rc.SessionInfo.Expired() {
+		log.Infof("Already have a valid session")
+	} else {
+		log.Infof("No valid session, authenticating")
+		ctx, cancel := context.WithTimeout(context.Background(), authFlowTimeout)
+		sessionInfo, err := m.deps.Auth.Login(ctx)
+		cancel()
+		m.rc.SetSessionInfo(sessionInfo)
+
+		if err != nil {
+			return m.fail(pb.AgentState_AuthenticateBackoff, "Authenticate with API server: %v", err)
+		}
+	}
+
+	m.status.ConnectedSince = timestamppb.New(m.deps.Clock.Now())
+	return pb.AgentState_SyncConfig
+}
+
+func (m *StateMachine) syncConfig() pb.AgentState {
+	if m.rc.SessionInfo.NeedsRenewal() {
+		ctx, cancel := context.WithTimeout(context.Background(), renewSessionTimeout)
+		sessionInfo, err := m.deps.APIServer.RenewSession(ctx, m.rc.SessionInfo.Key)
+		cancel()
+
+		if err != nil {
+			// the session is still valid, so keep using it until it expires and the user has to log in again
+			log.Warnf("Unable to renew session: %v", err)
+		} else {
+			log.Infof("Renewed session, expires at %s", time.Unix(sessionInfo.Expiry, 0))
+			m.rc.SetSessionInfo(sessionInfo)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), syncConfigTimeout)
+	gateways, err := m.deps.APIServer.GetDeviceConfig(ctx, m.rc.SessionInfo.Key)
+	cancel()
+
+	switch {
+	case errors.Is(err, &apiserver.UnauthorizedError{}):
+		log.Errorf("Unauthorized access from apiserver: %v", err)
+		log.Errorf("Assuming invalid session; disconnecting.")
+		m.rc.SetSessionInfo(nil)
+		m.status.Reason = "Session is no longer valid, log in again"
+		return pb.AgentState_Disconnecting
+
+	case errors.Is(err, &apiserver.UnhealthyError{}):
+		log.Errorf("Device is not healthy: %v", err)
+		// TODO consider moving all notify calls to systray code
+		m.deps.Notifier.Errorf("No access as your device is unhealthy. Run '/msg @Kolide status' on Slack and fix the errors")
+		m.status.Reason = err.Error()
+		return pb.AgentState_Unhealthy
+
+	case err != nil:
+		log.Errorf("Unable to get gateway config: %v", err)
+		m.schedule(EventSyncConfig, syncConfigBackoff)
+		return pb.AgentState_HealthCheck
+	}
+
+	m.schedule(EventSyncConfig, syncConfigInterval)
+
+	pb.MergeGatewayHealth(gateways, m.status.GetGateways())
+	m.status.Gateways = gateways
+
+	err = m.configureHelper(append(
+		[]*pb.Gateway{
+			m.rc.BootstrapConfig.Gateway(),
+		},
+		m.status.GetGateways()...,
+	))
+	if err != nil {
+		return m.fail(pb.AgentState_Disconnecting, "Configure device-helper: %v", err)
+	}
+
+	return pb.AgentState_HealthCheck
+}
+
+func (m *StateMachine) healthCheck() pb.AgentState {
+	wg := &sync.WaitGroup{}
+
+	total := len(m.status.GetGateways())
+	log.Infof("Health checking %d gateways...", total)
+	for i, gw := range m.status.GetGateways() {
+		wg.Add(1)
+		go func(i int, gw *pb.Gateway) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+			rtt, err := m.deps.CheckGateway(ctx, gw.Ip)
+			cancel()
+			pos := fmt.Sprintf("[%02d/%02d]", i+1, total)
+			switch {
+			case err == nil:
+				gw.Healthy = true
+				gw.RoundTripTime = durationpb.New(rtt)
+				gw.LastError = ""
+				log.Debugf("%s Gateway %v with ip: %v is healthy, round trip time %s", pos, gw.Name, gw.Ip, rtt)
+			case errors.Is(err, gatewayhealth.ErrNotAdmitted):
+				// the gateway is up, but has not yet received a configuration letting this device through
+				gw.Healthy = false
+				gw.RoundTripTime = durationpb.New(rtt)
+				gw.LastError = "Not authorized: " + err.Error()
+				log.Infof("%s Gateway %v with ip: %v has not admitted this device", pos, gw.Name, gw.Ip)
+			case !gw.GetAdmitted():
+				// the gateway has no peer for this device, so it drops the health check; the apiserver tells us why
+				gw.Healthy = false
+				gw.RoundTripTime = nil
+				gw.LastError = "Not authorized: the gateway has not been configured to admit this device"
+				log.Infof("%s Gateway %v with ip: %v has not been configured to admit this device: %v", pos, gw.Name, gw.Ip, err)
+			default:
+				gw.Healthy = false
+				gw.RoundTripTime = nil
+				gw.LastError = "Unreachable: " + err.Error()
+				log.Infof("%s Unable to health check gateway %v with ip: %v: %v", pos, gw.Name, gw.Ip, err)
+			}
+		}(i, gw)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), helperTimeout)
+	statistics, err := m.deps.Helper.PeerStatistics(ctx, &pb.PeerStatisticsRequest{})
+	cancel()
+
+	if err != nil {
+		log.Warnf("Reading WireGuard statistics from device-helper: %v", err)
+	} else {
+		pb.MergePeerStatistics(m.status.GetGateways(), statistics.GetPeers())
+	}
+
+	m.schedule(EventHealthCheck, healthCheckInterval)
+	return pb.AgentState_Connected
+}
+
+func (m *StateMachine) disconnect() pb.AgentState {
+	m.cancel(EventAuthenticate, EventCheckApproval, EventSyncConfig, EventHealthCheck)
+	m.awaitingApproval = false
+
+	log.Info("Tearing down network connections through device-helper...")
+	ctx, cancel := context.WithTimeout(context.Background(), helperTimeout)
+	_, err := m.deps.Helper.Teardown(ctx, &pb.TeardownRequest{})
+	cancel()
+
+	if err != nil {
+		m.deps.Notifier.Errorf("Tear down device-helper: %v", err)
+	}
+
+	return pb.AgentState_Disconnected
+}
+
+// fail tells the user why the agent is going to the state, and keeps the reason in the status until the next event.
+func (m *StateMachine) fail(state pb.AgentState, format string, args ...interface{}) pb.AgentState {
+	m.status.Reason = fmt.Sprintf(format, args...)
+	m.deps.Notifier.Errorf("%s", m.status.Reason)
+	return state
+}
+
+// revokeSession revokes the session on the apiserver, which has to happen while the tunnel to it is still up.
+func (m *StateMachine) revokeSession() {
+	if m.rc.SessionInfo == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), logoutTimeout)
+	err := m.deps.APIServer.Logout(ctx, m.rc.SessionInfo.Key)
+	cancel()
+
+	if err != nil {
+		log.Warnf("Revoking session on apiserver: %v", err)
+	}
+	m.rc.SetSessionInfo(nil)
+}
+
+func (m *StateMachine) checkVersion() {
+	ctx, cancel := context.WithTimeout(context.Background(), versionCheckTimeout)
+	newVersionAvailable, err := m.deps.NewVersionAvailable(ctx)
+	cancel()
+
+	if err != nil {
+		log.Errorf("check for new version: %s", err)
+		m.schedule(EventCheckVersion, versionCheckDelay)
+		return
+	}
+
+	m.status.NewVersionAvailable = newVersionAvailable
+	m.publish()
+
+	if newVersionAvailable {
+		m.deps.Notifier.Infof("New version of device agent available: https://doc.nais.io/device/install#installation")
+		m.cancel(EventCheckVersion)
+	} else {
+		m.schedule(EventCheckVersion, versionCheckInterval)
+	}
+}
+
+func (m *StateMachine) configureHelper(gateways []*pb.Gateway) error {
+	ctx, cancel := context.WithTimeout(context.Background(), helperTimeout)
+	defer cancel()
+
+	_, err := m.deps.Helper.Configure(ctx, &pb.Configuration{
+		PrivateKey: base64.StdEncoding.EncodeToString(m.rc.PrivateKey),
+		DeviceIP:   m.rc.BootstrapConfig.DeviceIP,
+		DeviceIPv6: m.rc.BootstrapConfig.DeviceIPv6,
+		Gateways:   gateways,
+	})
+	return err
+}
+
+func containsState(states []pb.AgentState, state pb.AgentState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,921 @@
+package device_agent_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/nais/device/device-agent/apiserver"
+	"github.com/nais/device/device-agent/auth"
+	"github.com/nais/device/device-agent/bootstrapper"
+	"github.com/nais/device/device-agent/runtimeconfig"
+	"github.com/nais/device/pkg/bootstrap"
+	"github.com/nais/device/pkg/device-agent"
+	"github.com/nais/device/pkg/gatewayhealth"
+	"github.com/nais/device/pkg/pb"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return make(chan time.Time)
+}
+
+type fakeHelper struct {
+	configureErrs []error
+	teardownErr   error
+	statistics    []*pb.PeerStatistics
+	statisticsErr error
+	configured    []*pb.Configuration
+	teardowns     int
+}
+
+func (h *fakeHelper) Configure(ctx context.Context, in *pb.Configuration, opts ...grpc.CallOption) (*pb.ConfigureResponse, error) {
+	h.configured = append(h.configured, in)
+	if len(h.configureErrs) > 0 {
+		err := h.configureErrs[0]
+		h.configureErrs = h.configureErrs[1:]
+		return nil, err
+	}
+	return &pb.ConfigureResponse{}, nil
+}
+
+func (h *fakeHelper) Teardown(ctx context.Context, in *pb.TeardownRequest, opts ...grpc.CallOption) (*pb.TeardownResponse, error) {
+	h.teardowns++
+	return &pb.TeardownResponse{}, h.teardownErr
+}
+
+func (h *fakeHelper) Upgrade(ctx context.Context, in *pb.UpgradeRequest, opts ...grpc.CallOption) (*pb.UpgradeResponse, error) {
+	return &pb.UpgradeResponse{}, nil
+}
+
+func (h *fakeHelper) PeerStatistics(ctx context.Context, in *pb.PeerStatisticsRequest, opts ...grpc.CallOption) (*pb.PeerStatisticsResponse, error) {
+	if h.statisticsErr != nil {
+		return nil, h.statisticsErr
+	}
+	return &pb.PeerStatisticsResponse{Peers: h.statistics}, nil
+}
+
+type fakeAPIServer struct {
+	gateways  []string
+	configErr error
+	// firstErr is returned by the first request for the device config only
+	firstErr error
+	// revoked are the session keys the apiserver no longer accepts
+	revoked []string
+	// withheld are the gateways the apiserver has not configured to admit the device
+	withheld map[string]bool
+	renewed  *auth.SessionInfo
+	renewErr error
+	renewals int
+	logouts  []string
+}
+
+func (a *fakeAPIServer) GetDeviceConfig(ctx context.Context, sessionKey string) ([]*pb.Gateway, error) {
+	if err := a.firstErr; err != nil {
+		a.firstErr = nil
+		return nil, err
+	}
+
+	for _, revoked := range a.revoked {
+		if sessionKey == revoked {
+			return nil, fmt.Errorf("getting device config: %w", &apiserver.UnauthorizedError{})
+		}
+	}
+
+	if a.configErr != nil {
+		return nil, a.configErr
+	}
+
+	gateways := make([]*pb.Gateway, 0, len(a.gateways))
+	for _, name := range a.gateways {
+		gateways = append(gateways, &pb.Gateway{Name: name, Ip: name, PublicKey: name, Admitted: !a.withheld[name]})
+	}
+	return gateways, nil
+}
+
+func (a *fakeAPIServer) RenewSession(ctx context.Context, sessionKey string) (*auth.SessionInfo, error) {
+	a.renewals++
+	return a.renewed, a.renewErr
+}
+
+func (a *fakeAPIServer) Logout(ctx context.Context, sessionKey string) error {
+	a.logouts = append(a.logouts, sessionKey)
+	return nil
+}
+
+type fakeAuth struct {
+	bootstrapErr error
+	loginErr     error
+	bootstraps   int
+	logins       int
+}
+
+func (a *fakeAuth) Bootstrap(ctx context.Context) (*bootstrap.Config, error) {
+	a.bootstraps++
+	if a.bootstrapErr != nil {
+		return nil, a.bootstrapErr
+	}
+	return bootstrapConfig(), nil
+}
+
+func (a *fakeAuth) Login(ctx context.Context) (*auth.SessionInfo, error) {
+	a.logins++
+	if a.loginErr != nil {
+		return nil, a.loginErr
+	}
+	return session("new-session", 10*time.Hour), nil
+}
+
+type fakeNotifier struct {
+	infos  []string
+	errors []string
+}
+
+func (n *fakeNotifier) Infof(format string, args ...interface{}) {
+	n.infos = append(n.infos, fmt.Sprintf(format, args...))
+}
+
+func (n *fakeNotifier) Errorf(format string, args ...interface{}) {
+	n.errors = append(n.errors, fmt.Sprintf(format, args...))
+}
+
+type memorySessionStore struct {
+	session *auth.SessionInfo
+}
+
+func (s *memorySessionStore) Load() (*auth.SessionInfo, error) {
+	return s.session, nil
+}
+
+func (s *memorySessionStore) Save(si *auth.SessionInfo) error {
+	s.session = si
+	return nil
+}
+
+func (s *memorySessionStore) Remove() error {
+	s.session = nil
+	return nil
+}
+
+type env struct {
+	clock       *fakeClock
+	helper      *fakeHelper
+	apiserver   *fakeAPIServer
+	auth        *fakeAuth
+	notifier    *fakeNotifier
+	store       *memorySessionStore
+	rc          *runtimeconfig.RuntimeConfig
+	unreachable map[string]bool
+	notAdmitted map[string]bool
+	newVersion  bool
+	versionErr  error
+	published   []pb.AgentState
+	statuses    []*pb.AgentStatus
+}
+
+func newEnv() *env {
+	e := &env{
+		clock:       &fakeClock{now: time.Date(2021, 2, 1, 12, 0, 0, 0, time.UTC)},
+		helper:      &fakeHelper{},
+		apiserver:   &fakeAPIServer{gateways: []string{"gw-1", "gw-2"}},
+		auth:        &fakeAuth{},
+		notifier:    &fakeNotifier{},
+		store:       &memorySessionStore{},
+		unreachable: make(map[string]bool),
+		notAdmitted: make(map[string]bool),
+	}
+	e.rc = &runtimeconfig.RuntimeConfig{
+		PrivateKey:   []byte("private key"),
+		SessionStore: e.store,
+	}
+	return e
+}
+
+func (e *env) machine() *device_agent.StateMachine {
+	return device_agent.NewStateMachine(e.rc, device_agent.Dependencies{
+		Clock:     e.clock,
+		Helper:    e.helper,
+		APIServer: e.apiserver,
+		Auth:      e.auth,
+		Notifier:  e.notifier,
+		CheckGateway: func(ctx context.Context, ip string) (time.Duration, error) {
+			if e.unreachable[ip] {
+				return 0, errors.New("connection refused")
+			}
+			if e.notAdmitted[ip] {
+				return 5 * time.Millisecond, gatewayhealth.ErrNotAdmitted
+			}
+			return 5 * time.Millisecond, nil
+		},
+		NewVersionAvailable: func(ctx context.Context) (bool, error) {
+			return e.newVersion, e.versionErr
+		},
+		Publish: func(status *pb.AgentStatus) {
+			e.published = append(e.published, status.GetConnectionState())
+			e.statuses = append(e.statuses, status)
+		},
+	})
+}
+
+// connected sets up a device that has been bootstrapped and has a valid session.
+func (e *env) connected(m *device_agent.StateMachine) {
+	e.rc.BootstrapConfig = bootstrapConfig()
+	e.rc.SetSessionInfo(session("session", 10*time.Hour))
+	m.Status().Gateways = []*pb.Gateway{{Name: "gw-1", Ip: "gw-1", PublicKey: "gw-1", Healthy: true, Admitted: true}}
+}
+
+func (e *env) after(d time.Duration) time.Time {
+	return e.clock.now.Add(d)
+}
+
+func bootstrapConfig() *bootstrap.Config {
+	return &bootstrap.Config{
+		DeviceIP:       "10.255.240.2",
+		PublicKey:      "apiserver public key",
+		TunnelEndpoint: "1.2.3.4:51820",
+		APIServerIP:    "10.255.240.1",
+	}
+}
+
+func session(key string, validFor time.Duration) *auth.SessionInfo {
+	return &auth.SessionInfo{Key: key, Expiry: time.Now().Add(validFor).Unix()}
+}
+
+var (
+	bootstrapping  = pb.AgentState_Bootstrapping
+	authenticating = pb.AgentState_Authenticating
+	syncConfig     = pb.AgentState_SyncConfig
+	healthCheck    = pb.AgentState_HealthCheck
+	connected      = pb.AgentState_Connected
+	disconnecting  = pb.AgentState_Disconnecting
+	disconnected   = pb.AgentState_Disconnected
+	backoff        = pb.AgentState_AuthenticateBackoff
+	approval       = pb.AgentState_AwaitingApproval
+	unhealthy      = pb.AgentState_Unhealthy
+	quitting       = pb.AgentState_Quitting
+)
+
+func TestStateMachine(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   pb.AgentState
+		setup  func(e *env, m *device_agent.StateMachine)
+		events []device_agent.Event
+		want   []pb.AgentState
+		check  func(t *testing.T, e *env, m *device_agent.StateMachine)
+	}{
+		{
+			name:   "login with new device bootstraps, authenticates and connects",
+			from:   disconnected,
+			events: []device_agent.Event{device_agent.EventLogin},
+			want:   []pb.AgentState{bootstrapping, authenticating, syncConfig, healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Equal(t, 1, e.auth.bootstraps)
+				assert.Equal(t, 1, e.auth.logins)
+				assert.Equal(t, "new-session", e.store.session.Key, "session is stored")
+				assert.Equal(t, e.clock.now, m.Status().GetConnectedSince().AsTime())
+
+				assert.Len(t, e.helper.configured, 2)
+				assert.Len(t, e.helper.configured[0].GetGateways(), 1, "only apiserver while authenticating")
+				assert.Len(t, e.helper.configured[1].GetGateways(), 3, "apiserver and gateways when synchronized")
+
+				assert.Len(t, m.Status().GetGateways(), 2)
+				for _, gw := range m.Status().GetGateways() {
+					assert.True(t, gw.GetHealthy())
+				}
+
+				at, _ := m.Scheduled(device_agent.EventSyncConfig)
+				assert.Equal(t, e.after(5*time.Minute), at)
+				at, _ = m.Scheduled(device_agent.EventHealthCheck)
+				assert.Equal(t, e.after(20*time.Second), at)
+			},
+		},
+		{
+			name: "login with bootstrapped device and valid session skips bootstrap and authentication",
+			from: disconnected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+			},
+			events: []device_agent.Event{device_agent.EventLogin},
+			want:   []pb.AgentState{bootstrapping, authenticating, syncConfig, healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Equal(t, 0, e.auth.bootstraps)
+				assert.Equal(t, 0, e.auth.logins)
+				assert.Equal(t, 0, e.apiserver.renewals)
+			},
+		},
+		{
+			name: "login awaiting approval tells the user once and checks again later",
+			from: disconnected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.auth.bootstrapErr = bootstrapper.ErrAwaitingApproval
+			},
+			events: []device_agent.Event{device_agent.EventLogin, device_agent.EventCheckApproval},
+			want:   []pb.AgentState{bootstrapping, approval, bootstrapping, approval},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Len(t, e.notifier.infos, 1)
+				at, ok := m.Scheduled(device_agent.EventCheckApproval)
+				assert.True(t, ok)
+				assert.Equal(t, e.after(30*time.Second), at)
+			},
+		},
+		{
+			name:   "approved enrollment connects",
+			from:   approval,
+			events: []device_agent.Event{device_agent.EventCheckApproval},
+			want:   []pb.AgentState{bootstrapping, authenticating, syncConfig, healthCheck, connected},
+		},
+		{
+			name: "failing bootstrap disconnects",
+			from: disconnected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.auth.bootstrapErr = errors.New("bootstrap-api unavailable")
+			},
+			events: []device_agent.Event{device_agent.EventLogin},
+			want:   []pb.AgentState{bootstrapping, disconnecting, disconnected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Len(t, e.notifier.errors, 1)
+				assert.Equal(t, "Bootstrap: bootstrap-api unavailable", m.Status().GetReason(), "reason is kept when disconnected")
+				assert.Equal(t, 1, e.helper.teardowns)
+			},
+		},
+		{
+			name: "failing to configure the helper for the apiserver disconnects",
+			from: disconnected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.helper.configureErrs = []error{errors.New("helper unavailable")}
+			},
+			events: []device_agent.Event{device_agent.EventLogin},
+			want:   []pb.AgentState{bootstrapping, disconnecting, disconnected},
+		},
+		{
+			name: "failing authentication backs off",
+			from: disconnected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.auth.loginErr = errors.New("login cancelled")
+			},
+			events: []device_agent.Event{device_agent.EventLogin},
+			want:   []pb.AgentState{bootstrapping, authenticating, backoff},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Len(t, e.notifier.errors, 1)
+				assert.Equal(t, "Authenticate with API server: login cancelled", m.Status().GetReason())
+				assert.Nil(t, e.rc.SessionInfo)
+				at, ok := m.Scheduled(device_agent.EventAuthenticate)
+				assert.True(t, ok)
+				assert.Equal(t, e.after(10*time.Second), at)
+			},
+		},
+		{
+			name: "restored session is validated and reused",
+			from: disconnected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.rc.BootstrapConfig = bootstrapConfig()
+				e.rc.SessionInfo = session("stored", 10*time.Hour)
+				e.rc.SessionRestored = true
+			},
+			events: []device_agent.Event{device_agent.EventLogin},
+			want:   []pb.AgentState{bootstrapping, authenticating, syncConfig, healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Equal(t, 0, e.apiserver.renewals, "validating the session must not renew it")
+				assert.Equal(t, 0, e.auth.logins)
+				assert.Equal(t, "stored", e.rc.SessionInfo.Key)
+				assert.False(t, e.rc.SessionRestored)
+			},
+		},
+		{
+			name: "revoked restored session makes the user log in",
+			from: disconnected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.rc.BootstrapConfig = bootstrapConfig()
+				e.rc.SessionInfo = session("stored", time.Hour)
+				e.rc.SessionRestored = true
+				e.apiserver.revoked = []string{"stored"}
+			},
+			events: []device_agent.Event{device_agent.EventLogin},
+			want:   []pb.AgentState{bootstrapping, authenticating, syncConfig, healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Equal(t, 1, e.auth.logins)
+				assert.Equal(t, "new-session", e.rc.SessionInfo.Key)
+			},
+		},
+		{
+			name: "restored session is kept when it can not be validated",
+			from: disconnected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.rc.BootstrapConfig = bootstrapConfig()
+				e.rc.SessionInfo = session("stored", 10*time.Hour)
+				e.rc.SessionRestored = true
+				e.apiserver.firstErr = errors.New("timeout")
+			},
+			events: []device_agent.Event{device_agent.EventLogin},
+			want:   []pb.AgentState{bootstrapping, authenticating, syncConfig, healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Equal(t, 0, e.auth.logins)
+				assert.Equal(t, "stored", e.rc.SessionInfo.Key)
+			},
+		},
+		{
+			name: "unhealthy device",
+			from: disconnected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.apiserver.configErr = fmt.Errorf("%w: device not healthy", &apiserver.UnhealthyError{})
+			},
+			events: []device_agent.Event{device_agent.EventLogin},
+			want:   []pb.AgentState{bootstrapping, authenticating, syncConfig, unhealthy},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Len(t, e.notifier.errors, 1)
+				assert.Equal(t, "device is in unhealthy state: device not healthy", m.Status().GetReason())
+				assert.NotNil(t, e.rc.SessionInfo)
+			},
+		},
+		{
+			name: "unauthorized session is discarded and disconnects",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+				e.apiserver.configErr = fmt.Errorf("unauthorized: %w", &apiserver.UnauthorizedError{})
+			},
+			events: []device_agent.Event{device_agent.EventSyncConfig},
+			want:   []pb.AgentState{syncConfig, disconnecting, disconnected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Nil(t, e.rc.SessionInfo)
+				assert.Nil(t, e.store.session)
+				assert.Empty(t, m.Status().GetGateways())
+			},
+		},
+		{
+			name: "failing config synchronization keeps gateways and retries sooner",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+				e.apiserver.configErr = errors.New("timeout")
+			},
+			events: []device_agent.Event{device_agent.EventSyncConfig},
+			want:   []pb.AgentState{syncConfig, healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Len(t, m.Status().GetGateways(), 1)
+				at, _ := m.Scheduled(device_agent.EventSyncConfig)
+				assert.Equal(t, e.after(15*time.Second), at)
+			},
+		},
+		{
+			name: "failing to configure the helper for the gateways disconnects",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+				e.helper.configureErrs = []error{errors.New("helper unavailable")}
+			},
+			events: []device_agent.Event{device_agent.EventSyncConfig},
+			want:   []pb.AgentState{syncConfig, disconnecting, disconnected},
+		},
+		{
+			name: "config synchronization renews session and keeps gateway health",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+				e.rc.SetSessionInfo(session("session", 5*time.Minute))
+				e.apiserver.renewed = session("session", 10*time.Hour)
+				e.unreachable["gw-1"] = true
+			},
+			events: []device_agent.Event{device_agent.EventSyncConfig},
+			want:   []pb.AgentState{syncConfig, healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Equal(t, 1, e.apiserver.renewals)
+				assert.Equal(t, e.apiserver.renewed, e.store.session)
+				assert.Len(t, m.Status().GetGateways(), 2)
+				assert.False(t, m.Status().GetGateways()[0].GetHealthy())
+				assert.True(t, m.Status().GetGateways()[1].GetHealthy())
+			},
+		},
+		{
+			name: "failing session renewal keeps the session",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+				e.rc.SetSessionInfo(session("session", 5*time.Minute))
+				e.apiserver.renewErr = errors.New("timeout")
+			},
+			events: []device_agent.Event{device_agent.EventSyncConfig},
+			want:   []pb.AgentState{syncConfig, healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Equal(t, "session", e.rc.SessionInfo.Key)
+			},
+		},
+		{
+			name: "health check marks unreachable gateways",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+				e.unreachable["gw-1"] = true
+			},
+			events: []device_agent.Event{device_agent.EventHealthCheck},
+			want:   []pb.AgentState{healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				gw := m.Status().GetGateways()[0]
+				assert.False(t, gw.GetHealthy())
+				assert.Equal(t, "Unreachable: connection refused", gw.GetLastError())
+				assert.Nil(t, gw.GetRoundTripTime())
+			},
+		},
+		{
+			name: "health check tells gateways that have not admitted the device from unreachable ones",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+				e.notAdmitted["gw-1"] = true
+			},
+			events: []device_agent.Event{device_agent.EventHealthCheck},
+			want:   []pb.AgentState{healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				gw := m.Status().GetGateways()[0]
+				assert.False(t, gw.GetHealthy())
+				assert.Contains(t, gw.GetLastError(), "Not authorized")
+				assert.Equal(t, 5*time.Millisecond, gw.GetRoundTripTime().AsDuration())
+			},
+		},
+		{
+			name: "health check tells unreachable gateways that the apiserver has not admitted the device to",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+				e.apiserver.withheld = map[string]bool{"gw-1": true}
+				e.unreachable["gw-1"] = true
+				e.unreachable["gw-2"] = true
+			},
+			events: []device_agent.Event{device_agent.EventSyncConfig},
+			want:   []pb.AgentState{syncConfig, healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				gateways := m.Status().GetGateways()
+				assert.False(t, gateways[0].GetHealthy())
+				assert.Contains(t, gateways[0].GetLastError(), "Not authorized")
+				assert.False(t, gateways[1].GetHealthy())
+				assert.Equal(t, "Unreachable: connection refused", gateways[1].GetLastError())
+			},
+		},
+		{
+			name: "health check reports round trip time and WireGuard statistics",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+				m.Status().GetGateways()[0].LastError = "Unreachable: connection refused"
+				e.helper.statistics = []*pb.PeerStatistics{
+					{PublicKey: "gw-1", LastHandshake: timestamppb.New(e.clock.now), RxBytes: 1024, TxBytes: 2048},
+				}
+			},
+			events: []device_agent.Event{device_agent.EventHealthCheck},
+			want:   []pb.AgentState{healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				gw := m.Status().GetGateways()[0]
+				assert.True(t, gw.GetHealthy())
+				assert.Empty(t, gw.GetLastError())
+				assert.Equal(t, 5*time.Millisecond, gw.GetRoundTripTime().AsDuration())
+				assert.Equal(t, e.clock.now, gw.GetLastHandshake().AsTime())
+				assert.Equal(t, uint64(1024), gw.GetRxBytes())
+				assert.Equal(t, uint64(2048), gw.GetTxBytes())
+			},
+		},
+		{
+			name: "health check without WireGuard statistics",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+				e.helper.statisticsErr = errors.New("interface not found")
+			},
+			events: []device_agent.Event{device_agent.EventHealthCheck},
+			want:   []pb.AgentState{healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.True(t, m.Status().GetGateways()[0].GetHealthy())
+				assert.Nil(t, m.Status().GetGateways()[0].GetLastHandshake())
+			},
+		},
+		{
+			name: "login when connected reconnects",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+			},
+			events: []device_agent.Event{device_agent.EventLogin},
+			want:   []pb.AgentState{bootstrapping, authenticating, syncConfig, healthCheck, connected},
+		},
+		{
+			name: "logout revokes the session and disconnects",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+			},
+			events: []device_agent.Event{device_agent.EventLogout},
+			want:   []pb.AgentState{disconnecting, disconnected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Equal(t, []string{"session"}, e.apiserver.logouts)
+				assert.Nil(t, e.rc.SessionInfo)
+				assert.Nil(t, e.store.session)
+				assert.Equal(t, 1, e.helper.teardowns)
+				_, ok := m.Scheduled(device_agent.EventSyncConfig)
+				assert.False(t, ok)
+				_, ok = m.Scheduled(device_agent.EventHealthCheck)
+				assert.False(t, ok)
+			},
+		},
+		{
+			name: "client going away disconnects but keeps the session",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+			},
+			events: []device_agent.Event{device_agent.EventClientGone},
+			want:   []pb.AgentState{disconnecting, disconnected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Empty(t, e.apiserver.logouts)
+				assert.Equal(t, "session", e.store.session.Key)
+			},
+		},
+		{
+			name: "failing teardown is reported",
+			from: connected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+				e.helper.teardownErr = errors.New("helper unavailable")
+			},
+			events: []device_agent.Event{device_agent.EventClientGone},
+			want:   []pb.AgentState{disconnecting, disconnected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Len(t, e.notifier.errors, 1)
+			},
+		},
+		{
+			name:   "quit when connected",
+			from:   connected,
+			events: []device_agent.Event{device_agent.EventQuit},
+			want:   []pb.AgentState{quitting},
+		},
+		{
+			name: "authentication is retried after backoff",
+			from: backoff,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.rc.BootstrapConfig = bootstrapConfig()
+			},
+			events: []device_agent.Event{device_agent.EventAuthenticate},
+			want:   []pb.AgentState{authenticating, syncConfig, healthCheck, connected},
+		},
+		{
+			name: "failing authentication after backoff backs off again",
+			from: backoff,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.rc.BootstrapConfig = bootstrapConfig()
+				e.auth.loginErr = errors.New("login cancelled")
+			},
+			events: []device_agent.Event{device_agent.EventAuthenticate},
+			want:   []pb.AgentState{authenticating, backoff},
+		},
+		{
+			name: "login during backoff starts over",
+			from: backoff,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				m.Status().Reason = "Authenticate with API server: login cancelled"
+			},
+			events: []device_agent.Event{device_agent.EventLogin},
+			want:   []pb.AgentState{bootstrapping, authenticating, syncConfig, healthCheck, connected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Empty(t, m.Status().GetReason())
+			},
+		},
+		{
+			name:   "logout during backoff disconnects",
+			from:   backoff,
+			events: []device_agent.Event{device_agent.EventLogout},
+			want:   []pb.AgentState{disconnecting, disconnected},
+		},
+		{
+			name:   "quit during backoff",
+			from:   backoff,
+			events: []device_agent.Event{device_agent.EventQuit},
+			want:   []pb.AgentState{quitting},
+		},
+		{
+			name:   "login while awaiting approval tries again",
+			from:   approval,
+			events: []device_agent.Event{device_agent.EventLogin},
+			want:   []pb.AgentState{bootstrapping, authenticating, syncConfig, healthCheck, connected},
+		},
+		{
+			name: "logout while awaiting approval disconnects and stops checking",
+			from: disconnected,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.auth.bootstrapErr = bootstrapper.ErrAwaitingApproval
+			},
+			events: []device_agent.Event{device_agent.EventLogin, device_agent.EventLogout},
+			want:   []pb.AgentState{bootstrapping, approval, disconnecting, disconnected},
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				_, ok := m.Scheduled(device_agent.EventCheckApproval)
+				assert.False(t, ok)
+			},
+		},
+		{
+			name:   "quit while awaiting approval",
+			from:   approval,
+			events: []device_agent.Event{device_agent.EventQuit},
+			want:   []pb.AgentState{quitting},
+		},
+		{
+			name: "login when unhealthy tries again",
+			from: unhealthy,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+			},
+			events: []device_agent.Event{device_agent.EventLogin},
+			want:   []pb.AgentState{bootstrapping, authenticating, syncConfig, healthCheck, connected},
+		},
+		{
+			name: "logout when unhealthy disconnects",
+			from: unhealthy,
+			setup: func(e *env, m *device_agent.StateMachine) {
+				e.connected(m)
+			},
+			events: []device_agent.Event{device_agent.EventLogout},
+			want:   []pb.AgentState{disconnecting, disconnected},
+		},
+		{
+			name:   "quit when unhealthy",
+			from:   unhealthy,
+			events: []device_agent.Event{device_agent.EventQuit},
+			want:   []pb.AgentState{quitting},
+		},
+		{
+			name:   "quit when disconnected",
+			from:   disconnected,
+			events: []device_agent.Event{device_agent.EventQuit},
+			want:   []pb.AgentState{quitting},
+		},
+		{
+			name: "events that do not apply to the state are ignored",
+			from: disconnected,
+			events: []device_agent.Event{
+				device_agent.EventLogout,
+				device_agent.EventClientGone,
+				device_agent.EventAuthenticate,
+				device_agent.EventCheckApproval,
+				device_agent.EventSyncConfig,
+				device_agent.EventHealthCheck,
+			},
+			want: nil,
+			check: func(t *testing.T, e *env, m *device_agent.StateMachine) {
+				assert.Equal(t, 0, e.helper.teardowns)
+			},
+		},
+		{
+			name: "timers do not apply when unhealthy",
+			from: unhealthy,
+			events: []device_agent.Event{
+				device_agent.EventAuthenticate,
+				device_agent.EventCheckApproval,
+				device_agent.EventSyncConfig,
+				device_agent.EventHealthCheck,
+			},
+			want: nil,
+		},
+	}
+
+	observed := make(map[[2]pb.AgentState]bool)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newEnv()
+			m := e.machine()
+			m.SetState(tt.from)
+			if tt.setup != nil {
+				tt.setup(e, m)
+			}
+
+			for _, event := range tt.events {
+				m.Handle(event)
+			}
+
+			assert.Equal(t, tt.want, e.published)
+			if len(tt.want) > 0 {
+				assert.Equal(t, tt.want[len(tt.want)-1], m.Status().GetConnectionState())
+			} else {
+				assert.Equal(t, tt.from, m.Status().GetConnectionState())
+			}
+			if tt.check != nil {
+				tt.check(t, e, m)
+			}
+
+			from := tt.from
+			for _, to := range e.published {
+				observed[[2]pb.AgentState{from, to}] = true
+				from = to
+			}
+		})
+	}
+
+	t.Run("every declared transition is tested", func(t *testing.T) {
+		for _, transition := range device_agent.Transitions() {
+			assert.True(t, observed[transition], "%s -> %s", transition[0], transition[1])
+		}
+	})
+}
+
+func TestTransitions(t *testing.T) {
+	for _, transition := range device_agent.EventTransitions() {
+		assert.True(t, device_agent.CanTransition(transition[0], transition[1]), "event leads from %s to %s, which is not declared", transition[0], transition[1])
+	}
+
+	assert.False(t, device_agent.CanTransition(pb.AgentState_Quitting, pb.AgentState_Bootstrapping))
+	assert.False(t, device_agent.CanTransition(pb.AgentState_Disconnected, pb.AgentState_Connected))
+}
+
+func TestStateMachineTimers(t *testing.T) {
+	e := newEnv()
+	e.rc.BootstrapConfig = bootstrapConfig()
+	e.auth.loginErr = errors.New("login cancelled")
+	m := e.machine()
+
+	m.Handle(device_agent.EventLogin)
+	assert.Equal(t, backoff, m.Status().GetConnectionState())
+
+	e.auth.loginErr = nil
+	e.clock.now = e.after(9 * time.Second)
+	m.FireTimers()
+	assert.Equal(t, backoff, m.Status().GetConnectionState(), "backoff has not expired")
+
+	e.clock.now = e.after(time.Second)
+	m.FireTimers()
+	assert.Equal(t, connected, m.Status().GetConnectionState())
+	_, ok := m.Scheduled(device_agent.EventAuthenticate)
+	assert.False(t, ok)
+
+	e.published = nil
+	e.clock.now = e.after(20 * time.Second)
+	m.FireTimers()
+	assert.Equal(t, []pb.AgentState{healthCheck, connected}, e.published)
+
+	e.published = nil
+	e.clock.now = e.after(5 * time.Minute)
+	m.FireTimers()
+	assert.Equal(t, []pb.AgentState{syncConfig, healthCheck, connected}, e.published, "sync and health check expiring together")
+}
+
+func TestStateMachineVersionCheck(t *testing.T) {
+	e := newEnv()
+	m := e.machine()
+
+	e.versionErr = errors.New("github unavailable")
+	m.Handle(device_agent.EventCheckVersion)
+	at, ok := m.Scheduled(device_agent.EventCheckVersion)
+	assert.True(t, ok)
+	assert.Equal(t, e.after(5*time.Second), at)
+
+	e.versionErr = nil
+	m.Handle(device_agent.EventCheckVersion)
+	assert.False(t, m.Status().GetNewVersionAvailable())
+	at, _ = m.Scheduled(device_agent.EventCheckVersion)
+	assert.Equal(t, e.after(time.Hour), at)
+
+	e.newVersion = true
+	m.Handle(device_agent.EventCheckVersion)
+	assert.True(t, m.Status().GetNewVersionAvailable())
+	assert.Len(t, e.notifier.infos, 1)
+	_, ok = m.Scheduled(device_agent.EventCheckVersion)
+	assert.False(t, ok, "no need to check again")
+}
+
+func TestStateMachineRun(t *testing.T) {
+	e := newEnv()
+	m := e.machine()
+
+	events := make(chan device_agent.Event, 2)
+	events <- device_agent.EventLogin
+	events <- device_agent.EventQuit
+
+	done := make(chan struct{})
+	go func() {
+		m.Run(context.Background(), events)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("state machine did not quit")
+	}
+
+	assert.Equal(t, []pb.AgentState{disconnected, bootstrapping, authenticating, syncConfig, healthCheck, connected, quitting}, e.published)
+
+	// published statuses are copies, and are not changed by later transitions
+	for i, status := range e.statuses {
+		assert.Equal(t, e.published[i], status.GetConnectionState())
+	}
+}
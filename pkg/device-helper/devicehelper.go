@@ -25,6 +25,7 @@ type OSConfigurator interface {
 	TeardownInterface(ctx context.Context) error
 	SyncConf(ctx context.Context, cfg *pb.Configuration) error
 	SetupRoutes(ctx context.Context, gateways []*pb.Gateway) error
+	PeerStatistics(ctx context.Context) ([]*pb.PeerStatistics, error)
 	Prerequisites() error
 }
 
@@ -106,3 +107,12 @@ func (dhs *DeviceHelperServer) writeConfigFile(cfg *pb.Configuration) error {
 func (dhs *DeviceHelperServer) Upgrade(context.Context, *pb.UpgradeRequest) (*pb.UpgradeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Upgrade not implemented")
 }
+
+func (dhs *DeviceHelperServer) PeerStatistics(ctx context.Context, req *pb.PeerStatisticsRequest) (*pb.PeerStatisticsResponse, error) {
+	peers, err := dhs.OSConfigurator.PeerStatistics(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "reading peer statistics: %s", err)
+	}
+
+	return &pb.PeerStatisticsResponse{Peers: peers}, nil
+}
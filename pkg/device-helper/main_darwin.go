@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/nais/device/pkg/netutil"
 	"github.com/nais/device/pkg/pb"
 
 	log "github.com/sirupsen/logrus"
@@ -46,6 +47,10 @@ func (c *DarwinConfigurator) SyncConf(ctx context.Context, cfg *pb.Configuration
 	return nil
 }
 
+func (c *DarwinConfigurator) PeerStatistics(ctx context.Context) ([]*pb.PeerStatistics, error) {
+	return peerStatistics(ctx, WireGuardBinary, c.helperConfig.Interface)
+}
+
 func (c *DarwinConfigurator) SetupRoutes(ctx context.Context, gateways []*pb.Gateway) error {
 	for _, gw := range gateways {
 		for _, cidr := range gw.GetRoutes() {
@@ -54,7 +59,12 @@ func (c *DarwinConfigurator) SetupRoutes(ctx context.Context, gateways []*pb.Gat
 				continue
 			}
 
-			cmd := exec.CommandContext(ctx, "route", "-q", "-n", "add", "-inet", cidr, "-interface", c.helperConfig.Interface)
+			family := "-inet"
+			if netutil.IsIPv6(cidr) {
+				family = "-inet6"
+			}
+
+			cmd := exec.CommandContext(ctx, "route", "-q", "-n", "add", family, cidr, "-interface", c.helperConfig.Interface)
 			output, err := cmd.CombinedOutput()
 			if err != nil {
 				log.Errorf("%v: %v", cmd, string(output))
@@ -79,6 +89,10 @@ func (c *DarwinConfigurator) SetupInterface(ctx context.Context, cfg *pb.Configu
 		{"route", "-q", "-n", "add", "-inet", cfg.GetDeviceIP() + "/21", "-interface", c.helperConfig.Interface},
 	}
 
+	if len(cfg.GetDeviceIPv6()) > 0 {
+		commands = append(commands, []string{"ifconfig", c.helperConfig.Interface, "inet6", cfg.GetDeviceIPv6(), "prefixlen", "64", "alias"})
+	}
+
 	return runCommands(ctx, commands)
 }
 
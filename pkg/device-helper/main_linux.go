@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"strings"
 
+	"github.com/nais/device/pkg/netutil"
 	"github.com/nais/device/pkg/pb"
 
 	log "github.com/sirupsen/logrus"
@@ -44,6 +45,10 @@ func (c *LinuxConfigurator) SyncConf(ctx context.Context, cfg *pb.Configuration)
 	return nil
 }
 
+func (c *LinuxConfigurator) PeerStatistics(ctx context.Context) ([]*pb.PeerStatistics, error) {
+	return peerStatistics(ctx, WireGuardBinary, c.helperConfig.Interface)
+}
+
 func (c *LinuxConfigurator) SetupRoutes(ctx context.Context, gateways []*pb.Gateway) error {
 	for _, gw := range gateways {
 		for _, cidr := range gw.GetRoutes() {
@@ -52,7 +57,12 @@ func (c *LinuxConfigurator) SetupRoutes(ctx context.Context, gateways []*pb.Gate
 				continue
 			}
 
-			cmd := exec.CommandContext(ctx, "ip", "-4", "route", "add", cidr, "dev", c.helperConfig.Interface)
+			family := "-4"
+			if netutil.IsIPv6(cidr) {
+				family = "-6"
+			}
+
+			cmd := exec.CommandContext(ctx, "ip", family, "route", "add", cidr, "dev", c.helperConfig.Interface)
 			output, err := cmd.CombinedOutput()
 			if exitErr, ok := err.(*exec.ExitError); ok {
 				log.Debugf("Command: %v, exit code: %v, output: %v", cmd, exitErr.ExitCode(), string(output))
@@ -80,6 +90,10 @@ func (c *LinuxConfigurator) SetupInterface(ctx context.Context, cfg *pb.Configur
 		{"ip", "address", "add", "dev", c.helperConfig.Interface, cfg.DeviceIP + "/21"},
 	}
 
+	if len(cfg.GetDeviceIPv6()) > 0 {
+		commands = append(commands, []string{"ip", "-6", "address", "add", "dev", c.helperConfig.Interface, cfg.GetDeviceIPv6() + "/64"})
+	}
+
 	return runCommands(ctx, commands)
 }
 
@@ -15,6 +15,7 @@ import (
 
 const (
 	WireGuardBinary = `c:\Program Files\WireGuard\wireguard.exe`
+	WgBinary        = `c:\Program Files\WireGuard\wg.exe`
 	ServiceName     = "naisdevice-agent-helper"
 )
 
@@ -109,6 +110,10 @@ func (configurator *WindowsConfigurator) SetupRoutes(ctx context.Context, gatewa
 	return nil
 }
 
+func (configurator *WindowsConfigurator) PeerStatistics(ctx context.Context) ([]*pb.PeerStatistics, error) {
+	return peerStatistics(ctx, WgBinary, configurator.helperConfig.Interface)
+}
+
 func (configurator *WindowsConfigurator) SyncConf(ctx context.Context, cfg *pb.Configuration) error {
 	newWireGuardConfig, err := ioutil.ReadFile(configurator.helperConfig.WireGuardConfigPath)
 	if err != nil {
@@ -0,0 +1,40 @@
+package device_helper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/nais/device/pkg/pb"
+	"github.com/nais/device/pkg/wireguard"
+)
+
+func peerStatistics(ctx context.Context, wgBinary, iface string) ([]*pb.PeerStatistics, error) {
+	cmd := exec.CommandContext(ctx, wgBinary, "show", iface, "dump")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %v: %w", cmd, err)
+	}
+
+	peers, err := wireguard.ParseDump(bytes.NewReader(out))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %v: %w", cmd, err)
+	}
+
+	statistics := make([]*pb.PeerStatistics, len(peers))
+	for i, peer := range peers {
+		statistics[i] = &pb.PeerStatistics{
+			PublicKey: peer.PublicKey,
+			RxBytes:   peer.RxBytes,
+			TxBytes:   peer.TxBytes,
+		}
+		if !peer.LatestHandshake.IsZero() {
+			statistics[i].LastHandshake = timestamppb.New(peer.LatestHandshake)
+		}
+	}
+
+	return statistics, nil
+}
@@ -0,0 +1,273 @@
+// Package fakeidp is a local identity provider for testing the login flow end-to-end.
+//
+// It implements the authorization code and refresh token grants, and serves an OpenID Connect discovery document
+// along with its signing key both as a JWK and as an x5c certificate, so tokens it issues can be validated by
+// idp.NewOIDC as well as by the Azure certificate discovery. Authorization is granted without user interaction,
+// to the user set with SetUser.
+package fakeidp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nais/device/pkg/random"
+	"golang.org/x/oauth2"
+)
+
+const (
+	KeyID         = "fakeidp"
+	TokenLifetime = time.Hour
+)
+
+// User is who the identity provider issues tokens to.
+type User struct {
+	Username string
+	ObjectID string
+	Groups   []string
+}
+
+type Server struct {
+	// URL is the issuer URL.
+	URL      string
+	ClientID string
+
+	server      *httptest.Server
+	key         *rsa.PrivateKey
+	certificate []byte
+
+	lock          sync.Mutex
+	user          User
+	codes         map[string]grant
+	refreshTokens map[string]User
+}
+
+// grant is an authorization code that has not yet been exchanged for a token.
+type grant struct {
+	user        User
+	redirectURI string
+}
+
+// New starts an identity provider issuing tokens for the client ID, to the given user. Close it when done.
+func New(clientID string, user User) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("generating key: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fakeidp"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("creating certificate: %w", err)
+	}
+
+	s := &Server{
+		ClientID:      clientID,
+		key:           key,
+		certificate:   certificate,
+		user:          user,
+		codes:         make(map[string]grant),
+		refreshTokens: make(map[string]User),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/keys", s.keys)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL
+
+	return s, nil
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// SetUser sets the user that subsequent authorizations are granted to.
+func (s *Server) SetUser(user User) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.user = user
+}
+
+// KeysURL returns the URL of the key set, which is what Azure calls the discovery URL.
+func (s *Server) KeysURL() string {
+	return s.URL + "/keys"
+}
+
+func (s *Server) Endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:   s.URL + "/authorize",
+		TokenURL:  s.URL + "/token",
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+}
+
+// Token returns a signed token issued to the user, valid for TokenLifetime.
+// It holds the claims of both Azure AD and generic OpenID Connect tokens.
+func (s *Server) Token(user User) (string, error) {
+	now := time.Now()
+	groups := user.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                s.URL,
+		"aud":                s.ClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(TokenLifetime).Unix(),
+		"sub":                user.ObjectID,
+		"oid":                user.ObjectID,
+		"preferred_username": user.Username,
+		"groups":             groups,
+	})
+	token.Header["kid"] = KeyID
+
+	return token.SignedString(s.key)
+}
+
+// RefreshToken returns a refresh token for the user, as if they had logged in.
+func (s *Server) RefreshToken(user User) string {
+	refreshToken := random.RandomString(32, random.LettersAndNumbers)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.refreshTokens[refreshToken] = user
+
+	return refreshToken
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.URL,
+		"authorization_endpoint": s.Endpoint().AuthURL,
+		"token_endpoint":         s.Endpoint().TokenURL,
+		"jwks_uri":               s.KeysURL(),
+	})
+}
+
+func (s *Server) keys(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]interface{}{{
+			"kid": KeyID,
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+			"x5c": []string{base64.StdEncoding.EncodeToString(s.certificate)},
+		}},
+	})
+}
+
+// authorize grants authorization to the current user right away, and redirects back to the client.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if query.Get("client_id") != s.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || len(redirectURI.Host) == 0 {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := random.RandomString(20, random.LettersAndNumbers)
+
+	s.lock.Lock()
+	s.codes[code] = grant{user: s.user, redirectURI: redirectURI.String()}
+	s.lock.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	clientID, _, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.Form.Get("client_id")
+	}
+
+	if clientID != s.ClientID {
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	s.lock.Lock()
+	var user User
+	var granted bool
+	switch r.Form.Get("grant_type") {
+	case "authorization_code":
+		var g grant
+		g, granted = s.codes[r.Form.Get("code")]
+		granted = granted && g.redirectURI == r.Form.Get("redirect_uri")
+		delete(s.codes, r.Form.Get("code"))
+		user = g.user
+	case "refresh_token":
+		user, granted = s.refreshTokens[r.Form.Get("refresh_token")]
+		delete(s.refreshTokens, r.Form.Get("refresh_token"))
+	}
+	s.lock.Unlock()
+
+	if !granted {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	token, err := s.Token(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  token,
+		"id_token":      token,
+		"refresh_token": s.RefreshToken(user),
+		"token_type":    "Bearer",
+		"expires_in":    int(TokenLifetime.Seconds()),
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	respondJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func respondJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,67 @@
+// Package gatewayhealth is the protocol devices use to check the health of gateways over the tunnel.
+//
+// The gateway-agent identifies the device by the source address of the request, which WireGuard guarantees to be the
+// tunnel address of the peer, and tells whether the device is admitted to the gateway.
+package gatewayhealth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const (
+	Port = 3001
+	Path = "/health"
+)
+
+// ErrNotAdmitted means that the gateway is up, but does not currently let the device through.
+var ErrNotAdmitted = errors.New("device is not admitted to the gateway")
+
+// Status is the answer from a gateway to a health check.
+type Status struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Generation counts the configurations the gateway has received from the apiserver since it started.
+	Generation uint64 `json:"generation"`
+	Admitted   bool   `json:"admitted"`
+}
+
+// Address returns the address of the health check endpoint of the gateway with the tunnel ip.
+func Address(ip string) string {
+	return net.JoinHostPort(ip, strconv.Itoa(Port))
+}
+
+// Check asks the gateway for its health. If the gateway answers but does not admit the device, the status is returned
+// along with ErrNotAdmitted.
+func Check(ctx context.Context, client *http.Client, address string) (*Status, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s%s", address, Path), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http response %v", http.StatusText(resp.StatusCode))
+	}
+
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("unmarshalling response body: %w", err)
+	}
+
+	if !status.Admitted {
+		return &status, ErrNotAdmitted
+	}
+
+	return &status, nil
+}
@@ -0,0 +1,62 @@
+package gatewayhealth_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nais/device/pkg/gatewayhealth"
+	"github.com/stretchr/testify/assert"
+)
+
+func serve(status *gatewayhealth.Status) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != gatewayhealth.Path || status == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(status)
+	}))
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("admitted", func(t *testing.T) {
+		want := &gatewayhealth.Status{Name: "gateway-1", Version: "v1", Generation: 3, Admitted: true}
+		server := serve(want)
+		defer server.Close()
+
+		status, err := gatewayhealth.Check(ctx, server.Client(), strings.TrimPrefix(server.URL, "http://"))
+		assert.NoError(t, err)
+		assert.Equal(t, want, status)
+	})
+
+	t.Run("not admitted", func(t *testing.T) {
+		want := &gatewayhealth.Status{Name: "gateway-1", Version: "v1", Generation: 3}
+		server := serve(want)
+		defer server.Close()
+
+		status, err := gatewayhealth.Check(ctx, server.Client(), strings.TrimPrefix(server.URL, "http://"))
+		assert.True(t, errors.Is(err, gatewayhealth.ErrNotAdmitted))
+		assert.Equal(t, want, status)
+	})
+
+	t.Run("unexpected response", func(t *testing.T) {
+		server := serve(nil)
+		defer server.Close()
+
+		_, err := gatewayhealth.Check(ctx, server.Client(), strings.TrimPrefix(server.URL, "http://"))
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, gatewayhealth.ErrNotAdmitted))
+	})
+}
+
+func TestAddress(t *testing.T) {
+	assert.Equal(t, "10.255.240.1:3001", gatewayhealth.Address("10.255.240.1"))
+	assert.Equal(t, "[fd00::1]:3001", gatewayhealth.Address("fd00::1"))
+}
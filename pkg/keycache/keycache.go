@@ -0,0 +1,294 @@
+// Package keycache holds the signing keys of an identity provider, so tokens can be validated after the keys rotate.
+package keycache
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultRefreshInterval = 1 * time.Hour   // how often to refresh the keys
+	MinRefreshInterval     = 1 * time.Minute // minimum time between refreshes triggered by unknown key IDs
+	refreshTimeout         = 10 * time.Second
+)
+
+// Cache holds the signing keys from a JSON Web Key Set, such as the Azure AD discovery URL.
+type Cache struct {
+	URL string
+	// MinRefreshInterval rate limits refreshes when a token is signed with an unknown key.
+	MinRefreshInterval time.Duration
+
+	// refreshLock serializes refreshes, so concurrent requests with a new key ID only refresh once
+	refreshLock sync.Mutex
+
+	lock sync.RWMutex
+	keys map[string]interface{}
+	// lastAttempt is when the keys were last fetched, successfully or not
+	lastAttempt time.Time
+}
+
+// New returns a cache holding the keys at the URL, failing if they can not be fetched.
+func New(ctx context.Context, url string) (*Cache, error) {
+	c := &Cache{
+		URL:                url,
+		MinRefreshInterval: MinRefreshInterval,
+	}
+
+	if err := c.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// NewKeyfunc returns a jwt.Keyfunc accepting tokens issued for the audience and signed with the keys at the URL,
+// which are refreshed until the context is done.
+func NewKeyfunc(ctx context.Context, url, audience string) (jwt.Keyfunc, error) {
+	if len(url) == 0 || len(audience) == 0 {
+		return nil, fmt.Errorf("missing signing key url or audience")
+	}
+
+	log.Infof("Discover signing keys from %s", url)
+	keys, err := New(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving signing keys for token validation: %w", err)
+	}
+	go keys.Run(ctx, DefaultRefreshInterval)
+
+	return keys.Keyfunc(audience), nil
+}
+
+// Run refreshes the keys on the interval until the context is done. Failures are logged, and the keys kept.
+func (c *Cache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Refresh(ctx); err != nil {
+				log.Errorf("Refreshing signing keys: %v", err)
+			}
+		}
+	}
+}
+
+// Refresh fetches the keys, replacing the cached keys if successful.
+func (c *Cache) Refresh(ctx context.Context) error {
+	c.refreshLock.Lock()
+	defer c.refreshLock.Unlock()
+
+	return c.refresh(ctx)
+}
+
+func (c *Cache) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+
+	c.lock.Lock()
+	c.lastAttempt = time.Now()
+	c.lock.Unlock()
+
+	keys, err := fetchKeys(ctx, c.URL)
+	if err != nil {
+		FailedRefreshes.Inc()
+		return fmt.Errorf("fetching signing keys from %s: %w", c.URL, err)
+	}
+
+	c.lock.Lock()
+	c.keys = keys
+	c.lock.Unlock()
+
+	LastSuccessfulRefresh.Set(float64(time.Now().Unix()))
+	log.Debugf("Fetched %d signing keys from %s", len(keys), c.URL)
+
+	return nil
+}
+
+// Key returns the key with the given ID. Unknown key IDs trigger a refresh, unless the keys were fetched, successfully
+// or not, within MinRefreshInterval.
+func (c *Cache) Key(kid string) (interface{}, error) {
+	if key, ok := c.key(kid); ok {
+		return key, nil
+	}
+
+	c.refreshLock.Lock()
+	defer c.refreshLock.Unlock()
+
+	// the keys might have been refreshed while waiting for the lock
+	if key, ok := c.key(kid); ok {
+		return key, nil
+	}
+
+	c.lock.RLock()
+	lastAttempt := c.lastAttempt
+	c.lock.RUnlock()
+
+	if time.Since(lastAttempt) < c.MinRefreshInterval {
+		return nil, fmt.Errorf("kid '%s' not found in signing keys", kid)
+	}
+
+	log.Infof("Refreshing signing keys from %s, as kid '%s' is unknown", c.URL, kid)
+	UnknownKeyRefreshes.Inc()
+	if err := c.refresh(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if key, ok := c.key(kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("kid '%s' not found in signing keys", kid)
+}
+
+func (c *Cache) key(kid string) (interface{}, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// Keyfunc returns a jwt.Keyfunc accepting RSA signed tokens issued for the audience, resolving the signing key
+// by the token's key ID. Tokens without a key ID are rejected.
+func (c *Cache) Keyfunc(audience string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		var claims jwt.MapClaims
+		switch t := token.Claims.(type) {
+		case *jwt.MapClaims:
+			claims = *t
+		case jwt.MapClaims:
+			claims = t
+		default:
+			return nil, fmt.Errorf("unable to retrieve claims from token")
+		}
+
+		if !hasAudience(claims, audience) {
+			return nil, fmt.Errorf("the token is not valid for this application")
+		}
+
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("field 'kid' is of invalid type %T, should be string", token.Header["kid"])
+		}
+
+		return c.Key(kid)
+	}
+}
+
+func hasAudience(claims jwt.MapClaims, audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type jwk struct {
+	Kid string   `json:"kid"`
+	Kty string   `json:"kty"`
+	Use string   `json:"use"`
+	N   string   `json:"n"`
+	E   string   `json:"e"`
+	X5c []string `json:"x5c"`
+}
+
+// fetchKeys fetches the RSA signing keys by key ID. The key is read from its first x5c certificate if present,
+// and from its modulus and exponent otherwise.
+func fetchKeys(ctx context.Context, url string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http response %v", resp.Status)
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("decoding key set: %w", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (len(k.Use) > 0 && k.Use != "sig") {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key '%s': %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RSA signing keys in key set")
+	}
+
+	return keys, nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	if len(k.X5c) > 0 {
+		der, err := base64.StdEncoding.DecodeString(k.X5c[0])
+		if err != nil {
+			return nil, fmt.Errorf("decoding certificate: %w", err)
+		}
+
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate: %w", err)
+		}
+
+		return certificate.PublicKey, nil
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("decoding modulus: %w", err)
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("decoding exponent: %w", err)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
@@ -0,0 +1,121 @@
+package keycache_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nais/device/pkg/keycache"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// keyServer serves a key set with the current keys, counting requests.
+type keyServer struct {
+	lock     sync.Mutex
+	keys     map[string]*rsa.PrivateKey
+	fail     bool
+	requests int
+}
+
+func (ks *keyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	ks.requests++
+	if ks.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var keys []map[string]string
+	for kid, key := range ks.keys {
+		keys = append(keys, map[string]string{
+			"kid": kid,
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (ks *keyServer) set(keys map[string]*rsa.PrivateKey, fail bool) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+	ks.keys = keys
+	ks.fail = fail
+}
+
+func TestCache(t *testing.T) {
+	key1, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	key2, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	ks := &keyServer{keys: map[string]*rsa.PrivateKey{"key1": key1}}
+	server := httptest.NewServer(ks)
+	defer server.Close()
+
+	cache, err := keycache.New(context.Background(), server.URL)
+	assert.NoError(t, err)
+	cache.MinRefreshInterval = 0
+
+	parse := func(kid string, key *rsa.PrivateKey, audience string) error {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"aud": audience, "exp": time.Now().Add(time.Minute).Unix()})
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		assert.NoError(t, err)
+
+		var claims jwt.MapClaims
+		_, err = jwt.ParseWithClaims(signed, &claims, cache.Keyfunc("client"))
+		return err
+	}
+
+	assert.NoError(t, parse("key1", key1, "client"))
+	assert.Error(t, parse("key1", key1, "other"), "token for another audience")
+
+	// rotate keys
+	ks.set(map[string]*rsa.PrivateKey{"key2": key2}, false)
+	unknownKeyRefreshes := testutil.ToFloat64(keycache.UnknownKeyRefreshes)
+	assert.NoError(t, parse("key2", key2, "client"), "unknown kid triggers refresh")
+	assert.Equal(t, unknownKeyRefreshes+1, testutil.ToFloat64(keycache.UnknownKeyRefreshes))
+	assert.Error(t, parse("key1", key1, "client"), "rotated out")
+
+	// refreshes triggered by unknown kids are rate limited
+	cache.MinRefreshInterval = time.Hour
+	requests := ks.requests
+	assert.Error(t, parse("key3", key1, "client"))
+	assert.Equal(t, requests, ks.requests)
+
+	// failed refreshes keep the keys
+	ks.set(nil, true)
+	failedRefreshes := testutil.ToFloat64(keycache.FailedRefreshes)
+	assert.Error(t, cache.Refresh(context.Background()))
+	assert.Equal(t, failedRefreshes+1, testutil.ToFloat64(keycache.FailedRefreshes))
+	assert.NoError(t, parse("key2", key2, "client"))
+
+	// failed refreshes triggered by unknown kids are rate limited as well
+	cache.MinRefreshInterval = 100 * time.Millisecond
+	time.Sleep(cache.MinRefreshInterval)
+	requests = ks.requests
+	assert.Error(t, parse("key3", key1, "client"))
+	assert.Error(t, parse("key4", key1, "client"))
+	assert.Equal(t, requests+1, ks.requests)
+
+	// tokens without a kid are rejected, even if there is only one key
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"aud": "client", "exp": time.Now().Add(time.Minute).Unix()})
+	signed, err := token.SignedString(key2)
+	assert.NoError(t, err)
+	_, err = jwt.Parse(signed, cache.Keyfunc("client"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,30 @@
+package keycache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	FailedRefreshes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "failed_refreshes",
+		Help:      "count of failed signing key refreshes",
+		Namespace: "naisdevice",
+		Subsystem: "keycache",
+	})
+	UnknownKeyRefreshes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:      "unknown_key_refreshes",
+		Help:      "count of signing key refreshes triggered by tokens signed with an unknown key",
+		Namespace: "naisdevice",
+		Subsystem: "keycache",
+	})
+	LastSuccessfulRefresh = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:      "last_successful_refresh",
+		Help:      "time of last successful signing key refresh",
+		Namespace: "naisdevice",
+		Subsystem: "keycache",
+	})
+)
+
+func InitializeMetrics() {
+	prometheus.MustRegister(FailedRefreshes, UnknownKeyRefreshes, LastSuccessfulRefresh)
+}
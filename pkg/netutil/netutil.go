@@ -0,0 +1,16 @@
+// Package netutil holds helpers for addresses and networks shared by the device-helper and the gateway-agent.
+package netutil
+
+import (
+	"net"
+)
+
+// IsIPv6 reports whether the address or network in CIDR notation is IPv6. Invalid addresses are not IPv6.
+func IsIPv6(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		ip, _, _ = net.ParseCIDR(address)
+	}
+
+	return ip != nil && ip.To4() == nil
+}
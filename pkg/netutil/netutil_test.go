@@ -0,0 +1,25 @@
+package netutil_test
+
+import (
+	"testing"
+
+	"github.com/nais/device/pkg/netutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsIPv6(t *testing.T) {
+	for address, ipv6 := range map[string]bool{
+		"10.0.0.1":            false,
+		"10.0.0.0/24":         false,
+		"::ffff:10.0.0.1":     false,
+		"fd75:568f:f19::2":    true,
+		"2001:db8::/64":       true,
+		"::1":                 true,
+		"":                    false,
+		"not:an:address":      false,
+		"2001:db8::/129":      false,
+		"10.0.0.0/24 -j DROP": false,
+	} {
+		assert.Equal(t, ipv6, netutil.IsIPv6(address), address)
+	}
+}
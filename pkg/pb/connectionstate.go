@@ -20,6 +20,8 @@ func (x *AgentStatus) ConnectionStateString() string {
 		return "Authenticating..."
 	case AgentState_AuthenticateBackoff:
 		return "Authentication failed; waiting to retry..."
+	case AgentState_AwaitingApproval:
+		return "Waiting for enrollment approval..."
 	case AgentState_Connected:
 		return "Connected since " + x.ConnectedSince.AsTime().Format(timeFormat)
 	default:
@@ -1,10 +1,21 @@
 package pb
 
+import (
+	"fmt"
+	"time"
+)
+
+// MergeHealth copies what the device-agent knows about its connection to the gateway.
 func (x *Gateway) MergeHealth(y *Gateway) {
 	x.Healthy = y.GetHealthy()
+	x.RoundTripTime = y.GetRoundTripTime()
+	x.LastHandshake = y.GetLastHandshake()
+	x.RxBytes = y.GetRxBytes()
+	x.TxBytes = y.GetTxBytes()
+	x.LastError = y.GetLastError()
 }
 
-// MergeGatewayHealth copies the `Healthy` member from one slice of gateways to the other.
+// MergeGatewayHealth copies the `Healthy` member, and the other connection details, from one slice of gateways to the other.
 func MergeGatewayHealth(dst []*Gateway, src []*Gateway) {
 	gatewayByName := func(name string) *Gateway {
 		for _, gw := range src {
@@ -17,7 +28,42 @@ func MergeGatewayHealth(dst []*Gateway, src []*Gateway) {
 	for _, gw := range dst {
 		healthGateway := gatewayByName(gw.Name)
 		if healthGateway != nil {
-			gw.Healthy = healthGateway.GetHealthy()
+			gw.MergeHealth(healthGateway)
+		}
+	}
+}
+
+// MergePeerStatistics copies the WireGuard statistics of each peer to the gateway with the same public key.
+func MergePeerStatistics(gateways []*Gateway, peers []*PeerStatistics) {
+	for _, gw := range gateways {
+		for _, peer := range peers {
+			if gw.GetPublicKey() == peer.GetPublicKey() {
+				gw.LastHandshake = peer.GetLastHandshake()
+				gw.RxBytes = peer.GetRxBytes()
+				gw.TxBytes = peer.GetTxBytes()
+			}
 		}
 	}
 }
+
+// Human-friendly connection details, one per line
+func (x *Gateway) ConnectionDetails(now time.Time) []string {
+	var details []string
+
+	if x.GetRoundTripTime() != nil {
+		details = append(details, "Round trip time: "+x.GetRoundTripTime().AsDuration().Round(100*time.Microsecond).String())
+	}
+
+	if x.GetLastHandshake() != nil {
+		age := now.Sub(x.GetLastHandshake().AsTime()).Round(time.Second)
+		details = append(details, fmt.Sprintf("Last handshake: %s ago", age))
+	} else {
+		details = append(details, "Last handshake: never")
+	}
+
+	if x.GetLastError() != "" {
+		details = append(details, "Error: "+x.GetLastError())
+	}
+
+	return details
+}
@@ -2,20 +2,26 @@ package pb_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/nais/device/pkg/pb"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestMergeGatewayHealth(t *testing.T) {
 	src := []*pb.Gateway{
 		{
-			Name:    "gw-1",
-			Healthy: false,
+			Name:      "gw-1",
+			Healthy:   false,
+			LastError: "connection refused",
 		},
 		{
-			Name:    "gw-2",
-			Healthy: true,
+			Name:          "gw-2",
+			Healthy:       true,
+			RoundTripTime: durationpb.New(time.Millisecond),
+			RxBytes:       1024,
 		},
 	}
 
@@ -51,9 +57,12 @@ func TestMergeGatewayHealth(t *testing.T) {
 
 	assert.Equal(t, "gw-1", dst[0].Name)
 	assert.False(t, dst[0].Healthy)
+	assert.Equal(t, "connection refused", dst[0].LastError)
 
 	assert.Equal(t, "gw-2", dst[1].Name)
 	assert.True(t, dst[1].Healthy)
+	assert.Equal(t, time.Millisecond, dst[1].RoundTripTime.AsDuration())
+	assert.Equal(t, uint64(1024), dst[1].RxBytes)
 
 	assert.Equal(t, "gw-3", dst[2].Name)
 	assert.True(t, dst[2].Healthy)
@@ -61,3 +70,23 @@ func TestMergeGatewayHealth(t *testing.T) {
 	assert.Equal(t, "gw-4", dst[3].Name)
 	assert.False(t, dst[3].Healthy)
 }
+
+func TestMergePeerStatistics(t *testing.T) {
+	handshake := timestamppb.New(time.Unix(1612180800, 0))
+	gateways := []*pb.Gateway{
+		{Name: "gw-1", PublicKey: "key-1"},
+		{Name: "gw-2", PublicKey: "key-2"},
+	}
+
+	pb.MergePeerStatistics(gateways, []*pb.PeerStatistics{
+		{PublicKey: "key-2", LastHandshake: handshake, RxBytes: 1, TxBytes: 2},
+		{PublicKey: "unknown", RxBytes: 3, TxBytes: 4},
+	})
+
+	assert.Nil(t, gateways[0].LastHandshake)
+	assert.Zero(t, gateways[0].RxBytes)
+
+	assert.Equal(t, handshake, gateways[1].LastHandshake)
+	assert.Equal(t, uint64(1), gateways[1].RxBytes)
+	assert.Equal(t, uint64(2), gateways[1].TxBytes)
+}
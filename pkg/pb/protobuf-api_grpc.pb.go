@@ -24,6 +24,8 @@ type DeviceHelperClient interface {
 	Teardown(ctx context.Context, in *TeardownRequest, opts ...grpc.CallOption) (*TeardownResponse, error)
 	// Install the newest version of naisdevice.
 	Upgrade(ctx context.Context, in *UpgradeRequest, opts ...grpc.CallOption) (*UpgradeResponse, error)
+	// Handshake and transfer statistics for each WireGuard peer.
+	PeerStatistics(ctx context.Context, in *PeerStatisticsRequest, opts ...grpc.CallOption) (*PeerStatisticsResponse, error)
 }
 
 type deviceHelperClient struct {
@@ -61,6 +63,15 @@ func (c *deviceHelperClient) Upgrade(ctx context.Context, in *UpgradeRequest, op
 	return out, nil
 }
 
+func (c *deviceHelperClient) PeerStatistics(ctx context.Context, in *PeerStatisticsRequest, opts ...grpc.CallOption) (*PeerStatisticsResponse, error) {
+	out := new(PeerStatisticsResponse)
+	err := c.cc.Invoke(ctx, "/naisdevice.DeviceHelper/PeerStatistics", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DeviceHelperServer is the server API for DeviceHelper service.
 // All implementations must embed UnimplementedDeviceHelperServer
 // for forward compatibility
@@ -71,6 +82,8 @@ type DeviceHelperServer interface {
 	Teardown(context.Context, *TeardownRequest) (*TeardownResponse, error)
 	// Install the newest version of naisdevice.
 	Upgrade(context.Context, *UpgradeRequest) (*UpgradeResponse, error)
+	// Handshake and transfer statistics for each WireGuard peer.
+	PeerStatistics(context.Context, *PeerStatisticsRequest) (*PeerStatisticsResponse, error)
 	mustEmbedUnimplementedDeviceHelperServer()
 }
 
@@ -87,6 +100,9 @@ func (UnimplementedDeviceHelperServer) Teardown(context.Context, *TeardownReques
 func (UnimplementedDeviceHelperServer) Upgrade(context.Context, *UpgradeRequest) (*UpgradeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Upgrade not implemented")
 }
+func (UnimplementedDeviceHelperServer) PeerStatistics(context.Context, *PeerStatisticsRequest) (*PeerStatisticsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PeerStatistics not implemented")
+}
 func (UnimplementedDeviceHelperServer) mustEmbedUnimplementedDeviceHelperServer() {}
 
 // UnsafeDeviceHelperServer may be embedded to opt out of forward compatibility for this service.
@@ -154,6 +170,24 @@ func _DeviceHelper_Upgrade_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DeviceHelper_PeerStatistics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PeerStatisticsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeviceHelperServer).PeerStatistics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/naisdevice.DeviceHelper/PeerStatistics",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeviceHelperServer).PeerStatistics(ctx, req.(*PeerStatisticsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // DeviceHelper_ServiceDesc is the grpc.ServiceDesc for DeviceHelper service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -173,6 +207,10 @@ var DeviceHelper_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Upgrade",
 			Handler:    _DeviceHelper_Upgrade_Handler,
 		},
+		{
+			MethodName: "PeerStatistics",
+			Handler:    _DeviceHelper_PeerStatistics_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "pkg/pb/protobuf-api.proto",
@@ -409,3 +447,120 @@ var DeviceAgent_ServiceDesc = grpc.ServiceDesc{
 	},
 	Metadata: "pkg/pb/protobuf-api.proto",
 }
+
+// APIServerClient is the client API for APIServer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type APIServerClient interface {
+	// Gateways subscribe to their configuration on this endpoint.
+	// A new configuration is pushed whenever sessions, device health or gateway routes change.
+	GetGatewayConfiguration(ctx context.Context, in *GetGatewayConfigurationRequest, opts ...grpc.CallOption) (APIServer_GetGatewayConfigurationClient, error)
+}
+
+type aPIServerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAPIServerClient(cc grpc.ClientConnInterface) APIServerClient {
+	return &aPIServerClient{cc}
+}
+
+func (c *aPIServerClient) GetGatewayConfiguration(ctx context.Context, in *GetGatewayConfigurationRequest, opts ...grpc.CallOption) (APIServer_GetGatewayConfigurationClient, error) {
+	stream, err := c.cc.NewStream(ctx, &APIServer_ServiceDesc.Streams[0], "/naisdevice.APIServer/GetGatewayConfiguration", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &aPIServerGetGatewayConfigurationClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type APIServer_GetGatewayConfigurationClient interface {
+	Recv() (*GatewayConfiguration, error)
+	grpc.ClientStream
+}
+
+type aPIServerGetGatewayConfigurationClient struct {
+	grpc.ClientStream
+}
+
+func (x *aPIServerGetGatewayConfigurationClient) Recv() (*GatewayConfiguration, error) {
+	m := new(GatewayConfiguration)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// APIServerServer is the server API for APIServer service.
+// All implementations must embed UnimplementedAPIServerServer
+// for forward compatibility
+type APIServerServer interface {
+	// Gateways subscribe to their configuration on this endpoint.
+	// A new configuration is pushed whenever sessions, device health or gateway routes change.
+	GetGatewayConfiguration(*GetGatewayConfigurationRequest, APIServer_GetGatewayConfigurationServer) error
+	mustEmbedUnimplementedAPIServerServer()
+}
+
+// UnimplementedAPIServerServer must be embedded to have forward compatible implementations.
+type UnimplementedAPIServerServer struct {
+}
+
+func (UnimplementedAPIServerServer) GetGatewayConfiguration(*GetGatewayConfigurationRequest, APIServer_GetGatewayConfigurationServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetGatewayConfiguration not implemented")
+}
+func (UnimplementedAPIServerServer) mustEmbedUnimplementedAPIServerServer() {}
+
+// UnsafeAPIServerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to APIServerServer will
+// result in compilation errors.
+type UnsafeAPIServerServer interface {
+	mustEmbedUnimplementedAPIServerServer()
+}
+
+func RegisterAPIServerServer(s grpc.ServiceRegistrar, srv APIServerServer) {
+	s.RegisterService(&APIServer_ServiceDesc, srv)
+}
+
+func _APIServer_GetGatewayConfiguration_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetGatewayConfigurationRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(APIServerServer).GetGatewayConfiguration(m, &aPIServerGetGatewayConfigurationServer{stream})
+}
+
+type APIServer_GetGatewayConfigurationServer interface {
+	Send(*GatewayConfiguration) error
+	grpc.ServerStream
+}
+
+type aPIServerGetGatewayConfigurationServer struct {
+	grpc.ServerStream
+}
+
+func (x *aPIServerGetGatewayConfigurationServer) Send(m *GatewayConfiguration) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// APIServer_ServiceDesc is the grpc.ServiceDesc for APIServer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var APIServer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "naisdevice.APIServer",
+	HandlerType: (*APIServerServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetGatewayConfiguration",
+			Handler:       _APIServer_GetGatewayConfiguration_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pkg/pb/protobuf-api.proto",
+}
@@ -0,0 +1,45 @@
+package pb
+
+// Allows returns true if a user with the given groups may reach this route.
+// Routes without access groups are open to everyone admitted to the gateway.
+func (x *Route) Allows(userGroups []string) bool {
+	if len(x.GetAccessGroupIDs()) == 0 {
+		return true
+	}
+
+	for _, userGroup := range userGroups {
+		for _, routeGroup := range x.GetAccessGroupIDs() {
+			if userGroup == routeGroup {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// AllowedRoutes returns the routes a user with the given groups may reach.
+func AllowedRoutes(routes []*Route, userGroups []string) []*Route {
+	var allowed []*Route
+	for _, route := range routes {
+		if route.Allows(userGroups) {
+			allowed = append(allowed, route)
+		}
+	}
+
+	return allowed
+}
+
+// RouteCIDRs returns the distinct networks of the given routes, in order of appearance.
+func RouteCIDRs(routes []*Route) []string {
+	var cidrs []string
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		if !seen[route.GetCidr()] {
+			seen[route.GetCidr()] = true
+			cidrs = append(cidrs, route.GetCidr())
+		}
+	}
+
+	return cidrs
+}
@@ -0,0 +1,29 @@
+package pb_test
+
+import (
+	"testing"
+
+	"github.com/nais/device/pkg/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowedRoutes(t *testing.T) {
+	open := &pb.Route{Cidr: "10.0.0.0/24"}
+	team := &pb.Route{Cidr: "10.0.1.0/24", AccessGroupIDs: []string{"team"}}
+	other := &pb.Route{Cidr: "10.0.1.0/24", Protocol: "udp", AccessGroupIDs: []string{"other"}}
+	routes := []*pb.Route{open, team, other}
+
+	assert.Equal(t, []*pb.Route{open}, pb.AllowedRoutes(routes, nil))
+	assert.Equal(t, []*pb.Route{open, team}, pb.AllowedRoutes(routes, []string{"team"}))
+	assert.Equal(t, []*pb.Route{open, team, other}, pb.AllowedRoutes(routes, []string{"other", "team"}))
+}
+
+func TestRouteCIDRs(t *testing.T) {
+	routes := []*pb.Route{
+		{Cidr: "10.0.1.0/24"},
+		{Cidr: "10.0.0.0/24"},
+		{Cidr: "10.0.1.0/24", Protocol: "udp"},
+	}
+
+	assert.Equal(t, []string{"10.0.1.0/24", "10.0.0.0/24"}, pb.RouteCIDRs(routes))
+}
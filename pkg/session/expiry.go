@@ -0,0 +1,20 @@
+// Package session holds the session expiry semantics shared by the apiserver and the device-agent.
+package session
+
+import (
+	"time"
+)
+
+// RenewalWindow is how long before expiry a session should be renewed.
+const RenewalWindow = 1 * time.Hour
+
+// Expired reports whether a session with the given expiry, in unix seconds, has expired at the given time.
+// A session is valid up to, but not including, its expiry.
+func Expired(expiry int64, now time.Time) bool {
+	return !now.Before(time.Unix(expiry, 0))
+}
+
+// NeedsRenewal reports whether a session with the given expiry is still valid, but expires within RenewalWindow.
+func NeedsRenewal(expiry int64, now time.Time) bool {
+	return !Expired(expiry, now) && time.Unix(expiry, 0).Sub(now) < RenewalWindow
+}
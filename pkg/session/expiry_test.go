@@ -0,0 +1,26 @@
+package session_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nais/device/pkg/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpired(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	assert.False(t, session.Expired(now.Add(time.Second).Unix(), now), "expires in the future")
+	assert.True(t, session.Expired(now.Unix(), now), "expires now")
+	assert.True(t, session.Expired(now.Add(-time.Second).Unix(), now), "expired in the past")
+	assert.True(t, session.Expired(0, now), "no expiry")
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	assert.False(t, session.NeedsRenewal(now.Add(session.RenewalWindow+time.Second).Unix(), now), "outside renewal window")
+	assert.True(t, session.NeedsRenewal(now.Add(session.RenewalWindow-time.Second).Unix(), now), "inside renewal window")
+	assert.False(t, session.NeedsRenewal(now.Unix(), now), "expired sessions can not be renewed")
+}
@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"sort"
+	"strings"
 	"syscall"
 	"time"
 
@@ -179,7 +180,7 @@ func (gui *Gui) handleAgentStatus(agentStatus *pb.AgentStatus) {
 		gui.MenuItems.Connect.SetTitle("Disconnect")
 	case pb.AgentState_Connected:
 		systray.SetIcon(NaisLogoGreen)
-	case pb.AgentState_Unhealthy:
+	case pb.AgentState_Unhealthy, pb.AgentState_AwaitingApproval:
 		systray.SetIcon(NaisLogoYellow)
 	case pb.AgentState_Disconnected:
 		systray.SetIcon(NaisLogoRed)
@@ -187,6 +188,7 @@ func (gui *Gui) handleAgentStatus(agentStatus *pb.AgentStatus) {
 	}
 
 	gui.MenuItems.State.SetTitle(agentStatus.ConnectionStateString())
+	gui.MenuItems.State.SetTooltip(agentStatus.GetReason())
 	if agentStatus.NewVersionAvailable {
 		gui.MenuItems.Upgrade.Show()
 	} else {
@@ -207,7 +209,7 @@ func (gui *Gui) handleAgentStatus(agentStatus *pb.AgentStatus) {
 
 		menuItem := gui.MenuItems.GatewayItems[i].MenuItem
 		menuItem.SetTitle(gateway.Name)
-		menuItem.SetTooltip(gateway.Endpoint)
+		menuItem.SetTooltip(strings.Join(append([]string{gateway.Endpoint}, gateway.ConnectionDetails(time.Now())...), "\n"))
 
 		if gateway.Healthy {
 			menuItem.Check()
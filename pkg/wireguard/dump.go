@@ -0,0 +1,73 @@
+package wireguard
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Peer is a peer as listed by `wg show <interface> dump`.
+type Peer struct {
+	PublicKey       string
+	Endpoint        string
+	AllowedIPs      []string
+	LatestHandshake time.Time // zero if there has been no handshake
+	RxBytes         uint64
+	TxBytes         uint64
+}
+
+// ParseDump parses the output of `wg show <interface> dump`. The first line describes the interface, and
+// is followed by one tab separated line per peer: public key, preshared key, endpoint, allowed ips, latest handshake,
+// received bytes, sent bytes and persistent keepalive.
+func ParseDump(r io.Reader) ([]Peer, error) {
+	peers := make([]Peer, 0)
+
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return peers, scanner.Err()
+	}
+
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 8 {
+			return nil, fmt.Errorf("parsing peer line %d: expected 8 fields, got %d", line, len(fields))
+		}
+
+		handshake, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing latest handshake: %w", err)
+		}
+
+		rx, err := strconv.ParseUint(fields[5], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing received bytes: %w", err)
+		}
+
+		tx, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing sent bytes: %w", err)
+		}
+
+		peer := Peer{
+			PublicKey: fields[0],
+			RxBytes:   rx,
+			TxBytes:   tx,
+		}
+		if fields[2] != "(none)" {
+			peer.Endpoint = fields[2]
+		}
+		if fields[3] != "(none)" {
+			peer.AllowedIPs = strings.Split(fields[3], ",")
+		}
+		if handshake > 0 {
+			peer.LatestHandshake = time.Unix(handshake, 0)
+		}
+
+		peers = append(peers, peer)
+	}
+
+	return peers, scanner.Err()
+}
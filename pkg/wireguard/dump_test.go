@@ -0,0 +1,58 @@
+package wireguard_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nais/device/pkg/wireguard"
+)
+
+func TestParseDump(t *testing.T) {
+	dump := strings.Join([]string{
+		"cHJpdmF0ZQ==\tcHVibGlj\t51820\toff",
+		"peer1\t(none)\t1.2.3.4:51820\t10.255.240.2/32,10.0.0.0/24\t1612180800\t1024\t2048\t0",
+		"peer2\t(none)\t(none)\t10.255.240.3/32\t0\t0\t0\toff",
+		"peer3\t(none)\t(none)\t(none)\t0\t0\t0\toff",
+	}, "\n") + "\n"
+
+	peers, err := wireguard.ParseDump(strings.NewReader(dump))
+	assert.NoError(t, err)
+	assert.Equal(t, []wireguard.Peer{
+		{
+			PublicKey:       "peer1",
+			Endpoint:        "1.2.3.4:51820",
+			AllowedIPs:      []string{"10.255.240.2/32", "10.0.0.0/24"},
+			LatestHandshake: time.Unix(1612180800, 0),
+			RxBytes:         1024,
+			TxBytes:         2048,
+		},
+		{PublicKey: "peer2", AllowedIPs: []string{"10.255.240.3/32"}},
+		{PublicKey: "peer3"},
+	}, peers)
+	assert.True(t, peers[1].LatestHandshake.IsZero(), "no handshake yet")
+}
+
+func TestParseDumpEmpty(t *testing.T) {
+	peers, err := wireguard.ParseDump(strings.NewReader(""))
+	assert.NoError(t, err)
+	assert.Empty(t, peers)
+
+	peers, err = wireguard.ParseDump(strings.NewReader("privkey\tpubkey\t51820\toff\n"))
+	assert.NoError(t, err)
+	assert.Empty(t, peers)
+}
+
+func TestParseDumpInvalid(t *testing.T) {
+	for _, dump := range []string{
+		"interface\nbroken line\n",
+		"interface\nnot\ta\tpeer\n",
+		"interface\npeer\t(none)\t(none)\t(none)\tnever\t0\t0\toff\n",
+		"interface\npeer\t(none)\t(none)\t(none)\t0\t-1\t0\toff\n",
+	} {
+		_, err := wireguard.ParseDump(strings.NewReader(dump))
+		assert.Error(t, err, dump)
+	}
+}